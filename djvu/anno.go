@@ -0,0 +1,623 @@
+package djvu
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image/color"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/janreggie/go-djvulibre/djvu/bzz"
+	"github.com/janreggie/go-djvulibre/djvu/iff"
+	"github.com/janreggie/go-djvulibre/djvu/miniexp"
+	"github.com/pkg/errors"
+)
+
+// Anno represents the annotations of a page,
+// as found in its `ANTa` and `ANTz` chunks.
+// Annotations are written as Lisp-like expressions:
+//
+//     (background #FFFFFF)
+//     (zoom d150)
+//     (mode bw)
+//     (align center top)
+//     (metadata (Author "Someone") (Title "Some book"))
+//     (maparea "http://www.example.com" "Example" (rect 10 10 100 50) (xor))
+//     (print yes)
+//     (phead "Some book") (pfoot "Page 3")
+//     (printer (copies 2) (zoom page) (mode bw) (landscape no))
+//
+// `ANTz` chunks hold the same text compressed with BZZ.
+// The zero value of each field stands for an annotation which is not given.
+type Anno struct {
+	// Color of the margins around the page
+	Background *color.RGBA
+
+	// Initial zoom and display mode of the page
+	Zoom Zoom
+	Mode DisplayMode
+
+	// Alignment of the page when it is smaller than the viewer
+	HorAlign Alignment
+	VerAlign Alignment
+
+	// Bibliographic information such as `Author` or `Title`
+	Metadata map[string]string
+
+	// XMP metadata, as an XML document
+	XMP string
+
+	// Hyperlinks and highlighted areas
+	MapAreas []*MapArea
+
+	// Whether viewers should let the page be printed,
+	// like the `print` viewer option
+	Print Toggle
+
+	// Header and footer printed on the page
+	PrintHeader string
+	PrintFooter string
+
+	// Settings of the printer, nil if not given
+	Printer *PrinterSettings
+
+	// Annotations which are not known, verbatim
+	Other []string
+}
+
+// PrinterSettings are the hints given to the printer by the `printer` annotation.
+// The zero value of each field stands for a setting which is not given.
+type PrinterSettings struct {
+	// Number of copies
+	Copies int
+
+	// Size of the printed page, such as ZOOM_FIT_PAGE or a percentage
+	Zoom Zoom
+
+	// Layers to print, such as DISPLAY_BW
+	Mode DisplayMode
+
+	// Whether to print in landscape rather than portrait orientation
+	Landscape Toggle
+
+	// Settings which are not known, verbatim
+	Other []string
+}
+
+func NewAnno() *Anno { return &Anno{} }
+
+// IsEmpty returns whether there are no annotations at all.
+func (a *Anno) IsEmpty() bool {
+	return a.Background == nil && a.Zoom == ZOOM_DEFAULT && a.Mode == DISPLAY_DEFAULT &&
+		a.HorAlign == ALIGN_DEFAULT && a.VerAlign == ALIGN_DEFAULT &&
+		len(a.Metadata) == 0 && a.XMP == "" && len(a.MapAreas) == 0 &&
+		a.Print == TOGGLE_DEFAULT && a.PrintHeader == "" && a.PrintFooter == "" && a.Printer == nil &&
+		len(a.Other) == 0
+}
+
+// Decode decodes the text of an `ANTa` chunk, replacing the annotations of a.
+func (a *Anno) Decode(r io.Reader) error {
+	*a = Anno{}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return errors.Wrap(err, "could not read annotations")
+	}
+	exprs, err := miniexp.Parse(data)
+	if err != nil {
+		return errors.Wrap(err, "could not parse annotations")
+	}
+	for _, e := range exprs {
+		if err := a.set(e); err != nil {
+			return errors.Wrapf(err, "bad annotation %s", e)
+		}
+	}
+	return nil
+}
+
+func (a *Anno) set(e miniexp.Expr) error {
+	l, _ := e.(miniexp.List)
+	args := l.Args()
+	// Reads the only symbol argument of e
+	symbolArg := func() (string, error) {
+		if len(args) == 1 {
+			if s, ok := args[0].(miniexp.Symbol); ok {
+				return string(s), nil
+			}
+		}
+		return "", errors.New("expected a single symbol")
+	}
+
+	switch l.Head() {
+	case "background":
+		s, err := symbolArg()
+		if err != nil {
+			return err
+		}
+		a.Background, err = parseColor(s)
+		return err
+
+	case "zoom":
+		s, err := symbolArg()
+		if err != nil {
+			return err
+		}
+		a.Zoom, err = parseAnnoZoom(s)
+		return err
+
+	case "mode":
+		s, err := symbolArg()
+		if err != nil {
+			return err
+		}
+		mode, err := parseEnum(displayModeNames, s)
+		a.Mode = DisplayMode(mode)
+		return err
+
+	case "align":
+		if len(args) == 0 || len(args) > 2 {
+			return errors.New("expected horizontal and vertical alignments")
+		}
+		var err error
+		if a.HorAlign, err = parseAnnoAlignment(args[0], horAlignments); err != nil {
+			return err
+		}
+		if len(args) == 2 {
+			a.VerAlign, err = parseAnnoAlignment(args[1], verAlignments)
+		}
+		return err
+
+	case "metadata":
+		return miniexp.Unmarshal(args, &a.Metadata)
+
+	case "xmp":
+		if len(args) == 1 {
+			if xmp, ok := args[0].(miniexp.String); ok {
+				a.XMP = string(xmp)
+				return nil
+			}
+		}
+		return errors.New("expected a string")
+
+	case "maparea":
+		area, err := parseMapArea(args)
+		if err != nil {
+			return err
+		}
+		a.MapAreas = append(a.MapAreas, area)
+
+	case "print":
+		s, err := symbolArg()
+		if err != nil {
+			return err
+		}
+		a.Print, err = parseToggle(s)
+		return err
+
+	case "phead", "pfoot":
+		if len(args) != 1 {
+			return errors.New("expected a string")
+		}
+		text, ok := args[0].(miniexp.String)
+		if !ok {
+			return errors.New("expected a string")
+		}
+		if l.Head() == "phead" {
+			a.PrintHeader = string(text)
+		} else {
+			a.PrintFooter = string(text)
+		}
+
+	case "printer":
+		printer, err := parsePrinterSettings(args)
+		if err != nil {
+			return err
+		}
+		a.Printer = printer
+
+	default:
+		a.Other = append(a.Other, e.String())
+	}
+	return nil
+}
+
+// Parses a zoom such as `page` or `d150`
+func parseAnnoZoom(s string) (Zoom, error) {
+	for zoom, name := range zoomNames {
+		if name == s {
+			return zoom, nil
+		}
+	}
+	percent, err := strconv.Atoi(strings.TrimPrefix(s, "d"))
+	if err != nil || !strings.HasPrefix(s, "d") || percent <= 0 {
+		return ZOOM_DEFAULT, errors.New("expected stretch, one2one, width, page or d<percent>")
+	}
+	return Zoom(percent), nil
+}
+
+// Formats a zoom as parsed by parseAnnoZoom
+func formatAnnoZoom(z Zoom) (string, error) {
+	if name, ok := zoomNames[z]; ok {
+		return name, nil
+	}
+	if z < 0 {
+		return "", errors.Errorf("unknown zoom %d", z)
+	}
+	return "d" + strconv.Itoa(int(z)), nil
+}
+
+// Parses the arguments of a `printer` annotation
+func parsePrinterSettings(args []miniexp.Expr) (*PrinterSettings, error) {
+	printer := &PrinterSettings{}
+	for _, arg := range args {
+		l, _ := arg.(miniexp.List)
+		var value string
+		if setting := l.Args(); len(setting) == 1 {
+			switch v := setting[0].(type) {
+			case miniexp.Symbol:
+				value = string(v)
+			case miniexp.Int:
+				value = v.String()
+			}
+		}
+
+		var err error
+		switch l.Head() {
+		case "copies":
+			if printer.Copies, err = strconv.Atoi(value); err == nil && printer.Copies <= 0 {
+				err = errors.New("expected a positive number")
+			}
+		case "zoom":
+			printer.Zoom, err = parseAnnoZoom(value)
+		case "mode":
+			var mode int
+			mode, err = parseEnum(displayModeNames, value)
+			printer.Mode = DisplayMode(mode)
+		case "landscape":
+			printer.Landscape, err = parseToggle(value)
+		default:
+			printer.Other = append(printer.Other, arg.String())
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "bad printer setting %s", arg)
+		}
+	}
+	return printer, nil
+}
+
+// Returns the `printer` annotation holding the settings
+func (p *PrinterSettings) expr() (miniexp.Expr, error) {
+	printer := miniexp.Call("printer")
+	if p.Copies < 0 {
+		return nil, errors.Errorf("invalid number of copies %d", p.Copies)
+	}
+	if p.Copies > 0 {
+		printer = append(printer, miniexp.Call("copies", miniexp.Int(p.Copies)))
+	}
+	if p.Zoom != ZOOM_DEFAULT {
+		name, err := formatAnnoZoom(p.Zoom)
+		if err != nil {
+			return nil, err
+		}
+		printer = append(printer, miniexp.Call("zoom", miniexp.Symbol(name)))
+	}
+	if p.Mode != DISPLAY_DEFAULT {
+		if int(p.Mode) >= len(displayModeNames) {
+			return nil, errors.Errorf("unknown mode %d", p.Mode)
+		}
+		printer = append(printer, miniexp.Call("mode", miniexp.Symbol(p.Mode.String())))
+	}
+	if p.Landscape != TOGGLE_DEFAULT {
+		if int(p.Landscape) >= len(toggleNames) {
+			return nil, errors.Errorf("unknown toggle %d", p.Landscape)
+		}
+		printer = append(printer, miniexp.Call("landscape", miniexp.Symbol(p.Landscape.String())))
+	}
+	for _, other := range p.Other {
+		e, err := miniexp.ParseString(other)
+		if err != nil {
+			return nil, errors.Wrapf(err, "bad printer setting %q", other)
+		}
+		printer = append(printer, e)
+	}
+	return printer, nil
+}
+
+func parseAnnoAlignment(e miniexp.Expr, allowed []Alignment) (Alignment, error) {
+	s, ok := e.(miniexp.Symbol)
+	if !ok {
+		return ALIGN_DEFAULT, errors.Errorf("expected an alignment instead of %s", e)
+	}
+	if s == "default" {
+		return ALIGN_DEFAULT, nil
+	}
+	return parseAlignment(string(s), allowed...)
+}
+
+// Encode writes the annotations as the text of an `ANTa` chunk,
+// one annotation per line.
+func (a *Anno) Encode(w io.Writer) error {
+	exprs, err := a.exprs()
+	if err != nil {
+		return err
+	}
+	for _, e := range exprs {
+		if _, err := fmt.Fprintln(w, e); err != nil {
+			return errors.Wrap(err, "could not write annotations")
+		}
+	}
+	return nil
+}
+
+func (a *Anno) exprs() ([]miniexp.Expr, error) {
+	var exprs []miniexp.Expr
+	if a.Background != nil {
+		exprs = append(exprs, miniexp.Call("background", miniexp.Symbol(formatColor(a.Background))))
+	}
+	if a.Zoom != ZOOM_DEFAULT {
+		name, err := formatAnnoZoom(a.Zoom)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, miniexp.Call("zoom", miniexp.Symbol(name)))
+	}
+	if a.Mode != DISPLAY_DEFAULT {
+		if int(a.Mode) >= len(displayModeNames) {
+			return nil, errors.Errorf("unknown mode %d", a.Mode)
+		}
+		exprs = append(exprs, miniexp.Call("mode", miniexp.Symbol(a.Mode.String())))
+	}
+	if a.HorAlign != ALIGN_DEFAULT || a.VerAlign != ALIGN_DEFAULT {
+		align := miniexp.Call("align")
+		for _, pair := range []struct {
+			value   Alignment
+			allowed []Alignment
+		}{{a.HorAlign, horAlignments}, {a.VerAlign, verAlignments}} {
+			name := "default"
+			if pair.value != ALIGN_DEFAULT {
+				if _, err := parseAlignment(pair.value.String(), pair.allowed...); err != nil {
+					return nil, errors.Wrap(err, "bad alignment")
+				}
+				name = pair.value.String()
+			}
+			align = append(align, miniexp.Symbol(name))
+		}
+		exprs = append(exprs, align)
+	}
+	if len(a.Metadata) > 0 {
+		if _, ok := a.Metadata[""]; ok {
+			return nil, errors.New("empty metadata key")
+		}
+		entries, err := miniexp.Marshal(a.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, miniexp.Call("metadata", entries.(miniexp.List)...))
+	}
+	if a.XMP != "" {
+		exprs = append(exprs, miniexp.Call("xmp", miniexp.String(a.XMP)))
+	}
+	for _, area := range a.MapAreas {
+		e, err := area.expr()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, e)
+	}
+	if a.Print != TOGGLE_DEFAULT {
+		if int(a.Print) >= len(toggleNames) {
+			return nil, errors.Errorf("unknown toggle %d", a.Print)
+		}
+		exprs = append(exprs, miniexp.Call("print", miniexp.Symbol(a.Print.String())))
+	}
+	if a.PrintHeader != "" {
+		exprs = append(exprs, miniexp.Call("phead", miniexp.String(a.PrintHeader)))
+	}
+	if a.PrintFooter != "" {
+		exprs = append(exprs, miniexp.Call("pfoot", miniexp.String(a.PrintFooter)))
+	}
+	if a.Printer != nil {
+		e, err := a.Printer.expr()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, e)
+	}
+	for _, other := range a.Other {
+		e, err := miniexp.ParseString(other)
+		if err != nil {
+			return nil, errors.Wrapf(err, "bad annotation %q", other)
+		}
+		exprs = append(exprs, e)
+	}
+	return exprs, nil
+}
+
+// Merge adds the annotations of other to a.
+// Annotations given in other replace those of a,
+// except for metadata entries, map areas and unknown annotations,
+// which are added to those of a.
+func (a *Anno) Merge(other *Anno) {
+	if other.Background != nil {
+		a.Background = other.Background
+	}
+	if other.Zoom != ZOOM_DEFAULT {
+		a.Zoom = other.Zoom
+	}
+	if other.Mode != DISPLAY_DEFAULT {
+		a.Mode = other.Mode
+	}
+	if other.HorAlign != ALIGN_DEFAULT {
+		a.HorAlign = other.HorAlign
+	}
+	if other.VerAlign != ALIGN_DEFAULT {
+		a.VerAlign = other.VerAlign
+	}
+	if len(other.Metadata) > 0 && a.Metadata == nil {
+		a.Metadata = make(map[string]string)
+	}
+	for key, value := range other.Metadata {
+		a.Metadata[key] = value
+	}
+	if other.XMP != "" {
+		a.XMP = other.XMP
+	}
+	a.MapAreas = append(a.MapAreas, other.MapAreas...)
+	if other.Print != TOGGLE_DEFAULT {
+		a.Print = other.Print
+	}
+	if other.PrintHeader != "" {
+		a.PrintHeader = other.PrintHeader
+	}
+	if other.PrintFooter != "" {
+		a.PrintFooter = other.PrintFooter
+	}
+	if other.Printer != nil {
+		a.Printer = other.Printer
+	}
+	a.Other = append(a.Other, other.Other...)
+}
+
+// DecodeChunk decodes an `ANTa` or `ANTz` chunk, replacing the annotations of a.
+func (a *Anno) DecodeChunk(chunk *iff.Chunk) error {
+	switch chunk.ID {
+	case "ANTa":
+		return a.Decode(bytes.NewReader(chunk.Data))
+	case "ANTz":
+		return a.Decode(bzz.NewReader(bytes.NewReader(chunk.Data)))
+	}
+	return errors.Errorf("%q is not an annotation chunk", chunk.ID)
+}
+
+// Chunk returns the annotations as an `ANTz` chunk if compress is set,
+// or as an `ANTa` chunk otherwise.
+func (a *Anno) Chunk(compress bool) (*iff.Chunk, error) {
+	var buf bytes.Buffer
+	if err := a.Encode(&buf); err != nil {
+		return nil, err
+	}
+	if !compress {
+		return &iff.Chunk{ID: "ANTa", Data: buf.Bytes()}, nil
+	}
+	data, err := bzz.Compress(buf.Bytes())
+	if err != nil {
+		return nil, errors.Wrap(err, "could not compress annotations")
+	}
+	return &iff.Chunk{ID: "ANTz", Data: data}, nil
+}
+
+// Returns whether id is that of an annotation chunk
+func isAnnoChunk(id string) bool { return id == "ANTa" || id == "ANTz" }
+
+// DecodeAnnoChunks merges the annotation chunks of a page,
+// given by its `FORM:DJVU` chunk, in the order they appear.
+// The Anno is empty if there are none.
+func DecodeAnnoChunks(form *iff.Chunk) (*Anno, error) {
+	retval := NewAnno()
+	for _, chunk := range form.Children {
+		if !isAnnoChunk(chunk.ID) {
+			continue
+		}
+		anno := NewAnno()
+		if err := anno.DecodeChunk(chunk); err != nil {
+			return nil, err
+		}
+		retval.Merge(anno)
+	}
+	return retval, nil
+}
+
+// SetAnnoChunks replaces the annotation chunks of a page,
+// given by its `FORM:DJVU` chunk, by a single chunk holding anno.
+// The chunk is put in place of the first annotation chunk,
+// or at the end of the page if there were none.
+// All annotation chunks are removed if anno is empty.
+func SetAnnoChunks(form *iff.Chunk, anno *Anno, compress bool) error {
+	var chunk *iff.Chunk
+	if !anno.IsEmpty() {
+		var err error
+		if chunk, err = anno.Chunk(compress); err != nil {
+			return err
+		}
+	}
+
+	children := make([]*iff.Chunk, 0, len(form.Children)+1)
+	for _, child := range form.Children {
+		if !isAnnoChunk(child.ID) {
+			children = append(children, child)
+		} else if chunk != nil {
+			children = append(children, chunk)
+			chunk = nil
+		}
+	}
+	if chunk != nil {
+		children = append(children, chunk)
+	}
+	form.Children = children
+	return nil
+}
+
+// GetPageAnno returns the annotations of page `page`, counted from 0.
+// Annotations of the files included by the page,
+// such as the annotations shared by all pages, come first
+// so that those of the page itself take precedence.
+func (doc *Document) GetPageAnno(ctx context.Context, page int) (*Anno, error) {
+	pool, err := doc.GetPageData(ctx, page)
+	if err != nil {
+		return nil, err
+	}
+	return doc.readAnno(ctx, pool, map[string]bool{})
+}
+
+// Reads the merged annotations of the file in pool.
+// Files in seen are not included again.
+func (doc *Document) readAnno(ctx context.Context, pool *DataPool, seen map[string]bool) (*Anno, error) {
+	form, err := iff.Decode(pool.NewReader(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read page")
+	}
+
+	retval := NewAnno()
+	for _, chunk := range form.Children {
+		if chunk.ID != "INCL" {
+			continue
+		}
+		id := strings.TrimSpace(string(chunk.Data))
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		included, err := doc.includedData(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		anno, err := doc.readAnno(ctx, included, seen)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not read included file %q", id)
+		}
+		retval.Merge(anno)
+	}
+
+	anno, err := DecodeAnnoChunks(form)
+	if err != nil {
+		return nil, err
+	}
+	retval.Merge(anno)
+	return retval, nil
+}
+
+// Parses a `#RRGGBB` color
+func parseColor(s string) (*color.RGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 || !strings.HasPrefix(s, "#") {
+		return nil, errors.Errorf("expected a #RRGGBB color instead of %q", s)
+	}
+	return &color.RGBA{R: uint8(rgb >> 16), G: uint8(rgb >> 8), B: uint8(rgb), A: 0xff}, nil
+}
+
+// Formats a color as `#RRGGBB`
+func formatColor(c *color.RGBA) string {
+	return fmt.Sprintf("#%02X%02X%02X", c.R, c.G, c.B)
+}
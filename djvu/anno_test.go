@@ -0,0 +1,234 @@
+package djvu
+
+import (
+	"bytes"
+	"context"
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/janreggie/go-djvulibre/djvu/iff"
+	"github.com/stretchr/testify/suite"
+)
+
+type AnnoTestSuite struct {
+	suite.Suite
+}
+
+func TestAnnoSuite(t *testing.T) {
+	suite.Run(t, new(AnnoTestSuite))
+}
+
+const testAnnoText = `(background #FFFFF0)
+(zoom d150)
+(mode bw)
+(align center default)
+(metadata (Author "A. Writer") (Title "The \"Book\"\n2nd ed."))
+(xmp "<x:xmpmeta/>")
+(maparea (url "http://example.com/" "_blank") "Example" (rect 10 20 100 50) (border #FF0000) (border_avis) (hilite #FFFF00) (opacity 0))
+(maparea "#+1" "" (line 0 0 50 50) (arrow) (width 3) (lineclr #0000FF))
+(maparea "" "Note" (text 5 5 80 20) (shadow_in 2) (backclr #FFFFFF) (textclr #000000) (pushpin) (custom 1))
+(print no)
+(phead "Header")
+(pfoot "Footer")
+(printer (copies 2) (zoom page) (mode bw) (landscape yes) (tray 1))
+(sound "ding.wav")
+`
+
+func (s *AnnoTestSuite) decode(text string) *Anno {
+	anno := NewAnno()
+	s.Require().NoError(anno.Decode(strings.NewReader(text)))
+	return anno
+}
+
+func (s *AnnoTestSuite) TestDecode() {
+	anno := s.decode(testAnnoText)
+	opacity := 0
+	s.Equal(&Anno{
+		Background: &color.RGBA{R: 0xff, G: 0xff, B: 0xf0, A: 0xff},
+		Zoom:       Zoom(150),
+		Mode:       DISPLAY_BW,
+		HorAlign:   ALIGN_CENTER,
+		Metadata:   map[string]string{"Author": "A. Writer", "Title": "The \"Book\"\n2nd ed."},
+		XMP:        "<x:xmpmeta/>",
+		MapAreas: []*MapArea{
+			{
+				URL: "http://example.com/", Target: "_blank", Comment: "Example",
+				Shape:  MapShape{Type: MAPAREA_RECT, Coords: []int{10, 20, 100, 50}},
+				Border: BORDER_SOLID, BorderColor: &color.RGBA{R: 0xff, A: 0xff}, BorderAlwaysVisible: true,
+				Hilite: &color.RGBA{R: 0xff, G: 0xff, A: 0xff}, Opacity: &opacity,
+			},
+			{
+				URL:   "#+1",
+				Shape: MapShape{Type: MAPAREA_LINE, Coords: []int{0, 0, 50, 50}},
+				Arrow: true, LineWidth: 3, LineColor: &color.RGBA{B: 0xff, A: 0xff},
+			},
+			{
+				Comment: "Note",
+				Shape:   MapShape{Type: MAPAREA_TEXT, Coords: []int{5, 5, 80, 20}},
+				Border:  BORDER_SHADOW_IN, BorderWidth: 2,
+				BackColor: &color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}, TextColor: &color.RGBA{A: 0xff},
+				Pushpin: true, Other: []string{"(custom 1)"},
+			},
+		},
+		Print:       TOGGLE_NO,
+		PrintHeader: "Header",
+		PrintFooter: "Footer",
+		Printer: &PrinterSettings{
+			Copies: 2, Zoom: ZOOM_FIT_PAGE, Mode: DISPLAY_BW, Landscape: TOGGLE_YES,
+			Other: []string{"(tray 1)"},
+		},
+		Other: []string{`(sound "ding.wav")`},
+	}, anno)
+	s.False(anno.IsEmpty())
+
+	s.True(s.decode("").IsEmpty())
+	s.True(s.decode("  \n\x00\x00garbage").IsEmpty())
+	s.Equal(ZOOM_FIT_PAGE, s.decode("(zoom page)").Zoom)
+	s.Equal(ALIGN_BOTTOM, s.decode("(align default bottom)").VerAlign)
+	s.Equal(Zoom(50), s.decode("(printer (zoom d50))").Printer.Zoom)
+	s.Equal(&PrinterSettings{}, s.decode("(printer)").Printer)
+}
+
+func (s *AnnoTestSuite) TestDecodeErrors() {
+	for _, text := range []string{
+		`(background red)`,
+		`(zoom 150)`,
+		`(mode sepia)`,
+		`(align top left)`,
+		`(metadata Author)`,
+		`(xmp)`,
+		`(maparea "url" "comment")`,
+		`(maparea "url" "comment" (star 1 2))`,
+		`(maparea "url" "comment" (rect 1 2 x 4))`,
+		`(maparea "url" "comment" (rect 1 2 3 4) (hilite yellow))`,
+		`(maparea "url" "comment" (rect 1 2 3 4)`,
+		`(xmp "unterminated)`,
+		`(print maybe)`,
+		`(phead Header)`,
+		`(printer (copies 0))`,
+		`(printer (copies two))`,
+		`(printer (mode sepia))`,
+		`)`,
+	} {
+		s.Error(NewAnno().Decode(strings.NewReader(text)), text)
+	}
+}
+
+func (s *AnnoTestSuite) TestRoundTrip() {
+	anno := s.decode(testAnnoText)
+	for _, compress := range []bool{false, true} {
+		chunk, err := anno.Chunk(compress)
+		s.Require().NoError(err)
+		if compress {
+			s.Equal("ANTz", chunk.ID)
+		} else {
+			s.Equal("ANTa", chunk.ID)
+			s.Contains(string(chunk.Data), `(metadata (Author "A. Writer") (Title "The \"Book\"\n2nd ed."))`)
+		}
+		decoded := NewAnno()
+		s.Require().NoError(decoded.DecodeChunk(chunk))
+		s.Equal(anno, decoded)
+	}
+
+	s.Error(NewAnno().DecodeChunk(&iff.Chunk{ID: "INFO"}))
+	s.Error((&Anno{Zoom: -5}).Encode(&bytes.Buffer{}))
+	s.Error((&Anno{HorAlign: ALIGN_TOP}).Encode(&bytes.Buffer{}))
+	s.Error((&Anno{Metadata: map[string]string{"": "x"}}).Encode(&bytes.Buffer{}))
+	s.Error((&Anno{MapAreas: []*MapArea{{Border: BORDER_SOLID}}}).Encode(&bytes.Buffer{}))
+	s.Error((&Anno{Print: TOGGLE_NO + 1}).Encode(&bytes.Buffer{}))
+	s.Error((&Anno{Printer: &PrinterSettings{Copies: -1}}).Encode(&bytes.Buffer{}))
+	s.Error((&Anno{Printer: &PrinterSettings{Other: []string{"(tray"}}}).Encode(&bytes.Buffer{}))
+}
+
+func (s *AnnoTestSuite) TestMerge() {
+	anno := s.decode(`(zoom d150) (mode bw) (metadata (Author "A") (Year "1999")) (maparea "a" "" (rect 0 0 1 1))`)
+	anno.Merge(s.decode(`(zoom width) (metadata (Year "2001")) (maparea "b" "" (oval 0 0 1 1))`))
+	s.Equal(ZOOM_FIT_WIDTH, anno.Zoom)
+	s.Equal(DISPLAY_BW, anno.Mode)
+	s.Equal(map[string]string{"Author": "A", "Year": "2001"}, anno.Metadata)
+	s.Len(anno.MapAreas, 2)
+
+	anno = s.decode(`(print no) (phead "A") (printer (copies 2))`)
+	anno.Merge(s.decode(`(pfoot "B") (printer (mode bw))`))
+	s.Equal(TOGGLE_NO, anno.Print)
+	s.Equal("A", anno.PrintHeader)
+	s.Equal("B", anno.PrintFooter)
+	s.Equal(&PrinterSettings{Mode: DISPLAY_BW}, anno.Printer)
+}
+
+func (s *AnnoTestSuite) TestSetAnnoChunks() {
+	form := testPage(100, 200)
+	form.Children = append(form.Children,
+		&iff.Chunk{ID: "ANTa", Data: []byte(`(mode bw)`)},
+		&iff.Chunk{ID: "TXTz"},
+		&iff.Chunk{ID: "ANTa", Data: []byte(`(zoom page)`)})
+
+	anno, err := DecodeAnnoChunks(form)
+	s.Require().NoError(err)
+	s.Equal(&Anno{Mode: DISPLAY_BW, Zoom: ZOOM_FIT_PAGE}, anno)
+
+	anno.Mode = DISPLAY_COLOR
+	s.NoError(SetAnnoChunks(form, anno, true))
+	s.Equal([]string{"INFO", "ANTz", "TXTz"}, chunkIDs(form))
+	decoded, err := DecodeAnnoChunks(form)
+	s.NoError(err)
+	s.Equal(anno, decoded)
+
+	s.NoError(SetAnnoChunks(form, NewAnno(), true))
+	s.Equal([]string{"INFO", "TXTz"}, chunkIDs(form))
+	s.NoError(SetAnnoChunks(form, anno, false))
+	s.Equal([]string{"INFO", "TXTz", "ANTa"}, chunkIDs(form))
+}
+
+func (s *AnnoTestSuite) TestGetPageAnno() {
+	shared := &iff.Chunk{ID: "FORM:DJVI", Children: []*iff.Chunk{
+		{ID: "ANTa", Data: []byte(`(background #000000) (mode fore) (metadata (Author "A"))`)},
+	}}
+	page := testPage(100, 200)
+	own, err := (&Anno{Mode: DISPLAY_BACK, Metadata: map[string]string{"Title": "T"}}).Chunk(true)
+	s.Require().NoError(err)
+	page.Children = append(page.Children, &iff.Chunk{ID: "INCL", Data: []byte("shared.djvu")}, own)
+
+	dir := NewMultiDir()
+	s.NoError(dir.InsertFile(&MultiDirFile{ID: "shared.djvu", Type: FILE_SHARED_ANNO}, -1))
+	s.NoError(dir.InsertFile(&MultiDirFile{ID: "p1.djvu", Type: FILE_PAGE}, -1))
+	var dirm bytes.Buffer
+	s.Require().NoError(dir.Encode(&dirm, false))
+
+	mem := NewMemoryPort()
+	for url, chunk := range map[string]*iff.Chunk{
+		"memory:index.djvu":  {ID: "FORM:DJVM", Children: []*iff.Chunk{{ID: "DIRM", Data: dirm.Bytes()}}},
+		"memory:shared.djvu": shared,
+		"memory:p1.djvu":     page,
+	} {
+		data, err := chunk.Bytes()
+		s.Require().NoError(err)
+		u, err := NewUrl(url)
+		s.Require().NoError(err)
+		mem.Add(u, data)
+	}
+
+	url, err := NewUrl("memory:index.djvu")
+	s.Require().NoError(err)
+	doc, err := OpenDocument(context.Background(), url, mem)
+	s.Require().NoError(err)
+	defer doc.Close()
+
+	anno, err := doc.GetPageAnno(context.Background(), 0)
+	s.Require().NoError(err)
+	s.Equal(&color.RGBA{A: 0xff}, anno.Background)
+	s.Equal(DISPLAY_BACK, anno.Mode)
+	s.Equal(map[string]string{"Author": "A", "Title": "T"}, anno.Metadata)
+
+	_, err = doc.GetPageAnno(context.Background(), 1)
+	s.Error(err)
+}
+
+func chunkIDs(form *iff.Chunk) []string {
+	var ids []string
+	for _, child := range form.Children {
+		ids = append(ids, child.ID)
+	}
+	return ids
+}
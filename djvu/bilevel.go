@@ -0,0 +1,264 @@
+package djvu
+
+import (
+	"bytes"
+
+	"github.com/janreggie/go-djvulibre/djvu/iff"
+	"github.com/janreggie/go-djvulibre/djvu/image"
+	"github.com/janreggie/go-djvulibre/djvu/jb2"
+	"github.com/pkg/errors"
+)
+
+// BilevelMode tells how much EncodeBilevelPage may change the bitmap
+type BilevelMode uint8
+
+const (
+	// Keeps every pixel
+	BILEVEL_LOSSLESS BilevelMode = iota
+	// Removes the specks
+	BILEVEL_CLEAN
+	// Removes the specks and draws marks which look alike with the same shape
+	BILEVEL_LOSSY
+)
+
+var bilevelModeNames = []string{"lossless", "clean", "lossy"}
+
+func (m BilevelMode) String() string { return enumName(bilevelModeNames, int(m)) }
+
+// BilevelOptions tells how EncodeBilevelPage encodes the bitmap.
+// Zero fields take their default values.
+type BilevelOptions struct {
+	Mode BilevelMode
+
+	// Number of pixels of the smallest groups of touching pixels kept
+	// by BILEVEL_CLEAN and BILEVEL_LOSSY, 4 by default
+	MinSize int
+
+	// How much marks drawn with the same shape by BILEVEL_LOSSY may differ,
+	// from 1 to 200, 100 by default
+	LossLevel int
+
+	// Whether to decode the page and check that it shows the bitmap,
+	// as cleaned and with marks substituted by the mode
+	Verify bool
+}
+
+// Default value of BilevelOptions.LossLevel
+const defaultLossLevel = 100
+
+// Largest difference of sizes, in pixels, of marks matched with each other
+const matchSlack = 2
+
+// EncodeBilevelPage encodes a black and white page into a `FORM:DJVU` chunk,
+// holding an `INFO` chunk and a `Sjbz` chunk.
+// Pixels of gray bitmaps are black if they are at least half black.
+// The resolution is 300 dots per inch if dpi is 0.
+//
+// Marks which look alike are coded as refinements of each other,
+// which keeps every pixel while taking less space.
+func EncodeBilevelPage(bm *image.Bitmap, dpi int, opts BilevelOptions) (*iff.Chunk, error) {
+	if opts.Mode > BILEVEL_LOSSY {
+		return nil, errors.Errorf("bad bilevel mode %v", opts.Mode)
+	}
+	if opts.MinSize == 0 {
+		opts.MinSize = defaultMinSize
+	}
+	if opts.LossLevel == 0 {
+		opts.LossLevel = defaultLossLevel
+	}
+	if opts.MinSize < 0 {
+		return nil, errors.Errorf("bad smallest size %d", opts.MinSize)
+	}
+	if opts.LossLevel < 0 || opts.LossLevel > 200 {
+		return nil, errors.Errorf("bad loss level %d", opts.LossLevel)
+	}
+	form, err := newPageForm(int(bm.Cols()), int(bm.Rows()), dpi, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	bilevel := bilevelBitmap(bm)
+	marks, err := jb2.NewImageFromBitmap(bilevel)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Mode != BILEVEL_LOSSLESS {
+		removeSpeckles(marks, opts.MinSize)
+	}
+	lossLevel := 0
+	if opts.Mode == BILEVEL_LOSSY {
+		lossLevel = opts.LossLevel
+	}
+	img := matchMarks(marks, lossLevel)
+
+	var sjbz bytes.Buffer
+	if err := img.Encode(&sjbz); err != nil {
+		return nil, err
+	}
+	form.Children = append(form.Children, &iff.Chunk{ID: "Sjbz", Data: sjbz.Bytes()})
+
+	if opts.Verify {
+		expected := bilevel
+		if opts.Mode != BILEVEL_LOSSLESS {
+			if expected, err = img.Bitmap(); err != nil {
+				return nil, err
+			}
+		}
+		if err := verifyBilevelPage(sjbz.Bytes(), expected); err != nil {
+			return nil, err
+		}
+	}
+	return form, nil
+}
+
+// Returns bm with its black pixels set to 1 and its white pixels to 0
+func bilevelBitmap(bm *image.Bitmap) *image.Bitmap {
+	retval := bm.CopyWithBorder(0)
+	grays := bm.GetGrays()
+	for rr := 0; rr < int(retval.Rows()); rr++ {
+		line := retval.GetLine(rr)
+		for cc, v := range line {
+			if 2*int(v) >= grays {
+				line[cc] = 1
+			} else {
+				line[cc] = 0
+			}
+		}
+	}
+	retval.SetGrays(2)
+	return retval
+}
+
+// Checks that the `Sjbz` chunk data decodes into expected
+func verifyBilevelPage(data []byte, expected *image.Bitmap) error {
+	decoded, err := jb2.Decode(bytes.NewReader(data), nil)
+	if err != nil {
+		return errors.Wrap(err, "could not decode encoded page")
+	}
+	actual, err := decoded.Bitmap()
+	if err != nil {
+		return errors.Wrap(err, "could not decode encoded page")
+	}
+	if actual.Rows() != expected.Rows() || actual.Cols() != expected.Cols() {
+		return errors.Errorf("encoded page is %dx%d rather than %dx%d",
+			actual.Cols(), actual.Rows(), expected.Cols(), expected.Rows())
+	}
+	for rr := 0; rr < int(expected.Rows()); rr++ {
+		if !bytes.Equal(actual.GetLine(rr), expected.GetLine(rr)) {
+			return errors.Errorf("encoded page differs from the bitmap on row %d", rr)
+		}
+	}
+	return nil
+}
+
+// A shape of an image made by matchMarks,
+// and where it goes relative to the mark it draws
+type markMatch struct {
+	shape  int
+	dx, dy int
+}
+
+// Returns the image drawing the blits of marks in the same order,
+// coding the shapes which look alike as refinements of each other.
+// If lossLevel is not 0, the shapes which differ little enough
+// are replaced by the first of them.
+func matchMarks(marks *jb2.Image, lossLevel int) *jb2.Image {
+	img := &jb2.Image{Width: marks.Width, Height: marks.Height}
+	bySize := make(map[[2]int][]int) // Shapes of img by their size
+	matches := make(map[int]markMatch)
+	for _, blit := range marks.Blits {
+		m, ok := matches[blit.Shape]
+		if !ok {
+			m = matchShape(img, bySize, marks.Shape(blit.Shape).Bits, lossLevel)
+			matches[blit.Shape] = m
+		}
+		img.Blits = append(img.Blits, jb2.Blit{Left: blit.Left + m.dx, Bottom: blit.Bottom + m.dy, Shape: m.shape})
+	}
+	return img
+}
+
+// Returns the shape of img for bits, adding it if it is not substituted
+func matchShape(img *jb2.Image, bySize map[[2]int][]int, bits *image.Bitmap, lossLevel int) markMatch {
+	w, h := int(bits.Cols()), int(bits.Rows())
+	black := blackPixels(bits, w*h)
+
+	best, bestDiff, bestWeighted := -1, 0, 0
+	for ww := w - matchSlack; ww <= w+matchSlack; ww++ {
+		for hh := h - matchSlack; hh <= h+matchSlack; hh++ {
+			for _, shape := range bySize[[2]int{ww, hh}] {
+				diff, weighted := markDifference(bits, img.Shape(shape).Bits)
+				if best < 0 || weighted < bestWeighted {
+					best, bestDiff, bestWeighted = shape, diff, weighted
+				}
+			}
+		}
+	}
+
+	if best >= 0 && lossLevel > 0 && bestWeighted*1000 <= black*lossLevel {
+		parent := img.Shape(best).Bits
+		return markMatch{shape: best, dx: w/2 - int(parent.Cols())/2, dy: h/2 - int(parent.Rows())/2}
+	}
+	shape := jb2.Shape{Bits: bits, Parent: jb2.NO_PARENT}
+	if best >= 0 && bestDiff*5 <= black {
+		shape.Parent = best
+	}
+	m := markMatch{shape: img.AddShape(shape)}
+	bySize[[2]int{w, h}] = append(bySize[[2]int{w, h}], m.shape)
+	return m
+}
+
+// Returns the number of pixels which differ between a and b
+// when their centers are aligned, and the sum of the pixels which differ
+// weighted by one plus the number of their neighbours which differ,
+// so that differences which cluster weigh more
+func markDifference(a, b *image.Bitmap) (int, int) {
+	aw, ah, bw, bh := int(a.Cols()), int(a.Rows()), int(b.Cols()), int(b.Rows())
+	// Column x of b goes on column x+dx of a
+	dx, dy := aw/2-bw/2, ah/2-bh/2
+	left, bottom := 0, 0
+	if dx < 0 {
+		left = dx
+	}
+	if dy < 0 {
+		bottom = dy
+	}
+	right, top := aw, ah
+	if bw+dx > right {
+		right = bw + dx
+	}
+	if bh+dy > top {
+		top = bh + dy
+	}
+
+	w, h := right-left, top-bottom
+	differs := make([]bool, w*h)
+	for y := bottom; y < top; y++ {
+		for x := left; x < right; x++ {
+			var va, vb byte
+			if y >= 0 && y < ah && x >= 0 && x < aw {
+				va = a.GetLine(y)[x]
+			}
+			if y-dy >= 0 && y-dy < bh && x-dx >= 0 && x-dx < bw {
+				vb = b.GetLine(y - dy)[x-dx]
+			}
+			differs[(y-bottom)*w+x-left] = (va != 0) != (vb != 0)
+		}
+	}
+
+	diff, weighted := 0, 0
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if !differs[y*w+x] {
+				continue
+			}
+			diff++
+			weighted++
+			for _, n := range [][2]int{{x - 1, y}, {x + 1, y}, {x, y - 1}, {x, y + 1}} {
+				if n[0] >= 0 && n[0] < w && n[1] >= 0 && n[1] < h && differs[n[1]*w+n[0]] {
+					weighted++
+				}
+			}
+		}
+	}
+	return diff, weighted
+}
@@ -0,0 +1,256 @@
+package djvu
+
+import (
+	"bytes"
+	"fmt"
+	stdimage "image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"github.com/janreggie/go-djvulibre/djvu/iff"
+	"github.com/janreggie/go-djvulibre/djvu/image"
+	"github.com/stretchr/testify/suite"
+)
+
+type BilevelTestSuite struct {
+	suite.Suite
+	Rand *rand.Rand
+}
+
+func TestBilevelSuite(t *testing.T) {
+	suite.Run(t, new(BilevelTestSuite))
+}
+
+func (s *BilevelTestSuite) SetupTest() {
+	s.Rand = rand.New(rand.NewSource(1))
+}
+
+// Returns a 300x200 bitmap with lines of rings, each with a few pixels flipped,
+// and specks of one pixel
+func (s *BilevelTestSuite) bitmap() *image.Bitmap {
+	bm, err := image.NewBitmap(200, 300, 0)
+	s.Require().NoError(err)
+	for top := 10; top+16 < 200; top += 30 {
+		for left := 10; left+12 < 300; left += 20 {
+			for rr := 0; rr < 16; rr++ {
+				line := bm.GetLine(top + rr)
+				for cc := 0; cc < 12; cc++ {
+					dx, dy := 2*cc-11, 2*rr-15
+					d := dx*dx*16*16 + dy*dy*12*12
+					if d < 12*12*16*16 && d > 7*7*16*16 {
+						line[left+cc] = 1
+					}
+					if cc > 0 && cc < 11 && s.Rand.Intn(60) == 0 {
+						line[left+cc] ^= 1
+					}
+				}
+			}
+		}
+	}
+	for _, speck := range [][2]int{{5, 5}, {290, 195}, {150, 2}} {
+		bm.GetLine(speck[1])[speck[0]] = 1
+	}
+	return bm
+}
+
+// Returns the mask of page, which has nothing but an `INFO` and a `Sjbz` chunk
+func (s *BilevelTestSuite) decode(form *iff.Chunk) (*Page, *image.Bitmap) {
+	var buf bytes.Buffer
+	s.Require().NoError(form.Encode(&buf, true))
+	decoded, err := iff.DecodeBytes(buf.Bytes())
+	s.Require().NoError(err)
+	s.Equal([]string{"INFO", "Sjbz"}, chunkIDs(decoded))
+	page, err := NewPage(0, decoded)
+	s.Require().NoError(err)
+	mask, err := page.Mask()
+	s.Require().NoError(err)
+	bm, err := mask.Bitmap()
+	s.Require().NoError(err)
+	return page, bm
+}
+
+// Checks that cleaned is bm without its specks, among which those of s.bitmap
+func (s *BilevelTestSuite) cleaned(bm, cleaned *image.Bitmap) {
+	n := 0
+	for rr := 0; rr < int(bm.Rows()); rr++ {
+		line := cleaned.GetLine(rr)
+		for cc, v := range bm.GetLine(rr) {
+			if v != line[cc] {
+				s.Equal(byte(0), line[cc])
+				n++
+			}
+		}
+	}
+	s.Less(n, 100)
+	for _, speck := range [][2]int{{5, 5}, {290, 195}, {150, 2}} {
+		s.Equal(byte(0), cleaned.GetLine(speck[1])[speck[0]])
+	}
+}
+
+// Returns the number of pixels which differ between a and b
+func (s *BilevelTestSuite) differences(a, b *image.Bitmap) int {
+	s.Require().Equal(a.Rows(), b.Rows())
+	s.Require().Equal(a.Cols(), b.Cols())
+	n := 0
+	for rr := 0; rr < int(a.Rows()); rr++ {
+		lb := b.GetLine(rr)
+		for cc, v := range a.GetLine(rr) {
+			if (v != 0) != (lb[cc] != 0) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func (s *BilevelTestSuite) TestModes() {
+	bm := s.bitmap()
+	form, err := EncodeBilevelPage(bm, 0, BilevelOptions{Verify: true})
+	s.Require().NoError(err)
+	page, decoded := s.decode(form)
+	s.Equal(uint16(300), page.Info.Dpi)
+	s.Equal(0, s.differences(bm, decoded))
+	lossless := len(form.Find("Sjbz").Data)
+
+	// Rings which look alike refine each other
+	marks, err := NewPage(0, form)
+	s.Require().NoError(err)
+	mask, err := marks.Mask()
+	s.Require().NoError(err)
+	refined := 0
+	for _, shape := range mask.Shapes {
+		if shape.Parent >= 0 {
+			refined++
+		}
+	}
+	s.Greater(refined, 0)
+
+	form, err = EncodeBilevelPage(bm, 600, BilevelOptions{Mode: BILEVEL_CLEAN, Verify: true})
+	s.Require().NoError(err)
+	page, decoded = s.decode(form)
+	s.Equal(uint16(600), page.Info.Dpi)
+	s.cleaned(bm, decoded)
+	clean := decoded
+
+	form, err = EncodeBilevelPage(bm, 0, BilevelOptions{Mode: BILEVEL_LOSSY, Verify: true})
+	s.Require().NoError(err)
+	_, decoded = s.decode(form)
+	s.Less(len(form.Find("Sjbz").Data), lossless)
+	s.Greater(s.differences(clean, decoded), 0)
+	s.Less(s.differences(clean, decoded), 300*200/20)
+
+	// Keeping larger specks
+	form, err = EncodeBilevelPage(bm, 0, BilevelOptions{Mode: BILEVEL_CLEAN, MinSize: 1})
+	s.Require().NoError(err)
+	_, decoded = s.decode(form)
+	s.Equal(0, s.differences(bm, decoded))
+
+	// Matching nothing
+	form, err = EncodeBilevelPage(bm, 0, BilevelOptions{Mode: BILEVEL_LOSSY, LossLevel: 1, Verify: true})
+	s.Require().NoError(err)
+	_, decoded = s.decode(form)
+	s.Equal(0, s.differences(clean, decoded))
+
+	blank, err := image.NewBitmap(10, 10, 0)
+	s.Require().NoError(err)
+	form, err = EncodeBilevelPage(blank, 0, BilevelOptions{Verify: true})
+	s.Require().NoError(err)
+	_, decoded = s.decode(form)
+	s.Equal(0, s.differences(blank, decoded))
+}
+
+func (s *BilevelTestSuite) TestErrors() {
+	bm := s.bitmap()
+	for _, opts := range []BilevelOptions{
+		{Mode: BILEVEL_LOSSY + 1},
+		{MinSize: -1},
+		{LossLevel: 201},
+	} {
+		_, err := EncodeBilevelPage(bm, 0, opts)
+		s.Error(err, "%+v", opts)
+	}
+	_, err := EncodeBilevelPage(bm, -1, BilevelOptions{})
+	s.Error(err)
+	_, err = EncodeBilevelPage(image.NewEmptyBitmap(), 0, BilevelOptions{})
+	s.Error(err)
+
+	s.Equal("lossy", BILEVEL_LOSSY.String())
+	s.Equal("3", BilevelMode(3).String())
+}
+
+func (s *BilevelTestSuite) TestInput() {
+	bm := s.bitmap()
+	w, h := int(bm.Cols()), int(bm.Rows())
+
+	// Raw and plain PBM, from the top row
+	var raw, plain, pgm, rle bytes.Buffer
+	fmt.Fprintf(&raw, "P4\n# comment\n%d %d\n", w, h)
+	fmt.Fprintf(&plain, "P1\n%d %d\n", w, h)
+	fmt.Fprintf(&pgm, "P5 %d %d 255\n", w, h)
+	fmt.Fprintf(&rle, "R4\n%d %d\n", w, h)
+	for rr := h - 1; rr >= 0; rr-- {
+		line := bm.GetLine(rr)
+		packed := make([]byte, (w+7)/8)
+		run, last := 0, byte(0)
+		writeRun := func() {
+			if run >= 0xc0 {
+				rle.WriteByte(byte(0xc0 + run>>8))
+			}
+			rle.WriteByte(byte(run))
+		}
+		for cc, v := range line {
+			if v != 0 {
+				packed[cc/8] |= 0x80 >> (cc % 8)
+			}
+			fmt.Fprintf(&plain, "%d", v)
+			pgm.WriteByte(255 - 255*v)
+			if v != last {
+				writeRun()
+				run, last = 0, v
+			}
+			run++
+		}
+		writeRun()
+		raw.Write(packed)
+		plain.WriteByte('\n')
+	}
+
+	for _, data := range []*bytes.Buffer{&raw, &plain, &pgm, &rle} {
+		read, err := image.NewBitmapFromStream(data, 0)
+		s.Require().NoError(err)
+		s.Equal(0, s.differences(bm, read))
+		form, err := EncodeBilevelPage(read, 0, BilevelOptions{Verify: true})
+		s.Require().NoError(err)
+		_, decoded := s.decode(form)
+		s.Equal(0, s.differences(bm, decoded))
+	}
+
+	// Gray levels
+	pgm.Reset()
+	fmt.Fprintf(&pgm, "P2\n4 1\n10\n0 4 6 10\n")
+	gray, err := image.NewBitmapFromStream(&pgm, 0)
+	s.Require().NoError(err)
+	form, err := EncodeBilevelPage(gray, 0, BilevelOptions{Verify: true})
+	s.Require().NoError(err)
+	_, decoded := s.decode(form)
+	s.Equal([]byte{1, 1, 0, 0}, decoded.GetLine(0))
+
+	for _, bad := range []string{"P1\n70000 1\n", "P2\n1 1\n10\n11\n", "P4\n8 2\n\x00"} {
+		_, err := image.NewBitmapFromStream(bytes.NewReader([]byte(bad)), 0)
+		s.Error(err, "%q", bad)
+	}
+
+	// Images, from the top row
+	img := stdimage.NewGray(stdimage.Rect(0, 0, 3, 2))
+	for ii := range img.Pix {
+		img.Pix[ii] = 0xff
+	}
+	img.Set(0, 0, color.Black)
+	img.Set(2, 1, color.Gray{Y: 0x7f})
+	img.Set(1, 1, color.Gray{Y: 0x80})
+	read, err := image.NewBitmapFromImage(img)
+	s.Require().NoError(err)
+	s.Equal([]byte{1, 0, 0}, read.GetLine(1))
+	s.Equal([]byte{0, 0, 1}, read.GetLine(0))
+}
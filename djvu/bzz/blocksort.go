@@ -0,0 +1,86 @@
+package bzz
+
+// blocksort applies the Burrows-Wheeler transform to data
+// terminated by a virtual end-of-block marker smaller than any byte.
+// Returns the transformed data, one byte longer than data,
+// and the position of the marker in it.
+func blocksort(data []byte) ([]byte, int) {
+	n := len(data) + 1
+
+	// Rank of each rotation according to its first k bytes,
+	// sorted by prefix doubling.
+	// The marker is unique, so sorting rotations sorts suffixes.
+	rank := make([]int, n)
+	for ii, c := range data {
+		rank[ii] = int(c) + 1
+	}
+	rank[n-1] = 0
+
+	sa := make([]int, n)
+	tmp := make([]int, n)
+	cnt := make([]int, maxInt(257, n)+1)
+	countingSort := func(keys func(int) int, src, dst []int, classes int) {
+		for ii := 0; ii <= classes; ii++ {
+			cnt[ii] = 0
+		}
+		for _, ii := range src {
+			cnt[keys(ii)+1]++
+		}
+		for ii := 1; ii <= classes; ii++ {
+			cnt[ii] += cnt[ii-1]
+		}
+		for _, ii := range src {
+			k := keys(ii)
+			dst[cnt[k]] = ii
+			cnt[k]++
+		}
+	}
+
+	for ii := range tmp {
+		tmp[ii] = ii
+	}
+	countingSort(func(ii int) int { return rank[ii] }, tmp, sa, 257)
+
+	classes := 257
+	next := make([]int, n)
+	for k := 1; k < n; k <<= 1 {
+		// Sort by the second half, which is already sorted by sa
+		for ii, pos := range sa {
+			tmp[ii] = (pos - k + n) % n
+		}
+		// Then stably by the first half
+		countingSort(func(ii int) int { return rank[ii] }, tmp, sa, classes)
+
+		next[sa[0]] = 0
+		classes = 1
+		for ii := 1; ii < n; ii++ {
+			cur, prev := sa[ii], sa[ii-1]
+			if rank[cur] != rank[prev] || rank[(cur+k)%n] != rank[(prev+k)%n] {
+				classes++
+			}
+			next[cur] = classes - 1
+		}
+		rank, next = next, rank
+		if classes == n {
+			break
+		}
+	}
+
+	result := make([]byte, n)
+	markerpos := 0
+	for ii, pos := range sa {
+		if pos == 0 {
+			markerpos = ii // Preceded by the marker
+			continue
+		}
+		result[ii] = data[pos-1]
+	}
+	return result, markerpos
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,73 @@
+package bzz
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type BZZTestSuite struct {
+	suite.Suite
+}
+
+func TestBZZSuite(t *testing.T) {
+	suite.Run(t, new(BZZTestSuite))
+}
+
+func (s *BZZTestSuite) roundTrip(data []byte, blockSize int) []byte {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, blockSize)
+	_, err := w.Write(data)
+	s.Require().NoError(err)
+	s.Require().NoError(w.Close())
+
+	got, err := io.ReadAll(NewReader(&buf))
+	s.Require().NoError(err)
+	s.Equal(len(data), len(got))
+	s.True(bytes.Equal(data, got))
+	return buf.Bytes()
+}
+
+func (s *BZZTestSuite) TestBlocksort() {
+	// The classic example, with the marker shown as '$'
+	data, markerpos := blocksort([]byte("banana"))
+	data[markerpos] = '$'
+	s.Equal("annb$aa", string(data))
+
+	back, err := unsort(data, markerpos)
+	s.NoError(err)
+	s.Equal("banana", string(back))
+}
+
+func (s *BZZTestSuite) TestRoundTrip() {
+	s.roundTrip(nil, DefaultBlockSize)
+	s.roundTrip([]byte("a"), DefaultBlockSize)
+	s.roundTrip([]byte("(bookmarks (\"Chapter 1\" \"#1\"))"), DefaultBlockSize)
+
+	rng := rand.New(rand.NewSource(1))
+	random := make([]byte, 30000)
+	rng.Read(random)
+	s.roundTrip(random, MinBlockSize) // Several blocks
+
+	text := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 3000)
+	compressed := s.roundTrip(text, DefaultBlockSize)
+	s.Less(len(compressed), len(text)/50)
+
+	s.roundTrip(bytes.Repeat([]byte{0}, 200000), MaxBlockSize) // Faster adaptation
+}
+
+func (s *BZZTestSuite) TestCompress() {
+	compressed, err := Compress([]byte("hello, hello, hello"))
+	s.NoError(err)
+	data, err := Decompress(compressed)
+	s.NoError(err)
+	s.Equal("hello, hello, hello", string(data))
+}
+
+func (s *BZZTestSuite) TestCorrupt() {
+	_, err := Decompress([]byte{0x12, 0x34, 0x56, 0x78, 0x9a})
+	s.Error(err)
+}
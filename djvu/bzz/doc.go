@@ -0,0 +1,79 @@
+// Package bzz implements the BZZ general purpose compression format
+// used by DjVu for text chunks such as DIRM, ANTz, TXTz and NAVM.
+//
+// BZZ splits the data into blocks,
+// applies the Burrows-Wheeler transform to each block,
+// turns the result into small numbers with an adaptive move-to-front
+// and codes them with the ZP-Coder.
+// The stream ends with an empty block.
+package bzz
+
+// Size limits of a block, in KiB
+const (
+	MinBlockSize = 10
+	MaxBlockSize = 4096
+
+	// DefaultBlockSize is suitable for the small chunks found in DjVu documents
+	DefaultBlockSize = 100
+)
+
+// Number of contexts used for the first two move-to-front tests
+const ctxIDs = 3
+
+// Number of move-to-front positions whose frequency is tracked
+const freqMax = 4
+
+// Blocks larger than these sizes adapt the move-to-front faster
+const (
+	freqs0 = 100000
+	freqs1 = 1000000
+)
+
+// mtf is the adaptive move-to-front shared by the encoder and the decoder.
+type mtf struct {
+	mtf   [256]byte
+	rmtf  [256]int // Position of each byte in mtf
+	freq  [freqMax]uint32
+	fadd  uint32
+	shift uint
+}
+
+func newMTF(shift uint) *mtf {
+	m := &mtf{fadd: 4, shift: shift}
+	for ii := range m.mtf {
+		m.mtf[ii] = byte(ii)
+		m.rmtf[ii] = ii
+	}
+	return m
+}
+
+// Moves the byte c found at position no according to the empirical frequencies.
+func (m *mtf) rotate(no int, c byte) {
+	// Adjust frequencies for overflow
+	m.fadd += m.fadd >> m.shift
+	if m.fadd > 0x10000000 {
+		m.fadd >>= 24
+		for ii := range m.freq {
+			m.freq[ii] >>= 24
+		}
+	}
+
+	// Relocate the byte according to the new frequency
+	fc := m.fadd
+	if no < freqMax {
+		fc += m.freq[no]
+	}
+	k := no
+	for ; k >= freqMax; k-- {
+		m.mtf[k] = m.mtf[k-1]
+		m.rmtf[m.mtf[k]] = k
+	}
+	for ; k > 0 && fc >= m.freq[k-1]; k-- {
+		m.mtf[k] = m.mtf[k-1]
+		m.freq[k] = m.freq[k-1]
+		m.rmtf[m.mtf[k]] = k
+	}
+	m.mtf[k] = c
+	m.freq[k] = fc
+	m.rmtf[c] = k
+}
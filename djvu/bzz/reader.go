@@ -0,0 +1,179 @@
+package bzz
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/janreggie/go-djvulibre/djvu/zp"
+	"github.com/pkg/errors"
+)
+
+// ErrCorrupt is returned when the compressed data is not valid BZZ.
+var ErrCorrupt = errors.New("corrupt BZZ data")
+
+// Reader decompresses a BZZ stream.
+type Reader struct {
+	zp    *zp.Decoder
+	ctx   [300]zp.Context
+	block []byte
+	pos   int
+	err   error
+}
+
+// NewReader creates a Reader decompressing the data read from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{zp: zp.NewDecoder(r)}
+}
+
+// Decompress decompresses a whole BZZ stream.
+func Decompress(data []byte) ([]byte, error) {
+	return io.ReadAll(NewReader(bytes.NewReader(data)))
+}
+
+// Read implements io.Reader
+func (r *Reader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if r.pos == len(r.block) {
+			if r.err != nil {
+				break
+			}
+			r.err = r.decodeBlock()
+			continue
+		}
+		copied := copy(p[n:], r.block[r.pos:])
+		n += copied
+		r.pos += copied
+	}
+	if n > 0 {
+		return n, nil
+	}
+	return 0, r.err
+}
+
+// Decodes a number of the given bits without context
+func (r *Reader) decodeRaw(bits uint) int {
+	n, m := 1, 1<<bits
+	for n < m {
+		n = (n << 1) | r.zp.DecodePassthrough()
+	}
+	return n - m
+}
+
+// Decodes a number of the given bits with the contexts ctx[0:2^bits-1]
+func (r *Reader) decodeBinary(ctx []zp.Context, bits uint) int {
+	n, m := 1, 1<<bits
+	for n < m {
+		n = (n << 1) | r.zp.Decode(&ctx[n-1])
+	}
+	return n - m
+}
+
+// Decodes the next block into r.block.
+// Returns io.EOF on the final empty block.
+func (r *Reader) decodeBlock() error {
+	size := r.decodeRaw(24)
+	if err := r.zp.Err(); err != nil {
+		return errors.Wrap(err, "could not read BZZ block size")
+	}
+	if size == 0 {
+		return io.EOF
+	}
+	if size > MaxBlockSize*1024 {
+		return errors.Wrapf(ErrCorrupt, "block of %d bytes is too large", size)
+	}
+
+	// Decode the estimation speed
+	shift := uint(0)
+	if r.zp.DecodePassthrough() != 0 {
+		shift++
+		if r.zp.DecodePassthrough() != 0 {
+			shift++
+		}
+	}
+
+	data := make([]byte, size)
+	m := newMTF(shift)
+	no := 3
+	markerpos := -1
+	for ii := 0; ii < size; ii++ {
+		ctxid := ctxIDs - 1
+		if ctxid > no {
+			ctxid = no
+		}
+		cx := r.ctx[:]
+		switch {
+		case r.zp.Decode(&cx[ctxid]) != 0:
+			no = 0
+		case r.zp.Decode(&cx[ctxIDs+ctxid]) != 0:
+			no = 1
+		default:
+			cx = cx[2*ctxIDs:]
+			no = 256
+			for bits := uint(1); bits < 8; bits++ {
+				if r.zp.Decode(&cx[0]) != 0 {
+					no = 1<<bits + r.decodeBinary(cx[1:], bits)
+					break
+				}
+				cx = cx[1<<bits:]
+			}
+		}
+
+		if no == 256 {
+			data[ii] = 0
+			markerpos = ii
+			continue
+		}
+		data[ii] = m.mtf[no]
+		m.rotate(no, data[ii])
+	}
+	if err := r.zp.Err(); err != nil {
+		return errors.Wrap(err, "could not read BZZ block")
+	}
+
+	block, err := unsort(data, markerpos)
+	if err != nil {
+		return err
+	}
+	r.block, r.pos = block, 0
+	return nil
+}
+
+// unsort undoes the Burrows-Wheeler transform of data,
+// whose end-of-block marker is at markerpos.
+func unsort(data []byte, markerpos int) ([]byte, error) {
+	size := len(data)
+	if markerpos < 1 || markerpos >= size {
+		return nil, errors.Wrap(ErrCorrupt, "bad block marker")
+	}
+
+	// Occurrence index of each byte among equal bytes
+	posn := make([]uint32, size)
+	var count [256]int
+	for ii, c := range data {
+		if ii == markerpos {
+			continue
+		}
+		posn[ii] = uint32(c)<<24 | uint32(count[c]&0xffffff)
+		count[c]++
+	}
+
+	// Sorted positions, the marker being the smallest
+	last := 1
+	for ii := range count {
+		count[ii], last = last, last+count[ii]
+	}
+
+	result := make([]byte, size-1)
+	ii := 0
+	for last = size - 1; last > 0; {
+		c := byte(posn[ii] >> 24)
+		last--
+		result[last] = c
+		ii = count[c] + int(posn[ii]&0xffffff)
+	}
+	if ii != markerpos {
+		return nil, errors.Wrap(ErrCorrupt, "bad block marker")
+	}
+	return result, nil
+}
@@ -0,0 +1,161 @@
+package bzz
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/janreggie/go-djvulibre/djvu/zp"
+	"github.com/pkg/errors"
+)
+
+// Writer compresses data into a BZZ stream.
+// Close must be called to write the final block.
+type Writer struct {
+	zp        *zp.Encoder
+	ctx       [300]zp.Context
+	block     []byte
+	blockSize int // In bytes, including the marker
+	closed    bool
+}
+
+// NewWriter creates a Writer compressing into w
+// using blocks of blockSize KiB.
+// The block size is clamped to [MinBlockSize, MaxBlockSize].
+func NewWriter(w io.Writer, blockSize int) *Writer {
+	if blockSize < MinBlockSize {
+		blockSize = MinBlockSize
+	}
+	if blockSize > MaxBlockSize {
+		blockSize = MaxBlockSize
+	}
+	return &Writer{zp: zp.NewEncoder(w), blockSize: blockSize * 1024}
+}
+
+// Compress compresses data using DefaultBlockSize.
+func Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, DefaultBlockSize)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Write implements io.Writer
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to closed BZZ writer")
+	}
+	n := 0
+	for n < len(p) {
+		room := w.blockSize - 1 - len(w.block)
+		if room > len(p)-n {
+			room = len(p) - n
+		}
+		w.block = append(w.block, p[n:n+room]...)
+		n += room
+		if len(w.block) == w.blockSize-1 {
+			w.encodeBlock()
+		}
+	}
+	return n, nil
+}
+
+// Close writes the pending block and the end of the stream.
+// The underlying writer is not closed.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if len(w.block) > 0 {
+		w.encodeBlock()
+	}
+	w.encodeRaw(24, 0)
+	return errors.Wrap(w.zp.Close(), "could not write BZZ data")
+}
+
+// Encodes x in the given bits without context
+func (w *Writer) encodeRaw(bits uint, x int) {
+	for ii := int(bits) - 1; ii >= 0; ii-- {
+		w.zp.EncodePassthrough((x >> uint(ii)) & 1)
+	}
+}
+
+// Encodes x in the given bits with the contexts ctx[0:2^bits-1]
+func (w *Writer) encodeBinary(ctx []zp.Context, bits uint, x int) {
+	n := 1
+	for ii := int(bits) - 1; ii >= 0; ii-- {
+		b := (x >> uint(ii)) & 1
+		w.zp.Encode(b, &ctx[n-1])
+		n = (n << 1) | b
+	}
+}
+
+func (w *Writer) encodeBlock() {
+	data, markerpos := blocksort(w.block)
+	w.block = w.block[:0]
+	size := len(data)
+
+	w.encodeRaw(24, size)
+
+	// Determine and encode the estimation speed
+	shift := uint(0)
+	switch {
+	case size < freqs0:
+		w.zp.EncodePassthrough(0)
+	case size < freqs1:
+		shift = 1
+		w.zp.EncodePassthrough(1)
+		w.zp.EncodePassthrough(0)
+	default:
+		shift = 2
+		w.zp.EncodePassthrough(1)
+		w.zp.EncodePassthrough(1)
+	}
+
+	m := newMTF(shift)
+	no := 3
+	for ii, c := range data {
+		ctxid := ctxIDs - 1
+		if ctxid > no {
+			ctxid = no
+		}
+		no = m.rmtf[c]
+		if ii == markerpos {
+			no = 256
+		}
+
+		cx := w.ctx[:]
+		w.zp.Encode(b2i(no == 0), &cx[ctxid])
+		if no != 0 {
+			w.zp.Encode(b2i(no == 1), &cx[ctxIDs+ctxid])
+		}
+		if no > 1 {
+			cx = cx[2*ctxIDs:]
+			for bits := uint(1); bits < 8; bits++ {
+				below := no < 1<<(bits+1)
+				w.zp.Encode(b2i(below), &cx[0])
+				if below {
+					w.encodeBinary(cx[1:], bits, no-1<<bits)
+					break
+				}
+				cx = cx[1<<bits:]
+			}
+		}
+
+		if no != 256 {
+			m.rotate(no, c)
+		}
+	}
+}
+
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,229 @@
+package djvu
+
+import (
+	"bytes"
+
+	"github.com/janreggie/go-djvulibre/djvu/iff"
+	"github.com/janreggie/go-djvulibre/djvu/image"
+	"github.com/janreggie/go-djvulibre/djvu/iw44"
+	"github.com/janreggie/go-djvulibre/djvu/jb2"
+	"github.com/pkg/errors"
+)
+
+// Layers are the separated layers of a page, which ComposePage encodes.
+// The page shows the foreground colors through the mask over the background.
+//
+// The background and the foreground may be smaller than the page
+// by an integer factor from 1 to 12, their sizes being rounded up:
+// a background of 100x75 pixels suits a page of 300x224 pixels, reduced by 3.
+type Layers struct {
+	// Size of the page in pixels
+	Width, Height int
+	// Resolution of the page in dots per inch, 300 if 0
+	Dpi int
+	// Gamma of the display the colors are meant for, 2.2 if 0
+	Gamma float64
+
+	// Mask of the foreground, of the size of the page, such as the text.
+	// Its nonzero pixels show the foreground.
+	// Nil for pages without foreground.
+	Mask *image.Bitmap
+
+	// Colors of the foreground, black if nil
+	Foreground *image.Pixmap
+
+	// Whether the foreground is encoded as a palette,
+	// giving each group of touching pixels of the mask
+	// the average color of the foreground under it,
+	// rather than as an image.
+	// Palettes suit text in a few plain colors.
+	ForegroundPalette bool
+
+	// Background image, white if nil
+	Background *image.Pixmap
+}
+
+// Number of slices of the successive `BG44` chunks of composed pages
+var backgroundSlices = []iw44.EncoderParams{{Slices: 74}, {Slices: 89}, {Slices: 99}}
+
+// Number of slices of the `FG44` chunk of composed pages
+var foregroundSlices = iw44.EncoderParams{Slices: 100}
+
+// Largest reduction of the background and foreground of pages
+const maxReduction = 12
+
+// ComposePage encodes the layers of a page into a `FORM:DJVU` chunk,
+// holding an `INFO` chunk, a `Sjbz` chunk for the mask,
+// a `FGbz` or `FG44` chunk for the foreground colors
+// and `BG44` chunks for the background.
+func ComposePage(layers *Layers) (*iff.Chunk, error) {
+	w, h := layers.Width, layers.Height
+	form, err := newPageForm(w, h, layers.Dpi, layers.Gamma)
+	if err != nil {
+		return nil, err
+	}
+
+	mask := layers.Mask
+	if mask != nil && (int(mask.Cols()) != w || int(mask.Rows()) != h) {
+		return nil, errors.Errorf("mask of %dx%d for page of %dx%d", mask.Cols(), mask.Rows(), w, h)
+	}
+	var jb2Image *jb2.Image
+	if mask != nil {
+		if jb2Image, err = jb2.NewImageFromBitmap(mask); err != nil {
+			return nil, err
+		}
+	}
+	if jb2Image != nil && len(jb2Image.Blits) > 0 {
+		var sjbz bytes.Buffer
+		if err := jb2Image.Encode(&sjbz); err != nil {
+			return nil, err
+		}
+		form.Children = append(form.Children, &iff.Chunk{ID: "Sjbz", Data: sjbz.Bytes()})
+
+		fg, err := composeForeground(layers, jb2Image)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not encode foreground")
+		}
+		form.Children = append(form.Children, fg...)
+	} else {
+		mask = nil
+	}
+
+	if bg := layers.Background; bg != nil {
+		k, err := reduction(w, h, bg)
+		if err != nil {
+			return nil, errors.Wrap(err, "bad background")
+		}
+		var bgMask *image.Bitmap
+		if mask != nil {
+			// Pixels entirely hidden by the foreground
+			bgMask = reduceMask(mask, k, func(set, total int) bool { return set == total })
+		}
+		e, err := iw44.NewPixmapEncoder(bg, bgMask, iw44.CRCB_NORMAL)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not encode background")
+		}
+		chunks, err := e.EncodeChunks("BG44", backgroundSlices...)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not encode background")
+		}
+		form.Children = append(form.Children, chunks...)
+	}
+	return form, nil
+}
+
+// Returns a `FORM:DJVU` chunk holding the `INFO` chunk of a page of w by h pixels,
+// with the default resolution and gamma for zero values
+func newPageForm(w, h, dpi int, gamma float64) (*iff.Chunk, error) {
+	if w <= 0 || h <= 0 || w > 0xffff || h > 0xffff {
+		return nil, errors.Errorf("cannot encode page of %dx%d", w, h)
+	}
+	info := NewInfo()
+	info.Width, info.Height, info.Dpi, info.Gamma = uint16(w), uint16(h), 300, 2.2
+	if dpi != 0 {
+		if dpi < 0 || dpi > 0xffff {
+			return nil, errors.Errorf("bad resolution %d", dpi)
+		}
+		info.Dpi = uint16(dpi)
+	}
+	if gamma != 0 {
+		info.Gamma = gamma
+	}
+	var buf bytes.Buffer
+	if err := info.Encode(&buf); err != nil {
+		return nil, err
+	}
+	return &iff.Chunk{ID: "FORM:DJVU", Children: []*iff.Chunk{{ID: "INFO", Data: buf.Bytes()}}}, nil
+}
+
+// Returns the chunks of the foreground colors of the mask img
+func composeForeground(layers *Layers, img *jb2.Image) ([]*iff.Chunk, error) {
+	fg := layers.Foreground
+	if fg == nil {
+		return nil, nil
+	}
+	k, err := reduction(layers.Width, layers.Height, fg)
+	if err != nil {
+		return nil, err
+	}
+
+	if !layers.ForegroundPalette {
+		// Pixels showing none of the foreground
+		fgMask := reduceMask(layers.Mask, k, func(set, total int) bool { return set == 0 })
+		e, err := iw44.NewPixmapEncoder(fg, fgMask, iw44.CRCB_NORMAL)
+		if err != nil {
+			return nil, err
+		}
+		return e.EncodeChunks("FG44", foregroundSlices)
+	}
+
+	palette := NewPalette()
+	colors := make(map[image.Pixel]int)
+	for _, blit := range img.Blits {
+		bits := img.Shape(blit.Shape).Bits
+		var b, g, r, n int
+		for rr := 0; rr < int(bits.Rows()); rr++ {
+			line := fg.GetLine((blit.Bottom + rr) / k)
+			for cc, v := range bits.GetLine(rr) {
+				if v != 0 {
+					pix := line[(blit.Left+cc)/k]
+					b, g, r, n = b+int(pix.B), g+int(pix.G), r+int(pix.R), n+1
+				}
+			}
+		}
+		color := image.Pixel{B: uint8((b + n/2) / n), G: uint8((g + n/2) / n), R: uint8((r + n/2) / n)}
+		index, ok := colors[color]
+		if !ok {
+			index = len(palette.Colors)
+			colors[color] = index
+			palette.Colors = append(palette.Colors, color)
+		}
+		palette.Indices = append(palette.Indices, index)
+	}
+	if len(palette.Colors) == 1 {
+		palette.Indices = nil
+	}
+	var buf bytes.Buffer
+	if err := palette.Encode(&buf); err != nil {
+		return nil, err
+	}
+	return []*iff.Chunk{{ID: "FGbz", Data: buf.Bytes()}}, nil
+}
+
+// Returns the factor by which pm is smaller than a page of w by h pixels
+func reduction(w, h int, pm *image.Pixmap) (int, error) {
+	for k := 1; k <= maxReduction; k++ {
+		if (w+k-1)/k == pm.Cols() && (h+k-1)/k == pm.Rows() {
+			return k, nil
+		}
+	}
+	return 0, errors.Errorf("image of %dx%d is not a reduction of the page of %dx%d", pm.Cols(), pm.Rows(), w, h)
+}
+
+// Returns mask reduced by k, with pixels set where hidden tells so,
+// given the number of set pixels of mask they cover among the total they cover
+func reduceMask(mask *image.Bitmap, k int, hidden func(set, total int) bool) *image.Bitmap {
+	w, h := int(mask.Cols()), int(mask.Rows())
+	rw, rh := (w+k-1)/k, (h+k-1)/k
+	set := make([]int, rw*rh)
+	total := make([]int, rw*rh)
+	for rr := 0; rr < h; rr++ {
+		for cc, v := range mask.GetLine(rr) {
+			total[rr/k*rw+cc/k]++
+			if v != 0 {
+				set[rr/k*rw+cc/k]++
+			}
+		}
+	}
+
+	reduced, _ := image.NewBitmap(uint16(rh), uint16(rw), 0)
+	for rr := 0; rr < rh; rr++ {
+		line := reduced.GetLine(rr)
+		for cc := range line {
+			if hidden(set[rr*rw+cc], total[rr*rw+cc]) {
+				line[cc] = 1
+			}
+		}
+	}
+	return reduced
+}
@@ -0,0 +1,176 @@
+package djvu
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/janreggie/go-djvulibre/djvu/iff"
+	"github.com/janreggie/go-djvulibre/djvu/image"
+	"github.com/stretchr/testify/suite"
+)
+
+type ComposeTestSuite struct {
+	suite.Suite
+}
+
+func TestComposeSuite(t *testing.T) {
+	suite.Run(t, new(ComposeTestSuite))
+}
+
+// Returns the 60x40 layers of a page with two 10x10 squares,
+// at (5, 5) over a red foreground and at (30, 20) over a black one,
+// on a uniform background reduced by 3
+func (s *ComposeTestSuite) layers() *Layers {
+	mask, err := image.NewBitmap(40, 60, 0)
+	s.Require().NoError(err)
+	fg := image.NewPixmap(40, 60)
+	for rr := 0; rr < 40; rr++ {
+		line, colors := mask.GetLine(rr), fg.GetLine(rr)
+		for cc := range line {
+			if rr >= 5 && rr < 15 && cc >= 5 && cc < 15 || rr >= 20 && rr < 30 && cc >= 30 && cc < 40 {
+				line[cc] = 1
+			}
+			if cc < 20 {
+				colors[cc] = testForeground
+			} else {
+				colors[cc] = image.BlackPixel
+			}
+		}
+	}
+	bg := image.NewPixmap(14, 20)
+	bg.Fill(testBackground)
+	return &Layers{Width: 60, Height: 40, Dpi: 150, Mask: mask, Foreground: fg, ForegroundPalette: true, Background: bg}
+}
+
+// Returns the page composed of layers, rendered at full size
+func (s *ComposeTestSuite) render(layers *Layers) (*Page, *image.Pixmap) {
+	form, err := ComposePage(layers)
+	s.Require().NoError(err)
+
+	// Survives writing
+	var buf bytes.Buffer
+	s.Require().NoError(form.Encode(&buf, true))
+	decoded, err := iff.DecodeBytes(buf.Bytes())
+	s.Require().NoError(err)
+	page, err := NewPage(0, decoded)
+	s.Require().NoError(err)
+
+	pm, err := page.Render(1)
+	s.Require().NoError(err)
+	s.Equal(layers.Height, pm.Rows())
+	s.Equal(layers.Width, pm.Cols())
+	return page, pm
+}
+
+func (s *ComposeTestSuite) TestPalette() {
+	layers := s.layers()
+	page, pm := s.render(layers)
+	s.Equal(uint16(60), page.Info.Width)
+	s.Equal(uint16(40), page.Info.Height)
+	s.Equal(uint16(150), page.Info.Dpi)
+	s.Equal(2.2, page.Info.Gamma)
+	s.Equal([]string{"INFO", "Sjbz", "FGbz", "BG44", "BG44", "BG44"}, chunkIDs(page.Form))
+
+	s.Equal(testForeground, pm.GetLine(10)[10])
+	s.Equal(image.BlackPixel, pm.GetLine(25)[35])
+	testNear(&s.Suite, testBackground, pm.GetLine(30)[10])
+	testNear(&s.Suite, testBackground, pm.GetLine(10)[50])
+
+	p := NewPalette()
+	s.Require().NoError(p.Decode(bytes.NewReader(page.Form.Find("FGbz").Data)))
+	s.Equal([]image.Pixel{image.BlackPixel, testForeground}, p.Colors)
+	s.Equal([]int{0, 1}, p.Indices)
+
+	// A single color needs no indices
+	layers.Foreground.Fill(testForeground)
+	page, pm = s.render(layers)
+	s.Require().NoError(p.Decode(bytes.NewReader(page.Form.Find("FGbz").Data)))
+	s.Equal([]image.Pixel{testForeground}, p.Colors)
+	s.Nil(p.Indices)
+	s.Equal(testForeground, pm.GetLine(25)[35])
+}
+
+func (s *ComposeTestSuite) TestImageForeground() {
+	layers := s.layers()
+	layers.ForegroundPalette = false
+	layers.Gamma = 1.8
+	page, pm := s.render(layers)
+	s.Equal(1.8, page.Info.Gamma)
+	s.Equal([]string{"INFO", "Sjbz", "FG44", "BG44", "BG44", "BG44"}, chunkIDs(page.Form))
+	testNear(&s.Suite, testForeground, pm.GetLine(10)[10])
+	testNear(&s.Suite, image.BlackPixel, pm.GetLine(25)[35])
+	testNear(&s.Suite, testBackground, pm.GetLine(30)[10])
+
+	// Reduced foreground
+	fg := image.NewPixmap(20, 30)
+	fg.Fill(testForeground)
+	layers.Foreground = fg
+	_, pm = s.render(layers)
+	testNear(&s.Suite, testForeground, pm.GetLine(25)[35])
+}
+
+func (s *ComposeTestSuite) TestPartialLayers() {
+	// Black text on white
+	layers := s.layers()
+	layers.Foreground, layers.Background = nil, nil
+	page, pm := s.render(layers)
+	s.Equal([]string{"INFO", "Sjbz"}, chunkIDs(page.Form))
+	s.Equal(image.BlackPixel, pm.GetLine(10)[10])
+	s.Equal(image.WhitePixel, pm.GetLine(30)[10])
+	s.Equal(uint16(150), page.Info.Dpi)
+
+	// Background alone
+	layers = s.layers()
+	layers.Mask = nil
+	page, pm = s.render(layers)
+	s.Equal([]string{"INFO", "BG44", "BG44", "BG44"}, chunkIDs(page.Form))
+	testNear(&s.Suite, testBackground, pm.GetLine(10)[10])
+
+	// An empty mask is left out
+	layers = s.layers()
+	layers.Mask.Fill(0)
+	page, _ = s.render(layers)
+	s.Equal([]string{"INFO", "BG44", "BG44", "BG44"}, chunkIDs(page.Form))
+
+	page, _ = s.render(&Layers{Width: 3, Height: 2})
+	s.Equal([]string{"INFO"}, chunkIDs(page.Form))
+	s.Equal(uint16(300), page.Info.Dpi)
+}
+
+func (s *ComposeTestSuite) TestErrors() {
+	small := image.NewPixmap(10, 10)
+	for _, modify := range []func(*Layers){
+		func(l *Layers) { l.Width = 0 },
+		func(l *Layers) { l.Height = 0x10000 },
+		func(l *Layers) { l.Dpi = -1 },
+		func(l *Layers) { l.Width = 61 },
+		func(l *Layers) { l.Background = small },
+		func(l *Layers) { l.Foreground = small },
+		func(l *Layers) { l.Foreground, l.ForegroundPalette = small, false },
+	} {
+		layers := s.layers()
+		modify(layers)
+		_, err := ComposePage(layers)
+		s.Error(err)
+	}
+}
+
+func (s *ComposeTestSuite) TestEncodePalette() {
+	colors := []image.Pixel{testForeground, image.BlackPixel, testBackground}
+	p := NewPalette()
+	p.Colors, p.Indices = colors, []int{2, 0, 1, 2}
+	var buf bytes.Buffer
+	s.Require().NoError(p.Encode(&buf))
+	decoded := NewPalette()
+	s.Require().NoError(decoded.Decode(&buf))
+	s.Equal(p.Colors, decoded.Colors)
+	s.Equal(p.Indices, decoded.Indices)
+
+	p.Indices = nil
+	buf.Reset()
+	s.Require().NoError(p.Encode(&buf))
+	s.Equal(testPaletteData(&s.Suite, colors, nil), buf.Bytes())
+
+	p.Indices = []int{3}
+	s.Error(p.Encode(&buf))
+}
@@ -1,125 +1,694 @@
 package djvu
 
-/** Thread safe data storage.
-    The purpose of #DataPool# is to provide a uniform interface for
-    accessing data from decoding routines running in a multi-threaded
-    environment. Depending on the mode of operation it may contain the
-    actual data, may be connected to another #DataPool# or may be mapped
-    to a file. Regardless of the mode, the class returns data in a
-    thread-safe way, blocking reading threads if there is no data of
-    interest available. This blocking is especially useful in the
-    networking environment (plugin) when there is a running decoding thread,
-    which wants to start decoding as soon as there is just one byte available
-    blocking if necessary.
-
-    Access to data in a #DataPool# may be direct (Using \Ref{get_data}()
-    function) or sequential (See \Ref{get_stream}() function).
-
-    If the #DataPool# is not connected to anything, that is it contains
-    some real data, this data can be added to it by means of two
-    \Ref{add_data}() functions. One of them adds data sequentially maintaining
-    the offset of the last block of data added by it. The other can store
-    data anywhere. Thus it's important to realize, that there may be "white
-    spots" in the data storage.
-
-    There is also a way to test if data is available for some given data
-    range (See \Ref{has_data}()). In addition to this mechanism, there are
-    so-called {\em trigger callbacks}, which are called, when there is
-    all data available for a given data range.
-
-    Let us consider all modes of operation in details:
-
-    \begin{enumerate}
-       \item {\bf Not connected #DataPool#}. In this mode the #DataPool#
-             contains some real data. As mentioned above, it may be added
-             by means of two functions \Ref{add_data}() operating independent
-	     of each other and allowing to add data sequentially and
-	     directly to any place of data storage. It's important to call
-	     function \Ref{set_eof}() after all data has been added.
-
-	     Functions like \Ref{get_data}() or \Ref{get_stream}() can
-	     be used to obtain direct or sequential access to the data. As
-	     long as \Ref{is_eof}() is #FALSE#, #DataPool# will block every
-	     reader, which is trying to read unavailable data until it
-	     really becomes available. But as soon as \Ref{is_eof}() is
-	     #TRUE#, any attempt to read non-existing data will read #0# bytes.
-
-	     Taking into account the fact, that #DataPool# was designed to
-	     store DjVu files, which are in IFF formats, it becomes possible
-	     to predict the size of the #DataPool# as soon as the first
-	     #32# bytes have been added. This is invaluable for estimating
-	     download progress. See function \Ref{get_length}() for details.
-	     If this estimate fails (which means, that stored data is not
-	     in IFF format), \Ref{get_length}() returns #-1#.
-
-	     Triggers may be added and removed by means of \Ref{add_trigger}()
-	     and \Ref{del_trigger}() functions. \Ref{add_trigger}() takes
-	     a data range. As soon as all data in that data range is
-	     available, the trigger callback will be called.
-
-	     All trigger callbacks will be called when #EOF# condition
-	     has been set.
-
-       \item {\bf #DataPool# connected to another #DataPool#}. In this
-             {\em slave} mode you can map a given #DataPool# to any offsets
-	     range inside another #DataPool#. You can connect the slave
-	     #DataPool# even if there is no data in the master #DataPool#.
-	     Any \Ref{get_data}() request will be forwarded to the master
-	     #DataPool#, and it will be responsible for blocking readers
-	     trying to access unavailable data.
-
-	     The usage of \Ref{add_data}() functions is prohibited for
-	     connected #DataPool#s.
-
-	     The offsets range used to map a slave #DataPool# can be fully
-	     specified (both start offset and length are positive numbers)
-	     or partially specified (the length is negative). In this mode
-	     the slave #DataPool# is assumed to extend up to the end
-	     of the master #DataPool#.
-
-	     Triggers may be used with slave #DataPool#s as well as with
-	     the master ones.
-
-	     Calling \Ref{stop}() function of a slave will stop only the slave
-	     (and any other slave connected to it), but not the master.
-
-	     \Ref{set_eof}() function is meaningless for slaves. They obtain
-	     the #ByteStream::EndOfFile# status from their master.
-
-	     Depending on the offsets range passed to the constructor,
-	     \Ref{get_length}() returns different values. If the length
-	     passed to the constructor was positive, then it is returned
-	     by \Ref{get_length}() all the time. Otherwise the value returned
-	     is either #-1# if master's length is still unknown (it didn't
-	     manage to parse IFF data yet) or it is calculated as
-	     #masters_length-slave_start#.
-
-       \item {\bf #DataPool# connected to a file}. This mode is quite similar
-             to the case, when the #DataPool# is connected to another
-	     #DataPool#. Similarly, the #DataPool# stores no data inside.
-	     It just forwards all \Ref{get_data}() requests to the underlying
-	     source (a file in this case). Thus these requests will never
-	     block the reader. But they may return #0# if there is no data
-	     available at the requested offset.
-
-	     The usage of \Ref{add_data}() functions is meaningless and
-	     is prohibited.
-
-	     \Ref{is_eof}() function always returns #TRUE#. Thus \Ref{set_eof}()
-	     us meaningless and does nothing.
-
-	     \Ref{get_length}() function always returns the file size.
-
-	     Calling \Ref{stop}() function will stop this #DataPool# and
-	     any other slave connected to it.
-
-	     Trigger callbacks passed through \Ref{add_trigger}() function
-	     are called immediately.
-
-	     This mode is useful to read and decode DjVu files without reading
-	     and storing them in full in memory.
-    \end{enumerate}
-*/
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// DataPool is a thread safe data storage.
+//
+// The purpose of DataPool is to provide a uniform interface
+// for accessing data from decoding routines running in a multi-threaded environment.
+// Depending on the mode of operation
+// it may contain the actual data,
+// may be connected to another DataPool,
+// or may be mapped to a file (or anything implementing io.ReaderAt).
+//
+// Access to data in a DataPool may be direct (using GetData or GetDataContext)
+// or sequential (using NewReader).
+// GetDataContext and readers block if there is no data of interest available,
+// until it is added, the context is done, or the DataPool is stopped with Stop.
+// This blocking is especially useful in the networking environment
+// when there is a running decoding goroutine
+// which wants to start decoding as soon as there is just one byte available.
+//
+// If the DataPool is not connected to anything, that is it contains some real data,
+// this data can be added to it by means of AddData and AddDataAt.
+// The former adds data sequentially maintaining the offset of the last block of data added by it.
+// The latter can store data anywhere.
+// Thus it's important to realize that there may be "white spots" in the data storage.
+//
+// There is also a way to test if data is available for some given data range (see HasData).
+// In addition to this mechanism, there are so-called trigger callbacks,
+// which are called when there is all data available for a given data range.
+//
+// Let us consider all modes of operation in detail:
+//
+// - Not connected DataPool (see NewDataPool).
+// In this mode the DataPool contains some real data.
+// It's important to call SetEOF after all data has been added.
+// As long as IsEOF is false, DataPool will block every reader
+// which is trying to read unavailable data until it really becomes available.
+// But as soon as IsEOF is true, any attempt to read non-existing data will read 0 bytes.
+// Taking into account the fact that DataPool was designed to store DjVu files, which are in IFF format,
+// it becomes possible to predict the size of the DataPool
+// as soon as the first 12 bytes have been added.
+// This is invaluable for estimating download progress.
+// See GetLength for details.
+// All trigger callbacks will be called when the EOF condition has been set.
+//
+// - DataPool connected to another DataPool (see NewSlaveDataPool).
+// In this slave mode you can map a given DataPool to any offsets range inside another DataPool.
+// You can connect the slave DataPool even if there is no data in the master DataPool.
+// Any GetData request will be forwarded to the master,
+// and it will be responsible for blocking readers trying to access unavailable data.
+// Calling Stop on a slave will stop only the slave (and any other slave connected to it),
+// but not the master.
+// The usage of AddData is prohibited for connected DataPools,
+// and SetEOF is meaningless since they obtain their EOF status from their master.
+// The offsets range used to map a slave can be fully specified
+// (both start offset and length are positive numbers)
+// or partially specified (the length is negative).
+// In the latter case the slave is assumed to extend up to the end of the master.
+// GetLength returns the length passed to the constructor if it was positive.
+// Otherwise it is either -1 if the master's length is still unknown,
+// or it is calculated as the master's length minus the slave's start.
+//
+// - DataPool connected to a file (see NewDataPoolFromReaderAt).
+// Similarly to the slave mode, the DataPool stores no data inside
+// and forwards all requests to the underlying io.ReaderAt.
+// Thus these requests will never block the reader.
+// The usage of AddData is prohibited,
+// IsEOF always returns true,
+// and GetLength always returns the size of the file.
+// Trigger callbacks are called immediately.
+// This mode is useful to read and decode DjVu files
+// without reading and storing them in full in memory.
 type DataPool struct {
-	// TODO: The needful
+	mtx sync.Mutex
+
+	// Not connected: the data itself, and which parts of it are available
+	data      []byte
+	available []dataRange
+	addAt     int64
+	eof       bool
+
+	// Connected to a file
+	file     io.ReaderAt
+	fileSize int64
+
+	// Connected to another DataPool
+	master *DataPool
+	start  int64
+	length int64
+
+	triggers []*Trigger
+
+	// Closed and replaced whenever blocked readers should check again
+	changed chan struct{}
+	stopped bool
+}
+
+// ErrDataNotAvailable is returned by GetData
+// when the requested data has not been added to the DataPool yet.
+var ErrDataNotAvailable = errors.New("data not available yet")
+
+// ErrDataPoolStopped is returned to readers of a DataPool which has been stopped.
+var ErrDataPoolStopped = errors.New("DataPool has been stopped")
+
+// ErrDataPoolConnected is returned when trying to add data
+// into a DataPool connected to a file or to another DataPool.
+var ErrDataPoolConnected = errors.New("cannot add data to a connected DataPool")
+
+// Trigger is a callback registered with AddTrigger.
+// It can be used to unregister the callback with DelTrigger.
+type Trigger struct {
+	start    int64
+	length   int64
+	callback func()
+
+	// Trigger registered with the master of a slave DataPool
+	masterTrigger *Trigger
+}
+
+// dataRange is the half-open range [start, end)
+type dataRange struct{ start, end int64 }
+
+// NewDataPool creates an empty DataPool not connected to anything.
+// Use AddData to fill it and SetEOF when done.
+func NewDataPool() *DataPool {
+	return &DataPool{length: -1}
+}
+
+// NewDataPoolFromBytes creates a DataPool containing `data`.
+// EOF is already set.
+func NewDataPoolFromBytes(data []byte) *DataPool {
+	pool := NewDataPool()
+	pool.data = append([]byte(nil), data...)
+	if len(data) > 0 {
+		pool.available = []dataRange{{0, int64(len(data))}}
+	}
+	pool.addAt = int64(len(data))
+	pool.eof = true
+	return pool
+}
+
+// NewDataPoolFromReaderAt creates a DataPool
+// connected to the first `size` bytes of `r`.
+// Nothing is read until requested.
+func NewDataPoolFromReaderAt(r io.ReaderAt, size int64) *DataPool {
+	return &DataPool{
+		file:     r,
+		fileSize: size,
+		length:   -1,
+	}
+}
+
+// NewSlaveDataPool creates a DataPool exposing `length` bytes of `master`
+// starting from `start`.
+// If `length` is negative, the slave extends up to the end of the master.
+func NewSlaveDataPool(master *DataPool, start int64, length int64) *DataPool {
+	if start < 0 {
+		start = 0
+	}
+	if length < 0 {
+		length = -1
+	}
+	return &DataPool{
+		master: master,
+		start:  start,
+		length: length,
+	}
+}
+
+// IsConnected returns whether the DataPool is connected to a file or to another DataPool.
+func (pool *DataPool) IsConnected() bool {
+	return pool.file != nil || pool.master != nil
+}
+
+// AddData appends `buf` after the data previously added by AddData.
+// This offset is independent of AddDataAt.
+func (pool *DataPool) AddData(buf []byte) error {
+	if pool.IsConnected() {
+		return ErrDataPoolConnected
+	}
+
+	pool.mtx.Lock()
+	offset := pool.addAt
+	pool.addAt += int64(len(buf))
+	ready := pool.addDataAt(buf, offset)
+	pool.mtx.Unlock()
+
+	callTriggers(ready)
+	return nil
+}
+
+// AddDataAt stores `buf` at `offset`, possibly leaving white spots before it.
+func (pool *DataPool) AddDataAt(buf []byte, offset int64) error {
+	if pool.IsConnected() {
+		return ErrDataPoolConnected
+	}
+	if offset < 0 {
+		return errors.Errorf("invalid offset %v", offset)
+	}
+
+	pool.mtx.Lock()
+	ready := pool.addDataAt(buf, offset)
+	pool.mtx.Unlock()
+
+	callTriggers(ready)
+	return nil
+}
+
+// Stores the data and returns the triggers which should now be called.
+// Unsafe. Must only be called while holding the lock.
+func (pool *DataPool) addDataAt(buf []byte, offset int64) []*Trigger {
+	if len(buf) == 0 {
+		return nil
+	}
+
+	end := offset + int64(len(buf))
+	if end > int64(len(pool.data)) {
+		if end > int64(cap(pool.data)) {
+			grown := make([]byte, end, 2*end)
+			copy(grown, pool.data)
+			pool.data = grown
+		} else {
+			pool.data = pool.data[:end]
+		}
+	}
+	copy(pool.data[offset:], buf)
+	pool.markAvailable(offset, end)
+	pool.broadcast()
+	return pool.readyTriggers()
+}
+
+// SetEOF tells the DataPool that all data has been added.
+// All trigger callbacks are called.
+// Meaningless for connected DataPools.
+func (pool *DataPool) SetEOF() {
+	if pool.IsConnected() {
+		return
+	}
+
+	pool.mtx.Lock()
+	if pool.eof {
+		pool.mtx.Unlock()
+		return
+	}
+	pool.eof = true
+	ready := pool.triggers
+	pool.triggers = nil
+	pool.broadcast()
+	pool.mtx.Unlock()
+
+	callTriggers(ready)
+}
+
+// IsEOF returns whether all data has been added to the DataPool.
+func (pool *DataPool) IsEOF() bool {
+	switch {
+	case pool.file != nil:
+		return true
+	case pool.master != nil:
+		return pool.master.IsEOF()
+	}
+
+	pool.mtx.Lock()
+	defer pool.mtx.Unlock()
+	return pool.eof
+}
+
+// GetLength returns the total length of the data, or -1 if it is not known yet.
+//
+// For a DataPool which is not connected,
+// the length is known once EOF is set,
+// or estimated from the IFF header as soon as its first 12 bytes are available.
+func (pool *DataPool) GetLength() int64 {
+	switch {
+	case pool.file != nil:
+		return pool.fileSize
+	case pool.master != nil:
+		if pool.length >= 0 {
+			return pool.length
+		}
+		if ml := pool.master.GetLength(); ml >= 0 {
+			if ml < pool.start {
+				return 0
+			}
+			return ml - pool.start
+		}
+		return -1
+	}
+
+	pool.mtx.Lock()
+	defer pool.mtx.Unlock()
+	if pool.eof {
+		return pool.size()
+	}
+	return pool.iffLength()
+}
+
+// GetSize returns the number of bytes available in the range [`start`, `start+length`).
+// A negative `length` means up to the end of the data.
+func (pool *DataPool) GetSize(start int64, length int64) int64 {
+	switch {
+	case pool.file != nil:
+		end := pool.fileSize
+		if length >= 0 && start+length < end {
+			end = start + length
+		}
+		if end < start {
+			return 0
+		}
+		return end - start
+	case pool.master != nil:
+		mstart, mlength := pool.toMaster(start, length)
+		return pool.master.GetSize(mstart, mlength)
+	}
+
+	pool.mtx.Lock()
+	defer pool.mtx.Unlock()
+	end := int64(-1)
+	if length >= 0 {
+		end = start + length
+	}
+	var retval int64
+	for _, r := range pool.available {
+		s, e := r.start, r.end
+		if s < start {
+			s = start
+		}
+		if end >= 0 && e > end {
+			e = end
+		}
+		if e > s {
+			retval += e - s
+		}
+	}
+	return retval
+}
+
+// HasData returns whether all data in the range [`start`, `start+length`) is available.
+// A negative `length` means up to the end of the data,
+// which can only be fully available once EOF has been set.
+// Once EOF has been set, data past the end is not considered missing.
+func (pool *DataPool) HasData(start int64, length int64) bool {
+	switch {
+	case pool.file != nil:
+		return true
+	case pool.master != nil:
+		mstart, mlength := pool.toMaster(start, length)
+		return pool.master.HasData(mstart, mlength)
+	}
+
+	pool.mtx.Lock()
+	defer pool.mtx.Unlock()
+	return pool.hasData(start, length)
+}
+
+// GetData copies available data starting at `offset` into `buf`
+// and returns the number of bytes copied.
+// It never blocks:
+// it returns ErrDataNotAvailable if no data is available at `offset` yet,
+// and io.EOF if `offset` is past the end of the data,
+// or if it was never added and EOF has been set.
+// Fewer than len(buf) bytes may be returned
+// if the data after them has not been added yet.
+func (pool *DataPool) GetData(buf []byte, offset int64) (int, error) {
+	return pool.getData(context.Background(), buf, offset)
+}
+
+// readerAtContext is an io.ReaderAt whose reads can be cancelled,
+// such as one fetching its data over the network.
+// A DataPool connected to one passes it the context of GetDataContext.
+type readerAtContext interface {
+	ReadAtContext(ctx context.Context, p []byte, off int64) (int, error)
+}
+
+func (pool *DataPool) getData(ctx context.Context, buf []byte, offset int64) (int, error) {
+	if offset < 0 {
+		return 0, errors.Errorf("invalid offset %v", offset)
+	}
+	if len(buf) == 0 {
+		return 0, nil
+	}
+
+	switch {
+	case pool.file != nil:
+		if offset >= pool.fileSize {
+			return 0, io.EOF
+		}
+		if rem := pool.fileSize - offset; int64(len(buf)) > rem {
+			buf = buf[:rem]
+		}
+		var n int
+		var err error
+		if r, ok := pool.file.(readerAtContext); ok {
+			n, err = r.ReadAtContext(ctx, buf, offset)
+		} else {
+			n, err = pool.file.ReadAt(buf, offset)
+		}
+		if err == io.EOF && n > 0 {
+			err = nil
+		}
+		return n, err
+
+	case pool.master != nil:
+		if pool.length >= 0 {
+			if offset >= pool.length {
+				return 0, io.EOF
+			}
+			if rem := pool.length - offset; int64(len(buf)) > rem {
+				buf = buf[:rem]
+			}
+		}
+		return pool.master.getData(ctx, buf, pool.start+offset)
+	}
+
+	pool.mtx.Lock()
+	defer pool.mtx.Unlock()
+	for _, r := range pool.available {
+		if r.start <= offset && offset < r.end {
+			return copy(buf, pool.data[offset:r.end]), nil
+		}
+	}
+	// No more data will be added once EOF is set, not even in the gaps
+	if pool.eof {
+		return 0, io.EOF
+	}
+	return 0, ErrDataNotAvailable
+}
+
+// GetDataContext is like GetData,
+// but blocks until some data is available at `offset`
+// instead of returning ErrDataNotAvailable.
+// It returns ctx.Err() if the context is done before that,
+// and ErrDataPoolStopped if the DataPool (or its master) is stopped.
+func (pool *DataPool) GetDataContext(ctx context.Context, buf []byte, offset int64) (int, error) {
+	root := pool.root()
+	for {
+		// Grab the channel before looking at the data so that no wake up is missed
+		changed := root.changedChan()
+		if pool.IsStopped() {
+			return 0, ErrDataPoolStopped
+		}
+		n, err := pool.getData(ctx, buf, offset)
+		if err != ErrDataNotAvailable {
+			return n, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// GetLengthContext is like GetLength,
+// but blocks until the length is known.
+func (pool *DataPool) GetLengthContext(ctx context.Context) (int64, error) {
+	root := pool.root()
+	for {
+		changed := root.changedChan()
+		if pool.IsStopped() {
+			return 0, ErrDataPoolStopped
+		}
+		if length := pool.GetLength(); length >= 0 {
+			return length, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Stop aborts all readers blocked on this DataPool and any slave connected to it,
+// and makes all further blocking reads fail with ErrDataPoolStopped.
+// Stopping a slave does not stop its master.
+func (pool *DataPool) Stop() {
+	pool.mtx.Lock()
+	pool.stopped = true
+	pool.mtx.Unlock()
+
+	root := pool.root()
+	root.mtx.Lock()
+	root.broadcast()
+	root.mtx.Unlock()
+}
+
+// IsStopped returns whether this DataPool or its master has been stopped.
+func (pool *DataPool) IsStopped() bool {
+	for p := pool; p != nil; p = p.master {
+		p.mtx.Lock()
+		stopped := p.stopped
+		p.mtx.Unlock()
+		if stopped {
+			return true
+		}
+	}
+	return false
+}
+
+// AddTrigger registers `callback` to be called
+// as soon as all data in the range [`start`, `start+length`) is available.
+// A negative `length` means up to the end of the data.
+// The callback is called immediately if the data is already there,
+// and in any case once EOF is set.
+// Callbacks are called at most once, without any lock held.
+func (pool *DataPool) AddTrigger(start int64, length int64, callback func()) *Trigger {
+	trigger := &Trigger{start: start, length: length, callback: callback}
+
+	switch {
+	case pool.file != nil:
+		callback()
+		return trigger
+	case pool.master != nil:
+		mstart, mlength := pool.toMaster(start, length)
+		trigger.masterTrigger = pool.master.AddTrigger(mstart, mlength, callback)
+		return trigger
+	}
+
+	pool.mtx.Lock()
+	if pool.eof || pool.hasData(start, length) {
+		pool.mtx.Unlock()
+		callback()
+		return trigger
+	}
+	pool.triggers = append(pool.triggers, trigger)
+	pool.mtx.Unlock()
+	return trigger
+}
+
+// DelTrigger unregisters a trigger added with AddTrigger.
+// Nothing happens if it has already been called.
+func (pool *DataPool) DelTrigger(trigger *Trigger) {
+	if trigger == nil {
+		return
+	}
+	if pool.master != nil {
+		pool.master.DelTrigger(trigger.masterTrigger)
+		return
+	}
+
+	pool.mtx.Lock()
+	defer pool.mtx.Unlock()
+	for ii, t := range pool.triggers {
+		if t == trigger {
+			pool.triggers = append(pool.triggers[:ii], pool.triggers[ii+1:]...)
+			return
+		}
+	}
+}
+
+// Returns the DataPool which actually holds the data
+func (pool *DataPool) root() *DataPool {
+	p := pool
+	for p.master != nil {
+		p = p.master
+	}
+	return p
+}
+
+func (pool *DataPool) changedChan() chan struct{} {
+	pool.mtx.Lock()
+	defer pool.mtx.Unlock()
+	if pool.changed == nil {
+		pool.changed = make(chan struct{})
+	}
+	return pool.changed
+}
+
+// Wakes up all blocked readers.
+// Unsafe. Must only be called while holding the lock.
+func (pool *DataPool) broadcast() {
+	if pool.changed != nil {
+		close(pool.changed)
+		pool.changed = nil
+	}
+}
+
+// Translates a range of a slave DataPool into the range of its master.
+func (pool *DataPool) toMaster(start int64, length int64) (int64, int64) {
+	if pool.length >= 0 {
+		if start > pool.length {
+			start = pool.length
+		}
+		if length < 0 || start+length > pool.length {
+			length = pool.length - start
+		}
+	}
+	return pool.start + start, length
+}
+
+// Unsafe. Must only be called while holding the lock.
+func (pool *DataPool) markAvailable(start int64, end int64) {
+	ranges := append(pool.available, dataRange{start, end})
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start < ranges[j].start })
+
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r.start <= last.end {
+			if r.end > last.end {
+				last.end = r.end
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	pool.available = merged
+}
+
+// Unsafe. Must only be called while holding the lock.
+func (pool *DataPool) hasData(start int64, length int64) bool {
+	end := start + length
+	if length < 0 {
+		if !pool.eof {
+			return false
+		}
+		end = pool.size()
+	}
+	if pool.eof && end > pool.size() {
+		end = pool.size()
+	}
+	if end <= start {
+		return true
+	}
+	for _, r := range pool.available {
+		if r.start <= start && end <= r.end {
+			return true
+		}
+	}
+	return false
+}
+
+// Removes and returns the triggers whose data is now available.
+// Unsafe. Must only be called while holding the lock.
+func (pool *DataPool) readyTriggers() []*Trigger {
+	var ready []*Trigger
+	remaining := pool.triggers[:0]
+	for _, t := range pool.triggers {
+		if pool.hasData(t.start, t.length) {
+			ready = append(ready, t)
+		} else {
+			remaining = append(remaining, t)
+		}
+	}
+	pool.triggers = remaining
+	return ready
+}
+
+// Returns the number of bytes of data stored in the DataPool.
+// Connected DataPools store none of the data they serve.
+func (pool *DataPool) memoryUsage() uintptr {
+	pool.mtx.Lock()
+	defer pool.mtx.Unlock()
+	return uintptr(cap(pool.data))
+}
+
+// Unsafe. Must only be called while holding the lock.
+func (pool *DataPool) size() int64 {
+	if len(pool.available) == 0 {
+		return 0
+	}
+	return pool.available[len(pool.available)-1].end
+}
+
+// Estimates the length from the IFF header `AT&TFORM<size>`.
+// Unsafe. Must only be called while holding the lock.
+func (pool *DataPool) iffLength() int64 {
+	if !pool.hasData(0, 12) {
+		return -1
+	}
+	header := pool.data[:12]
+	if string(header[:4]) != "AT&T" {
+		return -1
+	}
+	return int64(binary.BigEndian.Uint32(header[8:12])) + 12
+}
+
+func callTriggers(triggers []*Trigger) {
+	for _, t := range triggers {
+		t.callback()
+	}
 }
@@ -0,0 +1,90 @@
+package djvu
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// DataPoolReader provides sequential access to the data of a DataPool.
+// Reads block until the data they need has been added to the DataPool,
+// so a decoder can start working before a download has finished.
+//
+// Every DataPoolReader keeps its own offset,
+// so several readers can share the same DataPool.
+// A DataPoolReader is not safe for concurrent use.
+type DataPoolReader struct {
+	ctx    context.Context
+	pool   *DataPool
+	offset int64
+}
+
+// NewReader returns a reader positioned at the start of the DataPool.
+// Blocked reads return ctx.Err() once the context is done.
+func (pool *DataPool) NewReader(ctx context.Context) *DataPoolReader {
+	return &DataPoolReader{ctx: ctx, pool: pool}
+}
+
+// Read implements io.Reader.
+// It blocks until at least one byte is available,
+// and returns io.EOF once the end of the data has been reached.
+func (r *DataPoolReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n, err := r.pool.GetDataContext(r.ctx, p, r.offset)
+	r.offset += int64(n)
+	return n, err
+}
+
+// ReadAt implements io.ReaderAt.
+// It blocks until len(p) bytes are available at `off`
+// or the end of the data has been reached.
+// It does not move the offset used by Read.
+func (r *DataPoolReader) ReadAt(p []byte, off int64) (int, error) {
+	read := 0
+	for read < len(p) {
+		n, err := r.pool.GetDataContext(r.ctx, p[read:], off+int64(read))
+		read += n
+		if err != nil {
+			return read, err
+		}
+	}
+	return read, nil
+}
+
+// Seek implements io.Seeker.
+// Seeking relative to io.SeekEnd blocks until the length of the data is known.
+func (r *DataPoolReader) Seek(offset int64, whence int) (int64, error) {
+	var base int64
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		base = r.offset
+	case io.SeekEnd:
+		length, err := r.pool.GetLengthContext(r.ctx)
+		if err != nil {
+			return r.offset, err
+		}
+		base = length
+	default:
+		return r.offset, errors.Errorf("invalid whence %v", whence)
+	}
+
+	if base+offset < 0 {
+		return r.offset, errors.New("negative position")
+	}
+	r.offset = base + offset
+	return r.offset, nil
+}
+
+// Tell returns the current offset of the reader.
+func (r *DataPoolReader) Tell() int64 {
+	return r.offset
+}
+
+var (
+	_ io.ReadSeeker = &DataPoolReader{}
+	_ io.ReaderAt   = &DataPoolReader{}
+)
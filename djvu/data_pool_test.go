@@ -0,0 +1,256 @@
+package djvu
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type DataPoolTestSuite struct {
+	suite.Suite
+	Data []byte // A fake IFF file of 32 bytes
+}
+
+func TestDataPoolSuite(t *testing.T) {
+	suite.Run(t, new(DataPoolTestSuite))
+}
+
+func (s *DataPoolTestSuite) SetupTest() {
+	s.Data = append([]byte("AT&TFORM\x00\x00\x00\x14DJVU"), bytes.Repeat([]byte{'x'}, 16)...)
+}
+
+func (s *DataPoolTestSuite) TestMemory() {
+	pool := NewDataPool()
+	s.False(pool.IsEOF())
+	s.Equal(int64(-1), pool.GetLength())
+
+	s.NoError(pool.AddData(s.Data[:8]))
+	s.Equal(int64(-1), pool.GetLength())
+	s.NoError(pool.AddData(s.Data[8:16]))
+	s.Equal(int64(32), pool.GetLength()) // Estimated from the IFF header
+
+	buf := make([]byte, 32)
+	n, err := pool.GetData(buf, 4)
+	s.NoError(err)
+	s.Equal(12, n)
+	s.Equal(s.Data[4:16], buf[:n])
+
+	_, err = pool.GetData(buf, 16)
+	s.ErrorIs(err, ErrDataNotAvailable)
+
+	s.NoError(pool.AddData(s.Data[16:]))
+	pool.SetEOF()
+	s.True(pool.IsEOF())
+	s.Equal(int64(32), pool.GetLength())
+	_, err = pool.GetData(buf, 32)
+	s.ErrorIs(err, io.EOF)
+}
+
+func (s *DataPoolTestSuite) TestWhiteSpots() {
+	pool := NewDataPool()
+	s.NoError(pool.AddDataAt(s.Data[20:], 20))
+	s.NoError(pool.AddDataAt(s.Data[4:8], 4))
+	s.True(pool.HasData(20, 12))
+	s.True(pool.HasData(5, 2))
+	s.False(pool.HasData(0, 8))
+	s.False(pool.HasData(4, 20))
+	s.Equal(int64(16), pool.GetSize(0, -1))
+
+	// Sequential additions are independent from AddDataAt
+	s.NoError(pool.AddData(s.Data[:10]))
+	s.True(pool.HasData(0, 10))
+	s.NoError(pool.AddData(s.Data[10:20]))
+	s.True(pool.HasData(0, 32))
+	s.False(pool.HasData(0, -1)) // EOF not set yet
+
+	pool.SetEOF()
+	s.True(pool.HasData(0, -1))
+	s.True(pool.HasData(30, 100)) // Nothing is missing after EOF
+}
+
+func (s *DataPoolTestSuite) TestWhiteSpotsEOF() {
+	pool := NewDataPool()
+	s.NoError(pool.AddDataAt(s.Data[:8], 0))
+	s.NoError(pool.AddDataAt(s.Data[16:], 16))
+	buf := make([]byte, 8)
+	_, err := pool.GetData(buf, 10)
+	s.ErrorIs(err, ErrDataNotAvailable)
+	pool.SetEOF()
+
+	// The gap will never be filled, so it reads as the end of the data
+	n, err := pool.GetData(buf, 10)
+	s.Equal(0, n)
+	s.ErrorIs(err, io.EOF)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	n, err = pool.GetDataContext(ctx, buf, 10)
+	s.Equal(0, n)
+	s.ErrorIs(err, io.EOF)
+
+	data, err := io.ReadAll(pool.NewReader(ctx))
+	s.NoError(err)
+	s.Equal(s.Data[:8], data)
+	n, err = pool.GetData(buf, 20)
+	s.NoError(err)
+	s.Equal(s.Data[20:28], buf[:n])
+}
+
+func (s *DataPoolTestSuite) TestTriggers() {
+	pool := NewDataPool()
+	var calls []string
+	pool.AddTrigger(0, 8, func() { calls = append(calls, "header") })
+	pool.AddTrigger(16, 16, func() { calls = append(calls, "tail") })
+	deleted := pool.AddTrigger(0, 32, func() { calls = append(calls, "deleted") })
+	pool.AddTrigger(0, -1, func() { calls = append(calls, "eof") })
+	pool.DelTrigger(deleted)
+
+	s.NoError(pool.AddData(s.Data[:10]))
+	s.Equal([]string{"header"}, calls)
+	s.NoError(pool.AddDataAt(s.Data[16:], 16))
+	s.Equal([]string{"header", "tail"}, calls)
+	pool.SetEOF()
+	s.Equal([]string{"header", "tail", "eof"}, calls)
+
+	// Already available data triggers immediately
+	pool.AddTrigger(0, 4, func() { calls = append(calls, "now") })
+	s.Equal([]string{"header", "tail", "eof", "now"}, calls)
+}
+
+func (s *DataPoolTestSuite) TestReaderAt() {
+	pool := NewDataPoolFromReaderAt(bytes.NewReader(s.Data), int64(len(s.Data)))
+	s.True(pool.IsEOF())
+	s.True(pool.IsConnected())
+	s.Equal(int64(32), pool.GetLength())
+	s.ErrorIs(pool.AddData([]byte{1}), ErrDataPoolConnected)
+
+	buf := make([]byte, 8)
+	n, err := pool.GetData(buf, 28)
+	s.NoError(err)
+	s.Equal(s.Data[28:], buf[:n])
+
+	called := false
+	pool.AddTrigger(0, 100, func() { called = true })
+	s.True(called)
+}
+
+func (s *DataPoolTestSuite) TestSlave() {
+	master := NewDataPool()
+	slave := NewSlaveDataPool(master, 12, 8)
+	open := NewSlaveDataPool(master, 12, -1)
+	s.Equal(int64(8), slave.GetLength())
+	s.Equal(int64(-1), open.GetLength())
+	s.ErrorIs(slave.AddData([]byte{1}), ErrDataPoolConnected)
+
+	called := false
+	slave.AddTrigger(0, -1, func() { called = true })
+	s.NoError(master.AddData(s.Data[:16]))
+	s.False(called)
+	s.Equal(int64(20), open.GetLength())
+	s.NoError(master.AddData(s.Data[16:]))
+	s.True(called)
+	s.True(slave.HasData(0, -1))
+
+	buf := make([]byte, 32)
+	n, err := slave.GetData(buf, 0)
+	s.NoError(err)
+	s.Equal(s.Data[12:20], buf[:n])
+	_, err = slave.GetData(buf, 8)
+	s.ErrorIs(err, io.EOF)
+
+	s.False(open.IsEOF())
+	master.SetEOF()
+	s.True(open.IsEOF())
+	n, err = open.GetData(buf, 0)
+	s.NoError(err)
+	s.Equal(s.Data[12:], buf[:n])
+}
+
+func (s *DataPoolTestSuite) TestReaderBlocks() {
+	pool := NewDataPool()
+	reader := pool.NewReader(context.Background())
+
+	done := make(chan []byte)
+	go func() {
+		data, err := io.ReadAll(reader)
+		s.NoError(err)
+		done <- data
+	}()
+
+	for ii := 0; ii < len(s.Data); ii += 5 {
+		end := ii + 5
+		if end > len(s.Data) {
+			end = len(s.Data)
+		}
+		s.NoError(pool.AddData(s.Data[ii:end]))
+		time.Sleep(time.Millisecond)
+	}
+	pool.SetEOF()
+	s.Equal(s.Data, <-done)
+}
+
+func (s *DataPoolTestSuite) TestReaderSeek() {
+	pool := NewDataPool()
+	s.NoError(pool.AddData(s.Data[:16]))
+	reader := pool.NewReader(context.Background())
+
+	pos, err := reader.Seek(-4, io.SeekEnd) // Length is known from the IFF header
+	s.NoError(err)
+	s.Equal(int64(28), pos)
+	pos, err = reader.Seek(-20, io.SeekCurrent)
+	s.NoError(err)
+	s.Equal(int64(8), pos)
+
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(reader, buf)
+	s.NoError(err)
+	s.Equal(s.Data[8:12], buf)
+
+	_, err = reader.Seek(-100, io.SeekCurrent)
+	s.Error(err)
+}
+
+func (s *DataPoolTestSuite) TestReaderCancel() {
+	pool := NewDataPool()
+	s.NoError(pool.AddData(s.Data[:4]))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	buf := make([]byte, 8)
+	n, err := pool.NewReader(ctx).ReadAt(buf, 0)
+	s.Equal(4, n)
+	s.ErrorIs(err, context.DeadlineExceeded)
+}
+
+func (s *DataPoolTestSuite) TestStop() {
+	master := NewDataPool()
+	slave := NewSlaveDataPool(master, 0, -1)
+	other := NewSlaveDataPool(master, 0, -1)
+
+	errs := make(chan error)
+	go func() {
+		_, err := slave.NewReader(context.Background()).Read(make([]byte, 1))
+		errs <- err
+	}()
+	time.Sleep(time.Millisecond)
+	slave.Stop()
+	s.ErrorIs(<-errs, ErrDataPoolStopped)
+
+	// The master and other slaves carry on
+	s.False(master.IsStopped())
+	s.False(other.IsStopped())
+	go func() {
+		_, err := other.NewReader(context.Background()).Read(make([]byte, 1))
+		errs <- err
+	}()
+	s.NoError(master.AddData(s.Data))
+	s.NoError(<-errs)
+
+	// Stopping the master stops everyone
+	master.Stop()
+	_, err := other.NewReader(context.Background()).Read(make([]byte, 1))
+	s.ErrorIs(err, ErrDataPoolStopped)
+}
@@ -1,5 +1,14 @@
 package djvu
 
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/janreggie/go-djvulibre/djvu/iff"
+	"github.com/pkg/errors"
+)
+
 // Document allows for opening, decoding and saving back DjVu documents
 // in single page and multi page formats.
 //
@@ -29,7 +38,7 @@ package djvu
 //
 // Document provides convenient interface for obtaining Images
 // corresponding to any page of the document.
-// It uses FileCache to do caching
+// It can use a FileCache to do caching (see SetFileCache)
 // thus avoiding unnecessary multiple decoding of the same page.
 // The real decoding though is accomplished by File.
 //
@@ -102,4 +111,390 @@ package djvu
 // TODO: Update docs...
 type Document struct {
 	Port
+
+	mtx    sync.Mutex
+	events *EventPort
+
+	url     *Url
+	pool    *DataPool
+	docType DocType
+	dir     *MultiDir // Only for BUNDLED and INDIRECT documents
+	flags   uint64
+	cache   *FileCache
+
+	implicit *SimplePort // The port created by OpenDocument when given none
+}
+
+// DocType is the format of a Document
+type DocType uint8
+
+const (
+	// Obsolete format with all files in one, indexed by a DIR0 chunk
+	OLD_BUNDLED DocType = iota + 1
+	// Obsolete format with files apart, indexed by an NDIR chunk
+	OLD_INDEXED
+	// All files in one, indexed by a DIRM chunk
+	BUNDLED
+	// Files apart, indexed by a DIRM chunk in a separate index file
+	INDIRECT
+	// Single page document
+	SINGLE_PAGE
+	// Not known yet
+	UNKNOWN_TYPE
+)
+
+func (t DocType) String() string {
+	switch t {
+	case OLD_BUNDLED:
+		return "old bundled"
+	case OLD_INDEXED:
+		return "old indexed"
+	case BUNDLED:
+		return "bundled"
+	case INDIRECT:
+		return "indirect"
+	case SINGLE_PAGE:
+		return "single page"
+	default:
+		return "unknown"
+	}
+}
+
+// Flags sent with Port.NotifyDocFlagsChanged
+const (
+	// The type of the document has been determined
+	DOC_TYPE_KNOWN uint64 = 1 << iota
+	// The document directory has been decoded
+	DOC_DIR_CREATED
+	// The obsolete NDIR directory has been decoded
+	DOC_NDIR_KNOWN
+	// The document has been successfully initialized
+	DOC_INIT_OK
+	// The initialization failed
+	DOC_INIT_FAILED
+)
+
+// ErrUnsupportedFormat is returned when opening a document
+// in one of the obsolete formats, which are not supported yet.
+var ErrUnsupportedFormat = errors.New("unsupported document format")
+
+// OpenDocument opens the document at `url`.
+// Its data is requested through the PortCaster from `p`,
+// or from a SimplePort if `p` is nil.
+// Use a MemoryPort to open documents held in memory.
+//
+// OpenDocument waits for the document header and directory,
+// which may take a while if the data is still being received.
+// Cancelling `ctx` interrupts the wait.
+//
+// Close the Document once done with it.
+func OpenDocument(ctx context.Context, url *Url, p Port) (*Document, error) {
+	if url == nil || url.IsEmpty() {
+		return nil, errors.New("cannot open a document without URL")
+	}
+	doc := &Document{Port: &port{}, url: url.Copy(), docType: UNKNOWN_TYPE}
+	if p == nil {
+		doc.implicit = &SimplePort{}
+		p = doc.implicit
+	}
+	caster := GetPortCaster()
+	caster.AddRoute(doc, p)
+
+	doc.pool = caster.RequestData(ctx, doc, doc.url)
+	if doc.pool == nil {
+		doc.Close()
+		return nil, errors.Errorf("no data for %s", url.Raw())
+	}
+	if err := doc.init(ctx); err != nil {
+		doc.setFlags(DOC_INIT_FAILED)
+		doc.Close()
+		return nil, errors.Wrapf(err, "could not open %s", url.Raw())
+	}
+	doc.setFlags(DOC_INIT_OK)
+	return doc, nil
+}
+
+// Determines the type of the document and reads its directory
+func (doc *Document) init(ctx context.Context) error {
+	f := iff.NewReader(doc.pool.NewReader(ctx))
+	id, err := f.GetChunk()
+	if err != nil {
+		return errors.Wrap(err, "could not read document header")
+	}
+
+	switch id {
+	case "FORM:DJVM":
+		sub, err := f.GetChunk()
+		if err != nil {
+			return errors.Wrap(err, "could not read document directory")
+		}
+		switch sub {
+		case "DIRM":
+			dir := NewMultiDir()
+			if err := dir.Decode(f); err != nil {
+				return err
+			}
+			doc.dir = dir
+			if dir.IsBundled() {
+				doc.docType = BUNDLED
+			} else {
+				doc.docType = INDIRECT
+			}
+			doc.setFlags(DOC_TYPE_KNOWN | DOC_DIR_CREATED)
+		case "DIR0":
+			doc.docType = OLD_BUNDLED
+			doc.setFlags(DOC_TYPE_KNOWN)
+			return errors.Wrap(ErrUnsupportedFormat, doc.docType.String())
+		default:
+			return errors.Errorf("multipage document starts with %q instead of a directory", sub)
+		}
+
+	case "FORM:DJVU", "FORM:BM44", "FORM:PM44":
+		// The first page of old indexed documents holds an NDIR chunk
+		for {
+			sub, err := f.GetChunk()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return errors.Wrap(err, "could not read page")
+			}
+			if sub == "NDIR" {
+				doc.docType = OLD_INDEXED
+				doc.setFlags(DOC_TYPE_KNOWN)
+				return errors.Wrap(ErrUnsupportedFormat, doc.docType.String())
+			}
+			if err := f.CloseChunk(); err != nil {
+				return errors.Wrap(err, "could not read page")
+			}
+		}
+		doc.docType = SINGLE_PAGE
+		doc.setFlags(DOC_TYPE_KNOWN)
+
+	default:
+		return errors.Errorf("not a DjVu document: starts with %q", id)
+	}
+	return nil
+}
+
+func (doc *Document) setFlags(mask uint64) {
+	doc.mtx.Lock()
+	doc.flags |= mask
+	doc.mtx.Unlock()
+	GetPortCaster().NotifyDocFlagsChanged(doc, mask, 0)
+}
+
+// Close releases the routes of the Document
+// and closes its subscriptions.
+// If OpenDocument was given no port,
+// the files it opened are closed as well.
+func (doc *Document) Close() {
+	doc.mtx.Lock()
+	events, implicit := doc.events, doc.implicit
+	doc.events, doc.implicit = nil, nil
+	doc.mtx.Unlock()
+
+	GetPortCaster().DelPort(doc)
+	if implicit != nil {
+		GetPortCaster().DelPort(implicit)
+		implicit.Close()
+	}
+	if events != nil {
+		GetPortCaster().DelPort(events)
+		events.Close()
+	}
+}
+
+// SetFileCache makes GetPage look for decoded pages in `cache`
+// and add the pages it decodes to it.
+// The cache may be shared by several Documents,
+// since pages are keyed by their URL.
+// A nil cache disables caching, which is the default.
+func (doc *Document) SetFileCache(cache *FileCache) {
+	doc.mtx.Lock()
+	defer doc.mtx.Unlock()
+	doc.cache = cache
+}
+
+func (doc *Document) getFileCache() *FileCache {
+	doc.mtx.Lock()
+	defer doc.mtx.Unlock()
+	return doc.cache
+}
+
+// GetDocFlags returns the DOC_* flags set so far.
+func (doc *Document) GetDocFlags() uint64 {
+	doc.mtx.Lock()
+	defer doc.mtx.Unlock()
+	return doc.flags
+}
+
+// GetDocType returns the format of the document.
+func (doc *Document) GetDocType() DocType {
+	return doc.docType
+}
+
+// GetUrl returns the URL the document has been opened from.
+func (doc *Document) GetUrl() *Url {
+	return doc.url.Copy()
+}
+
+// GetMultiDir returns the directory of a BUNDLED or INDIRECT document,
+// and nil otherwise.
+func (doc *Document) GetMultiDir() *MultiDir {
+	return doc.dir
+}
+
+// GetPagesNum returns the number of pages.
+func (doc *Document) GetPagesNum() int {
+	if doc.dir != nil {
+		return doc.dir.GetPagesNum()
+	}
+	if doc.docType == SINGLE_PAGE {
+		return 1
+	}
+	return 0
+}
+
+// PageToUrl returns the URL of page `page`, counted from 0,
+// or nil if there is no such page.
+// Pages of BUNDLED documents are named by the URL of the document
+// with the ID of the page for hash argument.
+func (doc *Document) PageToUrl(page int) *Url {
+	if doc.dir == nil {
+		if doc.docType == SINGLE_PAGE && page == 0 {
+			return doc.url.Copy()
+		}
+		return nil
+	}
+	return doc.fileToUrl(doc.dir.PageToFile(page))
+}
+
+func (doc *Document) fileToUrl(file *MultiDirFile) *Url {
+	if file == nil {
+		return nil
+	}
+	switch doc.docType {
+	case BUNDLED:
+		url := doc.url.Copy()
+		url.SetHashArgument(file.GetLoadName())
+		return url
+	case INDIRECT:
+		// The `./` keeps names with colons from looking like absolute URLs
+		url, err := doc.url.Resolve("./" + encodeReserved(file.GetLoadName()))
+		if err != nil {
+			return nil
+		}
+		return url
+	}
+	return nil
+}
+
+// GetPageData returns the data of page `page`, counted from 0.
+func (doc *Document) GetPageData(ctx context.Context, page int) (*DataPool, error) {
+	if page < 0 || page >= doc.GetPagesNum() {
+		return nil, errors.Errorf("page %d out of range [0, %d)", page, doc.GetPagesNum())
+	}
+	if doc.docType == SINGLE_PAGE {
+		return doc.pool, nil
+	}
+	url := doc.PageToUrl(page)
+	if pool := doc.RequestData(ctx, doc, url); pool != nil {
+		return pool, nil
+	}
+	if pool := GetPortCaster().RequestData(ctx, doc, url); pool != nil {
+		return pool, nil
+	}
+	return nil, errors.Errorf("no data for page %d at %s", page, url.Raw())
+}
+
+// Inherits implements Port
+func (doc *Document) Inherits(className string) bool {
+	return className == "Document" || className == "Port"
+}
+
+// IdToUrl translates the ID of a file of this document,
+// as found in INCL chunks, into its URL.
+// Names and titles are tried after IDs.
+func (doc *Document) IdToUrl(source Port, id string) *Url {
+	if doc.dir == nil {
+		return nil
+	}
+	file := doc.dir.IdToFile(id)
+	if file == nil {
+		file = doc.dir.NameToFile(id)
+	}
+	if file == nil {
+		file = doc.dir.TitleToFile(id)
+	}
+	return doc.fileToUrl(file)
+}
+
+// Returns the data of the file with the given ID, as found in INCL chunks
+func (doc *Document) includedData(ctx context.Context, id string) (*DataPool, error) {
+	url := doc.IdToUrl(doc, id)
+	if url == nil {
+		return nil, errors.Errorf("no file %q to include", id)
+	}
+	included := doc.RequestData(ctx, doc, url)
+	if included == nil {
+		included = GetPortCaster().RequestData(ctx, doc, url)
+	}
+	if included == nil {
+		return nil, errors.Errorf("no data for included file %s", url.Raw())
+	}
+	return included, nil
+}
+
+// RequestData serves the files of a BUNDLED document,
+// whose URLs are given by PageToUrl and IdToUrl,
+// from the data of the document.
+func (doc *Document) RequestData(ctx context.Context, source Port, url *Url) *DataPool {
+	if url == nil || doc.pool == nil {
+		return nil
+	}
+	if doc.docType == SINGLE_PAGE && url.Equal(doc.url) {
+		return doc.pool
+	}
+	if doc.docType != BUNDLED {
+		return nil
+	}
+
+	id := url.HashArgument()
+	base := url.Copy()
+	base.ClearHashArguments()
+	if id == "" || !base.Equal(doc.url) {
+		return nil
+	}
+	file := doc.dir.IdToFile(id)
+	if file == nil {
+		return nil
+	}
+	return NewSlaveDataPool(doc.pool, int64(file.Offset), int64(file.Size))
+}
+
+// Subscribe returns a Subscription to the notifications
+// sent by this Document and by everything routed to it
+// (such as the Files composing it).
+// Close the Subscription once done with it.
+func (doc *Document) Subscribe(opts ...SubscribeOption) *Subscription {
+	doc.mtx.Lock()
+	if doc.events == nil {
+		doc.events = NewEventPort()
+		GetPortCaster().AddRoute(doc, doc.events)
+	}
+	events := doc.events
+	doc.mtx.Unlock()
+
+	return events.Subscribe(opts...)
+}
+
+// Events is a shorthand for Subscribe(opts...).C,
+// for when the events are wanted for as long as the Document lives:
+//
+//     for ev := range doc.Events() {
+//         // Handle ev
+//     }
+func (doc *Document) Events(opts ...SubscribeOption) <-chan Event {
+	return doc.Subscribe(opts...).C
 }
@@ -0,0 +1,319 @@
+package djvu
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/janreggie/go-djvulibre/djvu/iff"
+	"github.com/stretchr/testify/suite"
+)
+
+type DocumentTestSuite struct {
+	suite.Suite
+	Mem   *MemoryPort
+	Ctx   context.Context
+	Pages []*iff.Chunk // Three single page documents
+}
+
+func TestDocumentSuite(t *testing.T) {
+	suite.Run(t, new(DocumentTestSuite))
+}
+
+func (s *DocumentTestSuite) SetupTest() {
+	s.Mem = NewMemoryPort()
+	s.Ctx = context.Background()
+	s.Pages = nil
+	for ii := 0; ii < 3; ii++ {
+		s.Pages = append(s.Pages, testPage(uint16(100+ii), 200))
+	}
+}
+
+// testPage returns a FORM:DJVU holding nothing but its INFO chunk
+func testPage(width, height uint16) *iff.Chunk {
+	var info bytes.Buffer
+	i := NewInfo()
+	i.Width, i.Height, i.Dpi, i.Gamma = width, height, 300, 2.2
+	if err := i.Encode(&info); err != nil {
+		panic(err)
+	}
+	return &iff.Chunk{ID: "FORM:DJVU", Children: []*iff.Chunk{{ID: "INFO", Data: info.Bytes()}}}
+}
+
+func (s *DocumentTestSuite) url(raw string) *Url {
+	url, err := NewUrl(raw)
+	s.Require().NoError(err)
+	return url
+}
+
+func (s *DocumentTestSuite) bytes(chunk *iff.Chunk, magic bool) []byte {
+	var buf bytes.Buffer
+	s.Require().NoError(chunk.Encode(&buf, magic))
+	return buf.Bytes()
+}
+
+// Builds a bundled document out of the pages
+func (s *DocumentTestSuite) bundle() []byte {
+	dir := NewMultiDir()
+	var components [][]byte
+	for ii, page := range s.Pages {
+		data := s.bytes(page, false)
+		components = append(components, data)
+		s.NoError(dir.InsertFile(&MultiDirFile{ID: []string{"a.djvu", "b.djvu", "c.djvu"}[ii], Type: FILE_PAGE, Size: len(data), Offset: 1}, -1))
+	}
+
+	// The size of DIRM does not depend on the offsets
+	var dirm bytes.Buffer
+	s.NoError(dir.Encode(&dirm, true))
+	offset := 4 + 12 + 8 + dirm.Len()
+	for ii, f := range dir.GetFiles() {
+		offset += offset & 1
+		f.Offset = offset
+		offset += len(components[ii])
+	}
+	dirm.Reset()
+	s.NoError(dir.Encode(&dirm, true))
+
+	doc := &iff.Chunk{ID: "FORM:DJVM", Children: []*iff.Chunk{{ID: "DIRM", Data: dirm.Bytes()}}}
+	doc.Children = append(doc.Children, s.Pages...)
+	return s.bytes(doc, true)
+}
+
+func (s *DocumentTestSuite) pageWidth(pool *DataPool) uint16 {
+	page, err := iff.Decode(pool.NewReader(s.Ctx))
+	s.Require().NoError(err)
+	info := NewInfo()
+	s.Require().NoError(info.Decode(bytes.NewReader(page.Find("INFO").Data)))
+	return info.Width
+}
+
+func (s *DocumentTestSuite) TestMemoryPort() {
+	url := s.url("memory:doc.djvu")
+	s.Nil(s.Mem.RequestData(s.Ctx, nil, url))
+
+	s.Mem.Add(url, []byte("data"))
+	pool := s.Mem.RequestData(s.Ctx, nil, s.url("memory:doc.djvu#page"))
+	s.NotNil(pool)
+	data, err := io.ReadAll(pool.NewReader(s.Ctx))
+	s.NoError(err)
+	s.Equal("data", string(data))
+	s.True(s.Mem.Copy().(*MemoryPort).RequestData(s.Ctx, nil, url) == pool)
+
+	s.Mem.Remove(url)
+	s.Nil(s.Mem.RequestData(s.Ctx, nil, url))
+	s.True(s.Mem.Inherits("MemoryPort"))
+	s.True(s.Mem.Inherits("Port"))
+}
+
+func (s *DocumentTestSuite) TestSinglePage() {
+	url := s.url("memory:single.djvu")
+	s.Mem.Add(url, s.bytes(s.Pages[0], true))
+
+	doc, err := OpenDocument(s.Ctx, url, s.Mem)
+	s.Require().NoError(err)
+	defer doc.Close()
+	s.Equal(SINGLE_PAGE, doc.GetDocType())
+	s.Equal(1, doc.GetPagesNum())
+	s.Nil(doc.GetMultiDir())
+	s.True(doc.PageToUrl(0).Equal(url))
+	s.Nil(doc.PageToUrl(1))
+	s.Equal(DOC_TYPE_KNOWN|DOC_INIT_OK, doc.GetDocFlags())
+
+	pool, err := doc.GetPageData(s.Ctx, 0)
+	s.NoError(err)
+	s.Equal(uint16(100), s.pageWidth(pool))
+	_, err = doc.GetPageData(s.Ctx, 1)
+	s.Error(err)
+}
+
+func (s *DocumentTestSuite) TestBundled() {
+	url := s.url("memory:bundled.djvu")
+	s.Mem.Add(url, s.bundle())
+
+	doc, err := OpenDocument(s.Ctx, url, s.Mem)
+	s.Require().NoError(err)
+	defer doc.Close()
+	s.Equal(BUNDLED, doc.GetDocType())
+	s.Equal(3, doc.GetPagesNum())
+	s.Equal("memory:bundled.djvu#b.djvu", doc.PageToUrl(1).Raw())
+	s.Equal("memory:bundled.djvu#c.djvu", doc.IdToUrl(nil, "c.djvu").Raw())
+
+	for ii := 0; ii < 3; ii++ {
+		pool, err := doc.GetPageData(s.Ctx, ii)
+		s.NoError(err)
+		s.Equal(uint16(100+ii), s.pageWidth(pool))
+	}
+
+	// Files routed to the document get their data from it
+	file := &SimplePort{}
+	GetPortCaster().AddRoute(file, doc)
+	defer GetPortCaster().DelPort(file)
+	pool := GetPortCaster().RequestData(s.Ctx, file, doc.PageToUrl(2))
+	s.NotNil(pool)
+	s.Equal(uint16(102), s.pageWidth(pool))
+}
+
+// testIndirect returns an indirect document made of `pages`,
+// as the data of its index and of each of its pages.
+// The i-th page has a width of 100+i.
+func testIndirect(pages ...string) ([]byte, map[string][]byte) {
+	dir := NewMultiDir()
+	files := make(map[string][]byte)
+	for ii, name := range pages {
+		if err := dir.InsertFile(&MultiDirFile{ID: name, Type: FILE_PAGE}, -1); err != nil {
+			panic(err)
+		}
+		data, err := testPage(uint16(100+ii), 200).Bytes()
+		if err != nil {
+			panic(err)
+		}
+		files[name] = data
+	}
+	var dirm bytes.Buffer
+	if err := dir.Encode(&dirm, false); err != nil {
+		panic(err)
+	}
+	index, err := (&iff.Chunk{ID: "FORM:DJVM", Children: []*iff.Chunk{{ID: "DIRM", Data: dirm.Bytes()}}}).Bytes()
+	if err != nil {
+		panic(err)
+	}
+	return index, files
+}
+
+func (s *DocumentTestSuite) TestIndirect() {
+	index, files := testIndirect("p 1.djvu", "p 2.djvu", "p 3.djvu")
+	for name, data := range files {
+		s.Mem.Add(s.url("memory:books/"+encodeReserved(name)), data)
+	}
+	url := s.url("memory:books/index.djvu")
+	s.Mem.Add(url, index)
+
+	doc, err := OpenDocument(s.Ctx, url, s.Mem)
+	s.Require().NoError(err)
+	defer doc.Close()
+	s.Equal(INDIRECT, doc.GetDocType())
+	s.Equal(3, doc.GetPagesNum())
+	s.Equal("memory:books/p%201.djvu", doc.PageToUrl(0).Raw())
+
+	pool, err := doc.GetPageData(s.Ctx, 2)
+	s.NoError(err)
+	s.Equal(uint16(102), s.pageWidth(pool))
+
+	s.Mem.Remove(doc.PageToUrl(1))
+	_, err = doc.GetPageData(s.Ctx, 1)
+	s.Error(err)
+}
+
+func (s *DocumentTestSuite) TestIndirectOnDisk() {
+	dir := s.T().TempDir()
+	index, files := testIndirect("p 1.djvu", "p 2.djvu")
+	for name, data := range files {
+		s.Require().NoError(os.WriteFile(filepath.Join(dir, name), data, 0666))
+	}
+	s.Require().NoError(os.WriteFile(filepath.Join(dir, "index.djvu"), index, 0666))
+	url, err := UrlFromFilename(filepath.Join(dir, "index.djvu"))
+	s.Require().NoError(err)
+
+	// Without a port, documents fall back to the local files
+	doc, err := OpenDocument(s.Ctx, url, nil)
+	s.Require().NoError(err)
+	defer doc.Close()
+	s.Equal(INDIRECT, doc.GetDocType())
+	s.True(doc.PageToUrl(1).IsFile())
+
+	pool, err := doc.GetPageData(s.Ctx, 1)
+	s.NoError(err)
+	s.Equal(uint16(101), s.pageWidth(pool))
+}
+
+func (s *DocumentTestSuite) TestOpenErrors() {
+	_, err := OpenDocument(s.Ctx, s.url("memory:missing.djvu"), s.Mem)
+	s.Error(err)
+
+	url := s.url("memory:old.djvu")
+	old := &iff.Chunk{ID: "FORM:DJVM", Children: []*iff.Chunk{{ID: "DIR0", Data: []byte{0}}}}
+	s.Mem.Add(url, s.bytes(old, true))
+	_, err = OpenDocument(s.Ctx, url, s.Mem)
+	s.ErrorIs(err, ErrUnsupportedFormat)
+
+	s.Mem.Add(url, []byte("AT&TFORM\x00\x00\x00\x04JUNK"))
+	_, err = OpenDocument(s.Ctx, url, s.Mem)
+	s.Error(err)
+
+	// Waiting for data which never comes
+	url = s.url("memory:stream.djvu")
+	pool := NewDataPool()
+	s.NoError(pool.AddData([]byte("AT&TFORM")))
+	s.Mem.AddPool(url, pool)
+	ctx, cancel := context.WithCancel(s.Ctx)
+	cancel()
+	_, err = OpenDocument(ctx, url, s.Mem)
+	s.ErrorIs(err, context.Canceled)
+}
+
+// Returns the number of ports alive in the PortCaster
+func portsAlive() int {
+	c := GetPortCaster()
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return len(c.contMap)
+}
+
+func (s *DocumentTestSuite) TestImplicitPort() {
+	dir := s.T().TempDir()
+	s.Require().NoError(os.WriteFile(filepath.Join(dir, "page.djvu"), s.bytes(s.Pages[0], true), 0666))
+	s.Require().NoError(os.WriteFile(filepath.Join(dir, "junk.djvu"), []byte("AT&TFORM\x00\x00\x00\x04JUNK"), 0666))
+	alive := portsAlive()
+
+	url, err := UrlFromFilename(filepath.Join(dir, "page.djvu"))
+	s.Require().NoError(err)
+	doc, err := OpenDocument(s.Ctx, url, nil)
+	s.Require().NoError(err)
+	s.Equal(alive+2, portsAlive()) // The document and its SimplePort
+	implicit := doc.implicit
+	doc.Close()
+	s.Nil(GetPortCaster().IsPortAlive(implicit))
+	s.Equal(alive, portsAlive())
+
+	for _, name := range []string{"missing.djvu", "junk.djvu"} {
+		url, err := UrlFromFilename(filepath.Join(dir, name))
+		s.Require().NoError(err)
+		_, err = OpenDocument(s.Ctx, url, nil)
+		s.Error(err, name)
+		s.Equal(alive, portsAlive(), name)
+	}
+}
+
+func (s *DocumentTestSuite) TestImplicitPortFiles() {
+	openFiles := func() int {
+		fds, err := os.ReadDir("/proc/self/fd")
+		if err != nil {
+			s.T().Skip("cannot count open files:", err)
+		}
+		return len(fds)
+	}
+	dir := s.T().TempDir()
+	index, files := testIndirect("p1.djvu", "p2.djvu")
+	for name, data := range files {
+		s.Require().NoError(os.WriteFile(filepath.Join(dir, name), data, 0666))
+	}
+	s.Require().NoError(os.WriteFile(filepath.Join(dir, "index.djvu"), index, 0666))
+	url, err := UrlFromFilename(filepath.Join(dir, "index.djvu"))
+	s.Require().NoError(err)
+
+	before := openFiles()
+	doc, err := OpenDocument(s.Ctx, url, nil)
+	s.Require().NoError(err)
+	for ii := 0; ii < 20; ii++ {
+		_, err := doc.GetPage(s.Ctx, ii%2)
+		s.Require().NoError(err)
+	}
+	_, err = NewEditor(s.Ctx, doc)
+	s.Require().NoError(err)
+	s.Equal(before+3, openFiles()) // The index and the two pages
+	doc.Close()
+	s.Equal(before, openFiles())
+}
@@ -1,18 +1,1084 @@
 package djvu
 
-/** #DjVuDocEditor# is an extension of \Ref{DjVuDocument} class with
-  additional capabilities for editing the document contents.
-
-  It can be used to:
-  \begin{enumerate}
-     \item Create (compose) new multipage DjVu documents using single
-           page DjVu documents. The class does {\bf not} do compression.
-     \item Insert and remove different pages of multipage DjVu documents.
-     \item Change attributes ({\em names}, {\em IDs} and {\em titles})
-           of files composing the DjVu document.
-     \item Generate thumbnail images and integrate them into the document.
-  \end{enumerate}
-*/
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/janreggie/go-djvulibre/djvu/iff"
+	"github.com/janreggie/go-djvulibre/djvu/image"
+	"github.com/pkg/errors"
+)
+
+// Editor edits a copy of a Document in memory.
+// It can insert, remove and move pages, change the IDs, names and titles
+// of the files, the shared annotations and the thumbnails,
+// merge and split documents, and write the result
+// as a bundled or indirect document.
+// The outline keeps pointing to the same pages.
+// It does not compress pages.
+//
+// The Document it was created from is left unchanged,
+// and only used to locate the files when saving.
 type Editor struct {
-	Document
+	doc *Document // The document being edited, as it was opened
+
+	dir     *MultiDir             // Files of the edited document, without thumbnails files
+	files   map[string]*iff.Chunk // Contents of the files, by ID
+	outline *Outline
+	thumbs  map[string]*iff.Chunk // `TH44` chunks of the pages, by ID
+}
+
+// Number of thumbnails held by each thumbnails file written by Editor
+const thumbnailsPerFile = 10
+
+// NewEditor returns an Editor holding the files of `doc`,
+// which are all read in memory.
+// A single page document is edited as a multipage document of one page.
+func NewEditor(ctx context.Context, doc *Document) (*Editor, error) {
+	e := &Editor{
+		doc:     doc,
+		dir:     NewMultiDir(),
+		files:   make(map[string]*iff.Chunk),
+		outline: NewOutline(),
+		thumbs:  make(map[string]*iff.Chunk),
+	}
+
+	switch doc.docType {
+	case SINGLE_PAGE:
+		form, err := iff.Decode(doc.pool.NewReader(ctx))
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read page")
+		}
+		id := doc.url.Name()
+		if id == "" {
+			id = "page0001.djvu"
+		}
+		if err := e.dir.InsertFile(&MultiDirFile{ID: id, Type: FILE_PAGE}, -1); err != nil {
+			return nil, err
+		}
+		e.files[id] = form
+
+	case BUNDLED, INDIRECT:
+		forms := make(map[string]*iff.Chunk)
+		for _, file := range doc.dir.GetFiles() {
+			pool, err := doc.includedData(ctx, file.ID)
+			if err != nil {
+				return nil, err
+			}
+			form, err := iff.Decode(pool.NewReader(ctx))
+			if err != nil {
+				return nil, errors.Wrapf(err, "could not read file %q", file.ID)
+			}
+			forms[file.ID] = form
+			if file.Type == FILE_THUMBNAILS {
+				continue
+			}
+			copied := *file
+			copied.Offset, copied.Size = 0, 0
+			if err := e.dir.InsertFile(&copied, -1); err != nil {
+				return nil, err
+			}
+			e.files[file.ID] = form
+		}
+
+		pages := doc.dir.GetPagesNum()
+		eachThumbnailsFile(doc.dir, func(file *MultiDirFile, first int) error {
+			for ii, chunk := range forms[file.ID].FindAll("TH44") {
+				if first+ii >= pages {
+					break
+				}
+				e.thumbs[doc.dir.PageToFile(first+ii).ID] = chunk
+			}
+			return nil
+		})
+
+		outline, err := doc.GetOutline(ctx)
+		if err != nil {
+			return nil, err
+		}
+		e.outline = outline
+
+	default:
+		return nil, errors.Wrap(ErrUnsupportedFormat, doc.docType.String())
+	}
+	return e, nil
+}
+
+// GetMultiDir returns the directory of the edited document.
+// Thumbnails files are only added to it by Write.
+func (e *Editor) GetMultiDir() *MultiDir {
+	return e.dir
+}
+
+// GetPagesNum returns the number of pages of the edited document.
+func (e *Editor) GetPagesNum() int {
+	return e.dir.GetPagesNum()
+}
+
+// Returns the file with the given ID, as found in INCL chunks,
+// trying names and titles after IDs like Document.IdToUrl
+func (e *Editor) idToFile(id string) *MultiDirFile {
+	file := e.dir.IdToFile(id)
+	if file == nil {
+		file = e.dir.NameToFile(id)
+	}
+	if file == nil {
+		file = e.dir.TitleToFile(id)
+	}
+	return file
+}
+
+// GetPage decodes page `page` of the edited document, counted from 0.
+func (e *Editor) GetPage(ctx context.Context, page int) (*Page, error) {
+	file := e.dir.PageToFile(page)
+	if file == nil {
+		return nil, errors.Errorf("page %d out of range [0, %d)", page, e.GetPagesNum())
+	}
+	p, err := NewPage(page, e.files[file.ID])
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read page %d", page)
+	}
+	for _, chunk := range p.Form.FindAll("INCL") {
+		id := strings.TrimSpace(string(chunk.Data))
+		included := e.idToFile(id)
+		if included == nil {
+			return nil, errors.Errorf("page %d includes missing file %q", page, id)
+		}
+		p.Included = append(p.Included, e.files[included.ID])
+	}
+	return p, nil
+}
+
+// GetThumbnail returns the thumbnail of page `page` of the edited document,
+// counted from 0, or nil if it has none.
+func (e *Editor) GetThumbnail(ctx context.Context, page int) (*image.Pixmap, error) {
+	file := e.dir.PageToFile(page)
+	if file == nil {
+		return nil, errors.Errorf("page %d out of range [0, %d)", page, e.GetPagesNum())
+	}
+	chunk := e.thumbs[file.ID]
+	if chunk == nil {
+		return nil, nil
+	}
+	return DecodeThumbnail(chunk)
+}
+
+// SetThumbnail sets the thumbnail of page `page`, counted from 0.
+// A nil thumbnail removes the one of the page.
+func (e *Editor) SetThumbnail(page int, pm *image.Pixmap) error {
+	file := e.dir.PageToFile(page)
+	if file == nil {
+		return errors.Errorf("page %d out of range [0, %d)", page, e.GetPagesNum())
+	}
+	if pm == nil {
+		delete(e.thumbs, file.ID)
+		return nil
+	}
+	chunk, err := EncodeThumbnail(pm)
+	if err != nil {
+		return err
+	}
+	e.thumbs[file.ID] = chunk
+	return nil
+}
+
+// RemoveThumbnails removes the thumbnails of all pages.
+func (e *Editor) RemoveThumbnails() {
+	e.thumbs = make(map[string]*iff.Chunk)
+}
+
+// GenerateThumbnails renders the thumbnails of the pages which have none,
+// so that their larger side is `size` pixels long.
+// Use ThumbnailSize unless viewers ask for another size.
+func (e *Editor) GenerateThumbnails(ctx context.Context, size int) error {
+	for ii := 0; ii < e.GetPagesNum(); ii++ {
+		if e.thumbs[e.dir.PageToFile(ii).ID] != nil {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		page, err := e.GetPage(ctx, ii)
+		if err != nil {
+			return err
+		}
+		pm, err := page.Thumbnail(size)
+		if err != nil {
+			return errors.Wrapf(err, "could not render thumbnail of page %d", ii)
+		}
+		if err := e.SetThumbnail(ii, pm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the position of file among the files of the edited document, or -1
+func (e *Editor) filePos(file *MultiDirFile) int {
+	for ii, f := range e.dir.files {
+		if f == file {
+			return ii
+		}
+	}
+	return -1
+}
+
+// A file included by a page being inserted, with the ID it is given
+type includedFile struct {
+	id   string
+	form *iff.Chunk
+}
+
+// Resolves the INCL chunks of a page being inserted by InsertPage
+type includer struct {
+	e      *Editor
+	pageID string
+	given  map[string]*iff.Chunk // Files given with the page, by the IDs it includes them with
+	ids    map[string]string     // IDs given to these files, "" while resolving their own INCL chunks
+	insert []includedFile        // Files to insert, in order
+}
+
+// Returns form, or a copy of it whose INCL chunks name the IDs given to the files they include
+func (in *includer) form(form *iff.Chunk) (*iff.Chunk, error) {
+	children := make([]*iff.Chunk, len(form.Children))
+	changed := false
+	for ii, chunk := range form.Children {
+		children[ii] = chunk
+		if chunk.ID != "INCL" {
+			continue
+		}
+		id := strings.TrimSpace(string(chunk.Data))
+		newID, err := in.file(id)
+		if err != nil {
+			return nil, err
+		}
+		if newID != id {
+			children[ii] = &iff.Chunk{ID: "INCL", Data: []byte(newID)}
+			changed = true
+		}
+	}
+	if !changed {
+		return form, nil
+	}
+	copied := *form
+	copied.Children = children
+	return &copied, nil
+}
+
+// Returns the ID of the file included as `id`.
+// A given file is shared with an identical file of the document,
+// or else inserted under `id` or a variant of it which is not in use.
+func (in *includer) file(id string) (string, error) {
+	if newID, ok := in.ids[id]; ok {
+		if newID == "" {
+			return "", errors.Errorf("included file %q includes itself", id)
+		}
+		return newID, nil
+	}
+	form, ok := in.given[id]
+	if !ok {
+		if in.e.idToFile(id) == nil {
+			return "", errors.Errorf("included file %q is not in the document", id)
+		}
+		return id, nil
+	}
+	if form.ID != "FORM:DJVI" {
+		return "", errors.Errorf("included file %q is a %q instead of a FORM:DJVI", id, form.ID)
+	}
+
+	in.ids[id] = ""
+	form, err := in.form(form)
+	if err != nil {
+		return "", err
+	}
+	data, err := form.Bytes()
+	if err != nil {
+		return "", errors.Wrapf(err, "could not write included file %q", id)
+	}
+	same := func(candidate string) bool {
+		file := in.e.dir.IdToFile(candidate)
+		if file == nil || file.Type != FILE_INCLUDE {
+			return false
+		}
+		existing, err := in.e.files[candidate].Bytes()
+		return err == nil && bytes.Equal(existing, data)
+	}
+	newID := uniqueID(id, func(candidate string) bool {
+		if candidate == in.pageID {
+			return true
+		}
+		for _, file := range in.insert {
+			if file.id == candidate {
+				return true
+			}
+		}
+		return in.e.dir.IdToFile(candidate) != nil && !same(candidate)
+	})
+	in.ids[id] = newID
+	if !same(newID) {
+		in.insert = append(in.insert, includedFile{id: newID, form: form})
+	}
+	return newID, nil
+}
+
+// InsertPage inserts the page held by `form`, a `FORM:DJVU` chunk, under the ID `id`,
+// so that it becomes page `at`, counted from 0.
+// The page is added after the last page if `at` is negative.
+//
+// The files the page includes, such as shared dictionaries,
+// are either in the document or given in `included`,
+// by the IDs the INCL chunks of the page, or of the other included files, use.
+// Each given file is inserted before the page,
+// unless the document holds an identical file under that ID or a variant of it,
+// as inserted with a previous page, which is then shared.
+// Given files whose IDs are in use are inserted under variants `name_N.ext`,
+// and the INCL chunks naming them are changed in copies of the forms.
+//
+// The page is made to include the annotations shared by all pages, if any.
+func (e *Editor) InsertPage(at int, id string, form *iff.Chunk, included map[string]*iff.Chunk) error {
+	pages := e.GetPagesNum()
+	if at < 0 {
+		at = pages
+	}
+	if at > pages {
+		return errors.Errorf("cannot insert page at %d, past the %d pages", at, pages)
+	}
+	if form.ID != "FORM:DJVU" {
+		return errors.Errorf("%q is not a page", form.ID)
+	}
+	if e.dir.IdToFile(id) != nil {
+		return errors.Errorf("could not insert page: ID %q already in use", id)
+	}
+	in := &includer{e: e, pageID: id, given: included, ids: make(map[string]string)}
+	form, err := in.form(form)
+	if err != nil {
+		return errors.Wrapf(err, "could not insert page %q", id)
+	}
+
+	targets := e.outlineTargets()
+	pos := -1
+	if at < pages {
+		pos = e.filePos(e.dir.PageToFile(at))
+	}
+	for _, file := range append(in.insert, includedFile{id: id, form: form}) {
+		fileType := FILE_INCLUDE
+		if file.id == id {
+			fileType = FILE_PAGE
+		}
+		if err := e.dir.InsertFile(&MultiDirFile{ID: file.id, Type: fileType}, pos); err != nil {
+			return errors.Wrap(err, "could not insert page")
+		}
+		e.files[file.id] = file.form
+		if pos >= 0 {
+			pos++
+		}
+	}
+	if shared := e.sharedAnnoFile(); shared != nil && !includes(form, shared.ID) {
+		includeFirst(form, shared.ID)
+	}
+	e.updateOutline(targets)
+	return nil
+}
+
+// Returns whether form includes the file `id`
+func includes(form *iff.Chunk, id string) bool {
+	for _, chunk := range form.FindAll("INCL") {
+		if strings.TrimSpace(string(chunk.Data)) == id {
+			return true
+		}
+	}
+	return false
+}
+
+// RemovePage removes page `page`, counted from 0,
+// along with the included files no other file uses.
+// Bookmarks pointing to the page are removed, their children taking their place.
+func (e *Editor) RemovePage(page int) error {
+	file := e.dir.PageToFile(page)
+	if file == nil {
+		return errors.Errorf("page %d out of range [0, %d)", page, e.GetPagesNum())
+	}
+	targets := e.outlineTargets()
+	e.dir.DeleteFile(file.ID)
+	delete(e.files, file.ID)
+	delete(e.thumbs, file.ID)
+	e.removeUnused()
+	e.updateOutline(targets)
+	return nil
+}
+
+// Removes the included files which neither pages nor shared annotations use
+func (e *Editor) removeUnused() {
+	used := make(map[string]bool)
+	var use func(file *MultiDirFile)
+	use = func(file *MultiDirFile) {
+		if used[file.ID] {
+			return
+		}
+		used[file.ID] = true
+		for _, chunk := range e.files[file.ID].FindAll("INCL") {
+			if included := e.idToFile(strings.TrimSpace(string(chunk.Data))); included != nil {
+				use(included)
+			}
+		}
+	}
+	for _, file := range e.dir.GetFiles() {
+		if file.Type != FILE_INCLUDE {
+			use(file)
+		}
+	}
+	var unused []string
+	for _, file := range e.dir.GetFiles() {
+		if !used[file.ID] {
+			unused = append(unused, file.ID)
+		}
+	}
+	for _, id := range unused {
+		e.dir.DeleteFile(id)
+		delete(e.files, id)
+	}
+}
+
+// MovePage moves page `from` so that it becomes page `to`, both counted from 0.
+func (e *Editor) MovePage(from, to int) error {
+	pages := e.GetPagesNum()
+	if from < 0 || from >= pages || to < 0 || to >= pages {
+		return errors.Errorf("cannot move page %d to %d: out of range [0, %d)", from, to, pages)
+	}
+	order := make([]int, 0, pages)
+	for ii := 0; ii < pages; ii++ {
+		if ii != from {
+			order = append(order, ii)
+		}
+	}
+	order = append(order[:to], append([]int{from}, order[to:]...)...)
+	return e.SetPageOrder(order)
+}
+
+// SetPageOrder reorders the pages, so that they come in the order of `order`,
+// which holds the number of each page once, counted from 0.
+// The files other than pages keep their positions among the files of the document.
+func (e *Editor) SetPageOrder(order []int) error {
+	pages := e.GetPagesNum()
+	if len(order) != pages {
+		return errors.Errorf("order of %d pages for %d pages", len(order), pages)
+	}
+	files := make([]*MultiDirFile, pages)
+	seen := make([]bool, pages)
+	for ii, page := range order {
+		if page < 0 || page >= pages {
+			return errors.Errorf("page %d out of range [0, %d)", page, pages)
+		}
+		if seen[page] {
+			return errors.Errorf("page %d given twice", page)
+		}
+		seen[page] = true
+		files[ii] = e.dir.PageToFile(page)
+	}
+
+	targets := e.outlineTargets()
+	page := 0
+	for ii, file := range e.dir.files {
+		if file.IsPage() {
+			e.dir.files[ii] = files[page]
+			page++
+		}
+	}
+	e.updateOutline(targets)
+	return nil
+}
+
+// Returns the page each bookmark of the outline points to, if any,
+// so that updateOutline can follow the pages as they are edited
+func (e *Editor) outlineTargets() map[*Bookmark]*MultiDirFile {
+	targets := make(map[*Bookmark]*MultiDirFile)
+	var find func(bookmarks []*Bookmark)
+	find = func(bookmarks []*Bookmark) {
+		for _, bookmark := range bookmarks {
+			if file := e.pageTarget(bookmark.URL); file != nil {
+				targets[bookmark] = file
+			}
+			find(bookmark.Children)
+		}
+	}
+	find(e.outline.Bookmarks)
+	return targets
+}
+
+// Returns the page a URL such as `#3` or `#chapter1.djvu` points to, or nil
+func (e *Editor) pageTarget(url string) *MultiDirFile {
+	if !strings.HasPrefix(url, "#") {
+		return nil
+	}
+	target := url[1:]
+	if page, err := strconv.Atoi(target); err == nil && target[0] != '+' && target[0] != '-' {
+		return e.dir.PageToFile(page - 1)
+	}
+	for _, file := range []*MultiDirFile{e.dir.IdToFile(target), e.dir.NameToFile(target), e.dir.TitleToFile(target)} {
+		if file != nil && file.IsPage() {
+			return file
+		}
+	}
+	return nil
+}
+
+// Updates the bookmarks given by outlineTargets after the pages have been edited.
+// Page numbers are renumbered,
+// and bookmarks to removed pages are replaced by their children.
+func (e *Editor) updateOutline(targets map[*Bookmark]*MultiDirFile) {
+	var update func(bookmarks []*Bookmark) []*Bookmark
+	update = func(bookmarks []*Bookmark) []*Bookmark {
+		var retval []*Bookmark
+		for _, bookmark := range bookmarks {
+			bookmark.Children = update(bookmark.Children)
+			file := targets[bookmark]
+			if file == nil {
+				retval = append(retval, bookmark)
+				continue
+			}
+			page := e.dir.FileToPage(file)
+			if page < 0 {
+				retval = append(retval, bookmark.Children...)
+				continue
+			}
+			if _, err := strconv.Atoi(bookmark.URL[1:]); err == nil {
+				bookmark.URL = "#" + strconv.Itoa(page+1)
+			}
+			retval = append(retval, bookmark)
+		}
+		return retval
+	}
+	e.outline.Bookmarks = update(e.outline.Bookmarks)
+}
+
+// SetFileID changes the ID of the file `id` to `newID`,
+// which must not be the ID of another file.
+// The INCL chunks and bookmarks using the ID follow.
+// Files without name or title are saved and shown under their new ID,
+// so it must not be the name or title of another file either.
+func (e *Editor) SetFileID(id, newID string) error {
+	file := e.dir.IdToFile(id)
+	if file == nil {
+		return errors.Errorf("no file %q", id)
+	}
+	if newID == id {
+		return nil
+	}
+	if newID == "" {
+		return errors.New("file ID cannot be empty")
+	}
+	if other := e.dir.IdToFile(newID); other != nil {
+		return errors.Errorf("file ID %q already in use", newID)
+	}
+	if other := e.dir.NameToFile(newID); file.Name == "" && other != nil {
+		return errors.Errorf("file name %q already in use by %q", newID, other.ID)
+	}
+	if other := e.dir.TitleToFile(newID); file.Title == "" && other != nil {
+		return errors.Errorf("file title %q already in use by %q", newID, other.ID)
+	}
+
+	for _, form := range e.files {
+		for _, chunk := range form.FindAll("INCL") {
+			if e.idToFile(strings.TrimSpace(string(chunk.Data))) == file {
+				chunk.Data = []byte(newID)
+			}
+		}
+	}
+	for bookmark, target := range e.outlineTargets() {
+		if target == file && bookmark.URL == "#"+id {
+			bookmark.URL = "#" + newID
+		}
+	}
+	e.files[newID] = e.files[id]
+	delete(e.files, id)
+	if thumb := e.thumbs[id]; thumb != nil {
+		e.thumbs[newID] = thumb
+		delete(e.thumbs, id)
+	}
+	file.ID = newID
+	return nil
+}
+
+// SetFileName changes the name the file `id` is saved under,
+// which must not be that of another file.
+// An empty name stands for the ID.
+func (e *Editor) SetFileName(id, name string) error {
+	file := e.dir.IdToFile(id)
+	if file == nil {
+		return errors.Errorf("no file %q", id)
+	}
+	if name == "" {
+		name = id
+	}
+	if other := e.dir.NameToFile(name); other != nil && other != file {
+		return errors.Errorf("file name %q already in use by %q", name, other.ID)
+	}
+	if name == id {
+		name = ""
+	}
+	file.Name = name
+	return nil
+}
+
+// SetPageTitle changes the title of page `page`, counted from 0,
+// which viewers show as the label of the page, such as `iv` or `Cover`.
+// The title must not be that of another file.
+// An empty title stands for the ID.
+// Bookmarks using the former title follow.
+func (e *Editor) SetPageTitle(page int, title string) error {
+	file := e.dir.PageToFile(page)
+	if file == nil {
+		return errors.Errorf("page %d out of range [0, %d)", page, e.GetPagesNum())
+	}
+	if title == "" {
+		title = file.ID
+	}
+	if other := e.dir.TitleToFile(title); other != nil && other != file {
+		return errors.Errorf("file title %q already in use by %q", title, other.ID)
+	}
+
+	old := file.GetTitle()
+	for bookmark, target := range e.outlineTargets() {
+		if target == file && bookmark.URL == "#"+old && old != file.ID && old != file.GetSaveName() {
+			bookmark.URL = "#" + title
+		}
+	}
+	if title == file.ID {
+		title = ""
+	}
+	file.Title = title
+	return nil
+}
+
+// Returns the file holding the annotations shared by all pages, or nil
+func (e *Editor) sharedAnnoFile() *MultiDirFile {
+	for _, file := range e.dir.GetFiles() {
+		if file.Type == FILE_SHARED_ANNO {
+			return file
+		}
+	}
+	return nil
+}
+
+// GetSharedAnno returns the annotations shared by all pages,
+// such as the metadata of the document.
+// The Anno is empty if there are none.
+func (e *Editor) GetSharedAnno() (*Anno, error) {
+	file := e.sharedAnnoFile()
+	if file == nil {
+		return NewAnno(), nil
+	}
+	return DecodeAnnoChunks(e.files[file.ID])
+}
+
+// SetSharedAnno replaces the annotations shared by all pages,
+// such as the metadata of the document.
+// They are held by a file of type FILE_SHARED_ANNO, included by every page,
+// which is created if needed and removed if anno is empty.
+func (e *Editor) SetSharedAnno(anno *Anno) error {
+	file := e.sharedAnnoFile()
+	if anno.IsEmpty() {
+		if file != nil {
+			e.removeIncluded(file)
+		}
+		return nil
+	}
+
+	if file == nil {
+		id := uniqueID("shared_anno.iff", func(id string) bool { return e.dir.IdToFile(id) != nil })
+		file = &MultiDirFile{ID: id, Type: FILE_SHARED_ANNO}
+		if err := e.dir.InsertFile(file, 0); err != nil {
+			return err
+		}
+		e.files[id] = &iff.Chunk{ID: "FORM:DJVI"}
+		for _, page := range e.dir.GetFiles() {
+			if page.IsPage() {
+				includeFirst(e.files[page.ID], id)
+			}
+		}
+	}
+	return SetAnnoChunks(e.files[file.ID], anno, true)
+}
+
+// Removes file and the INCL chunks including it
+func (e *Editor) removeIncluded(file *MultiDirFile) {
+	for _, form := range e.files {
+		var children []*iff.Chunk
+		for _, chunk := range form.Children {
+			if chunk.ID != "INCL" || e.idToFile(strings.TrimSpace(string(chunk.Data))) != file {
+				children = append(children, chunk)
+			}
+		}
+		form.Children = children
+	}
+	e.dir.DeleteFile(file.ID)
+	delete(e.files, file.ID)
+}
+
+// Includes the file `id` in form, after its INFO chunk,
+// so that the annotations of the file come before those of the form
+func includeFirst(form *iff.Chunk, id string) {
+	pos := 0
+	if len(form.Children) > 0 && form.Children[0].ID == "INFO" {
+		pos = 1
+	}
+	chunk := &iff.Chunk{ID: "INCL", Data: []byte(id)}
+	form.Children = append(form.Children[:pos], append([]*iff.Chunk{chunk}, form.Children[pos:]...)...)
+}
+
+// Merge appends the pages of `docs` to the edited document, in order,
+// along with the files they include and their thumbnails.
+// Files whose IDs are in use are given new IDs, which their INCL chunks follow,
+// their names are dropped if in use,
+// and their titles are given variants `title_N` if in use.
+// The shared annotations of each document are merged into those of the edited one,
+// which take precedence, and included by every page.
+// The bookmarks of each document are appended to the outline,
+// pointing to the same pages.
+func (e *Editor) Merge(ctx context.Context, docs ...*Document) error {
+	for _, doc := range docs {
+		other, err := NewEditor(ctx, doc)
+		if err != nil {
+			return errors.Wrapf(err, "could not merge %s", doc.url.Raw())
+		}
+		if err := e.merge(other); err != nil {
+			return errors.Wrapf(err, "could not merge %s", doc.url.Raw())
+		}
+	}
+	return nil
+}
+
+// Appends the files of other, which is not used afterwards
+func (e *Editor) merge(other *Editor) error {
+	var shared *Anno // The shared annotations of other, merged after its files
+	if file := other.sharedAnnoFile(); file != nil {
+		anno, err := other.GetSharedAnno()
+		if err != nil {
+			return err
+		}
+		other.removeIncluded(file)
+		shared = anno
+	}
+
+	pages := e.GetPagesNum()
+	ids := make(map[string]string) // New IDs of the files of other
+	taken := make(map[string]bool)
+	for _, file := range other.dir.GetFiles() {
+		ids[file.ID] = uniqueID(file.ID, func(id string) bool {
+			// Without a title, the ID is used as the title
+			return taken[id] || e.dir.IdToFile(id) != nil || (file.Title == "" && e.dir.TitleToFile(id) != nil)
+		})
+		taken[ids[file.ID]] = true
+	}
+
+	for _, file := range other.dir.GetFiles() {
+		form := other.files[file.ID]
+		for _, chunk := range form.FindAll("INCL") {
+			if included := other.idToFile(strings.TrimSpace(string(chunk.Data))); included != nil {
+				chunk.Data = []byte(ids[included.ID])
+			}
+		}
+		copied := *file
+		copied.ID = ids[file.ID]
+		if copied.Name != "" && e.dir.NameToFile(copied.Name) != nil {
+			copied.Name = ""
+		}
+		if copied.Title != "" {
+			copied.Title = uniqueID(copied.Title, func(title string) bool {
+				return taken[title] || e.dir.TitleToFile(title) != nil || e.dir.IdToFile(title) != nil
+			})
+		}
+		if err := e.dir.InsertFile(&copied, -1); err != nil {
+			return err
+		}
+		e.files[copied.ID] = form
+		if thumb := other.thumbs[file.ID]; thumb != nil {
+			e.thumbs[copied.ID] = thumb
+		}
+	}
+
+	for bookmark, file := range other.outlineTargets() {
+		if _, err := strconv.Atoi(bookmark.URL[1:]); err == nil {
+			bookmark.URL = "#" + strconv.Itoa(pages+other.dir.FileToPage(file)+1)
+		} else {
+			bookmark.URL = "#" + ids[file.ID]
+		}
+	}
+	e.outline.Bookmarks = append(e.outline.Bookmarks, other.outline.Bookmarks...)
+
+	if shared == nil {
+		return nil
+	}
+	anno, err := e.GetSharedAnno()
+	if err != nil {
+		return err
+	}
+	shared.Merge(anno)
+	existing := e.sharedAnnoFile()
+	if err := e.SetSharedAnno(shared); err != nil {
+		return err
+	}
+	if existing != nil { // Only included by the pages of e so far
+		for _, file := range other.dir.GetFiles() {
+			if file.IsPage() {
+				includeFirst(e.files[ids[file.ID]], existing.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// PageRange is a range of pages, counted from 0
+type PageRange struct {
+	First, Last int // Included
+}
+
+// Split returns the bundled documents holding each range of pages
+// of the edited document, which is left unchanged.
+// Each carries the files its pages include, the shared annotations,
+// the thumbnails of its pages
+// and the bookmarks pointing to them, renumbered.
+func (e *Editor) Split(ranges ...PageRange) ([][]byte, error) {
+	pages := e.GetPagesNum()
+	var retval [][]byte
+	for _, r := range ranges {
+		if r.First < 0 || r.Last >= pages || r.First > r.Last {
+			return nil, errors.Errorf("bad range of pages [%d, %d] for %d pages", r.First, r.Last, pages)
+		}
+		part := e.copy()
+		for page := pages - 1; page > r.Last; page-- {
+			if err := part.RemovePage(page); err != nil {
+				return nil, err
+			}
+		}
+		for page := r.First - 1; page >= 0; page-- {
+			if err := part.RemovePage(page); err != nil {
+				return nil, err
+			}
+		}
+		var buf bytes.Buffer
+		if err := part.Write(&buf); err != nil {
+			return nil, err
+		}
+		retval = append(retval, buf.Bytes())
+	}
+	return retval, nil
+}
+
+// Returns a copy of the editor which can be edited apart.
+// The contents of the files are shared.
+func (e *Editor) copy() *Editor {
+	retval := &Editor{
+		doc:     e.doc,
+		dir:     NewMultiDir(),
+		files:   make(map[string]*iff.Chunk, len(e.files)),
+		outline: &Outline{Bookmarks: copyBookmarks(e.outline.Bookmarks)},
+		thumbs:  make(map[string]*iff.Chunk, len(e.thumbs)),
+	}
+	for _, file := range e.dir.files {
+		copied := *file
+		retval.dir.files = append(retval.dir.files, &copied)
+	}
+	for id, form := range e.files {
+		retval.files[id] = form
+	}
+	for id, chunk := range e.thumbs {
+		retval.thumbs[id] = chunk
+	}
+	return retval
+}
+
+func copyBookmarks(bookmarks []*Bookmark) []*Bookmark {
+	var retval []*Bookmark
+	for _, bookmark := range bookmarks {
+		copied := *bookmark
+		copied.Children = copyBookmarks(bookmark.Children)
+		retval = append(retval, &copied)
+	}
+	return retval
+}
+
+// Returns id, or the first of its variants `name_N.ext` for which taken is false
+func uniqueID(id string, taken func(id string) bool) string {
+	if !taken(id) {
+		return id
+	}
+	name, ext := id, ""
+	if dot := strings.LastIndexByte(id, '.'); dot > 0 {
+		name, ext = id[:dot], id[dot:]
+	}
+	for n := 1; ; n++ {
+		if candidate := name + "_" + strconv.Itoa(n) + ext; !taken(candidate) {
+			return candidate
+		}
+	}
+}
+
+// Returns the files of the edited document as they are written,
+// with the thumbnails of consecutive pages grouped in thumbnails files
+// placed before the first page they cover,
+// and their contents
+func (e *Editor) components() (*MultiDir, []*iff.Chunk, error) {
+	dir := NewMultiDir()
+	var forms []*iff.Chunk
+	add := func(file *MultiDirFile, form *iff.Chunk) error {
+		forms = append(forms, form)
+		return dir.InsertFile(file, -1)
+	}
+
+	var thumbs *iff.Chunk // Thumbnails file of the previous page
+	for _, file := range e.dir.GetFiles() {
+		switch chunk := e.thumbs[file.ID]; {
+		case !file.IsPage():
+			// Files between pages do not break the thumbnails
+		case chunk == nil:
+			thumbs = nil
+		case thumbs != nil && len(thumbs.Children) < thumbnailsPerFile:
+			thumbs.Children = append(thumbs.Children, chunk)
+		default:
+			thumbs = &iff.Chunk{ID: "FORM:THUM", Children: []*iff.Chunk{chunk}}
+			id := file.ID
+			if dot := strings.LastIndexByte(id, '.'); dot > 0 {
+				id = id[:dot]
+			}
+			id = uniqueID(id+".thumb", func(id string) bool {
+				return e.dir.IdToFile(id) != nil || dir.IdToFile(id) != nil
+			})
+			if err := add(&MultiDirFile{ID: id, Type: FILE_THUMBNAILS}, thumbs); err != nil {
+				return nil, nil, err
+			}
+		}
+		copied := *file
+		copied.Offset, copied.Size = 0, 0
+		if err := add(&copied, e.files[file.ID]); err != nil {
+			return nil, nil, err
+		}
+	}
+	return dir, forms, nil
+}
+
+// Write writes the edited document as a bundled document.
+//
+// The thumbnails of consecutive pages are grouped in thumbnails files
+// placed before the first page they cover,
+// and named after it with the extension `.thumb`.
+func (e *Editor) Write(w io.Writer) error {
+	dir, forms, err := e.components()
+	if err != nil {
+		return err
+	}
+	for ii, form := range forms {
+		var buf bytes.Buffer
+		if err := form.Encode(&buf, false); err != nil {
+			return errors.Wrapf(err, "could not encode file %q", dir.GetFiles()[ii].ID)
+		}
+		dir.GetFiles()[ii].Size, dir.GetFiles()[ii].Offset = buf.Len(), 1
+	}
+
+	// The size of DIRM does not depend on the offsets
+	var dirm bytes.Buffer
+	if err := dir.Encode(&dirm, true); err != nil {
+		return err
+	}
+	doc := &iff.Chunk{ID: "FORM:DJVM", Children: []*iff.Chunk{{ID: "DIRM", Data: dirm.Bytes()}}}
+	if err := SetOutlineChunk(doc, e.outline); err != nil {
+		return err
+	}
+	offset := 4 + 12
+	for _, chunk := range doc.Children {
+		offset += offset & 1
+		offset += 8 + len(chunk.Data)
+	}
+	for _, file := range dir.GetFiles() {
+		offset += offset & 1
+		file.Offset = offset
+		offset += file.Size
+	}
+	dirm.Reset()
+	if err := dir.Encode(&dirm, true); err != nil {
+		return err
+	}
+	doc.Children[0].Data = dirm.Bytes()
+
+	doc.Children = append(doc.Children, forms...)
+	return errors.Wrap(doc.Encode(w, true), "could not write document")
+}
+
+// WriteIndirect writes the edited document as an indirect document
+// into the directory `dirname`:
+// the index under the name `index`, and each file under its ID.
+// Thumbnails are written like with Write.
+func (e *Editor) WriteIndirect(dirname, index string) error {
+	dir, forms, err := e.components()
+	if err != nil {
+		return err
+	}
+	for ii, file := range dir.GetFiles() {
+		if file.ID == index {
+			return errors.Errorf("file %q has the name of the index", file.ID)
+		}
+		if err := writeFile(filepath.Join(dirname, file.GetLoadName()), chunkWriter(forms[ii])); err != nil {
+			return err
+		}
+	}
+
+	var dirm bytes.Buffer
+	if err := dir.Encode(&dirm, false); err != nil {
+		return err
+	}
+	doc := &iff.Chunk{ID: "FORM:DJVM", Children: []*iff.Chunk{{ID: "DIRM", Data: dirm.Bytes()}}}
+	if err := SetOutlineChunk(doc, e.outline); err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(dirname, index), chunkWriter(doc))
+}
+
+// Writes the file `filename` with write, replacing it only once it is complete
+func writeFile(filename string, write func(w io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+"-*")
+	if err != nil {
+		return errors.Wrapf(err, "could not write %s", filename)
+	}
+	defer os.Remove(f.Name())
+	werr := write(f)
+	if err := f.Close(); werr == nil {
+		werr = err
+	}
+	if werr != nil {
+		return errors.Wrapf(werr, "could not write %s", filename)
+	}
+	return errors.Wrapf(os.Rename(f.Name(), filename), "could not write %s", filename)
+}
+
+// Returns a function writing chunk with the magic `AT&T`, for writeFile
+func chunkWriter(chunk *iff.Chunk) func(w io.Writer) error {
+	return func(w io.Writer) error { return chunk.Encode(w, true) }
+}
+
+// Save writes the edited document back to the local file it was opened from.
+// Indirect documents are saved as indirect documents,
+// their files next to the index,
+// and other documents as bundled documents.
+func (e *Editor) Save() error {
+	if !e.doc.url.IsLocalFileUrl() {
+		return errors.Errorf("cannot save %s, which is not a local file", e.doc.url.Raw())
+	}
+	filename, err := e.doc.url.Filename()
+	if err != nil {
+		return errors.Wrapf(err, "cannot save %s", e.doc.url.Raw())
+	}
+	if e.doc.docType == INDIRECT {
+		return e.WriteIndirect(filepath.Dir(filename), filepath.Base(filename))
+	}
+	return e.SaveAs(filename)
+}
+
+// SaveAs writes the edited document as a bundled document to the file `filename`.
+func (e *Editor) SaveAs(filename string) error {
+	return writeFile(filename, e.Write)
 }
@@ -0,0 +1,580 @@
+package djvu
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/janreggie/go-djvulibre/djvu/iff"
+	"github.com/janreggie/go-djvulibre/djvu/image"
+	"github.com/stretchr/testify/suite"
+)
+
+type EditorTestSuite struct {
+	suite.Suite
+	Mem *MemoryPort
+	Ctx context.Context
+}
+
+func TestEditorSuite(t *testing.T) {
+	suite.Run(t, new(EditorTestSuite))
+}
+
+func (s *EditorTestSuite) SetupTest() {
+	s.Mem = NewMemoryPort()
+	s.Ctx = context.Background()
+}
+
+func (s *EditorTestSuite) open(raw string, data []byte) *Document {
+	url, err := NewUrl(raw)
+	s.Require().NoError(err)
+	s.Mem.Add(url, data)
+	doc, err := OpenDocument(s.Ctx, url, s.Mem)
+	s.Require().NoError(err)
+	s.T().Cleanup(doc.Close)
+	return doc
+}
+
+// Opens an indirect document of pages a.djvu, b.djvu and c.djvu with the test outline
+func (s *EditorTestSuite) openIndirect() *Document {
+	indexData, files := testIndirect("a.djvu", "b.djvu", "c.djvu")
+	for name, data := range files {
+		url, err := NewUrl("memory:indirect/" + name)
+		s.Require().NoError(err)
+		s.Mem.Add(url, data)
+	}
+	index, err := iff.DecodeBytes(indexData)
+	s.Require().NoError(err)
+	s.Require().NoError(SetOutlineChunk(index, testOutline()))
+	indexData, err = index.Bytes()
+	s.Require().NoError(err)
+	return s.open("memory:indirect/index.djvu", indexData)
+}
+
+// Writes the edited document and opens it back
+func (s *EditorTestSuite) reopen(e *Editor, raw string) *Document {
+	var buf bytes.Buffer
+	s.Require().NoError(e.Write(&buf))
+	doc := s.open(raw, buf.Bytes())
+	s.Equal(BUNDLED, doc.GetDocType())
+	return doc
+}
+
+func (s *EditorTestSuite) fileIDs(dir *MultiDir) []string {
+	var ids []string
+	for _, file := range dir.GetFiles() {
+		ids = append(ids, file.ID)
+	}
+	return ids
+}
+
+func (s *EditorTestSuite) TestSinglePage() {
+	data, err := testPage(100, 50).Bytes()
+	s.Require().NoError(err)
+	e, err := NewEditor(s.Ctx, s.open("memory:single.djvu", data))
+	s.Require().NoError(err)
+	s.Equal(1, e.GetPagesNum())
+
+	doc := s.reopen(e, "memory:bundled.djvu")
+	s.Equal(1, doc.GetPagesNum())
+	page, err := doc.GetPage(s.Ctx, 0)
+	s.Require().NoError(err)
+	s.Equal(uint16(100), page.Info.Width)
+}
+
+func (s *EditorTestSuite) TestIndirect() {
+	e, err := NewEditor(s.Ctx, s.openIndirect())
+	s.Require().NoError(err)
+	s.Equal(3, e.GetPagesNum())
+	page, err := e.GetPage(s.Ctx, 1)
+	s.Require().NoError(err)
+	s.Equal(uint16(101), page.Info.Width)
+
+	doc := s.reopen(e, "memory:bundled.djvu")
+	s.Equal([]string{"a.djvu", "b.djvu", "c.djvu"}, s.fileIDs(doc.GetMultiDir()))
+	s.Equal(3, doc.GetPagesNum())
+	outline, err := doc.GetOutline(s.Ctx)
+	s.NoError(err)
+	s.Equal(testOutline(), outline)
+	for ii := 0; ii < 3; ii++ {
+		page, err := doc.GetPage(s.Ctx, ii)
+		s.Require().NoError(err)
+		s.Equal(uint16(100+ii), page.Info.Width)
+	}
+}
+
+func (s *EditorTestSuite) TestThumbnails() {
+	e, err := NewEditor(s.Ctx, s.openIndirect())
+	s.Require().NoError(err)
+	s.Equal(3, e.GetPagesNum())
+	pm, err := e.GetThumbnail(s.Ctx, 0)
+	s.NoError(err)
+	s.Nil(pm)
+
+	s.Require().NoError(e.GenerateThumbnails(s.Ctx, ThumbnailSize))
+	doc := s.reopen(e, "memory:thumbs.djvu")
+	s.Equal([]string{"a.thumb", "a.djvu", "b.djvu", "c.djvu"}, s.fileIDs(doc.GetMultiDir()))
+	s.Equal(FILE_THUMBNAILS, doc.GetMultiDir().IdToFile("a.thumb").Type)
+	s.Equal(3, doc.GetPagesNum())
+	outline, err := doc.GetOutline(s.Ctx)
+	s.NoError(err)
+	s.Equal(testOutline(), outline)
+
+	// Pages of 100x200, 101x200 and 102x200 with nothing on them
+	for ii := 0; ii < 3; ii++ {
+		pm, err := doc.GetThumbnail(s.Ctx, ii)
+		s.Require().NoError(err)
+		s.Require().NotNil(pm)
+		s.Equal(ThumbnailSize, pm.Rows())
+		s.Equal((100+ii)*ThumbnailSize/200, pm.Cols())
+		s.Equal(image.WhitePixel, pm.GetLine(10)[10])
+	}
+
+	// Thumbnails which do not cover every page
+	e, err = NewEditor(s.Ctx, doc)
+	s.Require().NoError(err)
+	s.Equal([]string{"a.djvu", "b.djvu", "c.djvu"}, s.fileIDs(e.GetMultiDir()))
+	pm, err = e.GetThumbnail(s.Ctx, 2)
+	s.NoError(err)
+	s.NotNil(pm)
+	s.Require().NoError(e.SetThumbnail(1, nil))
+	red := image.NewPixmap(10, 20)
+	red.Fill(image.Pixel{R: 255})
+	s.Require().NoError(e.SetThumbnail(2, red))
+	doc = s.reopen(e, "memory:partial.djvu")
+	s.Equal([]string{"a.thumb", "a.djvu", "b.djvu", "c.thumb", "c.djvu"}, s.fileIDs(doc.GetMultiDir()))
+	pm, err = doc.GetThumbnail(s.Ctx, 1)
+	s.NoError(err)
+	s.Nil(pm)
+	pm, err = doc.GetThumbnail(s.Ctx, 2)
+	s.Require().NoError(err)
+	s.Equal(10, pm.Rows())
+	s.Equal(20, pm.Cols())
+	s.InDelta(255, int(pm.GetLine(5)[5].R), 8)
+
+	e.RemoveThumbnails()
+	doc = s.reopen(e, "memory:none.djvu")
+	s.Equal([]string{"a.djvu", "b.djvu", "c.djvu"}, s.fileIDs(doc.GetMultiDir()))
+	pm, err = doc.GetThumbnail(s.Ctx, 0)
+	s.NoError(err)
+	s.Nil(pm)
+
+	_, err = doc.GetThumbnail(s.Ctx, 3)
+	s.Error(err)
+	s.Error(e.SetThumbnail(3, red))
+}
+
+func (s *EditorTestSuite) TestManyThumbnails() {
+	var pages []string
+	for ii := 0; ii < thumbnailsPerFile+2; ii++ {
+		pages = append(pages, string(rune('a'+ii))+".djvu")
+	}
+	index, files := testIndirect(pages...)
+	for name, data := range files {
+		url, err := NewUrl("memory:many/" + name)
+		s.Require().NoError(err)
+		s.Mem.Add(url, data)
+	}
+	e, err := NewEditor(s.Ctx, s.open("memory:many/index.djvu", index))
+	s.Require().NoError(err)
+	s.Require().NoError(e.GenerateThumbnails(s.Ctx, 16))
+
+	// The ID of the second thumbnails file is taken by a page
+	s.Require().NoError(e.dir.InsertFile(&MultiDirFile{ID: "k.thumb", Type: FILE_INCLUDE}, 0))
+	e.files["k.thumb"] = &iff.Chunk{ID: "FORM:DJVI"}
+	doc := s.reopen(e, "memory:many.djvu")
+	ids := s.fileIDs(doc.GetMultiDir())
+	s.Equal([]string{"k.thumb", "a.thumb", "a.djvu"}, ids[:3])
+	s.Equal([]string{"k_1.thumb", "k.djvu", "l.djvu"}, ids[len(ids)-3:])
+	pm, err := doc.GetThumbnail(s.Ctx, thumbnailsPerFile+1)
+	s.Require().NoError(err)
+	s.Equal(16, pm.Rows())
+}
+
+func (s *EditorTestSuite) outline(e *Editor) *Outline {
+	var buf bytes.Buffer
+	s.Require().NoError(e.Write(&buf))
+	form, err := iff.DecodeBytes(buf.Bytes())
+	s.Require().NoError(err)
+	outline, err := DecodeOutlineChunk(form)
+	s.Require().NoError(err)
+	return outline
+}
+
+func (s *EditorTestSuite) TestPageOperations() {
+	e, err := NewEditor(s.Ctx, s.openIndirect())
+	s.Require().NoError(err)
+	s.Require().NoError(e.dir.InsertFile(&MultiDirFile{ID: "dict.djvi", Type: FILE_INCLUDE}, 0))
+	e.files["dict.djvi"] = &iff.Chunk{ID: "FORM:DJVI"}
+
+	page := testPage(300, 200)
+	page.Children = append(page.Children, &iff.Chunk{ID: "INCL", Data: []byte("dict.djvi")})
+	s.Require().NoError(e.InsertPage(1, "new.djvu", page, nil))
+	s.Equal([]string{"dict.djvi", "a.djvu", "new.djvu", "b.djvu", "c.djvu"}, s.fileIDs(e.GetMultiDir()))
+	p, err := e.GetPage(s.Ctx, 1)
+	s.Require().NoError(err)
+	s.Equal(uint16(300), p.Info.Width)
+	s.Len(p.Included, 1)
+	s.Equal("#3", s.outline(e).Bookmarks[0].Children[0].Children[0].URL)
+
+	s.Require().NoError(e.MovePage(0, 3))
+	s.Equal([]string{"dict.djvi", "new.djvu", "b.djvu", "c.djvu", "a.djvu"}, s.fileIDs(e.GetMultiDir()))
+	outline := s.outline(e)
+	s.Equal("#4", outline.Bookmarks[0].URL)
+	s.Equal("#2", outline.Bookmarks[0].Children[0].Children[0].URL)
+
+	// The dictionary goes with the only page including it
+	s.Require().NoError(e.RemovePage(0))
+	s.Equal([]string{"b.djvu", "c.djvu", "a.djvu"}, s.fileIDs(e.GetMultiDir()))
+
+	s.Require().NoError(e.SetPageOrder([]int{2, 0, 1}))
+	s.Equal([]string{"a.djvu", "b.djvu", "c.djvu"}, s.fileIDs(e.GetMultiDir()))
+	s.Equal(testOutline(), s.outline(e))
+
+	s.Require().NoError(e.RemovePage(1))
+	s.Equal(&Outline{Bookmarks: []*Bookmark{
+		{Title: "Chapter 1", URL: "#1", Children: []*Bookmark{{Title: "Section 1.2", URL: "#Third"}}},
+		{Title: "Errata", URL: "http://www.example.com/errata"},
+	}}, s.outline(e))
+	s.Require().NoError(e.RemovePage(0))
+	s.Equal(&Outline{Bookmarks: []*Bookmark{
+		{Title: "Section 1.2", URL: "#Third"},
+		{Title: "Errata", URL: "http://www.example.com/errata"},
+	}}, s.outline(e))
+
+	doc := s.reopen(e, "memory:edited.djvu")
+	s.Equal(1, doc.GetPagesNum())
+	s.Equal([]string{"c.djvu"}, s.fileIDs(doc.GetMultiDir()))
+
+	s.Error(e.InsertPage(2, "x.djvu", testPage(10, 10), nil))
+	s.Error(e.InsertPage(0, "c.djvu", testPage(10, 10), nil))
+	s.Error(e.InsertPage(0, "x.djvu", &iff.Chunk{ID: "FORM:DJVI"}, nil))
+	s.Error(e.InsertPage(0, "x.djvu", page, nil))
+	s.Error(e.RemovePage(1))
+	s.Error(e.MovePage(0, 1))
+	s.Error(e.SetPageOrder([]int{0, 0}))
+	s.Require().NoError(e.InsertPage(-1, "x.djvu", testPage(10, 10), nil))
+	s.Error(e.SetPageOrder([]int{1, 1}))
+	s.Error(e.SetPageOrder([]int{0, 2}))
+}
+
+// Returns a page of width `width` including the files `ids`
+func includingPage(width uint16, ids ...string) *iff.Chunk {
+	page := testPage(width, 100)
+	for _, id := range ids {
+		page.Children = append(page.Children, &iff.Chunk{ID: "INCL", Data: []byte(id)})
+	}
+	return page
+}
+
+func (s *EditorTestSuite) TestInsertIncluded() {
+	e, err := NewEditor(s.Ctx, s.openIndirect())
+	s.Require().NoError(err)
+	dict := &iff.Chunk{ID: "FORM:DJVI", Children: []*iff.Chunk{{ID: "Djbz", Data: []byte("first")}}}
+	s.Require().NoError(e.InsertPage(-1, "p1.djvu", includingPage(10, "dict.djvi"), map[string]*iff.Chunk{"dict.djvi": dict}))
+	s.Equal([]string{"a.djvu", "b.djvu", "c.djvu", "dict.djvi", "p1.djvu"}, s.fileIDs(e.GetMultiDir()))
+	s.Equal(FILE_INCLUDE, e.GetMultiDir().IdToFile("dict.djvi").Type)
+
+	// The same dictionary is shared by the pages of a chapter
+	s.Require().NoError(e.InsertPage(-1, "p2.djvu", includingPage(20, "dict.djvi"), map[string]*iff.Chunk{"dict.djvi": dict}))
+	s.Equal([]string{"a.djvu", "b.djvu", "c.djvu", "dict.djvi", "p1.djvu", "p2.djvu"}, s.fileIDs(e.GetMultiDir()))
+
+	// Another one with the same ID is renamed, along with what includes it
+	other := &iff.Chunk{ID: "FORM:DJVI", Children: []*iff.Chunk{{ID: "Djbz", Data: []byte("second")}}}
+	page := includingPage(30, "dict.djvi")
+	s.Require().NoError(e.InsertPage(1, "p3.djvu", page, map[string]*iff.Chunk{
+		"dict.djvi": {ID: "FORM:DJVI", Children: []*iff.Chunk{{ID: "INCL", Data: []byte("p1.djvu")}}},
+		"p1.djvu":   other,
+	}))
+	s.Equal([]string{"a.djvu", "p1_1.djvu", "dict_1.djvi", "p3.djvu", "b.djvu", "c.djvu", "dict.djvi", "p1.djvu", "p2.djvu"}, s.fileIDs(e.GetMultiDir()))
+	s.Equal("dict.djvi", string(page.Find("INCL").Data)) // Left unchanged
+	s.Equal("p1_1.djvu", string(e.files["dict_1.djvi"].Find("INCL").Data))
+
+	doc := s.reopen(e, "memory:chapters.djvu")
+	for page, dict := range map[int]string{4: "first", 5: "first"} {
+		p, err := doc.GetPage(s.Ctx, page)
+		s.Require().NoError(err)
+		s.Require().Len(p.Included, 1)
+		s.Equal(dict, string(p.Included[0].Find("Djbz").Data), "page %d", page)
+	}
+	p, err := doc.GetPage(s.Ctx, 1)
+	s.Require().NoError(err)
+	s.Require().Len(p.Included, 1)
+	pool, err := doc.includedData(s.Ctx, string(p.Included[0].Find("INCL").Data))
+	s.Require().NoError(err)
+	nested, err := iff.Decode(pool.NewReader(s.Ctx))
+	s.Require().NoError(err)
+	s.Equal("second", string(nested.Find("Djbz").Data))
+
+	// Nothing is inserted when the included files are wrong
+	files := s.fileIDs(e.GetMultiDir())
+	s.Error(e.InsertPage(-1, "x.djvu", includingPage(10, "loop.djvi"), map[string]*iff.Chunk{
+		"loop.djvi": {ID: "FORM:DJVI", Children: []*iff.Chunk{{ID: "INCL", Data: []byte("loop.djvi")}}},
+	}))
+	s.Error(e.InsertPage(-1, "x.djvu", includingPage(10, "new.djvi", "missing.djvi"), map[string]*iff.Chunk{"new.djvi": dict}))
+	s.Error(e.InsertPage(-1, "x.djvu", includingPage(10, "page.djvi"), map[string]*iff.Chunk{"page.djvi": testPage(1, 1)}))
+	s.Error(e.InsertPage(-1, "p1.djvu", includingPage(10, "new.djvi"), map[string]*iff.Chunk{"new.djvi": dict}))
+	s.Equal(files, s.fileIDs(e.GetMultiDir()))
+}
+
+func (s *EditorTestSuite) TestSave() {
+	dir := s.T().TempDir()
+	index, files := testIndirect("p1.djvu", "p2.djvu", "p3.djvu")
+	for name, data := range files {
+		s.Require().NoError(os.WriteFile(filepath.Join(dir, name), data, 0666))
+	}
+	s.Require().NoError(os.WriteFile(filepath.Join(dir, "index.djvu"), index, 0666))
+	url, err := UrlFromFilename(filepath.Join(dir, "index.djvu"))
+	s.Require().NoError(err)
+	doc, err := OpenDocument(s.Ctx, url, nil)
+	s.Require().NoError(err)
+	defer doc.Close()
+
+	e, err := NewEditor(s.Ctx, doc)
+	s.Require().NoError(err)
+	s.Require().NoError(e.MovePage(2, 0))
+	s.Require().NoError(e.Save())
+	saved, err := OpenDocument(s.Ctx, url, nil)
+	s.Require().NoError(err)
+	defer saved.Close()
+	s.Equal(INDIRECT, saved.GetDocType())
+	s.Equal([]string{"p3.djvu", "p1.djvu", "p2.djvu"}, s.fileIDs(saved.GetMultiDir()))
+
+	bundled := filepath.Join(dir, "bundled.djvu")
+	s.Require().NoError(e.SaveAs(bundled))
+	url, err = UrlFromFilename(bundled)
+	s.Require().NoError(err)
+	saved, err = OpenDocument(s.Ctx, url, nil)
+	s.Require().NoError(err)
+	defer saved.Close()
+	s.Equal(BUNDLED, saved.GetDocType())
+	page, err := saved.GetPage(s.Ctx, 0)
+	s.Require().NoError(err)
+	s.Equal(uint16(102), page.Info.Width)
+
+	entries, err := os.ReadDir(dir)
+	s.Require().NoError(err)
+	s.Len(entries, 5)
+
+	e, err = NewEditor(s.Ctx, s.openIndirect())
+	s.Require().NoError(err)
+	s.Error(e.Save())
+}
+
+// Adds a shared file dict.djvi to the edited document, included by the page `id`
+func (s *EditorTestSuite) addDict(e *Editor, id string) {
+	s.Require().NoError(e.dir.InsertFile(&MultiDirFile{ID: "dict.djvi", Type: FILE_INCLUDE}, 0))
+	e.files["dict.djvi"] = &iff.Chunk{ID: "FORM:DJVI"}
+	page := e.files[id]
+	page.Children = append(page.Children, &iff.Chunk{ID: "INCL", Data: []byte("dict.djvi")})
+}
+
+func (s *EditorTestSuite) TestMergeAndSplit() {
+	e, err := NewEditor(s.Ctx, s.openIndirect())
+	s.Require().NoError(err)
+	s.addDict(e, "b.djvu")
+
+	indexData, files := testIndirect("a.djvu", "d.djvu")
+	for name, data := range files {
+		url, err := NewUrl("memory:other/" + name)
+		s.Require().NoError(err)
+		s.Mem.Add(url, data)
+	}
+	other, err := NewEditor(s.Ctx, s.open("memory:other/index.djvu", indexData))
+	s.Require().NoError(err)
+	s.addDict(other, "d.djvu")
+	other.outline.Bookmarks = []*Bookmark{{Title: "Part 2", URL: "#1", Children: []*Bookmark{{Title: "D", URL: "#d.djvu"}}}}
+	red := image.NewPixmap(10, 10)
+	red.Fill(image.Pixel{R: 255})
+	s.Require().NoError(other.SetThumbnail(1, red))
+	second := s.reopen(other, "memory:second.djvu")
+
+	s.Require().NoError(e.Merge(s.Ctx, second))
+	s.Equal([]string{"dict.djvi", "a.djvu", "b.djvu", "c.djvu", "dict_1.djvi", "a_1.djvu", "d.djvu"}, s.fileIDs(e.GetMultiDir()))
+	s.Equal(5, e.GetPagesNum())
+	page, err := e.GetPage(s.Ctx, 4)
+	s.Require().NoError(err)
+	s.Equal([]*iff.Chunk{e.files["dict_1.djvi"]}, page.Included)
+	pm, err := e.GetThumbnail(s.Ctx, 4)
+	s.NoError(err)
+	s.NotNil(pm)
+	expected := testOutline()
+	expected.Bookmarks = append(expected.Bookmarks, &Bookmark{Title: "Part 2", URL: "#4", Children: []*Bookmark{{Title: "D", URL: "#d.djvu"}}})
+	s.Equal(expected, s.outline(e))
+
+	parts, err := e.Split(PageRange{1, 1}, PageRange{3, 4})
+	s.Require().NoError(err)
+	s.Require().Len(parts, 2)
+	s.Equal(5, e.GetPagesNum())
+
+	doc := s.open("memory:part1.djvu", parts[0])
+	s.Equal([]string{"dict.djvi", "b.djvu"}, s.fileIDs(doc.GetMultiDir()))
+	outline, err := doc.GetOutline(s.Ctx)
+	s.Require().NoError(err)
+	s.Equal(&Outline{Bookmarks: []*Bookmark{
+		{Title: "Section 1.1", URL: "#b.djvu", Children: []*Bookmark{{Title: "Note", URL: "#1"}}},
+		{Title: "Section 1.2", URL: "#Third"}, // Not a page of the document
+		{Title: "Errata", URL: "http://www.example.com/errata"},
+	}}, outline)
+
+	doc = s.open("memory:part2.djvu", parts[1])
+	s.Equal([]string{"dict_1.djvi", "a_1.djvu", "d.thumb", "d.djvu"}, s.fileIDs(doc.GetMultiDir()))
+	outline, err = doc.GetOutline(s.Ctx)
+	s.Require().NoError(err)
+	s.Equal(&Outline{Bookmarks: []*Bookmark{
+		{Title: "Section 1.2", URL: "#Third"},
+		{Title: "Errata", URL: "http://www.example.com/errata"},
+		{Title: "Part 2", URL: "#1", Children: []*Bookmark{{Title: "D", URL: "#d.djvu"}}},
+	}}, outline)
+	pm, err = doc.GetThumbnail(s.Ctx, 1)
+	s.NoError(err)
+	s.NotNil(pm)
+
+	_, err = e.Split(PageRange{2, 1})
+	s.Error(err)
+	_, err = e.Split(PageRange{0, 5})
+	s.Error(err)
+}
+
+// Opens an indirect document of pages `names` under memory:`dir`/,
+// with `titles` and shared annotations holding `metadata`
+func (s *EditorTestSuite) openTitled(dir string, names, titles []string, metadata map[string]string) *Document {
+	indexData, files := testIndirect(names...)
+	for name, data := range files {
+		url, err := NewUrl("memory:" + dir + "/" + name)
+		s.Require().NoError(err)
+		s.Mem.Add(url, data)
+	}
+	e, err := NewEditor(s.Ctx, s.open("memory:"+dir+"/index.djvu", indexData))
+	s.Require().NoError(err)
+	for ii, title := range titles {
+		s.Require().NoError(e.SetPageTitle(ii, title))
+	}
+	if metadata != nil {
+		anno := NewAnno()
+		anno.Metadata = metadata
+		s.Require().NoError(e.SetSharedAnno(anno))
+	}
+	return s.reopen(e, "memory:"+dir+".djvu")
+}
+
+func (s *EditorTestSuite) TestMergeTitlesAndSharedAnno() {
+	e, err := NewEditor(s.Ctx, s.openTitled("first", []string{"a.djvu", "b.djvu", "c.djvu"}, []string{"i", "ii"}, map[string]string{"Title": "First", "Author": "A"}))
+	s.Require().NoError(err)
+	second := s.openTitled("second", []string{"a.djvu", "d.djvu"}, []string{"i", "b.djvu"}, map[string]string{"Title": "Second", "Subject": "S"})
+
+	s.Require().NoError(e.Merge(s.Ctx, second))
+	s.Equal([]string{"shared_anno.iff", "a.djvu", "b.djvu", "c.djvu", "a_1.djvu", "d.djvu"}, s.fileIDs(e.GetMultiDir()))
+	var titles []string
+	for _, file := range e.GetMultiDir().GetFiles() {
+		titles = append(titles, file.GetTitle())
+	}
+	s.Equal([]string{"shared_anno.iff", "i", "ii", "c.djvu", "i_1", "b_1.djvu"}, titles)
+	s.Equal([]string{"INFO", "INCL"}, chunkIDs(e.files["d.djvu"]))
+
+	// The annotations of the first document take precedence
+	expected := NewAnno()
+	expected.Metadata = map[string]string{"Title": "First", "Author": "A", "Subject": "S"}
+	doc := s.reopen(e, "memory:merged.djvu")
+	for ii := 0; ii < 5; ii++ {
+		anno, err := doc.GetPageAnno(s.Ctx, ii)
+		s.Require().NoError(err)
+		s.Equal(expected, anno, "page %d", ii)
+	}
+
+	// Pages without shared annotations include those merged in
+	e, err = NewEditor(s.Ctx, s.openTitled("third", []string{"a.djvu"}, nil, nil))
+	s.Require().NoError(err)
+	s.Require().NoError(e.Merge(s.Ctx, second))
+	s.Equal([]string{"shared_anno.iff", "a.djvu", "a_1.djvu", "d.djvu"}, s.fileIDs(e.GetMultiDir()))
+	expected.Metadata = map[string]string{"Title": "Second", "Subject": "S"}
+	doc = s.reopen(e, "memory:merged2.djvu")
+	for ii := 0; ii < 3; ii++ {
+		anno, err := doc.GetPageAnno(s.Ctx, ii)
+		s.Require().NoError(err)
+		s.Equal(expected, anno, "page %d", ii)
+	}
+}
+
+func (s *EditorTestSuite) TestAttributes() {
+	e, err := NewEditor(s.Ctx, s.openIndirect())
+	s.Require().NoError(err)
+	s.addDict(e, "b.djvu")
+
+	s.Require().NoError(e.SetFileID("b.djvu", "intro.djvu"))
+	s.Require().NoError(e.SetFileID("dict.djvi", "fonts.djvi"))
+	s.Equal([]string{"fonts.djvi", "a.djvu", "intro.djvu", "c.djvu"}, s.fileIDs(e.GetMultiDir()))
+	page, err := e.GetPage(s.Ctx, 1)
+	s.Require().NoError(err)
+	s.Equal([]*iff.Chunk{e.files["fonts.djvi"]}, page.Included)
+	s.Equal("#intro.djvu", s.outline(e).Bookmarks[0].Children[0].URL)
+	s.Require().NoError(e.SetFileID("c.djvu", "c.djvu"))
+	s.Error(e.SetFileID("c.djvu", "a.djvu"))
+	s.Error(e.SetFileID("c.djvu", ""))
+	s.Error(e.SetFileID("b.djvu", "x.djvu"))
+
+	s.Require().NoError(e.SetFileName("a.djvu", "cover.djvu"))
+	s.Equal("a.djvu", e.GetMultiDir().NameToFile("cover.djvu").ID)
+	s.Error(e.SetFileName("c.djvu", "cover.djvu"))
+	s.Error(e.SetFileName("c.djvu", "intro.djvu"))
+	s.Error(e.SetFileID("intro.djvu", "cover.djvu"))
+	s.Error(e.SetFileName("x.djvu", "x.djvu"))
+	s.Require().NoError(e.SetFileName("a.djvu", ""))
+	s.Equal("", e.GetMultiDir().IdToFile("a.djvu").Name)
+
+	// Page labels
+	s.Require().NoError(e.SetPageTitle(0, "i"))
+	s.Require().NoError(e.SetPageTitle(1, "ii"))
+	s.Require().NoError(e.SetPageTitle(2, "Third"))
+	s.Require().NoError(e.SetPageTitle(2, "iii"))
+	s.Error(e.SetPageTitle(2, "i"))
+	s.Error(e.SetPageTitle(2, "ii"))
+	s.Error(e.SetPageTitle(3, "iv"))
+	s.Equal("#iii", s.outline(e).Bookmarks[0].Children[1].URL)
+	s.Require().NoError(e.SetPageTitle(1, ""))
+
+	doc := s.reopen(e, "memory:attributes.djvu")
+	var titles []string
+	for _, file := range doc.GetMultiDir().GetFiles() {
+		titles = append(titles, file.GetTitle())
+	}
+	s.Equal([]string{"fonts.djvi", "i", "intro.djvu", "iii"}, titles)
+}
+
+func (s *EditorTestSuite) TestSharedAnno() {
+	e, err := NewEditor(s.Ctx, s.openIndirect())
+	s.Require().NoError(err)
+	anno, err := e.GetSharedAnno()
+	s.Require().NoError(err)
+	s.True(anno.IsEmpty())
+
+	anno.Metadata = map[string]string{"Title": "Some book"}
+	anno.XMP = "<x:xmpmeta/>"
+	s.Require().NoError(e.SetSharedAnno(anno))
+	s.Require().NoError(e.InsertPage(-1, "d.djvu", testPage(10, 10), nil))
+	s.Equal([]string{"shared_anno.iff", "a.djvu", "b.djvu", "c.djvu", "d.djvu"}, s.fileIDs(e.GetMultiDir()))
+	s.Equal(FILE_SHARED_ANNO, e.GetMultiDir().IdToFile("shared_anno.iff").Type)
+	s.Equal([]string{"INFO", "INCL"}, chunkIDs(e.files["d.djvu"]))
+
+	doc := s.reopen(e, "memory:anno.djvu")
+	for ii := 0; ii < 4; ii++ {
+		pageAnno, err := doc.GetPageAnno(s.Ctx, ii)
+		s.Require().NoError(err)
+		s.Equal(anno, pageAnno)
+	}
+
+	// Changing the annotations keeps the file
+	anno.Metadata["Author"] = "Someone"
+	s.Require().NoError(e.SetSharedAnno(anno))
+	s.Len(e.GetMultiDir().GetFiles(), 5)
+	shared, err := e.GetSharedAnno()
+	s.Require().NoError(err)
+	s.Equal(anno, shared)
+
+	s.Require().NoError(e.SetSharedAnno(NewAnno()))
+	s.Equal([]string{"a.djvu", "b.djvu", "c.djvu", "d.djvu"}, s.fileIDs(e.GetMultiDir()))
+	s.Equal([]string{"INFO"}, chunkIDs(e.files["d.djvu"]))
+	s.Require().NoError(e.SetSharedAnno(NewAnno()))
+}
@@ -1,6 +1,9 @@
 package djvu
 
-import "sync"
+import (
+	"sync"
+	"unsafe"
+)
 
 // File plays the central role in decoding Images.
 // First of all, it represents a DjVu file
@@ -110,3 +113,15 @@ type File struct {
 	decodeDataPool  *DataPool
 	decodeLifeSaver *File
 }
+
+// Returns the number of bytes used by this object,
+// including the data held by the DataPool it decodes
+func (f *File) GetMemoryUsage() uintptr {
+	f.mtx.RLock()
+	defer f.mtx.RUnlock()
+	size := unsafe.Sizeof(*f)
+	if f.decodeDataPool != nil {
+		size += f.decodeDataPool.memoryUsage()
+	}
+	return size
+}
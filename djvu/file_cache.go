@@ -0,0 +1,248 @@
+package djvu
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Cacheable is something which can be stored in a FileCache.
+// Page and File report how much memory they hold
+// so that the cache can keep itself within its budget.
+type Cacheable interface {
+	// Returns the number of bytes used by this object
+	GetMemoryUsage() uintptr
+}
+
+// FileCache is a thread-safe cache of decoded Pages and Files,
+// keyed by the Url they were decoded from.
+//
+// Decoding the same page twice is expensive,
+// so once given a cache with SetFileCache,
+// Document.GetPage consults it before decoding a page.
+// The cache has a memory budget expressed in bytes.
+// The size of every item is obtained with its GetMemoryUsage method
+// at the moment it is added.
+// Whenever the total size exceeds the budget,
+// the least recently used items are evicted until it fits again.
+// Items larger than the whole budget are never cached.
+//
+// A FileCache with a budget of zero is disabled:
+// nothing is ever added to it.
+type FileCache struct {
+	mtx     sync.Mutex
+	maxSize uintptr
+	size    uintptr
+
+	// Items sorted from the most recently used to the least recently used
+	lru *list.List
+	// Map of Url hashes to the items with that hash
+	buckets map[uint32][]*list.Element
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+// FileCacheStats describes how well a FileCache has been doing.
+type FileCacheStats struct {
+	// Number of lookups which found their item
+	Hits uint64
+	// Number of lookups which did not find their item
+	Misses uint64
+	// Number of items removed to make room for new ones
+	Evictions uint64
+	// Number of items currently in the cache
+	Items int
+	// Bytes currently used by the cached items
+	Size uintptr
+	// Memory budget of the cache
+	MaxSize uintptr
+}
+
+type fileCacheItem struct {
+	url  *Url
+	hash uint32
+	size uintptr
+	item Cacheable
+}
+
+// DefaultFileCacheSize is the budget used by NewDefaultFileCache.
+const DefaultFileCacheSize = 5 * 1024 * 1024
+
+// NewFileCache creates an empty cache which can hold up to `maxSize` bytes.
+func NewFileCache(maxSize uintptr) *FileCache {
+	return &FileCache{
+		maxSize: maxSize,
+		lru:     list.New(),
+		buckets: make(map[uint32][]*list.Element),
+	}
+}
+
+// NewDefaultFileCache creates an empty cache with a budget of DefaultFileCacheSize.
+func NewDefaultFileCache() *FileCache {
+	return NewFileCache(DefaultFileCacheSize)
+}
+
+// Get returns the item cached for `url`, if any,
+// and marks it as the most recently used.
+func (c *FileCache) Get(url *Url) (Cacheable, bool) {
+	hash := url.Hash()
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	elem := c.find(url, hash)
+	if elem == nil {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*fileCacheItem).item, true
+}
+
+// GetFile returns the File cached for `url`,
+// or nil if there is none.
+func (c *FileCache) GetFile(url *Url) *File {
+	item, _ := c.Get(url)
+	file, _ := item.(*File)
+	return file
+}
+
+// GetPage returns the Page cached for `url`,
+// or nil if there is none.
+func (c *FileCache) GetPage(url *Url) *Page {
+	item, _ := c.Get(url)
+	page, _ := item.(*Page)
+	return page
+}
+
+// Add stores `item` under `url`, replacing anything that was cached for it.
+// Least recently used items are evicted if the budget is exceeded.
+// Returns false if the item was not cached because it is too large.
+func (c *FileCache) Add(url *Url, item Cacheable) bool {
+	hash := url.Hash()
+	size := item.GetMemoryUsage()
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if elem := c.find(url, hash); elem != nil {
+		c.remove(elem)
+	}
+	if size > c.maxSize {
+		return false
+	}
+
+	c.reduce(c.maxSize - size)
+	elem := c.lru.PushFront(&fileCacheItem{
+		url:  url.Copy(),
+		hash: hash,
+		size: size,
+		item: item,
+	})
+	c.buckets[hash] = append(c.buckets[hash], elem)
+	c.size += size
+	return true
+}
+
+// Del removes whatever is cached under `url`.
+func (c *FileCache) Del(url *Url) {
+	hash := url.Hash()
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if elem := c.find(url, hash); elem != nil {
+		c.remove(elem)
+	}
+}
+
+// Clear removes everything from the cache.
+// The statistics are kept.
+func (c *FileCache) Clear() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.lru.Init()
+	c.buckets = make(map[uint32][]*list.Element)
+	c.size = 0
+}
+
+// SetMaxSize changes the memory budget of the cache,
+// evicting items if the new budget is smaller than the current size.
+func (c *FileCache) SetMaxSize(maxSize uintptr) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.maxSize = maxSize
+	c.reduce(maxSize)
+}
+
+// GetMaxSize returns the memory budget of the cache.
+func (c *FileCache) GetMaxSize() uintptr {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.maxSize
+}
+
+// GetSize returns the number of bytes used by the cached items.
+func (c *FileCache) GetSize() uintptr {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.size
+}
+
+// Stats returns a snapshot of the cache statistics.
+func (c *FileCache) Stats() FileCacheStats {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return FileCacheStats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Items:     c.lru.Len(),
+		Size:      c.size,
+		MaxSize:   c.maxSize,
+	}
+}
+
+// ResetStats sets the hit, miss and eviction counters back to zero.
+func (c *FileCache) ResetStats() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.hits, c.misses, c.evictions = 0, 0, 0
+}
+
+// Unsafe. Must only be called while holding the lock.
+func (c *FileCache) find(url *Url, hash uint32) *list.Element {
+	for _, elem := range c.buckets[hash] {
+		if elem.Value.(*fileCacheItem).url.Equal(url) {
+			return elem
+		}
+	}
+	return nil
+}
+
+// Unsafe. Must only be called while holding the lock.
+func (c *FileCache) remove(elem *list.Element) {
+	it := c.lru.Remove(elem).(*fileCacheItem)
+	c.size -= it.size
+
+	bucket := c.buckets[it.hash]
+	for ii := range bucket {
+		if bucket[ii] == elem {
+			bucket = append(bucket[:ii], bucket[ii+1:]...)
+			break
+		}
+	}
+	if len(bucket) == 0 {
+		delete(c.buckets, it.hash)
+	} else {
+		c.buckets[it.hash] = bucket
+	}
+}
+
+// Evicts the least recently used items until the cache uses at most `size` bytes.
+// Unsafe. Must only be called while holding the lock.
+func (c *FileCache) reduce(size uintptr) {
+	for c.size > size && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+		c.evictions++
+	}
+}
@@ -0,0 +1,186 @@
+package djvu
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/janreggie/go-djvulibre/djvu/iff"
+	"github.com/stretchr/testify/suite"
+)
+
+type FileCacheTestSuite struct {
+	suite.Suite
+	Cache *FileCache // Holds up to 100 bytes
+}
+
+func TestFileCacheSuite(t *testing.T) {
+	suite.Run(t, new(FileCacheTestSuite))
+}
+
+// sizedItem is a Cacheable with a fixed size
+type sizedItem uintptr
+
+func (it sizedItem) GetMemoryUsage() uintptr { return uintptr(it) }
+
+func (s *FileCacheTestSuite) url(str string) *Url {
+	url, err := NewUrl(str)
+	s.Require().NoError(err)
+	return url
+}
+
+func (s *FileCacheTestSuite) SetupTest() {
+	s.Cache = NewFileCache(100)
+}
+
+func (s *FileCacheTestSuite) TestGetAndAdd() {
+	_, ok := s.Cache.Get(s.url("http://example.com/a.djvu"))
+	s.False(ok)
+
+	s.True(s.Cache.Add(s.url("http://example.com/a.djvu"), sizedItem(10)))
+	item, ok := s.Cache.Get(s.url("http://example.com/a.djvu"))
+	s.True(ok)
+	s.Equal(sizedItem(10), item)
+
+	// Trailing slashes are not significant
+	_, ok = s.Cache.Get(s.url("http://example.com/a.djvu/"))
+	s.True(ok)
+
+	stats := s.Cache.Stats()
+	s.Equal(uint64(2), stats.Hits)
+	s.Equal(uint64(1), stats.Misses)
+	s.Equal(1, stats.Items)
+	s.Equal(uintptr(10), stats.Size)
+}
+
+func (s *FileCacheTestSuite) TestReplace() {
+	s.Cache.Add(s.url("http://example.com/a.djvu"), sizedItem(10))
+	s.Cache.Add(s.url("http://example.com/a.djvu"), sizedItem(20))
+	s.Equal(uintptr(20), s.Cache.GetSize())
+	s.Equal(1, s.Cache.Stats().Items)
+}
+
+func (s *FileCacheTestSuite) TestEviction() {
+	for ii := 0; ii < 5; ii++ {
+		s.Cache.Add(s.url(fmt.Sprintf("http://example.com/%d.djvu", ii)), sizedItem(30))
+	}
+	// Only the last three fit
+	s.Equal(uintptr(90), s.Cache.GetSize())
+	s.Equal(uint64(2), s.Cache.Stats().Evictions)
+	_, ok := s.Cache.Get(s.url("http://example.com/1.djvu"))
+	s.False(ok)
+
+	// Touching 2 makes 3 the least recently used
+	_, ok = s.Cache.Get(s.url("http://example.com/2.djvu"))
+	s.True(ok)
+	s.Cache.Add(s.url("http://example.com/5.djvu"), sizedItem(30))
+	_, ok = s.Cache.Get(s.url("http://example.com/3.djvu"))
+	s.False(ok)
+	_, ok = s.Cache.Get(s.url("http://example.com/2.djvu"))
+	s.True(ok)
+}
+
+func (s *FileCacheTestSuite) TestTooLarge() {
+	s.False(s.Cache.Add(s.url("http://example.com/a.djvu"), sizedItem(101)))
+	s.Equal(0, s.Cache.Stats().Items)
+
+	disabled := NewFileCache(0)
+	s.False(disabled.Add(s.url("http://example.com/a.djvu"), sizedItem(1)))
+}
+
+func (s *FileCacheTestSuite) TestSetMaxSize() {
+	s.Cache.Add(s.url("http://example.com/a.djvu"), sizedItem(40))
+	s.Cache.Add(s.url("http://example.com/b.djvu"), sizedItem(40))
+	s.Cache.SetMaxSize(50)
+	s.Equal(uintptr(40), s.Cache.GetSize())
+	s.Nil(s.Cache.GetFile(s.url("http://example.com/a.djvu")))
+}
+
+func (s *FileCacheTestSuite) TestTypedGetters() {
+	cache := NewFileCache(1 << 20)
+	file, page := &File{}, &Page{}
+	cache.Add(s.url("http://example.com/file.djvu"), file)
+	cache.Add(s.url("http://example.com/page.djvu"), page)
+	s.Same(file, cache.GetFile(s.url("http://example.com/file.djvu")))
+	s.Nil(cache.GetPage(s.url("http://example.com/file.djvu")))
+	s.Same(page, cache.GetPage(s.url("http://example.com/page.djvu")))
+	s.Nil(cache.GetFile(s.url("http://example.com/page.djvu")))
+}
+
+func (s *FileCacheTestSuite) TestConcurrent() {
+	cache := NewFileCache(1000)
+	var wg sync.WaitGroup
+	for ii := 0; ii < 8; ii++ {
+		wg.Add(1)
+		go func(ii int) {
+			defer wg.Done()
+			for jj := 0; jj < 100; jj++ {
+				url := s.url(fmt.Sprintf("http://example.com/%d.djvu", (ii*jj)%20))
+				if _, ok := cache.Get(url); !ok {
+					cache.Add(url, sizedItem(64))
+				}
+			}
+		}(ii)
+	}
+	wg.Wait()
+	s.LessOrEqual(uint64(cache.GetSize()), uint64(1000))
+}
+
+func (s *FileCacheTestSuite) TestMemoryUsage() {
+	data := bytes.Repeat([]byte{'x'}, 1000)
+	s.Less(uint64((&File{}).GetMemoryUsage()), uint64(1000))
+	file := &File{decodeDataPool: NewDataPoolFromBytes(data)}
+	s.GreaterOrEqual(uint64(file.GetMemoryUsage()), uint64(1000))
+
+	page, err := NewPage(0, testPage(100, 50))
+	s.Require().NoError(err)
+	small := page.GetMemoryUsage()
+	page.Form.Children = append(page.Form.Children, &iff.Chunk{ID: "Sjbz", Data: data})
+	page.Included = append(page.Included, &iff.Chunk{ID: "FORM:DJVI", Children: []*iff.Chunk{{ID: "Djbz", Data: data}}})
+	s.GreaterOrEqual(uint64(page.GetMemoryUsage()), uint64(small+2000))
+}
+
+func (s *FileCacheTestSuite) TestDocumentPages() {
+	indexData, files := testIndirect("a.djvu", "b.djvu")
+	mem := NewMemoryPort()
+	for name, data := range files {
+		mem.Add(s.url("memory:cached/"+name), data)
+	}
+	mem.Add(s.url("memory:cached/index.djvu"), indexData)
+	doc, err := OpenDocument(context.Background(), s.url("memory:cached/index.djvu"), mem)
+	s.Require().NoError(err)
+	defer doc.Close()
+
+	cache := NewFileCache(1 << 20)
+	doc.SetFileCache(cache)
+	first, err := doc.GetPage(context.Background(), 1)
+	s.Require().NoError(err)
+	again, err := doc.GetPage(context.Background(), 1)
+	s.Require().NoError(err)
+	cached := cache.GetPage(doc.PageToUrl(1))
+	s.Require().NotNil(cached)
+	s.Same(cached.Form, again.Form) // Not decoded again
+	s.Equal(cached.GetMemoryUsage(), cache.GetSize())
+
+	// Each caller gets its own Page
+	s.NotSame(first, again)
+	s.NotSame(cached, again)
+	again.HiddenText = NewText()
+	again.Included = append(again.Included, &iff.Chunk{ID: "FORM:DJVI"})
+	s.NotSame(again.HiddenText, cached.HiddenText)
+	s.Empty(cached.Included)
+
+	// Pages are only decoded again once evicted
+	cache.SetMaxSize(first.GetMemoryUsage() - 1)
+	again, err = doc.GetPage(context.Background(), 1)
+	s.Require().NoError(err)
+	s.NotSame(cached.Form, again.Form)
+	s.Equal(first.Info, again.Info)
+
+	doc.SetFileCache(nil)
+	_, err = doc.GetPage(context.Background(), 0)
+	s.Require().NoError(err)
+	s.Equal(uint64(2), cache.Stats().Hits) // The second GetPage, and cache.GetPage
+}
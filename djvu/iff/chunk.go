@@ -0,0 +1,123 @@
+package iff
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Chunk is an IFF chunk held in memory, with its children if composite.
+type Chunk struct {
+	// Full id, such as `INFO` or `FORM:DJVU`
+	ID string
+	// Data of a simple chunk
+	Data []byte
+	// Children of a composite chunk
+	Children []*Chunk
+}
+
+// IsComposite returns whether the chunk contains other chunks.
+func (c *Chunk) IsComposite() bool {
+	return IsComposite(c.ID)
+}
+
+// Find returns the first child with the given full id, or nil.
+func (c *Chunk) Find(id string) *Chunk {
+	for _, child := range c.Children {
+		if child.ID == id {
+			return child
+		}
+	}
+	return nil
+}
+
+// FindAll returns the children with the given full id.
+func (c *Chunk) FindAll(id string) []*Chunk {
+	var retval []*Chunk
+	for _, child := range c.Children {
+		if child.ID == id {
+			retval = append(retval, child)
+		}
+	}
+	return retval
+}
+
+// Decode reads a whole chunk from r,
+// which may start with the magic `AT&T`.
+func Decode(r io.Reader) (*Chunk, error) {
+	f := NewReader(r)
+	id, err := f.GetChunk()
+	if err == io.EOF {
+		return nil, errors.Wrap(io.ErrUnexpectedEOF, "no chunk found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return readChunk(f, id)
+}
+
+// DecodeBytes reads a whole chunk from data.
+func DecodeBytes(data []byte) (*Chunk, error) {
+	return Decode(bytes.NewReader(data))
+}
+
+// Reads the chunk f has just entered
+func readChunk(f *IFF, id string) (*Chunk, error) {
+	chunk := &Chunk{ID: id}
+	if !IsComposite(id) {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not read chunk %q", id)
+		}
+		chunk.Data = data
+		return chunk, f.CloseChunk()
+	}
+
+	for {
+		childID, err := f.GetChunk()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "in chunk %q", id)
+		}
+		child, err := readChunk(f, childID)
+		if err != nil {
+			return nil, err
+		}
+		chunk.Children = append(chunk.Children, child)
+	}
+	return chunk, f.CloseChunk()
+}
+
+// Encode writes the chunk to w, preceded by the magic `AT&T` if magic is true.
+func (c *Chunk) Encode(w io.Writer, magic bool) error {
+	f := NewWriter(w, magic)
+	return c.write(f)
+}
+
+// Bytes returns the chunk encoded with the magic `AT&T`.
+func (c *Chunk) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := c.Encode(&buf, true); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *Chunk) write(f *IFF) error {
+	if err := f.PutChunk(c.ID); err != nil {
+		return err
+	}
+	if c.IsComposite() {
+		for _, child := range c.Children {
+			if err := child.write(f); err != nil {
+				return err
+			}
+		}
+	} else if _, err := f.Write(c.Data); err != nil {
+		return err
+	}
+	return f.CloseChunk()
+}
@@ -1,3 +1,337 @@
+// Package iff reads and writes the IFF85 structured files DjVu is made of.
+//
+// An IFF file is a sequence of chunks.
+// Each chunk starts with a 4 character identifier
+// and a 32-bit big-endian length,
+// followed by its data and a padding byte if the length is odd.
+// Composite chunks (FORM, LIST, PROP and CAT) contain other chunks
+// and have a secondary identifier,
+// which is reported with the primary one as in `FORM:DJVU`.
+// DjVu files start with the magic `AT&T`
+// which is not part of the IFF standard.
 package iff
 
-type IFF struct{}
+import (
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Magic is written at the start of DjVu files
+const Magic = "AT&T"
+
+var (
+	// ErrNotComposite is returned when entering a chunk which cannot have children
+	ErrNotComposite = errors.New("not a composite chunk")
+	// ErrBadChunkID is returned for identifiers which are not 4 printable characters
+	ErrBadChunkID = errors.New("bad chunk identifier")
+)
+
+// IsComposite returns whether the chunk with this full id contains other chunks.
+func IsComposite(id string) bool {
+	switch primary(id) {
+	case "FORM", "LIST", "PROP", "CAT ":
+		return true
+	}
+	return false
+}
+
+func primary(id string) string {
+	if len(id) < 4 {
+		return id
+	}
+	return id[:4]
+}
+
+// Returns whether id is made of 4 printable characters
+func checkID(id string) error {
+	if len(id) != 4 {
+		return errors.Wrapf(ErrBadChunkID, "%q", id)
+	}
+	for ii := 0; ii < 4; ii++ {
+		if id[ii] < 0x20 || id[ii] > 0x7e {
+			return errors.Wrapf(ErrBadChunkID, "%q", id)
+		}
+	}
+	return nil
+}
+
+// Splits and checks "FORM:DJVU" into "FORM" and "DJVU".
+func splitID(id string) (string, string, error) {
+	split := strings.SplitN(id, ":", 2)
+	if err := checkID(split[0]); err != nil {
+		return "", "", err
+	}
+	if !IsComposite(split[0]) {
+		if len(split) > 1 {
+			return "", "", errors.Wrapf(ErrBadChunkID, "%q", id)
+		}
+		return split[0], "", nil
+	}
+	if len(split) != 2 {
+		return "", "", errors.Wrapf(ErrBadChunkID, "composite %q needs a secondary id", id)
+	}
+	if err := checkID(split[1]); err != nil {
+		return "", "", err
+	}
+	return split[0], split[1], nil
+}
+
+// IFF is a stream of IFF chunks, which is either read or written.
+//
+// When reading, GetChunk enters the next chunk,
+// Read reads its data and CloseChunk leaves it.
+// Calling GetChunk while in a composite chunk enters its first child.
+//
+// When writing, PutChunk starts a chunk,
+// Write writes its data and CloseChunk ends it.
+// Chunks are buffered until their length is known.
+type IFF struct {
+	r io.Reader
+	w io.Writer
+
+	offset int64   // Current position in the stream
+	stack  []frame // Open chunks, innermost last
+	magic  bool    // Whether the magic is (expected to be) at the start
+
+	err error // Sticky write error
+}
+
+type frame struct {
+	id  string
+	end int64 // Reading: offset of the end of the chunk
+	buf []byte
+}
+
+// NewReader creates an IFF reading chunks from r.
+// The magic `AT&T` is skipped if present.
+func NewReader(r io.Reader) *IFF {
+	return &IFF{r: r, magic: true}
+}
+
+// NewWriter creates an IFF writing chunks to w.
+// The magic `AT&T` is written before the first chunk
+// if magic is true.
+func NewWriter(w io.Writer, magic bool) *IFF {
+	return &IFF{w: w, magic: magic}
+}
+
+// Depth returns the number of open chunks.
+func (f *IFF) Depth() int {
+	return len(f.stack)
+}
+
+// Offset returns the current position in the stream,
+// counted from where the IFF was created.
+func (f *IFF) Offset() int64 {
+	return f.offset
+}
+
+// GetChunk enters the next chunk and returns its full id.
+// Returns io.EOF when there are no more chunks
+// at this level of the stream.
+func (f *IFF) GetChunk() (string, error) {
+	if f.r == nil {
+		return "", errors.New("IFF is not open for reading")
+	}
+	if len(f.stack) > 0 && !IsComposite(f.stack[len(f.stack)-1].id) {
+		return "", errors.Wrapf(ErrNotComposite, "%q", f.stack[len(f.stack)-1].id)
+	}
+
+	end := int64(-1)
+	if len(f.stack) > 0 {
+		end = f.stack[len(f.stack)-1].end
+	}
+
+	// Skip the padding byte
+	if f.offset&1 == 1 && (end < 0 || f.offset < end) {
+		if err := f.skip(1); err != nil {
+			return "", f.eofAtTop(err)
+		}
+	}
+	if end >= 0 && f.offset+8 > end {
+		return "", io.EOF
+	}
+
+	var header [8]byte
+	if err := f.readFull(header[:4]); err != nil {
+		return "", f.eofAtTop(err)
+	}
+	if f.magic && f.offset == 4 && string(header[:4]) == Magic {
+		if err := f.readFull(header[:4]); err != nil {
+			return "", errors.Wrap(unexpected(err), "could not read chunk header")
+		}
+	}
+	if err := f.readFull(header[4:]); err != nil {
+		return "", errors.Wrap(unexpected(err), "could not read chunk header")
+	}
+
+	id := string(header[:4])
+	if err := checkID(id); err != nil {
+		return "", err
+	}
+	size := int64(header[4])<<24 | int64(header[5])<<16 | int64(header[6])<<8 | int64(header[7])
+	chunkEnd := f.offset + size
+	if end >= 0 && chunkEnd > end {
+		return "", errors.Errorf("chunk %q overflows its parent", id)
+	}
+
+	if IsComposite(id) {
+		if size < 4 {
+			return "", errors.Errorf("composite chunk %q is too small", id)
+		}
+		var secondary [4]byte
+		if err := f.readFull(secondary[:]); err != nil {
+			return "", errors.Wrap(unexpected(err), "could not read chunk header")
+		}
+		if err := checkID(string(secondary[:])); err != nil {
+			return "", err
+		}
+		id += ":" + string(secondary[:])
+	}
+
+	f.stack = append(f.stack, frame{id: id, end: chunkEnd})
+	return id, nil
+}
+
+// Read reads the data of the current chunk.
+// Returns io.EOF at the end of the chunk.
+func (f *IFF) Read(p []byte) (int, error) {
+	if f.r == nil {
+		return 0, errors.New("IFF is not open for reading")
+	}
+	if len(f.stack) == 0 {
+		return 0, io.EOF
+	}
+	remaining := f.stack[len(f.stack)-1].end - f.offset
+	if remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := f.r.Read(p)
+	f.offset += int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// CloseChunk leaves the current chunk.
+// When reading, the rest of its data is skipped.
+// When writing, the chunk is written to its parent.
+func (f *IFF) CloseChunk() error {
+	if len(f.stack) == 0 {
+		return errors.New("no open chunk")
+	}
+	top := f.stack[len(f.stack)-1]
+	f.stack = f.stack[:len(f.stack)-1]
+
+	if f.r != nil {
+		return f.skip(top.end - f.offset)
+	}
+	return f.writeChunk(top)
+}
+
+// PutChunk starts a chunk with the full id (such as `FORM:DJVU` or `INFO`).
+// The chunk is written once closed.
+func (f *IFF) PutChunk(id string) error {
+	if f.w == nil {
+		return errors.New("IFF is not open for writing")
+	}
+	_, secondary, err := splitID(id)
+	if err != nil {
+		return err
+	}
+	if len(f.stack) > 0 && !IsComposite(f.stack[len(f.stack)-1].id) {
+		return errors.Wrapf(ErrNotComposite, "%q", f.stack[len(f.stack)-1].id)
+	}
+
+	if f.magic && f.offset == 0 && len(f.stack) == 0 {
+		f.emit([]byte(Magic))
+	}
+	if f.offset&1 == 1 {
+		f.emit([]byte{0})
+	}
+	f.offset += 8 // Header, written on CloseChunk
+	f.stack = append(f.stack, frame{id: id})
+	if secondary != "" {
+		f.emit([]byte(secondary))
+	}
+	return nil
+}
+
+// Write writes data to the current chunk.
+func (f *IFF) Write(p []byte) (int, error) {
+	if f.w == nil {
+		return 0, errors.New("IFF is not open for writing")
+	}
+	if len(f.stack) == 0 {
+		return 0, errors.New("no open chunk")
+	}
+	f.emit(p)
+	return len(p), f.err
+}
+
+// Writes data to the innermost chunk, or to the stream at the top level
+func (f *IFF) emit(p []byte) {
+	f.offset += int64(len(p))
+	if len(f.stack) > 0 {
+		top := &f.stack[len(f.stack)-1]
+		top.buf = append(top.buf, p...)
+		return
+	}
+	if f.err == nil {
+		_, f.err = f.w.Write(p)
+	}
+}
+
+func (f *IFF) writeChunk(chunk frame) error {
+	size := len(chunk.buf)
+	header := []byte(primary(chunk.id))
+	header = append(header, byte(size>>24), byte(size>>16), byte(size>>8), byte(size))
+
+	// The header has already been counted in the offset
+	f.offset -= int64(len(chunk.buf)) + 8
+	f.emit(header)
+	f.emit(chunk.buf)
+	return errors.Wrap(f.err, "could not write chunk")
+}
+
+func (f *IFF) readFull(p []byte) error {
+	n, err := io.ReadFull(f.r, p)
+	f.offset += int64(n)
+	return err
+}
+
+func (f *IFF) skip(n int64) error {
+	if n <= 0 {
+		return nil
+	}
+	if seeker, ok := f.r.(io.Seeker); ok {
+		if _, err := seeker.Seek(n, io.SeekCurrent); err == nil {
+			f.offset += n
+			return nil
+		}
+	}
+	copied, err := io.CopyN(io.Discard, f.r, n)
+	f.offset += copied
+	return errors.Wrap(unexpected(err), "could not skip chunk data")
+}
+
+// At the top level, running out of data between chunks is the normal end of the stream
+func (f *IFF) eofAtTop(err error) error {
+	if err == io.EOF && len(f.stack) == 0 {
+		return io.EOF
+	}
+	return errors.Wrap(unexpected(err), "could not read chunk header")
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+package iff
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type IFFTestSuite struct {
+	suite.Suite
+	Page *Chunk
+}
+
+func TestIFFSuite(t *testing.T) {
+	suite.Run(t, new(IFFTestSuite))
+}
+
+func (s *IFFTestSuite) SetupTest() {
+	s.Page = &Chunk{ID: "FORM:DJVU", Children: []*Chunk{
+		{ID: "INFO", Data: []byte("0123456789")},
+		{ID: "ANTa", Data: []byte("odd")},
+		{ID: "FORM:DJVI", Children: []*Chunk{{ID: "TXTa", Data: []byte("x")}}},
+		{ID: "TXTa", Data: []byte("last")},
+	}}
+}
+
+func (s *IFFTestSuite) TestEncoding() {
+	data, err := s.Page.Bytes()
+	s.NoError(err)
+	s.Equal([]byte("AT&TFORM\x00\x00\x00\x44DJVU"+
+		"INFO\x00\x00\x00\x0a0123456789"+
+		"ANTa\x00\x00\x00\x03odd\x00"+
+		"FORM\x00\x00\x00\x0dDJVITXTa\x00\x00\x00\x01x\x00"+
+		"TXTa\x00\x00\x00\x04last"), data)
+
+	decoded, err := DecodeBytes(data)
+	s.NoError(err)
+	s.Equal(s.Page, decoded)
+
+	// Without the magic
+	var buf bytes.Buffer
+	s.NoError(s.Page.Encode(&buf, false))
+	s.Equal(data[4:], buf.Bytes())
+	decoded, err = DecodeBytes(buf.Bytes())
+	s.NoError(err)
+	s.Equal(s.Page, decoded)
+}
+
+func (s *IFFTestSuite) TestStreaming() {
+	data, err := s.Page.Bytes()
+	s.NoError(err)
+	f := NewReader(bytes.NewReader(data))
+
+	id, err := f.GetChunk()
+	s.NoError(err)
+	s.Equal("FORM:DJVU", id)
+	id, err = f.GetChunk()
+	s.NoError(err)
+	s.Equal("INFO", id)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(f, buf)
+	s.NoError(err)
+	s.Equal("0123", string(buf))
+	s.NoError(f.CloseChunk()) // Skips the rest
+
+	var ids []string
+	for {
+		id, err := f.GetChunk()
+		if err == io.EOF {
+			break
+		}
+		s.NoError(err)
+		ids = append(ids, id)
+		s.NoError(f.CloseChunk())
+	}
+	s.Equal([]string{"ANTa", "FORM:DJVI", "TXTa"}, ids)
+	s.NoError(f.CloseChunk())
+	_, err = f.GetChunk()
+	s.Equal(io.EOF, err)
+}
+
+func (s *IFFTestSuite) TestErrors() {
+	data, err := s.Page.Bytes()
+	s.NoError(err)
+	_, err = DecodeBytes(data[:30])
+	s.ErrorIs(err, io.ErrUnexpectedEOF)
+
+	_, err = DecodeBytes([]byte("AT&Tfo\x01m\x00\x00\x00\x00"))
+	s.ErrorIs(err, ErrBadChunkID)
+
+	w := NewWriter(io.Discard, true)
+	s.ErrorIs(w.PutChunk("FORM"), ErrBadChunkID)
+	s.ErrorIs(w.PutChunk("INFO:DJVU"), ErrBadChunkID)
+	s.NoError(w.PutChunk("INFO"))
+	s.ErrorIs(w.PutChunk("BG44"), ErrNotComposite)
+}
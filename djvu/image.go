@@ -6,4 +6,6 @@ package djvu
   components are created and populated by the decoding function.  The
   rendering functions then can use the available components to compute a
   pixel representation of the desired segment of the DjVu image. */
-type Image struct{}
+type Image struct {
+	Port
+}
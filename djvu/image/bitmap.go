@@ -1,6 +1,8 @@
 package image
 
 import (
+	"image"
+	"image/color"
 	"sync"
 
 	"github.com/pkg/errors"
@@ -61,22 +63,67 @@ func NewBitmap(nrows uint16, ncols uint16, border uint16) (*Bitmap, error) {
 	return b, nil
 }
 
+// NewBitmapFromImage converts a standard library image,
+// whose top line comes first, into a bilevel Bitmap.
+// Pixels darker than middle gray are black.
+func NewBitmapFromImage(img image.Image) (*Bitmap, error) {
+	bounds := img.Bounds()
+	if bounds.Dx() > 0xffff || bounds.Dy() > 0xffff {
+		return nil, errors.Errorf("image of %dx%d is too large for a Bitmap", bounds.Dx(), bounds.Dy())
+	}
+	b, err := NewBitmap(uint16(bounds.Dy()), uint16(bounds.Dx()), 0)
+	if err != nil {
+		return nil, err
+	}
+	for rr := 0; rr < int(b.nrows); rr++ {
+		line := b.GetLine(int(b.nrows) - 1 - rr)
+		for cc := range line {
+			if color.GrayModel.Convert(img.At(bounds.Min.X+cc, bounds.Min.Y+rr)).(color.Gray).Y < 0x80 {
+				line[cc] = 1
+			}
+		}
+	}
+	return b, nil
+}
+
 // Copy copies an existing Bitmmap and returns said copy
 func (b *Bitmap) Copy() *Bitmap {
-	panic("unimplemented")
+	return b.CopyWithBorder(b.border)
 }
 
 // CopyWithBorder copies an existing Bitmmap and returns said copy,
 // but sets border to a specified value.
-func (b *Bitmap) CopyWithBorder(border uint8) *Bitmap {
-	panic("unimplemented")
+func (b *Bitmap) CopyWithBorder(border uint16) *Bitmap {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+	retval, err := NewBitmap(b.nrows, b.ncols, border)
+	if err != nil {
+		panic(err) // The size has been checked when b was made
+	}
+	retval.grays = b.grays
+	for rr := 0; rr < int(b.nrows); rr++ {
+		copy(retval.GetLine(rr), b.GetLine(rr))
+	}
+	return retval
 }
 
 // CopySection creates a Bitmap by copying a rectangular segment `rect` of Bitmap `b`.
 // `border` specifies the size of an optional border of white pixels
 // surrounding the image.
-func (b *Bitmap) CopySection(r Rect, border uint8) {
-	panic("unimplemented")
+// Pixels of `rect` outside of `b` are white.
+func (b *Bitmap) CopySection(r Rect, border uint16) (*Bitmap, error) {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+	retval, err := NewBitmap(uint16(r.Height()), uint16(r.Width()), border)
+	if err != nil || int(r.Height()) != int(retval.nrows) || int(r.Width()) != int(retval.ncols) {
+		return nil, errors.Errorf("cannot copy section of %dx%d", r.Width(), r.Height())
+	}
+	retval.grays = b.grays
+	within := r.Intersect(NewRect(0, 0, int32(b.ncols), int32(b.nrows)))
+	for y := within.ymin; y < within.ymax; y++ {
+		copy(retval.GetLine(int(y - r.ymin))[within.xmin-r.xmin:], b.GetLine(int(y))[within.xmin:within.xmax])
+	}
+	return retval, nil
 }
 
 // Init resets the Bitmap size to `nrows` by `ncols` and sets all pixels to white.
@@ -87,7 +134,7 @@ func (b *Bitmap) Init(nrows uint16, ncols uint16, border uint16) error {
 	// Some checking to make sure nothing overflows
 	nr, nc, br := uint32(nrows), uint32(ncols), uint32(border)
 	np := nr*(nc+br) + br
-	if nc+br != uint32(ncols+nrows) ||
+	if nc+br != uint32(ncols+border) ||
 		(nr > 0 && (np-br)/nr != uint32(ncols+border)) {
 		return errors.Errorf("Bitmap: image size exceeds maximum (corrupted file?)")
 	}
@@ -111,7 +158,46 @@ func (b *Bitmap) Init(nrows uint16, ncols uint16, border uint16) error {
 
 // Fill initializes all Bitmap pixels to some `value`
 func (b *Bitmap) Fill(value byte) {
-	panic("unimplemented")
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	for rr := 0; rr < int(b.nrows); rr++ {
+		line := b.GetLine(rr)
+		for cc := range line {
+			line[cc] = value
+		}
+	}
+}
+
+// GetLine returns the pixels of row `row`, row zero being the bottom line.
+// Writing to the returned slice changes the image.
+func (b *Bitmap) GetLine(row int) []byte {
+	start := b.offset(row)
+	return b.bytes[start : start+int(b.ncols)]
+}
+
+// Returns the index of the first pixel of row `row` in bytes
+func (b *Bitmap) offset(row int) int {
+	return row*int(b.bytesPerRow) + int(b.border)
+}
+
+// GetGrays returns the number of gray levels.
+// Value 2 denotes a bilevel image.
+func (b *Bitmap) GetGrays() int {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+	return int(b.grays)
+}
+
+// SetGrays sets the number of gray levels without changing the pixels.
+// It must be between 2 and 256.
+func (b *Bitmap) SetGrays(grays int) error {
+	if grays < 2 || grays > 256 {
+		return errors.Errorf("bad number of gray levels %d", grays)
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.grays = uint16(grays)
+	return nil
 }
 
 // Rows returns the number of rows (the image height)
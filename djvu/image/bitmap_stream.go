@@ -24,14 +24,14 @@ func NewBitmapFromStream(r io.Reader, border uint16) (*Bitmap, error) {
 		return nil, errors.Wrapf(err, "could not read number of columns")
 	}
 	if acols > math.MaxUint16 {
-		return nil, errors.Wrapf(err, "too many columns in image")
+		return nil, errors.Errorf("too many columns in image, got %v", acols)
 	}
 	arows, err := br.ReadInteger()
 	if err != nil {
 		return nil, errors.Wrapf(err, "could not read number of rows")
 	}
 	if arows > math.MaxUint16 {
-		return nil, errors.Wrapf(err, "too many rows in image")
+		return nil, errors.Errorf("too many rows in image, got %v", arows)
 	}
 	bitmap, err := NewBitmap(uint16(arows), uint16(acols), border)
 	if err != nil {
@@ -109,10 +109,10 @@ func NewBitmapFromStream(r io.Reader, border uint16) (*Bitmap, error) {
 func (b *Bitmap) readPbmText(r io.Reader) error {
 	br := bytestream.NewByteReader(r)
 
-	for rr := b.nrows - 1; rr <= b.nrows-1; rr-- {
-		row := rr*b.bytesPerRow + b.border
+	for rr := int(b.nrows) - 1; rr >= 0; rr-- {
+		row := b.GetLine(rr)
 
-		for cc := uint16(0); cc < b.ncols; cc++ {
+		for cc := range row {
 			if br.Advance(); br.E != nil {
 				return br.E
 			}
@@ -124,9 +124,9 @@ func (b *Bitmap) readPbmText(r io.Reader) error {
 
 			switch br.B {
 			case '1':
-				b.bytes[row+cc] = 1
+				row[cc] = 1
 			case '0':
-				b.bytes[row+cc] = 0
+				row[cc] = 0
 			default:
 				return errors.Errorf("bad PBM")
 			}
@@ -144,15 +144,18 @@ func (b *Bitmap) readPgmText(r io.Reader, maxval uint32) error {
 
 	br := bytestream.NewReader(r)
 
-	for rr := b.nrows - 1; rr <= b.nrows-1; rr-- {
-		row := rr*b.bytesPerRow + b.border
+	for rr := int(b.nrows) - 1; rr >= 0; rr-- {
+		row := b.GetLine(rr)
 
-		for cc := uint16(0); cc < b.ncols; cc++ {
+		for cc := range row {
 			ind, err := br.ReadInteger()
 			if err != nil {
 				return err
 			}
-			b.bytes[row+cc] = ramp[ind]
+			if ind > maxval {
+				return errors.Errorf("gray level %v exceeds maxval %v", ind, maxval)
+			}
+			row[cc] = ramp[ind]
 		}
 	}
 
@@ -162,10 +165,10 @@ func (b *Bitmap) readPgmText(r io.Reader, maxval uint32) error {
 func (b *Bitmap) readPbmRaw(r io.Reader) error {
 	br := newBytereader(r)
 
-	for rr := b.nrows - 1; rr <= b.nrows-1; rr-- {
-		row := rr*b.bytesPerRow + b.border
+	for rr := int(b.nrows) - 1; rr >= 0; rr-- {
+		row := b.GetLine(rr)
 		var mask byte
-		for cc := uint16(0); cc < b.ncols; cc++ {
+		for cc := range row {
 			if mask == 0 {
 				if br.advance(); br.E != nil {
 					return br.E
@@ -173,9 +176,9 @@ func (b *Bitmap) readPbmRaw(r io.Reader) error {
 				mask = 0x80
 			}
 			if br.B&mask != 0 {
-				b.bytes[row+cc] = 1
+				row[cc] = 1
 			} else {
-				b.bytes[row+cc] = 0
+				row[cc] = 0
 			}
 			mask >>= 1
 		}
@@ -191,15 +194,15 @@ func (b *Bitmap) readPgmRaw(r io.Reader, maxval uint32) error {
 		maxbin = 65536
 	}
 
-	ramp := make([]byte, maxbin-1)
-	for ii := uint32(0); ii < maxval; ii++ {
+	ramp := make([]byte, maxbin)
+	for ii := uint32(0); ii < maxval && ii < maxbin; ii++ {
 		ramp[ii] = byte((uint32(b.grays-1)*(maxval-ii) + maxval/2) / maxval)
 	}
 
-	for rr := b.nrows - 1; rr <= b.nrows-1; rr-- {
-		row := rr*b.bytesPerRow + b.border
-		for cc := uint16(0); cc < b.ncols; cc++ {
-			if maxbin > 255 { // Two bytes read
+	for rr := int(b.nrows) - 1; rr >= 0; rr-- {
+		row := b.GetLine(rr)
+		for cc := range row {
+			if maxval > 255 { // Two bytes read
 				if br.advance(); br.E != nil {
 					return br.E
 				}
@@ -209,14 +212,14 @@ func (b *Bitmap) readPgmRaw(r io.Reader, maxval uint32) error {
 				}
 				b2 := br.B
 
-				b.bytes[row+cc] = ramp[uint16(b1)*256+uint16(b2)]
+				row[cc] = ramp[uint16(b1)*256+uint16(b2)]
 
 			} else { // One byte read
 				if br.advance(); br.E != nil {
 					return br.E
 				}
 				bb := br.B
-				b.bytes[row+cc] = ramp[bb]
+				row[cc] = ramp[bb]
 			}
 		}
 	}
@@ -227,11 +230,10 @@ func (b *Bitmap) readPgmRaw(r io.Reader, maxval uint32) error {
 func (b *Bitmap) readRleRaw(r io.Reader) error {
 	br := newBytereader(r)
 	var p byte
-	n := b.nrows - 1
-	row := n*b.bytesPerRow + b.border
+	n := int(b.nrows) - 1
 	var c uint32
 
-	for n <= b.nrows-1 {
+	for n >= 0 {
 		if br.advance(); br.E != nil {
 			return br.E
 		}
@@ -248,15 +250,15 @@ func (b *Bitmap) readRleRaw(r io.Reader) error {
 			return errors.New("bitmap lost sync")
 		}
 
+		row := b.GetLine(n)
 		for ; x > 0; x-- {
-			b.bytes[uint32(row)+c] = p
+			row[c] = p
 			c++
 		}
 		p = 1 - p
 		if c >= uint32(b.ncols) {
 			c = 0
 			p = 0
-			row -= b.bytesPerRow
 			n--
 		}
 	}
@@ -0,0 +1,69 @@
+package image
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type BitmapStreamTestSuite struct {
+	suite.Suite
+}
+
+func TestBitmapStreamSuite(t *testing.T) {
+	suite.Run(t, new(BitmapStreamTestSuite))
+}
+
+// Returns the gray levels of the bitmap from the top row down,
+// in the order the rows are stored in a PNM file
+func (s *BitmapStreamTestSuite) levels(bm *Bitmap) [][]byte {
+	var levels [][]byte
+	for rr := int(bm.Rows()) - 1; rr >= 0; rr-- {
+		levels = append(levels, append([]byte(nil), bm.GetLine(rr)...))
+	}
+	return levels
+}
+
+func (s *BitmapStreamTestSuite) TestPbm() {
+	bm, err := NewBitmapFromStream(bytes.NewReader([]byte("P1\n3 2\n1 0 0\n0 1 1\n")), 0)
+	s.Require().NoError(err)
+	s.Equal(2, bm.GetGrays())
+	s.Equal([][]byte{{1, 0, 0}, {0, 1, 1}}, s.levels(bm))
+
+	bm, err = NewBitmapFromStream(bytes.NewReader([]byte{'P', '4', '\n', '3', ' ', '2', '\n', 0x80, 0x60}), 2)
+	s.Require().NoError(err)
+	s.Equal([][]byte{{1, 0, 0}, {0, 1, 1}}, s.levels(bm))
+}
+
+func (s *BitmapStreamTestSuite) TestPgmRaw() {
+	// White is the largest value and black is zero,
+	// the opposite of the gray levels of a Bitmap
+	data := append([]byte("P5\n3 1\n255\n"), 255, 128, 0)
+	bm, err := NewBitmapFromStream(bytes.NewReader(data), 0)
+	s.Require().NoError(err)
+	s.Equal(256, bm.GetGrays())
+	s.Equal([][]byte{{0, 127, 255}}, s.levels(bm))
+
+	// Two bytes per value, most significant first
+	data = append([]byte("P5\n2 2\n65535\n"), 0xff, 0xff, 0x80, 0x00, 0x00, 0x00, 0x00, 0xff)
+	bm, err = NewBitmapFromStream(bytes.NewReader(data), 1)
+	s.Require().NoError(err)
+	s.Equal(256, bm.GetGrays())
+	s.Equal([][]byte{{0, 127}, {255, 254}}, s.levels(bm))
+
+	// Truncated data
+	data = append([]byte("P5\n2 2\n65535\n"), 0xff, 0xff, 0x80)
+	_, err = NewBitmapFromStream(bytes.NewReader(data), 0)
+	s.Error(err)
+}
+
+func (s *BitmapStreamTestSuite) TestPgmText() {
+	bm, err := NewBitmapFromStream(bytes.NewReader([]byte("P2\n2 1\n3\n3 0\n")), 0)
+	s.Require().NoError(err)
+	s.Equal(4, bm.GetGrays())
+	s.Equal([][]byte{{0, 3}}, s.levels(bm))
+
+	_, err = NewBitmapFromStream(bytes.NewReader([]byte("P2\n2 1\n3\n4 0\n")), 0)
+	s.Error(err)
+}
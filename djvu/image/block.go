@@ -1,3 +0,0 @@
-package image
-
-type Block struct{}
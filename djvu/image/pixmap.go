@@ -1,3 +1,154 @@
 package image
 
-type Pixmap struct{}
+import (
+	"image"
+	"image/color"
+)
+
+// Pixel is a color pixel, with its components in the order of DjVu images.
+type Pixel struct {
+	B, G, R uint8
+}
+
+var (
+	WhitePixel = Pixel{B: 0xff, G: 0xff, R: 0xff}
+	BlackPixel = Pixel{}
+)
+
+// Pixmap represents color images.
+// Like Bitmap, GetLine returns the pixels of one line of the image
+// and line zero represents the bottom line of the image.
+type Pixmap struct {
+	nrows  int
+	ncols  int
+	pixels []Pixel
+}
+
+// NewPixmap constructs a Pixmap object with `nrows` rows and `ncols` columns.
+// All pixels are initialized to white.
+func NewPixmap(nrows, ncols int) *Pixmap {
+	if nrows < 0 || ncols < 0 {
+		nrows, ncols = 0, 0
+	}
+	p := &Pixmap{nrows: nrows, ncols: ncols, pixels: make([]Pixel, nrows*ncols)}
+	p.Fill(WhitePixel)
+	return p
+}
+
+// NewPixmapFromImage converts a standard library image,
+// whose top line comes first, into a Pixmap.
+func NewPixmapFromImage(img image.Image) *Pixmap {
+	bounds := img.Bounds()
+	p := NewPixmap(bounds.Dy(), bounds.Dx())
+	for rr := 0; rr < p.nrows; rr++ {
+		line := p.GetLine(p.nrows - 1 - rr)
+		for cc := range line {
+			c := color.RGBAModel.Convert(img.At(bounds.Min.X+cc, bounds.Min.Y+rr)).(color.RGBA)
+			line[cc] = Pixel{B: c.B, G: c.G, R: c.R}
+		}
+	}
+	return p
+}
+
+// Rows returns the number of rows (the image height)
+func (p *Pixmap) Rows() int { return p.nrows }
+
+// Cols returns the number of columns (the image width)
+func (p *Pixmap) Cols() int { return p.ncols }
+
+// GetLine returns the pixels of row `row`, row zero being the bottom line.
+// Writing to the returned slice changes the image.
+func (p *Pixmap) GetLine(row int) []Pixel {
+	return p.pixels[row*p.ncols : (row+1)*p.ncols]
+}
+
+// Fill sets all pixels to `value`
+func (p *Pixmap) Fill(value Pixel) {
+	for ii := range p.pixels {
+		p.pixels[ii] = value
+	}
+}
+
+// Copy returns a copy of the Pixmap
+func (p *Pixmap) Copy() *Pixmap {
+	retval := &Pixmap{nrows: p.nrows, ncols: p.ncols, pixels: make([]Pixel, len(p.pixels))}
+	copy(retval.pixels, p.pixels)
+	return retval
+}
+
+// Scale returns the image resized to `nrows` by `ncols`.
+// Each pixel is the average of the pixels it covers,
+// or the nearest pixel when enlarging.
+func (p *Pixmap) Scale(nrows, ncols int) *Pixmap {
+	retval := NewPixmap(nrows, ncols)
+	if p.nrows == 0 || p.ncols == 0 {
+		return retval
+	}
+	for rr := 0; rr < nrows; rr++ {
+		rmin, rmax := span(rr, nrows, p.nrows)
+		line := retval.GetLine(rr)
+		for cc := range line {
+			cmin, cmax := span(cc, ncols, p.ncols)
+			var r, g, b, n int
+			for sr := rmin; sr < rmax; sr++ {
+				for _, pix := range p.GetLine(sr)[cmin:cmax] {
+					r, g, b, n = r+int(pix.R), g+int(pix.G), b+int(pix.B), n+1
+				}
+			}
+			line[cc] = Pixel{B: uint8((b + n/2) / n), G: uint8((g + n/2) / n), R: uint8((r + n/2) / n)}
+		}
+	}
+	return retval
+}
+
+// Returns the range of the `size` source pixels covered by pixel `ii` out of `n`,
+// which holds at least one pixel
+func span(ii, n, size int) (int, int) {
+	lo, hi := ii*size/n, (ii+1)*size/n
+	if hi <= lo {
+		hi = lo + 1
+	}
+	if hi > size {
+		lo, hi = size-1, size
+	}
+	return lo, hi
+}
+
+// Rotate returns the image turned counterclockwise by `turns` quarter turns.
+func (p *Pixmap) Rotate(turns int) *Pixmap {
+	turns &= 3
+	if turns == 0 {
+		return p.Copy()
+	}
+	nrows, ncols := p.nrows, p.ncols
+	if turns&1 != 0 {
+		nrows, ncols = ncols, nrows
+	}
+	retval := NewPixmap(nrows, ncols)
+	for rr := 0; rr < p.nrows; rr++ {
+		for cc, pix := range p.GetLine(rr) {
+			// Row zero is at the bottom, so x goes right and y goes up
+			switch turns {
+			case 1:
+				retval.pixels[cc*ncols+(ncols-1-rr)] = pix
+			case 2:
+				retval.pixels[(nrows-1-rr)*ncols+(ncols-1-cc)] = pix
+			case 3:
+				retval.pixels[(nrows-1-cc)*ncols+rr] = pix
+			}
+		}
+	}
+	return retval
+}
+
+// Image converts the Pixmap into a standard library image,
+// whose top line comes first.
+func (p *Pixmap) Image() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, p.ncols, p.nrows))
+	for rr := 0; rr < p.nrows; rr++ {
+		for cc, pix := range p.GetLine(p.nrows - 1 - rr) {
+			img.SetRGBA(cc, rr, color.RGBA{R: pix.R, G: pix.G, B: pix.B, A: 0xff})
+		}
+	}
+	return img
+}
@@ -42,6 +42,18 @@ func NewRect(xmin int32, ymin int32, width int32, height int32) Rect {
 	}
 }
 
+// Xmin returns the minimal horizontal coordinate of the rectangle
+func (r Rect) Xmin() int32 { return r.xmin }
+
+// Ymin returns the minimal vertical coordinate of the rectangle
+func (r Rect) Ymin() int32 { return r.ymin }
+
+// Xmax returns the maximal horizontal coordinate of the rectangle
+func (r Rect) Xmax() int32 { return r.xmax }
+
+// Ymax returns the maximal vertical coordinate of the rectangle
+func (r Rect) Ymax() int32 { return r.ymax }
+
 // Width returns the rectangle's width
 func (r Rect) Width() int32 {
 	return r.xmax - r.xmin
@@ -112,7 +124,18 @@ func (r Rect) Intersect(r2 Rect) Rect {
 // Recthull returns the smallest rectangle that contains all points in both rectangles.
 // If either rectangle is empty, return the other one.
 func (r Rect) Recthull(r2 Rect) Rect {
-	panic("unimplemented")
+	if r.IsEmpty() {
+		return r2
+	}
+	if r2.IsEmpty() {
+		return r
+	}
+	return Rect{
+		xmin: min(r.xmin, r2.xmin),
+		ymin: min(r.ymin, r2.ymin),
+		xmax: max(r.xmax, r2.xmax),
+		ymax: max(r.ymax, r2.ymax),
+	}
 }
 
 // Scale expands the rectangle,
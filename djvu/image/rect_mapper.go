@@ -95,6 +95,7 @@ func (rm *RectMapper) Rotate(turns int) {
 	if (oldcode^rm.code)&_SWAPXY != 0 {
 		rm.src.xmin, rm.src.ymin = rm.src.ymin, rm.src.xmin
 		rm.src.xmax, rm.src.ymax = rm.src.ymax, rm.src.xmax
+		rm.precalc()
 	}
 }
 
@@ -10,6 +10,12 @@ func newZerobuffer(zerosize uint32) zerobuffer {
 	}
 }
 
+// Returns a buffer of at least `required` zeroes,
+// shared between all bitmaps when it is small enough.
 func zeroes(required uint32) *zerobuffer {
-	panic("unimplemented")
+	if required <= zerosize {
+		return &zerobuffer{buffer: _zerobuffer[:]}
+	}
+	zb := newZerobuffer(required)
+	return &zb
 }
@@ -1,5 +1,448 @@
 package iw44
 
-// Codec mainatins information shared
-// while encoding or decoding
-type Codec struct{}
+import "github.com/janreggie/go-djvulibre/djvu/zp"
+
+// codec holds the state of the coding of one Map, slice after slice.
+// A slice codes one bit plane of one band of every block:
+// first which coefficients become significant, with their sign,
+// then one more bit of the coefficients which already were.
+type codec struct {
+	// Coefficients known to the decoder.
+	// When encoding, they are the magnitudes of the estimates of the decoder.
+	m *Map
+	// Coefficients to code, only when encoding
+	emap *Map
+
+	curband int
+	curbit  int // Bit plane being coded, or -1 once done
+
+	// Thresholds of the coefficients of band 0 and of the other bands,
+	// halved after each bit plane
+	quantLo [16]int
+	quantHi [10]int
+
+	coeffState  [256]byte
+	bucketState [16]byte
+
+	ctxStart  [32]zp.Context
+	ctxBucket [10][8]zp.Context
+	ctxMant   zp.Context
+	ctxRoot   zp.Context
+}
+
+func newCodec(m *Map) *codec {
+	c := &codec{m: m, curbit: 1}
+	for ii := range c.quantLo {
+		if ii < 4 {
+			c.quantLo[ii] = iwQuant[ii]
+		} else {
+			c.quantLo[ii] = iwQuant[4+(ii-4)/4]
+		}
+	}
+	for band := 1; band < len(c.quantHi); band++ {
+		c.quantHi[band] = iwQuant[6+band]
+	}
+	return c
+}
+
+// Returns whether the current slice codes nothing,
+// because its thresholds are too large or null
+func (c *codec) isNullSlice() bool {
+	if c.curband == 0 {
+		null := true
+		for ii, thres := range c.quantLo {
+			c.coeffState[ii] = stateZero
+			if thres > 0 && thres < 0x8000 {
+				c.coeffState[ii] = stateUnk
+				null = false
+			}
+		}
+		return null
+	}
+	thres := c.quantHi[c.curband]
+	return !(thres > 0 && thres < 0x8000)
+}
+
+// Moves on to the next slice.
+// Returns false once all thresholds are null.
+func (c *codec) finishSlice() bool {
+	c.quantHi[c.curband] >>= 1
+	if c.curband == 0 {
+		for ii := range c.quantLo {
+			c.quantLo[ii] >>= 1
+		}
+	}
+	c.curband++
+	if c.curband >= len(bandBuckets) {
+		c.curband = 0
+		c.curbit++
+		if c.quantHi[len(bandBuckets)-1] == 0 {
+			c.curbit = -1
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the context of the bit telling whether bucket n gets new coefficients,
+// from the coefficients of its parent bucket
+func (c *codec) bucketContext(blk *block, band, n int, bbstate byte) int {
+	ctx := 0
+	if band > 0 {
+		k := n << 2
+		if parent := blk[k>>4]; parent != nil {
+			k &= 0xf
+			for ii := 0; ii < 4 && ctx < 3; ii++ {
+				if parent[k+ii] != 0 {
+					ctx++
+				}
+			}
+		}
+	}
+	if bbstate&stateActive != 0 {
+		ctx |= 4
+	}
+	return ctx
+}
+
+// Returns the context of the bit telling whether a coefficient becomes significant
+func (c *codec) startContext(gotcha int, bucketState byte) int {
+	ctx := gotcha
+	if ctx > 7 {
+		ctx = 7
+	}
+	if bucketState&stateActive != 0 {
+		ctx |= 8
+	}
+	return ctx
+}
+
+func (c *codec) threshold(band, ii int) int {
+	if band == 0 {
+		return c.quantLo[ii]
+	}
+	return c.quantHi[band]
+}
+
+// Decodes a slice into the Map.
+// Returns false once there are no more slices.
+func (c *codec) decodeSlice(zd *zp.Decoder) bool {
+	if c.curbit < 0 {
+		return false
+	}
+	if !c.isNullSlice() {
+		bb := bandBuckets[c.curband]
+		for bno := range c.m.blocks {
+			c.decodeBuckets(zd, c.curband, &c.m.blocks[bno], bb.start, bb.size)
+		}
+	}
+	return c.finishSlice()
+}
+
+// Computes the states of the coefficients of the buckets of a band
+func (c *codec) decodePrepare(blk *block, fbucket, nbucket int) byte {
+	var bbstate byte
+	if fbucket == 0 {
+		// Band zero, whose coefficients may be always null
+		if blk[0] == nil {
+			bbstate = stateUnk
+		} else {
+			for ii, v := range blk[0] {
+				state := c.coeffState[ii]
+				if state != stateZero {
+					state = stateUnk
+					if v != 0 {
+						state = stateActive
+					}
+				}
+				c.coeffState[ii] = state
+				bbstate |= state
+			}
+		}
+		c.bucketState[0] = bbstate
+		return bbstate
+	}
+
+	for buckno := 0; buckno < nbucket; buckno++ {
+		var bstate byte
+		if bucket := blk[fbucket+buckno]; bucket == nil {
+			bstate = stateUnk
+		} else {
+			for ii, v := range bucket {
+				state := byte(stateUnk)
+				if v != 0 {
+					state = stateActive
+				}
+				c.coeffState[buckno<<4|ii] = state
+				bstate |= state
+			}
+		}
+		c.bucketState[buckno] = bstate
+		bbstate |= bstate
+	}
+	return bbstate
+}
+
+func (c *codec) decodeBuckets(zd *zp.Decoder, band int, blk *block, fbucket, nbucket int) {
+	bbstate := c.decodePrepare(blk, fbucket, nbucket)
+
+	// Whether any bucket gets new coefficients
+	if nbucket < 16 || bbstate&stateActive != 0 {
+		bbstate |= stateNew
+	} else if bbstate&stateUnk != 0 {
+		if zd.Decode(&c.ctxRoot) != 0 {
+			bbstate |= stateNew
+		}
+	}
+
+	// Which buckets get new coefficients
+	if bbstate&stateNew != 0 {
+		for buckno := 0; buckno < nbucket; buckno++ {
+			if c.bucketState[buckno]&stateUnk != 0 {
+				ctx := c.bucketContext(blk, band, fbucket+buckno, bbstate)
+				if zd.Decode(&c.ctxBucket[band][ctx]) != 0 {
+					c.bucketState[buckno] |= stateNew
+				}
+			}
+		}
+	}
+
+	// New coefficients, with their sign
+	if bbstate&stateNew != 0 {
+		for buckno := 0; buckno < nbucket; buckno++ {
+			if c.bucketState[buckno]&stateNew == 0 {
+				continue
+			}
+			cstate := c.coeffState[buckno<<4 : (buckno+1)<<4]
+			if blk[fbucket+buckno] == nil {
+				// The states of the coefficients of the bucket are only known now
+				for ii := range cstate {
+					if fbucket != 0 || cstate[ii] != stateZero {
+						cstate[ii] = stateUnk
+					}
+				}
+			}
+			pcoeff := blk.alloc(fbucket + buckno)
+
+			gotcha := 0
+			for _, state := range cstate {
+				if state&stateUnk != 0 {
+					gotcha++
+				}
+			}
+			for ii := range cstate {
+				if cstate[ii]&stateUnk == 0 {
+					continue
+				}
+				ctx := c.startContext(gotcha, c.bucketState[buckno])
+				if zd.Decode(&c.ctxStart[ctx]) != 0 {
+					cstate[ii] |= stateNew
+					thres := c.threshold(band, ii)
+					half := thres >> 1
+					coeff := thres + half - (half >> 2)
+					if zd.DecodeIW() != 0 {
+						coeff = -coeff
+					}
+					pcoeff[ii] = int16(coeff)
+					gotcha = 0
+				} else if gotcha > 0 {
+					gotcha--
+				}
+			}
+		}
+	}
+
+	// One more bit of the coefficients which were significant
+	if bbstate&stateActive != 0 {
+		for buckno := 0; buckno < nbucket; buckno++ {
+			if c.bucketState[buckno]&stateActive == 0 {
+				continue
+			}
+			pcoeff := blk[fbucket+buckno]
+			for ii := 0; ii < 16; ii++ {
+				if c.coeffState[buckno<<4|ii]&stateActive == 0 {
+					continue
+				}
+				coeff := int(pcoeff[ii])
+				if coeff < 0 {
+					coeff = -coeff
+				}
+				thres := c.threshold(band, ii)
+				var bit int
+				if coeff <= 3*thres {
+					// Moves the first estimate to the middle of its interval
+					coeff += thres >> 2
+					bit = zd.Decode(&c.ctxMant)
+				} else {
+					bit = zd.DecodeIW()
+				}
+				if bit != 0 {
+					coeff += thres >> 1
+				} else {
+					coeff += (thres >> 1) - thres
+				}
+				if pcoeff[ii] > 0 {
+					pcoeff[ii] = int16(coeff)
+				} else {
+					pcoeff[ii] = int16(-coeff)
+				}
+			}
+		}
+	}
+}
+
+// Encodes a slice of the coefficients of emap.
+// Returns false once there are no more slices.
+func (c *codec) encodeSlice(ze *zp.Encoder) bool {
+	if c.curbit < 0 {
+		return false
+	}
+	if !c.isNullSlice() {
+		bb := bandBuckets[c.curband]
+		for bno := range c.m.blocks {
+			c.encodeBuckets(ze, c.curband, &c.m.blocks[bno], &c.emap.blocks[bno], bb.start, bb.size)
+		}
+	}
+	return c.finishSlice()
+}
+
+// Computes the states of the coefficients of the buckets of a band,
+// knowing which ones become significant
+func (c *codec) encodePrepare(band int, blk, eblk *block, fbucket, nbucket int) byte {
+	var bbstate byte
+	if fbucket == 0 {
+		pcoeff, epcoeff := blk.alloc(0), eblk.alloc(0)
+		for ii := range pcoeff {
+			state := c.coeffState[ii]
+			if state != stateZero {
+				state = stateUnk
+				thres := int16(c.quantLo[ii])
+				if pcoeff[ii] != 0 {
+					state = stateActive
+				} else if epcoeff[ii] >= thres || epcoeff[ii] <= -thres {
+					state = stateNew | stateUnk
+				}
+			}
+			c.coeffState[ii] = state
+			bbstate |= state
+		}
+		c.bucketState[0] = bbstate
+		return bbstate
+	}
+
+	thres := c.quantHi[band]
+	for buckno := 0; buckno < nbucket; buckno++ {
+		pcoeff, epcoeff := blk[fbucket+buckno], eblk[fbucket+buckno]
+		var bstate byte
+		if pcoeff == nil {
+			bstate = stateUnk
+		}
+		for ii := 0; ii < 16 && (pcoeff != nil || epcoeff != nil); ii++ {
+			state := byte(stateUnk)
+			if pcoeff != nil && pcoeff[ii] != 0 {
+				state = stateActive
+			} else if epcoeff != nil && (int(epcoeff[ii]) >= thres || int(epcoeff[ii]) <= -thres) {
+				state = stateNew | stateUnk
+			}
+			c.coeffState[buckno<<4|ii] = state
+			bstate |= state
+		}
+		c.bucketState[buckno] = bstate
+		bbstate |= bstate
+	}
+	return bbstate
+}
+
+func (c *codec) encodeBuckets(ze *zp.Encoder, band int, blk, eblk *block, fbucket, nbucket int) {
+	bbstate := c.encodePrepare(band, blk, eblk, fbucket, nbucket)
+
+	// Whether any bucket gets new coefficients
+	if nbucket < 16 || bbstate&stateActive != 0 {
+		bbstate |= stateNew
+	} else if bbstate&stateUnk != 0 {
+		ze.Encode(b2i(bbstate&stateNew != 0), &c.ctxRoot)
+	}
+
+	// Which buckets get new coefficients
+	if bbstate&stateNew != 0 {
+		for buckno := 0; buckno < nbucket; buckno++ {
+			if c.bucketState[buckno]&stateUnk != 0 {
+				ctx := c.bucketContext(blk, band, fbucket+buckno, bbstate)
+				ze.Encode(b2i(c.bucketState[buckno]&stateNew != 0), &c.ctxBucket[band][ctx])
+			}
+		}
+	}
+
+	// New coefficients, with their sign
+	if bbstate&stateNew != 0 {
+		for buckno := 0; buckno < nbucket; buckno++ {
+			if c.bucketState[buckno]&stateNew == 0 {
+				continue
+			}
+			cstate := c.coeffState[buckno<<4 : (buckno+1)<<4]
+			pcoeff, epcoeff := blk.alloc(fbucket+buckno), eblk.alloc(fbucket+buckno)
+
+			gotcha := 0
+			for _, state := range cstate {
+				if state&stateUnk != 0 {
+					gotcha++
+				}
+			}
+			for ii := range cstate {
+				if cstate[ii]&stateUnk == 0 {
+					continue
+				}
+				ctx := c.startContext(gotcha, c.bucketState[buckno])
+				ze.Encode(b2i(cstate[ii]&stateNew != 0), &c.ctxStart[ctx])
+				if cstate[ii]&stateNew != 0 {
+					ze.EncodeIW(b2i(epcoeff[ii] < 0))
+					thres := c.threshold(band, ii)
+					pcoeff[ii] = int16(thres + thres>>1)
+					gotcha = 0
+				} else if gotcha > 0 {
+					gotcha--
+				}
+			}
+		}
+	}
+
+	// One more bit of the coefficients which were significant
+	if bbstate&stateActive != 0 {
+		for buckno := 0; buckno < nbucket; buckno++ {
+			if c.bucketState[buckno]&stateActive == 0 {
+				continue
+			}
+			pcoeff, epcoeff := blk[fbucket+buckno], eblk.alloc(fbucket+buckno)
+			for ii := 0; ii < 16; ii++ {
+				if c.coeffState[buckno<<4|ii]&stateActive == 0 {
+					continue
+				}
+				coeff := int(epcoeff[ii])
+				if coeff < 0 {
+					coeff = -coeff
+				}
+				pix := int(pcoeff[ii])
+				thres := c.threshold(band, ii)
+				bit := b2i(coeff >= pix)
+				if pix <= 3*thres {
+					ze.Encode(bit, &c.ctxMant)
+				} else {
+					ze.EncodeIW(bit)
+				}
+				if bit != 0 {
+					pix += thres >> 1
+				} else {
+					pix += (thres >> 1) - thres
+				}
+				pcoeff[ii] = int16(pix)
+			}
+		}
+	}
+}
+
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
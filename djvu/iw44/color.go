@@ -0,0 +1,71 @@
+package iw44
+
+import "github.com/janreggie/go-djvulibre/djvu/image"
+
+// Coefficients of the conversion from RGB to Y, Cr and Cb
+var rgbToYcc = [3][3]float64{
+	{0.304348, 0.608696, 0.086956},
+	{0.463768, -0.405797, -0.057971},
+	{-0.173913, -0.347826, 0.521739},
+}
+
+// Multiplication tables of the conversion, in fixed point with 16 bits of fraction
+var yccMul [3][3][256]int
+
+func init() {
+	for ii := range yccMul {
+		for jj := range yccMul[ii] {
+			for k := range yccMul[ii][jj] {
+				yccMul[ii][jj][k] = int(float64(k) * 0x10000 * rgbToYcc[ii][jj])
+			}
+		}
+	}
+}
+
+func clampInt8(v int) int8 {
+	if v < -128 {
+		return -128
+	} else if v > 127 {
+		return 127
+	}
+	return int8(v)
+}
+
+func clampUint8(v int) uint8 {
+	if v < 0 {
+		return 0
+	} else if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
+
+// Returns the component `comp` (0 for Y, 1 for Cr, 2 for Cb) of the pixels of pm,
+// bottom row first
+func yccPlane(pm *image.Pixmap, comp int) []int8 {
+	w, h := pm.Cols(), pm.Rows()
+	plane := make([]int8, w*h)
+	mul := &yccMul[comp]
+	for rr := 0; rr < h; rr++ {
+		for cc, pix := range pm.GetLine(rr) {
+			v := (mul[0][pix.R] + mul[1][pix.G] + mul[2][pix.B] + 32768) >> 16
+			if comp == 0 {
+				v -= 128
+			}
+			plane[rr*w+cc] = clampInt8(v)
+		}
+	}
+	return plane
+}
+
+// Returns the pixel of luminance y and chrominances cb and cr
+func yccToPixel(y, cb, cr int) image.Pixel {
+	t1 := cb >> 2
+	t2 := cr + (cr >> 1)
+	t3 := y + 128 - t1
+	return image.Pixel{
+		R: clampUint8(y + 128 + t2),
+		G: clampUint8(t3 - (t2 >> 1)),
+		B: clampUint8(t3 + (cb << 1)),
+	}
+}
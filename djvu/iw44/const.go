@@ -1,8 +1,55 @@
 package iw44
 
+// Version of the codec written in the header of the first chunk
 const (
-	IWALLOCSIZE   = 4080
 	IWCODEC_MAJOR = 1
 	IWCODEC_MINOR = 2
-	DECIBEL_PRUNE = 5.0
 )
+
+// Pixel values are scaled up by 2^iwShift before the transform
+const (
+	iwShift = 6
+	iwRound = 1 << (iwShift - 1)
+)
+
+// Coefficients of a 32x32 block are grouped in 64 buckets of 16.
+// The buckets of each band are coded in turn, coarsest band first.
+var bandBuckets = [10]struct{ start, size int }{
+	{0, 1}, {1, 1}, {2, 1}, {3, 1},
+	{4, 4}, {8, 4}, {12, 4},
+	{16, 16}, {32, 16}, {48, 16},
+}
+
+// Initial quantization thresholds:
+// one per coefficient of band 0, which are given first, then one per band
+var iwQuant = [16]int{
+	0x004000, 0x008000, 0x008000, 0x010000,
+	0x010000, 0x010000, 0x020000, 0x020000,
+	0x020000, 0x040000, 0x040000, 0x040000,
+	0x080000, 0x040000, 0x040000, 0x080000,
+}
+
+// States of coefficients and buckets while coding a slice
+const (
+	stateZero   = 1 // Coefficient which is always null at this threshold
+	stateActive = 2 // Coefficient already known to be significant
+	stateNew    = 4 // Coefficient becoming significant in this slice
+	stateUnk    = 8 // Coefficient whose significance is coded in this slice
+)
+
+// zigzagLoc maps the position of a coefficient in its buckets
+// to its position in a 32x32 block.
+// Bits of the position alternate between columns and rows,
+// so that the 16 coefficients of a bucket are spread over its band.
+var zigzagLoc [1024]int
+
+func init() {
+	for ii := range zigzagLoc {
+		var x, y int
+		for bit := 0; bit < 5; bit++ {
+			x |= (ii >> (2 * bit) & 1) << (4 - bit)
+			y |= (ii >> (2*bit + 1) & 1) << (4 - bit)
+		}
+		zigzagLoc[ii] = y*32 + x
+	}
+}
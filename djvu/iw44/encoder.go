@@ -0,0 +1,283 @@
+package iw44
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/janreggie/go-djvulibre/djvu/iff"
+	"github.com/janreggie/go-djvulibre/djvu/image"
+	"github.com/janreggie/go-djvulibre/djvu/zp"
+	"github.com/pkg/errors"
+)
+
+// Encoder encodes a gray-level or color image into IW44 chunks.
+// Successive calls to EncodeChunk encode successive chunks,
+// each one refining the image coded by the previous ones.
+type Encoder struct {
+	width, height int
+	color         bool
+	crcbHalf      bool
+	crcbDelay     int
+
+	serial int // Number of chunks encoded
+	cslice int // Number of slices encoded
+	size   int // Number of bytes encoded
+	done   bool
+
+	ycodec, cbcodec, crcodec *codec
+}
+
+// NewBitmapEncoder prepares the encoding of a gray-level image.
+//
+// The optional `mask` has the size of the image.
+// Its black pixels are hidden by some foreground when the image is shown,
+// so their values are replaced by values which are cheaper to encode.
+func NewBitmapEncoder(bm *image.Bitmap, mask *image.Bitmap) (*Encoder, error) {
+	w, h := int(bm.Cols()), int(bm.Rows())
+	if err := checkSize(w, h, mask); err != nil {
+		return nil, err
+	}
+	grays := bm.GetGrays() - 1
+	plane := make([]int8, w*h)
+	for rr := 0; rr < h; rr++ {
+		for cc, v := range bm.GetLine(rr) {
+			pix := int(v) * 255 / grays
+			if pix > 255 {
+				pix = 255
+			}
+			plane[rr*w+cc] = int8(pix - 128)
+		}
+	}
+	e := &Encoder{width: w, height: h, crcbDelay: -1}
+	e.ycodec = newEncodeCodec(plane, w, h, mask)
+	return e, nil
+}
+
+// NewPixmapEncoder prepares the encoding of a color image.
+// `mode` tells how the chrominance is encoded;
+// with CRCB_NONE, the image is encoded in shades of gray.
+//
+// The optional `mask` has the size of the image.
+// Its black pixels are hidden by some foreground when the image is shown,
+// so their values are replaced by values which are cheaper to encode.
+func NewPixmapEncoder(pm *image.Pixmap, mask *image.Bitmap, mode CRCBMode) (*Encoder, error) {
+	w, h := pm.Cols(), pm.Rows()
+	if err := checkSize(w, h, mask); err != nil {
+		return nil, err
+	}
+	e := &Encoder{width: w, height: h}
+
+	y := yccPlane(pm, 0)
+	switch mode {
+	case CRCB_NONE:
+		// Gray-level images store the darkness
+		for ii := range y {
+			y[ii] = -1 - y[ii]
+		}
+		e.crcbDelay = -1
+	case CRCB_HALF, CRCB_NORMAL, CRCB_FULL:
+		e.color = true
+		e.crcbHalf = mode == CRCB_HALF
+		e.crcbDelay = 10
+		if mode == CRCB_FULL {
+			e.crcbDelay = 0
+		}
+	default:
+		return nil, errors.Errorf("unknown chrominance mode %d", mode)
+	}
+
+	e.ycodec = newEncodeCodec(y, w, h, mask)
+	if e.color {
+		e.cbcodec = newEncodeCodec(yccPlane(pm, 2), w, h, mask)
+		e.crcodec = newEncodeCodec(yccPlane(pm, 1), w, h, mask)
+		if e.crcbHalf {
+			e.cbcodec.emap.slashres(2)
+			e.crcodec.emap.slashres(2)
+		}
+	}
+	return e, nil
+}
+
+func checkSize(w, h int, mask *image.Bitmap) error {
+	if w <= 0 || h <= 0 || w > 0xffff || h > 0xffff {
+		return errors.Errorf("cannot encode an image of %dx%d", w, h)
+	}
+	if mask != nil && (int(mask.Cols()) != w || int(mask.Rows()) != h) {
+		return errors.Errorf("mask of %dx%d does not match image of %dx%d", mask.Cols(), mask.Rows(), w, h)
+	}
+	return nil
+}
+
+// Returns a codec encoding the plane, whose masked pixels are replaced first
+func newEncodeCodec(plane []int8, w, h int, mask *image.Bitmap) *codec {
+	if mask != nil {
+		inpaint(plane, w, h, mask)
+	}
+	c := newCodec(newMap(w, h))
+	c.emap = newEncodeMap(plane, w, h)
+	return c
+}
+
+// inpaint replaces the masked pixels of the plane
+// by the average of the visible pixels around them,
+// taken over larger and larger squares until one holds visible pixels.
+func inpaint(plane []int8, w, h int, mask *image.Bitmap) {
+	type level struct {
+		w, h       int
+		sum, count []int
+	}
+
+	// Sums and counts of the visible pixels over squares of 1, 2, 4... pixels
+	lw, lh := w, h
+	base := level{w: lw, h: lh, sum: make([]int, lw*lh), count: make([]int, lw*lh)}
+	for rr := 0; rr < h; rr++ {
+		for cc, m := range mask.GetLine(rr) {
+			if m == 0 {
+				base.sum[rr*w+cc] = int(plane[rr*w+cc])
+				base.count[rr*w+cc] = 1
+			}
+		}
+	}
+	levels := []level{base}
+	for lw > 1 || lh > 1 {
+		prev := levels[len(levels)-1]
+		lw, lh = (lw+1)/2, (lh+1)/2
+		next := level{w: lw, h: lh, sum: make([]int, lw*lh), count: make([]int, lw*lh)}
+		for rr := 0; rr < prev.h; rr++ {
+			for cc := 0; cc < prev.w; cc++ {
+				ii := (rr/2)*lw + cc/2
+				next.sum[ii] += prev.sum[rr*prev.w+cc]
+				next.count[ii] += prev.count[rr*prev.w+cc]
+			}
+		}
+		levels = append(levels, next)
+	}
+
+	for rr := 0; rr < h; rr++ {
+		for cc, m := range mask.GetLine(rr) {
+			if m == 0 {
+				continue
+			}
+			var v int
+			for ll, lev := range levels[1:] {
+				ii := (rr>>(ll+1))*lev.w + cc>>(ll+1)
+				if n := lev.count[ii]; n > 0 {
+					v = lev.sum[ii] / n
+					break
+				}
+			}
+			plane[rr*w+cc] = int8(v)
+		}
+	}
+}
+
+// SetCrcbDelay sets the number of slices of luminance
+// which are encoded before the chrominance.
+// It must be called before the first chunk is encoded.
+func (e *Encoder) SetCrcbDelay(delay int) error {
+	if e.serial > 0 {
+		return errors.New("cannot change the chrominance delay once encoding started")
+	}
+	if delay < 0 || delay > 0x7f {
+		return errors.Errorf("bad chrominance delay %d", delay)
+	}
+	if e.color {
+		e.crcbDelay = delay
+	}
+	return nil
+}
+
+// EncodeChunk encodes the data of the next chunk into w,
+// without any IFF header, until meeting a target of params.
+// It returns whether data remain to be encoded in further chunks.
+func (e *Encoder) EncodeChunk(w io.Writer, params EncoderParams) (bool, error) {
+	if e.done {
+		return false, errors.New("IW44 image is already fully encoded")
+	}
+
+	var header []byte
+	header = append(header, byte(e.serial), 0)
+	if e.serial == 0 {
+		major := byte(IWCODEC_MAJOR)
+		if !e.color {
+			major |= 0x80
+		}
+		delay := byte(0x80)
+		if e.crcbHalf {
+			delay = 0
+		}
+		if e.crcbDelay > 0 {
+			delay |= byte(e.crcbDelay)
+		}
+		header = append(header, major, IWCODEC_MINOR,
+			byte(e.width>>8), byte(e.width), byte(e.height>>8), byte(e.height), delay)
+	}
+
+	var buf bytes.Buffer
+	ze := zp.NewEncoder(&buf)
+	nslices, more := 0, true
+	for more && nslices < 0xff {
+		if params.Bytes > 0 && e.size+len(header)+ze.Len()+20 >= params.Bytes {
+			break
+		}
+		if params.Slices > 0 && e.cslice+nslices >= params.Slices {
+			break
+		}
+		more = e.ycodec.encodeSlice(ze)
+		if e.color && e.crcbDelay <= e.cslice+nslices {
+			more = e.cbcodec.encodeSlice(ze) || more
+			more = e.crcodec.encodeSlice(ze) || more
+		}
+		nslices++
+	}
+	if err := ze.Close(); err != nil {
+		return false, errors.Wrap(err, "could not encode IW44 chunk")
+	}
+	header[1] = byte(nslices)
+
+	if _, err := w.Write(header); err != nil {
+		return false, errors.Wrap(err, "could not write IW44 chunk")
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return false, errors.Wrap(err, "could not write IW44 chunk")
+	}
+	e.serial++
+	e.cslice += nslices
+	e.size += len(header) + buf.Len()
+	e.done = !more
+	return more, nil
+}
+
+// EncodeChunks encodes one chunk with identifier `id`
+// (such as `BM44`, `PM44`, `BG44` or `TH44`) per element of params.
+// Encoding stops early once all the data are encoded.
+func (e *Encoder) EncodeChunks(id string, params ...EncoderParams) ([]*iff.Chunk, error) {
+	var retval []*iff.Chunk
+	for _, p := range params {
+		var buf bytes.Buffer
+		more, err := e.EncodeChunk(&buf, p)
+		if err != nil {
+			return nil, err
+		}
+		retval = append(retval, &iff.Chunk{ID: id, Data: buf.Bytes()})
+		if !more {
+			break
+		}
+	}
+	return retval, nil
+}
+
+// EncodeIFF encodes the image into a `FORM:BM44` chunk for gray-level images,
+// or a `FORM:PM44` chunk for color images,
+// holding one chunk per element of params.
+func (e *Encoder) EncodeIFF(params ...EncoderParams) (*iff.Chunk, error) {
+	id := "BM44"
+	if e.color {
+		id = "PM44"
+	}
+	chunks, err := e.EncodeChunks(id, params...)
+	if err != nil {
+		return nil, err
+	}
+	return &iff.Chunk{ID: "FORM:" + id, Children: chunks}, nil
+}
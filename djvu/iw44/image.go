@@ -1,168 +1,193 @@
 package iw44
 
 import (
+	"bytes"
 	"io"
 
 	"github.com/janreggie/go-djvulibre/djvu/iff"
 	"github.com/janreggie/go-djvulibre/djvu/image"
+	"github.com/janreggie/go-djvulibre/djvu/zp"
+	"github.com/pkg/errors"
 )
 
-// Image represents Image-encoded gray-level and color images.
-// This struct acts as a base for images
-// represented as a collection of Image wavelet coefficients.
-// The coefficients are stored in a memory efficient data structure.
+// Image is a gray-level or color IW44 image, decoded chunk after chunk.
+// Each chunk refines the image decoded so far,
+// so that the image can be shown before all of its chunks are decoded.
 //
-// Method GetBitmap renders an arbitrary segment of the image into a Bitmap.
-// Methods DecodeIFF and EncodeIFF read and write DjVu Image files.
-//
-// Images should not be copied once created.
-type Image interface {
-	//////////////
-	//  Access  //
-	//////////////
-
-	// Returns the width of the image
-	GetWidth() uint16
-
-	// Returns the height of the image
-	GetHeight() uint16
-
-	// Reconstructs the complete image as a pixmap.
-	GetBitmap() *image.Bitmap
-
-	// Reconstructs a segment of the image at a given scale.
-	// The subsampling ratio `subsample` must be a power of two
-	// between `1` and `32`.
-	// Argument `rect` specifies
-	// which segment of the subsampled image should be reconstructed.
-	// The reconstructed image is returned as a Bitmap
-	// whose size is equal to the size of the rectangle.
-	GetBitmapSample(subsample uint8, rect *image.Rect) *image.Bitmap
-
-	// Reconstructs the complete image as a pixmap.
-	GetPixmap() *image.Pixmap
-
-	// Reconstructs a segment of the image at a given scale.
-	// The subsampling ratio `subsample` must be a power of two
-	// between `1` and `32`.
-	// Argument `rect` specifies
-	// which segment of the subsampled image should be reconstructed.
-	// The reconstructed image is returned as a Pixmap
-	// whose size is equal to the size of the rectangle.
-	GetPixmapSample(subsample uint8, rect *image.Rect) *image.Pixmap
-
-	// Returns the amount of memory used by the wavelet coefficients.
-	// This amount of memory is expressed in bytes.
-	GetMemoryUsage() uintptr
-
-	// Returns the filling ratio of the internal data structure.
-	// Wavelet coefficients are stored in a sparse array.
-	// This function tells what percentage of bins
-	// have been effectively allocated.
-	GetPercentMemory() uint64
-
-	/////////////////////////////
-	//  Encoding and Decoding  //
-	/////////////////////////////
-
-	// Encodes one data chunk into a ByteStream.
-	// Parameter controls how much data is generated.
-	// The chunk data is written with no IFF header.
-	// Successive calls to EncodeChunk encode successive chunks.
-	//
-	// You must call CloseCodec after encoding the last chunk of a file.
-	EncodeChunk(w io.Writer) int
-
-	// Writes a gray level image into DjVu IW44 file.
-	// This function creates a composite chunk
-	// (identifier `FORM:BM44` or `FORM:PM44`)
-	// composed of `chunks` chunks
-	// (identifier `BM44` or `PM44`).
-	// Data for each chunk is generated with EncodeChunk
-	// using the corresponding parameters.
-	EncodeIFF(iff *iff.IFF, chunks uint16, params *EncoderParams)
-
-	// Decodes one data chunk from a ByteStream.
-	// Successive calls to DecodeChunk decode successive chunks.
-	//
-	// You must call CloseCodec after decoding the last chunk of a file.
-	DecodeChunk(r io.Reader) int
-
-	// This function enters a composite chunk
-	// (identifier `FORM:BM44`, or `FORM:PM44`),
-	// and decodes a maximum of `maxChunks` data chunks
-	// (identifier `BM44#`).
-	// Data for each chunk is processed using the function DecodeChunk.
-	DecodeIff(iff *iff.IFF, maxChunks uint16)
-
-	/////////////////////
-	//  Miscellaneous  //
-	/////////////////////
-
-	// Resets the encoder/decoder state.
-	// The first call to DecodeChunk or EncodeChunk
-	// initializes the coder for encoding/decoding.
-	// This method must be called after processing the last chunk
-	// in order to reset the coder and release the associated memory.
-	CloseCodec()
-
-	// Returns the chunk serial number.
-	// This function returns the serial number of the last chunk
-	// encoded with EncodeChunk or decoded with DecodeChunk.
-	// The first chunk always has serial number `1`.
-	// Successive chunks have increasing serial numbers.
-	// Value `0` is returned
-	// if no chunks have been encoded/decoded since.
-	GetSerial() uint16
-
-	// Set the chrominance delay parameter.
-	// This function can be called before encoding the first color IW44 data chunk.
-	// Parameter `delay` is an encoding delay
-	// which reduces the bitrate associated with the chrominance information.
-	// The default chrominance encoding delay is 10.
-	//
-	// This returns a value.
-	// TODO: Look at implementations and determine what it returns.
-	SetCrcbDelay(delay uint16) uint16
-
-	// Sets the `dbfrac` parameter.
-	// This function can be called before encoding the first IW44 data chunk.
-	// Parameter `frac` modifies the decibel estimation algorithm
-	// in such a way that the decibel target
-	// only pertains to the average error of the fraction `frac`
-	// of the most misrepresented 32x32 pixel blocks.
-	// Setting arguments `frac` to `1.0` restores the normal behavior.
-	SetDbFrac(frac float64)
+// The data of the chunks are found in `BM44` and `PM44` chunks
+// of `FORM:BM44` and `FORM:PM44` files,
+// in the `BG44` and `FG44` chunks of DjVu pages,
+// and in the `TH44` chunks of thumbnails.
+type Image struct {
+	width, height int
+	color         bool
+	crcbHalf      bool
+	crcbDelay     int
+
+	serial int // Number of chunks decoded
+	cslice int // Number of slices decoded
+
+	ymap, cbmap, crmap       *Map
+	ycodec, cbcodec, crcodec *codec
 }
 
-func NewDecode(imageType ImageType) Image {
-	panic("unimplemented")
+// NewImage returns an Image to which no chunk has been decoded yet.
+func NewImage() *Image {
+	return &Image{}
 }
 
-func NewEncode(ImageType ImageType) Image {
-	panic("unimplemented")
+// Decode decodes the IW44 chunks of a `FORM:BM44` or `FORM:PM44` chunk.
+func Decode(form *iff.Chunk) (*Image, error) {
+	if form.ID != "FORM:BM44" && form.ID != "FORM:PM44" {
+		return nil, errors.Errorf("chunk %s is not an IW44 image", form.ID)
+	}
+	img := NewImage()
+	for _, chunk := range form.Children {
+		if chunk.ID != "BM44" && chunk.ID != "PM44" {
+			continue
+		}
+		if err := img.DecodeChunk(bytes.NewReader(chunk.Data)); err != nil {
+			return nil, err
+		}
+	}
+	if img.serial == 0 {
+		return nil, errors.Errorf("%s holds no image data", form.ID)
+	}
+	return img, nil
 }
 
-func NewEncodeWithBitmap(bitmap *image.Bitmap, mask *image.Bitmap) Image {
-	panic("unimplemented")
+// DecodeChunk decodes the data of the next chunk of the image.
+func (img *Image) DecodeChunk(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return errors.Wrap(err, "could not read IW44 chunk")
+	}
+	if len(data) < 2 {
+		return errors.New("IW44 chunk is too short")
+	}
+	serial, slices := int(data[0]), int(data[1])
+	if serial != img.serial {
+		return errors.Errorf("IW44 chunk %d found where chunk %d was expected", serial, img.serial)
+	}
+	data = data[2:]
+
+	if serial == 0 {
+		if len(data) < 7 {
+			return errors.New("IW44 chunk is too short")
+		}
+		major, minor := data[0], data[1]
+		if major&0x7f != IWCODEC_MAJOR {
+			return errors.Errorf("IW44 codec version %d is not supported", major&0x7f)
+		}
+		if minor > IWCODEC_MINOR {
+			return errors.Errorf("IW44 codec version %d.%d is not supported", major&0x7f, minor)
+		}
+		img.width = int(data[2])<<8 | int(data[3])
+		img.height = int(data[4])<<8 | int(data[5])
+		if img.width == 0 || img.height == 0 {
+			return errors.New("IW44 image is empty")
+		}
+		img.color = major&0x80 == 0
+		img.crcbHalf = false
+		img.crcbDelay = 0
+		if minor >= 2 {
+			img.crcbHalf = data[6]&0x80 == 0
+			img.crcbDelay = int(data[6] & 0x7f)
+		}
+		data = data[7:]
+
+		img.ymap = newMap(img.width, img.height)
+		img.ycodec = newCodec(img.ymap)
+		if img.color {
+			img.cbmap, img.crmap = newMap(img.width, img.height), newMap(img.width, img.height)
+			img.cbcodec, img.crcodec = newCodec(img.cbmap), newCodec(img.crmap)
+		}
+	}
+
+	zd := zp.NewDecoder(bytes.NewReader(data))
+	for ii := 0; ii < slices; ii++ {
+		more := img.ycodec.decodeSlice(zd)
+		if img.color && img.crcbDelay <= img.cslice {
+			more = img.cbcodec.decodeSlice(zd) || more
+			more = img.crcodec.decodeSlice(zd) || more
+		}
+		img.cslice++
+		if !more {
+			break
+		}
+	}
+	if err := zd.Err(); err != nil {
+		return errors.Wrapf(err, "could not decode IW44 chunk %d", serial)
+	}
+	img.serial++
+	return nil
 }
 
-func NewEncodeWithPixmap(pixmap *image.Pixmap, mask *Bitmap, crcbMode CRCBMode) Image {
-	panic("unimplemented")
+// Width returns the width of the image, or 0 before the first chunk is decoded
+func (img *Image) Width() int { return img.width }
+
+// Height returns the height of the image, or 0 before the first chunk is decoded
+func (img *Image) Height() int { return img.height }
+
+// IsColor returns whether the image holds chrominance information
+func (img *Image) IsColor() bool { return img.color }
+
+// Serial returns the number of chunks decoded so far
+func (img *Image) Serial() int { return img.serial }
+
+// Slices returns the number of slices decoded so far
+func (img *Image) Slices() int { return img.cslice }
+
+// Bitmap returns the luminance of the image as a Bitmap with 256 gray levels.
+func (img *Image) Bitmap() (*image.Bitmap, error) {
+	if img.ymap == nil {
+		return nil, errors.New("no IW44 data has been decoded")
+	}
+	bm, err := image.NewBitmap(uint16(img.height), uint16(img.width), 0)
+	if err != nil || int(bm.Rows()) != img.height || int(bm.Cols()) != img.width {
+		return nil, errors.Errorf("IW44 image of %dx%d is too large for a Bitmap", img.width, img.height)
+	}
+	if err := bm.SetGrays(256); err != nil {
+		return nil, err
+	}
+	y := img.ymap.image()
+	for rr := 0; rr < img.height; rr++ {
+		line := bm.GetLine(rr)
+		for cc := range line {
+			v := int(y[rr*img.width+cc])
+			if img.color {
+				// Color images store the luminance, where bitmaps store the darkness
+				v = -1 - v
+			}
+			line[cc] = uint8(v + 128)
+		}
+	}
+	return bm, nil
 }
 
-// TODO: How can we turn these subclasses into idiomatic Go?
-type Transform struct{}
-type Block struct{}
-type Alloc struct{}
-type PrimaryHeader struct{}
-type SecondaryHeader struct{}
-type TertiaryHeader struct{}
-
-// Determines the type of image
-type ImageType bool
-
-const (
-	IMAGE_GREY  ImageType = false
-	IMAGE_COLOR ImageType = true
-)
+// Pixmap returns the image as a Pixmap.
+// Gray-level images are returned in shades of gray.
+func (img *Image) Pixmap() (*image.Pixmap, error) {
+	if img.ymap == nil {
+		return nil, errors.New("no IW44 data has been decoded")
+	}
+	pm := image.NewPixmap(img.height, img.width)
+	y := img.ymap.image()
+	var cb, cr []int8
+	if img.color {
+		cb, cr = img.cbmap.image(), img.crmap.image()
+	}
+	for rr := 0; rr < img.height; rr++ {
+		line := pm.GetLine(rr)
+		for cc := range line {
+			ii := rr*img.width + cc
+			if !img.color {
+				v := uint8(127 - int(y[ii]))
+				line[cc] = image.Pixel{B: v, G: v, R: v}
+				continue
+			}
+			line[cc] = yccToPixel(int(y[ii]), int(cb[ii]), int(cr[ii]))
+		}
+	}
+	return pm, nil
+}
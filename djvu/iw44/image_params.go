@@ -5,7 +5,7 @@ package iw44
 // This data structure gathers the quality specification parameters
 // needed for encoding each chunk of an IW44 file.
 // Chunk data is generated until meeting
-// either the slice target, the size target or the decibel target.
+// either the slice target or the size target.
 type EncoderParams struct {
 	// Slice target.
 	// Data generation for the current chunk stops
@@ -15,7 +15,7 @@ type EncoderParams struct {
 	//
 	// The default value `0` has a special meaning:
 	// data will be generated regardless of the number of slices in the file.
-	slices uint64
+	Slices int
 
 	// Size target.
 	// Data generation for the current chunk stops
@@ -26,19 +26,7 @@ type EncoderParams struct {
 	//
 	// The default value `0` has a special meaning:
 	// data will be generated regardless of the file size.
-	bytes uint64
-
-	// Decibel target.
-	// Data generation for the current chunk stops
-	// if the estimated luminance error, expressed in decibels,
-	// reaches this value.
-	//
-	// The default value `0` has a special meaning:
-	// data will be generated regardless of the estimated luminance error.
-	// In fact, setting the value to `0`
-	// shortcuts the computation of the estimated luminance error
-	// and sensibly speeds up the encoding process.
-	decibels float64
+	Bytes int
 }
 
 // CRCBMode represents the chrominance processing selector.
@@ -0,0 +1,255 @@
+package iw44
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/janreggie/go-djvulibre/djvu/image"
+	"github.com/stretchr/testify/suite"
+)
+
+type IW44TestSuite struct {
+	suite.Suite
+	Rand *rand.Rand
+}
+
+func TestIW44Suite(t *testing.T) {
+	suite.Run(t, new(IW44TestSuite))
+}
+
+func (s *IW44TestSuite) SetupTest() {
+	s.Rand = rand.New(rand.NewSource(1))
+}
+
+// Returns a smooth color image with a bit of noise
+func (s *IW44TestSuite) pixmap(w, h int) *image.Pixmap {
+	pm := image.NewPixmap(h, w)
+	for rr := 0; rr < h; rr++ {
+		line := pm.GetLine(rr)
+		for cc := range line {
+			line[cc] = image.Pixel{
+				R: uint8(rr * 200 / h),
+				G: uint8(cc * 200 / w),
+				B: uint8(100 + s.Rand.Intn(20)),
+			}
+		}
+	}
+	return pm
+}
+
+// Returns the largest difference between components of pixels of a and b
+func maxPixelDiff(a, b *image.Pixmap, skip func(rr, cc int) bool) int {
+	retval := 0
+	for rr := 0; rr < a.Rows(); rr++ {
+		la, lb := a.GetLine(rr), b.GetLine(rr)
+		for cc := range la {
+			if skip != nil && skip(rr, cc) {
+				continue
+			}
+			for _, d := range []int{
+				int(la[cc].R) - int(lb[cc].R),
+				int(la[cc].G) - int(lb[cc].G),
+				int(la[cc].B) - int(lb[cc].B),
+			} {
+				if d < 0 {
+					d = -d
+				}
+				if d > retval {
+					retval = d
+				}
+			}
+		}
+	}
+	return retval
+}
+
+func (s *IW44TestSuite) TestTransform() {
+	for _, size := range [][2]int{{1, 1}, {5, 3}, {32, 32}, {70, 45}} {
+		w, h := size[0], size[1]
+		rowsize := (w + 31) &^ 31
+		data := make([]int16, rowsize*((h+31)&^31))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				data[y*rowsize+x] = int16(s.Rand.Intn(256)-128) << iwShift
+			}
+		}
+		orig := append([]int16(nil), data...)
+		forward(data, w, h, rowsize)
+		backward(data, w, h, rowsize)
+		s.Equal(orig, data, "%dx%d", w, h)
+	}
+}
+
+func (s *IW44TestSuite) TestZigzag() {
+	seen := make(map[int]bool)
+	for _, loc := range zigzagLoc {
+		s.False(seen[loc])
+		seen[loc] = true
+	}
+	s.Len(seen, 1024)
+	s.Equal(0, zigzagLoc[0])
+}
+
+func (s *IW44TestSuite) TestColorRoundTrip() {
+	pm := s.pixmap(70, 45)
+	for _, mode := range []CRCBMode{CRCB_NORMAL, CRCB_FULL, CRCB_HALF} {
+		e, err := NewPixmapEncoder(pm, nil, mode)
+		s.Require().NoError(err)
+		form, err := e.EncodeIFF(EncoderParams{Slices: 30}, EncoderParams{})
+		s.Require().NoError(err)
+		s.Equal("FORM:PM44", form.ID)
+		s.Len(form.Children, 2)
+
+		img, err := Decode(form)
+		s.Require().NoError(err)
+		s.True(img.IsColor())
+		s.Equal(70, img.Width())
+		s.Equal(45, img.Height())
+		s.Equal(2, img.Serial())
+		decoded, err := img.Pixmap()
+		s.Require().NoError(err)
+		if mode == CRCB_HALF {
+			s.LessOrEqual(maxPixelDiff(pm, decoded, nil), 40, "mode %d", mode)
+		} else {
+			s.LessOrEqual(maxPixelDiff(pm, decoded, nil), 8, "mode %d", mode)
+		}
+	}
+}
+
+func (s *IW44TestSuite) TestProgressive() {
+	pm := s.pixmap(64, 64)
+	e, err := NewPixmapEncoder(pm, nil, CRCB_NORMAL)
+	s.Require().NoError(err)
+
+	img := NewImage()
+	var diffs []int
+	for _, params := range []EncoderParams{{Slices: 15}, {Slices: 40}, {}} {
+		var buf bytes.Buffer
+		_, err := e.EncodeChunk(&buf, params)
+		s.Require().NoError(err)
+		s.Require().NoError(img.DecodeChunk(&buf))
+		decoded, err := img.Pixmap()
+		s.Require().NoError(err)
+		diffs = append(diffs, maxPixelDiff(pm, decoded, nil))
+	}
+	s.Equal(e.cslice, img.Slices())
+	s.Greater(diffs[0], diffs[2])
+	s.LessOrEqual(diffs[2], 8)
+
+	// Everything has been encoded
+	_, err = e.EncodeChunk(&bytes.Buffer{}, EncoderParams{})
+	s.Error(err)
+}
+
+func (s *IW44TestSuite) TestBytesTarget() {
+	pm := s.pixmap(128, 128)
+	e, err := NewPixmapEncoder(pm, nil, CRCB_NORMAL)
+	s.Require().NoError(err)
+	var buf bytes.Buffer
+	more, err := e.EncodeChunk(&buf, EncoderParams{Bytes: 500})
+	s.Require().NoError(err)
+	s.True(more)
+	s.Less(buf.Len(), 600)
+}
+
+func (s *IW44TestSuite) TestGray() {
+	bm, err := image.NewBitmap(40, 50, 0)
+	s.Require().NoError(err)
+	s.Require().NoError(bm.SetGrays(256))
+	for rr := 0; rr < 40; rr++ {
+		for cc := range bm.GetLine(rr) {
+			bm.GetLine(rr)[cc] = uint8(rr*5 + cc)
+		}
+	}
+
+	e, err := NewBitmapEncoder(bm, nil)
+	s.Require().NoError(err)
+	form, err := e.EncodeIFF(EncoderParams{})
+	s.Require().NoError(err)
+	s.Equal("FORM:BM44", form.ID)
+
+	img, err := Decode(form)
+	s.Require().NoError(err)
+	s.False(img.IsColor())
+	decoded, err := img.Bitmap()
+	s.Require().NoError(err)
+	s.Equal(256, decoded.GetGrays())
+	for rr := 0; rr < 40; rr++ {
+		for cc, v := range decoded.GetLine(rr) {
+			s.InDelta(int(bm.GetLine(rr)[cc]), int(v), 3, "%d,%d", rr, cc)
+		}
+	}
+
+	// Bitmaps store the darkness, pixmaps the luminance
+	pm, err := img.Pixmap()
+	s.Require().NoError(err)
+	s.InDelta(255-int(bm.GetLine(10)[10]), int(pm.GetLine(10)[10].R), 3)
+
+	// Gray pixmaps are encoded like bitmaps
+	e, err = NewPixmapEncoder(pm, nil, CRCB_NONE)
+	s.Require().NoError(err)
+	form, err = e.EncodeIFF(EncoderParams{})
+	s.Require().NoError(err)
+	s.Equal("FORM:BM44", form.ID)
+	img, err = Decode(form)
+	s.Require().NoError(err)
+	again, err := img.Pixmap()
+	s.Require().NoError(err)
+	s.LessOrEqual(maxPixelDiff(pm, again, nil), 3)
+}
+
+func (s *IW44TestSuite) TestMask() {
+	pm := s.pixmap(64, 48)
+	mask, err := image.NewBitmap(48, 64, 0)
+	s.Require().NoError(err)
+	masked := func(rr, cc int) bool { return rr >= 10 && rr < 20 && cc >= 5 && cc < 50 }
+	for rr := 0; rr < 48; rr++ {
+		line, pix := mask.GetLine(rr), pm.GetLine(rr)
+		for cc := range line {
+			if masked(rr, cc) {
+				line[cc] = 1
+				pix[cc] = image.BlackPixel
+			}
+		}
+	}
+
+	e, err := NewPixmapEncoder(pm, mask, CRCB_NORMAL)
+	s.Require().NoError(err)
+	form, err := e.EncodeIFF(EncoderParams{})
+	s.Require().NoError(err)
+	img, err := Decode(form)
+	s.Require().NoError(err)
+	decoded, err := img.Pixmap()
+	s.Require().NoError(err)
+	s.LessOrEqual(maxPixelDiff(pm, decoded, masked), 8)
+
+	// Masked pixels are filled from their surroundings
+	s.Greater(int(decoded.GetLine(15)[30].G), 60)
+
+	_, err = NewPixmapEncoder(pm, mask, CRCBMode(9))
+	s.Error(err)
+	small, err := image.NewBitmap(4, 4, 0)
+	s.Require().NoError(err)
+	_, err = NewPixmapEncoder(pm, small, CRCB_NORMAL)
+	s.Error(err)
+}
+
+func (s *IW44TestSuite) TestDecodeErrors() {
+	pm := s.pixmap(32, 32)
+	e, err := NewPixmapEncoder(pm, nil, CRCB_NORMAL)
+	s.Require().NoError(err)
+	chunks, err := e.EncodeChunks("PM44", EncoderParams{Slices: 10}, EncoderParams{})
+	s.Require().NoError(err)
+	s.Len(chunks, 2)
+
+	img := NewImage()
+	s.Error(img.DecodeChunk(bytes.NewReader(chunks[1].Data)))
+	s.Error(img.DecodeChunk(bytes.NewReader(nil)))
+	_, err = img.Pixmap()
+	s.Error(err)
+
+	bad := append([]byte(nil), chunks[0].Data...)
+	bad[2] = 7 // Major version
+	s.Error(img.DecodeChunk(bytes.NewReader(bad)))
+}
@@ -1,26 +1,122 @@
 package iw44
 
-import "github.com/janreggie/go-djvulibre/djvu/image"
-
-// Map represents all blocks of an image
+// Map holds the wavelet coefficients of one color component of an image,
+// as 32x32 blocks in raster order starting from the bottom left.
 type Map struct {
-	iw, ih uint16
-	bw, bh uint16
-	nb     uint16
-	blocks *image.Block
-	top    uint16
-}
-
-func NewMap(w, h uint16) *Map {
-	bw := (w + 0x20 - 1) & (0 ^ 0x1f)
-	bh := (h + 0x20 - 1) & (0 ^ 0x1f)
-	return &Map{
-		iw:     w,
-		ih:     h,
-		bw:     bw,
-		bh:     bh,
-		nb:     (bw * bh) / (32 * 32),
-		blocks: &image.Block{}, // TODO: Construct
-		top:    IWALLOCSIZE,
+	iw, ih int // Size of the image
+	bw, bh int // Size rounded up to whole blocks
+	blocks []block
+}
+
+// block holds the 1024 coefficients of a block in 64 buckets of 16.
+// Buckets are only allocated once one of their coefficients is not null.
+type block [64]*[16]int16
+
+func newMap(w, h int) *Map {
+	m := &Map{iw: w, ih: h, bw: (w + 31) &^ 31, bh: (h + 31) &^ 31}
+	m.blocks = make([]block, (m.bw/32)*(m.bh/32))
+	return m
+}
+
+// Returns bucket n, allocating it if needed
+func (b *block) alloc(n int) *[16]int16 {
+	if b[n] == nil {
+		b[n] = new([16]int16)
+	}
+	return b[n]
+}
+
+// Copies the coefficients to coeff, in the layout of the transform
+func (b *block) write(coeff *[1024]int16) {
+	for n1, bucket := range b {
+		for n2 := 0; n2 < 16; n2++ {
+			var v int16
+			if bucket != nil {
+				v = bucket[n2]
+			}
+			coeff[zigzagLoc[n1<<4|n2]] = v
+		}
+	}
+}
+
+// Copies the coefficients from coeff, in the layout of the transform
+func (b *block) read(coeff *[1024]int16) {
+	for n1 := range b {
+		for n2 := 0; n2 < 16; n2++ {
+			if v := coeff[zigzagLoc[n1<<4|n2]]; v != 0 {
+				b.alloc(n1)[n2] = v
+			} else if b[n1] != nil {
+				b[n1][n2] = 0
+			}
+		}
+	}
+}
+
+// Returns the transform of a w by h plane of signed pixels, bottom row first
+func newEncodeMap(plane []int8, w, h int) *Map {
+	m := newMap(w, h)
+	data := make([]int16, m.bw*m.bh)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			data[y*m.bw+x] = int16(plane[y*w+x]) << iwShift
+		}
+	}
+	forward(data, w, h, m.bw)
+
+	var coeff [1024]int16
+	for bno := range m.blocks {
+		x0, y0 := (bno%(m.bw/32))*32, (bno/(m.bw/32))*32
+		for y := 0; y < 32; y++ {
+			copy(coeff[y*32:(y+1)*32], data[(y0+y)*m.bw+x0:])
+		}
+		m.blocks[bno].read(&coeff)
+	}
+	return m
+}
+
+// Reconstructs the plane of signed pixels, bottom row first
+func (m *Map) image() []int8 {
+	data := make([]int16, m.bw*m.bh)
+	var coeff [1024]int16
+	for bno := range m.blocks {
+		m.blocks[bno].write(&coeff)
+		x0, y0 := (bno%(m.bw/32))*32, (bno/(m.bw/32))*32
+		for y := 0; y < 32; y++ {
+			copy(data[(y0+y)*m.bw+x0:], coeff[y*32:(y+1)*32])
+		}
+	}
+	backward(data, m.iw, m.ih, m.bw)
+
+	plane := make([]int8, m.iw*m.ih)
+	for y := 0; y < m.ih; y++ {
+		for x := 0; x < m.iw; x++ {
+			v := (int(data[y*m.bw+x]) + iwRound) >> iwShift
+			if v < -128 {
+				v = -128
+			} else if v > 127 {
+				v = 127
+			}
+			plane[y*m.iw+x] = int8(v)
+		}
+	}
+	return plane
+}
+
+// Drops the coefficients finer than the resolution reduced by res,
+// which is a power of two
+func (m *Map) slashres(res int) {
+	minbucket := 1
+	switch {
+	case res < 2:
+		return
+	case res < 4:
+		minbucket = 16
+	case res < 8:
+		minbucket = 4
+	}
+	for bno := range m.blocks {
+		for n := minbucket; n < 64; n++ {
+			m.blocks[bno][n] = nil
+		}
 	}
 }
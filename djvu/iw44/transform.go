@@ -0,0 +1,96 @@
+package iw44
+
+// The wavelet transform is made of interpolating lifting steps
+// on the rows then the columns of the image, at scales 1 to 16.
+// At each scale, samples at odd multiples of the scale become details
+// predicted from their four even neighbours,
+// and even samples are updated from the four details around them.
+
+// forward applies the wavelet transform on the w by h image in p,
+// whose rows are rowsize apart.
+func forward(p []int16, w, h, rowsize int) {
+	buf := make([]int32, maxInt(w, h))
+	for scale := 1; scale < 32; scale <<= 1 {
+		for y := 0; y < h; y += scale {
+			liftLine(p[y*rowsize:], buf, (w-1)/scale+1, scale, false)
+		}
+		for x := 0; x < w; x += scale {
+			liftLine(p[x:], buf, (h-1)/scale+1, scale*rowsize, false)
+		}
+	}
+}
+
+// backward reverts forward.
+func backward(p []int16, w, h, rowsize int) {
+	buf := make([]int32, maxInt(w, h))
+	for scale := 16; scale >= 1; scale >>= 1 {
+		for x := 0; x < w; x += scale {
+			liftLine(p[x:], buf, (h-1)/scale+1, scale*rowsize, true)
+		}
+		for y := 0; y < h; y += scale {
+			liftLine(p[y*rowsize:], buf, (w-1)/scale+1, scale, true)
+		}
+	}
+}
+
+// Transforms the n samples of p which are step apart, or reverts the transform
+func liftLine(p []int16, buf []int32, n, step int, inverse bool) {
+	if n < 2 {
+		return
+	}
+	x := buf[:n]
+	for k := range x {
+		x[k] = int32(p[k*step])
+	}
+	if inverse {
+		for k := 0; k < n; k += 2 {
+			x[k] -= update(x, k)
+		}
+		for k := 1; k < n; k += 2 {
+			x[k] += predict(x, k)
+		}
+	} else {
+		for k := 1; k < n; k += 2 {
+			x[k] -= predict(x, k)
+		}
+		for k := 0; k < n; k += 2 {
+			x[k] += update(x, k)
+		}
+	}
+	for k, v := range x {
+		p[k*step] = int16(v)
+	}
+}
+
+// Predicts the odd sample k from the even samples around it,
+// linearly near the ends
+func predict(x []int32, k int) int32 {
+	n := len(x)
+	if k >= 3 && k+3 < n {
+		return (9*(x[k-1]+x[k+1]) - (x[k-3] + x[k+3]) + 8) >> 4
+	}
+	next := x[k-1]
+	if k+1 < n {
+		next = x[k+1]
+	}
+	return (x[k-1] + next + 1) >> 1
+}
+
+// Returns the update of the even sample k from the details around it,
+// missing details counting as 0
+func update(x []int32, k int) int32 {
+	at := func(ii int) int32 {
+		if ii < 0 || ii >= len(x) {
+			return 0
+		}
+		return x[ii]
+	}
+	return (9*(at(k-1)+at(k+1)) - (at(k-3) + at(k+3)) + 16) >> 5
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,620 @@
+package jb2
+
+import (
+	"github.com/janreggie/go-djvulibre/djvu/image"
+	"github.com/janreggie/go-djvulibre/djvu/zp"
+	"github.com/pkg/errors"
+)
+
+// codec holds the state shared by the encoder and the decoder.
+// Every coding method either encodes the values it is given
+// or decodes and returns them, depending on `encoding`.
+type codec struct {
+	encoding bool
+	zd       *zp.Decoder
+	ze       *zp.Encoder
+
+	// Dictionary offered to streams which require one, when decoding
+	inherited *Dict
+
+	gotStart      bool
+	refinement    bool // Whether the stream refines a lossy image
+	width, height int
+
+	// Number coder: binary trees of cells, cell 0 being reserved
+	bitcells  []zp.Context
+	leftcell  []int
+	rightcell []int
+	nums      numContexts
+
+	distRefinementFlag zp.Context
+	offsetTypeDist     zp.Context
+	bitdist            [1024]zp.Context
+	cbitdist           [2048]zp.Context
+
+	// Position of the last blits
+	lastLeft, lastRight, lastBottom int
+	lastRowLeft, lastRowBottom      int
+	shortList                       [3]int
+	shortListPos                    int
+
+	// Library of the shapes which can be matched
+	lib2shape []int
+	shape2lib map[int]int
+	libinfo   []libRect
+}
+
+// Roots of the trees of the number coder, 0 when not allocated
+type numContexts struct {
+	recordType, matchIndex               int
+	commentByte, commentLength           int
+	absLocX, absLocY, absSizeX, absSizeY int
+	imageSize, inheritedShapeCount       int
+	relLocXCurrent, relLocXLast          int
+	relLocYCurrent, relLocYLast          int
+	relSizeX, relSizeY                   int
+}
+
+func newCodec(encoding bool) *codec {
+	c := &codec{encoding: encoding, shape2lib: make(map[int]int)}
+	c.resetNumcoder()
+	return c
+}
+
+func (c *codec) resetNumcoder() {
+	c.bitcells = []zp.Context{0}
+	c.leftcell = []int{0}
+	c.rightcell = []int{0}
+	c.nums = numContexts{}
+}
+
+func (c *codec) newCell() int {
+	c.bitcells = append(c.bitcells, 0)
+	c.leftcell = append(c.leftcell, 0)
+	c.rightcell = append(c.rightcell, 0)
+	return len(c.bitcells) - 1
+}
+
+func (c *codec) codeBit(bit int, ctx *zp.Context) int {
+	if c.encoding {
+		c.ze.Encode(bit, ctx)
+		return bit
+	}
+	return c.zd.Decode(ctx)
+}
+
+// codeNum codes v between low and high, using the tree rooted at *root.
+// The sign is coded first, then the number of bits of the magnitude,
+// then the magnitude by bisection.
+func (c *codec) codeNum(low, high int, root *int, v int) int {
+	if *root == 0 {
+		*root = c.newCell()
+	}
+	node := *root
+	negative := false
+	cutoff := 0
+	for phase, rng := 1, -1; rng != 1; {
+		var decision bool
+		if c.encoding {
+			decision = v >= cutoff
+			if low < cutoff && high >= cutoff {
+				c.ze.Encode(b2i(decision), &c.bitcells[node])
+			}
+		} else {
+			decision = low >= cutoff || (high >= cutoff && c.zd.Decode(&c.bitcells[node]) != 0)
+		}
+		children := c.leftcell
+		if decision {
+			children = c.rightcell
+		}
+
+		switch phase {
+		case 1:
+			negative = !decision
+			if negative {
+				if c.encoding {
+					v = -v - 1
+				}
+				low, high = -high-1, -low-1
+			}
+			phase, cutoff = 2, 1
+		case 2:
+			if !decision {
+				phase = 3
+				rng = (cutoff + 1) / 2
+				if rng == 1 {
+					cutoff = 0
+				} else {
+					cutoff -= rng / 2
+				}
+			} else {
+				cutoff += cutoff + 1
+			}
+		case 3:
+			rng /= 2
+			if rng != 1 {
+				if !decision {
+					cutoff -= rng / 2
+				} else {
+					cutoff += rng / 2
+				}
+			} else if !decision {
+				cutoff--
+			}
+		}
+
+		if rng != 1 {
+			child := children[node]
+			if child == 0 {
+				child = c.newCell()
+				if decision {
+					c.rightcell[node] = child
+				} else {
+					c.leftcell[node] = child
+				}
+			}
+			node = child
+		}
+	}
+	if negative {
+		return -cutoff - 1
+	}
+	return cutoff
+}
+
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// bits is a bilevel bitmap whose pixels outside of the image read as white
+type bits struct {
+	w, h int
+	data []byte
+}
+
+func newBits(w, h int) *bits {
+	return &bits{w: w, h: h, data: make([]byte, w*h)}
+}
+
+func toBits(bm *image.Bitmap) *bits {
+	b := newBits(int(bm.Cols()), int(bm.Rows()))
+	for y := 0; y < b.h; y++ {
+		for x, v := range bm.GetLine(y) {
+			if v != 0 {
+				b.data[y*b.w+x] = 1
+			}
+		}
+	}
+	return b
+}
+
+func (b *bits) bitmap() *image.Bitmap {
+	bm, err := image.NewBitmap(uint16(b.h), uint16(b.w), 0)
+	if err != nil {
+		panic(err) // Sizes have been checked when decoded
+	}
+	for y := 0; y < b.h; y++ {
+		copy(bm.GetLine(y), b.data[y*b.w:(y+1)*b.w])
+	}
+	return bm
+}
+
+func (b *bits) at(x, y int) int {
+	if x < 0 || y < 0 || x >= b.w || y >= b.h {
+		return 0
+	}
+	return int(b.data[y*b.w+x])
+}
+
+// libRect is the bounding box of the black pixels of a shape of the library.
+// It is empty, with right and top at -1, for blank shapes.
+type libRect struct {
+	left, right, bottom, top int
+}
+
+func boundingBox(b *bits) libRect {
+	var l libRect
+	for l.right = b.w - 1; l.right >= 0; l.right-- {
+		if b.columnHasBlack(l.right) {
+			break
+		}
+	}
+	for l.top = b.h - 1; l.top >= 0; l.top-- {
+		if b.rowHasBlack(l.top) {
+			break
+		}
+	}
+	for l.left = 0; l.left <= l.right; l.left++ {
+		if b.columnHasBlack(l.left) {
+			break
+		}
+	}
+	for l.bottom = 0; l.bottom <= l.top; l.bottom++ {
+		if b.rowHasBlack(l.bottom) {
+			break
+		}
+	}
+	return l
+}
+
+func (b *bits) columnHasBlack(x int) bool {
+	for y := 0; y < b.h; y++ {
+		if b.data[y*b.w+x] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *bits) rowHasBlack(y int) bool {
+	for _, v := range b.data[y*b.w : (y+1)*b.w] {
+		if v != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *codec) initLibrary(d *Dict) {
+	n := d.InheritedShapeCount()
+	c.lib2shape = c.lib2shape[:0]
+	c.libinfo = c.libinfo[:0]
+	for shapeno := 0; shapeno < n; shapeno++ {
+		c.addLibrary(shapeno, d.Shape(shapeno).Bits)
+	}
+}
+
+func (c *codec) addLibrary(shapeno int, bm *image.Bitmap) {
+	c.shape2lib[shapeno] = len(c.lib2shape)
+	c.lib2shape = append(c.lib2shape, shapeno)
+	c.libinfo = append(c.libinfo, boundingBox(toBits(bm)))
+}
+
+// Codes the pixels of b, top row first,
+// each from the ten pixels before it
+func (c *codec) codeDirect(b *bits) {
+	for y := b.h - 1; y >= 0; y-- {
+		ctx := b.at(-1, y+2)<<9 | b.at(0, y+2)<<8 | b.at(1, y+2)<<7 |
+			b.at(-2, y+1)<<6 | b.at(-1, y+1)<<5 | b.at(0, y+1)<<4 | b.at(1, y+1)<<3 | b.at(2, y+1)<<2 |
+			b.at(-2, y)<<1 | b.at(-1, y)
+		row := b.data[y*b.w : (y+1)*b.w]
+		for x := range row {
+			n := c.codeBit(int(row[x]), &c.bitdist[ctx])
+			row[x] = byte(n)
+			ctx = (ctx<<1)&0x37a | b.at(x+3, y+1)<<2 | b.at(x+2, y+2)<<7 | n
+		}
+	}
+}
+
+// Codes the pixels of b, top row first,
+// each from four pixels before it and the pixels around it in the shape it refines.
+// The shapes are aligned on the centers of their bounding boxes.
+func (c *codec) codeCross(b, ref *bits, l libRect) {
+	xd2c := (b.w/2 - b.w + 1) - ((l.right-l.left+1)/2 - l.right)
+	yd2c := (b.h/2 - b.h + 1) - ((l.top-l.bottom+1)/2 - l.top)
+	for y := b.h - 1; y >= 0; y-- {
+		cy := y + yd2c
+		ctx := b.at(-1, y+1)<<10 | b.at(0, y+1)<<9 | b.at(1, y+1)<<8 | b.at(-1, y)<<7 |
+			ref.at(xd2c, cy+1)<<6 |
+			ref.at(xd2c-1, cy)<<5 | ref.at(xd2c, cy)<<4 | ref.at(xd2c+1, cy)<<3 |
+			ref.at(xd2c-1, cy-1)<<2 | ref.at(xd2c, cy-1)<<1 | ref.at(xd2c+1, cy-1)
+		row := b.data[y*b.w : (y+1)*b.w]
+		for x := range row {
+			n := c.codeBit(int(row[x]), &c.cbitdist[ctx])
+			row[x] = byte(n)
+			x1 := x + 1
+			ctx = (ctx<<1)&0x636 | b.at(x1+1, y+1)<<8 | n<<7 |
+				ref.at(x1+xd2c, cy+1)<<6 | ref.at(x1+xd2c+1, cy)<<3 | ref.at(x1+xd2c+1, cy-1)
+		}
+	}
+}
+
+func (c *codec) fillShortList(v int) {
+	c.shortList = [3]int{v, v, v}
+	c.shortListPos = 0
+}
+
+// Adds v to the short list and returns the median of the list
+func (c *codec) updateShortList(v int) int {
+	c.shortListPos = (c.shortListPos + 1) % 3
+	s := &c.shortList
+	s[c.shortListPos] = v
+	if s[0] >= s[1] {
+		if s[0] > s[2] {
+			if s[1] >= s[2] {
+				return s[1]
+			}
+			return s[2]
+		}
+		return s[0]
+	}
+	if s[0] < s[2] {
+		if s[1] >= s[2] {
+			return s[2]
+		}
+		return s[1]
+	}
+	return s[0]
+}
+
+// Codes the size of the image, or zeros for a dictionary,
+// and initializes the location of the blits
+func (c *codec) codeImageSize(img *Image) error {
+	var w, h int
+	if img != nil {
+		w, h = img.Width, img.Height
+	}
+	w = c.codeNum(0, bigPositive, &c.nums.imageSize, w)
+	h = c.codeNum(0, bigPositive, &c.nums.imageSize, h)
+	if img == nil && (w != 0 || h != 0) {
+		return errors.New("JB2 dictionary has an image size")
+	}
+	if img != nil {
+		if w == 0 || h == 0 {
+			return errors.New("JB2 image is empty")
+		}
+		img.Width, img.Height = w, h
+	}
+	c.width, c.height = w, h
+	c.lastLeft = 1 + w
+	c.lastRowLeft = 0
+	c.lastRowBottom = h
+	c.lastRight = 0
+	c.fillShortList(c.lastRowBottom)
+	c.gotStart = true
+	return nil
+}
+
+// Codes the location of a blit of a `rows` by `columns` shape
+// relative to the previous blits
+func (c *codec) codeRelativeLocation(blit *Blit, rows, columns int) {
+	var left, right, bottom, top int
+	if c.encoding {
+		left = blit.Left + 1
+		bottom = blit.Bottom + 1
+		right = left + columns - 1
+		top = bottom + rows - 1
+	}
+	newRow := c.codeBit(b2i(left < c.lastLeft), &c.offsetTypeDist)
+	if newRow != 0 {
+		xdiff := c.codeNum(bigNegative, bigPositive, &c.nums.relLocXLast, left-c.lastRowLeft)
+		ydiff := c.codeNum(bigNegative, bigPositive, &c.nums.relLocYLast, top-c.lastRowBottom)
+		if !c.encoding {
+			left = c.lastRowLeft + xdiff
+			top = c.lastRowBottom + ydiff
+			right = left + columns - 1
+			bottom = top - rows + 1
+		}
+		c.lastLeft, c.lastRowLeft = left, left
+		c.lastRight = right
+		c.lastBottom, c.lastRowBottom = bottom, bottom
+		c.fillShortList(bottom)
+	} else {
+		xdiff := c.codeNum(bigNegative, bigPositive, &c.nums.relLocXCurrent, left-c.lastRight)
+		ydiff := c.codeNum(bigNegative, bigPositive, &c.nums.relLocYCurrent, bottom-c.lastBottom)
+		if !c.encoding {
+			left = c.lastRight + xdiff
+			bottom = c.lastBottom + ydiff
+			right = left + columns - 1
+		}
+		c.lastLeft = left
+		c.lastRight = right
+		c.lastBottom = c.updateShortList(bottom)
+	}
+	blit.Left, blit.Bottom = left-1, bottom-1
+}
+
+// Codes the location of a blit of a `rows` by `columns` shape in the image
+func (c *codec) codeAbsoluteLocation(blit *Blit, rows int) {
+	left := blit.Left + 1
+	top := blit.Bottom + rows
+	left = c.codeNum(1, c.width, &c.nums.absLocX, left)
+	top = c.codeNum(1, c.height, &c.nums.absLocY, top)
+	blit.Left, blit.Bottom = left-1, top-rows
+}
+
+// Codes the size of a shape, returning its blank bitmap when decoding
+func (c *codec) codeAbsoluteMarkSize(b *bits) (*bits, error) {
+	var w, h int
+	if c.encoding {
+		w, h = b.w, b.h
+	}
+	w = c.codeNum(0, bigPositive, &c.nums.absSizeX, w)
+	h = c.codeNum(0, bigPositive, &c.nums.absSizeY, h)
+	if c.encoding {
+		return b, nil
+	}
+	return decodedBits(w, h)
+}
+
+// Codes the size of a shape relative to the size of the shape it refines,
+// returning its blank bitmap when decoding
+func (c *codec) codeRelativeMarkSize(b *bits, cw, ch int) (*bits, error) {
+	var w, h int
+	if c.encoding {
+		w, h = b.w, b.h
+	}
+	w = cw + c.codeNum(bigNegative, bigPositive, &c.nums.relSizeX, w-cw)
+	h = ch + c.codeNum(bigNegative, bigPositive, &c.nums.relSizeY, h-ch)
+	if c.encoding {
+		return b, nil
+	}
+	return decodedBits(w, h)
+}
+
+func decodedBits(w, h int) (*bits, error) {
+	if w < 0 || h < 0 || w > 0xffff || h > 0xffff {
+		return nil, errors.Errorf("JB2 shape of %dx%d is too large", w, h)
+	}
+	return newBits(w, h), nil
+}
+
+// Codes the library number of a shape, returning the shape and its library number
+func (c *codec) codeMatchIndex(shapeno int) (int, int, error) {
+	if len(c.lib2shape) == 0 {
+		return 0, 0, errors.New("JB2 stream matches a shape with an empty library")
+	}
+	match, ok := c.shape2lib[shapeno]
+	if c.encoding && !ok {
+		return 0, 0, errors.Errorf("shape %d is matched before being coded", shapeno)
+	}
+	match = c.codeNum(0, len(c.lib2shape)-1, &c.nums.matchIndex, match)
+	if match < 0 || match >= len(c.lib2shape) {
+		return 0, 0, errors.Errorf("JB2 stream matches bad library shape %d", match)
+	}
+	return c.lib2shape[match], match, nil
+}
+
+func (c *codec) codeComment(comment string) string {
+	n := c.codeNum(0, bigPositive, &c.nums.commentLength, len(comment))
+	buf := make([]byte, n)
+	for ii := range buf {
+		var v int
+		if c.encoding {
+			v = int(comment[ii])
+		}
+		buf[ii] = byte(c.codeNum(0, 255, &c.nums.commentByte, v))
+	}
+	return string(buf)
+}
+
+// Codes the number of shapes of the required dictionary
+func (c *codec) codeInheritedShapeCount(d *Dict) error {
+	n := c.codeNum(0, bigPositive, &c.nums.inheritedShapeCount, d.InheritedShapeCount())
+	if c.encoding {
+		return nil
+	}
+	if n > 0 {
+		if c.inherited == nil {
+			return errors.Errorf("JB2 stream requires a dictionary of %d shapes", n)
+		}
+		if c.inherited.ShapeCount() != n {
+			return errors.Errorf("JB2 stream requires a dictionary of %d shapes, not %d", n, c.inherited.ShapeCount())
+		}
+		d.Inherited = c.inherited
+	}
+	return nil
+}
+
+// codeRecord codes a record of type rectype.
+// When encoding, shapeno and blit are the shape and the blit coded by the record.
+// When decoding, the type is decoded
+// and the decoded shape and blit are added to d and img.
+// img is nil for dictionaries.
+func (c *codec) codeRecord(rectype RecordType, d *Dict, img *Image, shapeno int, blit Blit) (RecordType, error) {
+	rectype = RecordType(c.codeNum(int(START_OF_DATA), int(END_OF_DATA), &c.nums.recordType, int(rectype)))
+
+	switch rectype {
+	case START_OF_DATA:
+		if c.gotStart {
+			return rectype, errors.New("JB2 stream has two START_OF_DATA records")
+		}
+	case REQUIRED_DICT_OR_RESET, PRESERVED_COMMENT:
+	default:
+		if !c.gotStart {
+			return rectype, errors.Errorf("JB2 stream has %s before START_OF_DATA", rectype)
+		}
+	}
+	switch rectype {
+	case NEW_MARK, NEW_MARK_IMAGE_ONLY, MATCHED_REFINE, MATCHED_REFINE_IMAGE_ONLY, MATCHED_COPY, NON_MARK_DATA:
+		if img == nil {
+			return rectype, errors.Errorf("JB2 dictionary has %s record", rectype)
+		}
+	}
+
+	var shape Shape
+	var b *bits
+	if c.encoding && shapeno >= 0 && shapeno < d.ShapeCount() {
+		shape = *d.Shape(shapeno)
+		b = toBits(shape.Bits)
+	}
+	var err error
+
+	switch rectype {
+	case START_OF_DATA:
+		if err := c.codeImageSize(img); err != nil {
+			return rectype, err
+		}
+		c.refinement = c.codeBit(b2i(c.refinement), &c.distRefinementFlag) != 0
+		c.initLibrary(d)
+
+	case NEW_MARK, NEW_MARK_LIBRARY_ONLY, NEW_MARK_IMAGE_ONLY, NON_MARK_DATA:
+		if b, err = c.codeAbsoluteMarkSize(b); err != nil {
+			return rectype, err
+		}
+		c.codeDirect(b)
+		shape.Parent = NO_PARENT
+		switch rectype {
+		case NEW_MARK, NEW_MARK_IMAGE_ONLY:
+			c.codeRelativeLocation(&blit, b.h, b.w)
+		case NON_MARK_DATA:
+			shape.Parent = NON_MARK
+			c.codeAbsoluteLocation(&blit, b.h)
+		}
+
+	case MATCHED_REFINE, MATCHED_REFINE_LIBRARY_ONLY, MATCHED_REFINE_IMAGE_ONLY:
+		parent, match, err := c.codeMatchIndex(shape.Parent)
+		if err != nil {
+			return rectype, err
+		}
+		shape.Parent = parent
+		l := c.libinfo[match]
+		if b, err = c.codeRelativeMarkSize(b, l.right-l.left+1, l.top-l.bottom+1); err != nil {
+			return rectype, err
+		}
+		c.codeCross(b, toBits(d.Shape(parent).Bits), l)
+		if rectype != MATCHED_REFINE_LIBRARY_ONLY {
+			c.codeRelativeLocation(&blit, b.h, b.w)
+		}
+
+	case MATCHED_COPY:
+		shapeno, match, err := c.codeMatchIndex(blit.Shape)
+		if err != nil {
+			return rectype, err
+		}
+		blit.Shape = shapeno
+		l := c.libinfo[match]
+		blit.Left += l.left
+		blit.Bottom += l.bottom
+		c.codeRelativeLocation(&blit, l.top-l.bottom+1, l.right-l.left+1)
+		blit.Left -= l.left
+		blit.Bottom -= l.bottom
+
+	case PRESERVED_COMMENT:
+		d.Comment = c.codeComment(d.Comment)
+
+	case REQUIRED_DICT_OR_RESET:
+		if c.gotStart {
+			c.resetNumcoder()
+		} else if err := c.codeInheritedShapeCount(d); err != nil {
+			return rectype, err
+		}
+
+	case END_OF_DATA:
+	}
+
+	// Record the new shape and blit
+	switch rectype {
+	case NEW_MARK, NEW_MARK_LIBRARY_ONLY, NEW_MARK_IMAGE_ONLY,
+		MATCHED_REFINE, MATCHED_REFINE_LIBRARY_ONLY, MATCHED_REFINE_IMAGE_ONLY, NON_MARK_DATA:
+		if !c.encoding {
+			shape.Bits = b.bitmap()
+			shapeno = d.AddShape(shape)
+		}
+		blit.Shape = shapeno
+	}
+	if !c.encoding {
+		switch rectype {
+		case NEW_MARK, NEW_MARK_IMAGE_ONLY, MATCHED_REFINE, MATCHED_REFINE_IMAGE_ONLY, MATCHED_COPY, NON_MARK_DATA:
+			img.Blits = append(img.Blits, blit)
+		}
+	}
+	switch rectype {
+	case NEW_MARK, NEW_MARK_LIBRARY_ONLY, MATCHED_REFINE, MATCHED_REFINE_LIBRARY_ONLY:
+		c.addLibrary(shapeno, d.Shape(shapeno).Bits)
+	}
+	return rectype, nil
+}
@@ -0,0 +1,93 @@
+package jb2
+
+import (
+	"github.com/janreggie/go-djvulibre/djvu/image"
+	"github.com/pkg/errors"
+)
+
+// NewImageFromBitmap returns the image of bm, whose nonzero pixels are black.
+// Each group of black pixels touching each other,
+// even by a corner, is blitted as a shape.
+// Identical groups share the same shape.
+// Blits come in the order the groups are met
+// when scanning the image from the top, which is the reading order of lines of text.
+func NewImageFromBitmap(bm *image.Bitmap) (*Image, error) {
+	w, h := int(bm.Cols()), int(bm.Rows())
+	if w == 0 || h == 0 {
+		return nil, errors.New("cannot make JB2 image of an empty bitmap")
+	}
+	img := &Image{Width: w, Height: h}
+	shapes := make(map[string]int) // Shape numbers by contents
+
+	seen := make([]bool, w*h)
+	var stack, pixels []int
+	for rr := h - 1; rr >= 0; rr-- {
+		for cc, v := range bm.GetLine(rr) {
+			if v == 0 || seen[rr*w+cc] {
+				continue
+			}
+
+			// Gathers the pixels of the group
+			pixels = pixels[:0]
+			stack = append(stack[:0], rr*w+cc)
+			seen[rr*w+cc] = true
+			left, right, bottom, top := cc, cc, rr, rr
+			for len(stack) > 0 {
+				p := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				pixels = append(pixels, p)
+				y, x := p/w, p%w
+				left, right = minInt(left, x), maxInt(right, x)
+				bottom, top = minInt(bottom, y), maxInt(top, y)
+				for ny := maxInt(y-1, 0); ny <= minInt(y+1, h-1); ny++ {
+					line := bm.GetLine(ny)
+					for nx := maxInt(x-1, 0); nx <= minInt(x+1, w-1); nx++ {
+						if line[nx] != 0 && !seen[ny*w+nx] {
+							seen[ny*w+nx] = true
+							stack = append(stack, ny*w+nx)
+						}
+					}
+				}
+			}
+
+			bits, err := image.NewBitmap(uint16(top-bottom+1), uint16(right-left+1), 0)
+			if err != nil {
+				return nil, err
+			}
+			for _, p := range pixels {
+				bits.GetLine(p/w - bottom)[p%w-left] = 1
+			}
+			key := shapeKey(bits)
+			shape, ok := shapes[key]
+			if !ok {
+				shape = img.AddShape(Shape{Bits: bits, Parent: NO_PARENT})
+				shapes[key] = shape
+			}
+			img.Blits = append(img.Blits, Blit{Left: left, Bottom: bottom, Shape: shape})
+		}
+	}
+	return img, nil
+}
+
+// Returns a string telling apart bitmaps of different sizes or contents
+func shapeKey(bits *image.Bitmap) string {
+	key := []byte{byte(bits.Cols() >> 8), byte(bits.Cols()), byte(bits.Rows() >> 8), byte(bits.Rows())}
+	for rr := 0; rr < int(bits.Rows()); rr++ {
+		key = append(key, bits.GetLine(rr)...)
+	}
+	return string(key)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
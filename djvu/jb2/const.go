@@ -0,0 +1,58 @@
+package jb2
+
+import "fmt"
+
+// RecordType is the type of a record of a JB2 stream
+type RecordType uint8
+
+const (
+	START_OF_DATA RecordType = iota
+	NEW_MARK
+	NEW_MARK_LIBRARY_ONLY
+	NEW_MARK_IMAGE_ONLY
+	MATCHED_REFINE
+	MATCHED_REFINE_LIBRARY_ONLY
+	MATCHED_REFINE_IMAGE_ONLY
+	MATCHED_COPY
+	NON_MARK_DATA
+	REQUIRED_DICT_OR_RESET
+	PRESERVED_COMMENT
+	END_OF_DATA
+)
+
+var recordTypeNames = []string{
+	"START_OF_DATA",
+	"NEW_MARK",
+	"NEW_MARK_LIBRARY_ONLY",
+	"NEW_MARK_IMAGE_ONLY",
+	"MATCHED_REFINE",
+	"MATCHED_REFINE_LIBRARY_ONLY",
+	"MATCHED_REFINE_IMAGE_ONLY",
+	"MATCHED_COPY",
+	"NON_MARK_DATA",
+	"REQUIRED_DICT_OR_RESET",
+	"PRESERVED_COMMENT",
+	"END_OF_DATA",
+}
+
+func (t RecordType) String() string {
+	if int(t) < len(recordTypeNames) {
+		return recordTypeNames[t]
+	}
+	return fmt.Sprintf("RecordType(%d)", t)
+}
+
+// Parents of shapes which do not refine another shape
+const (
+	NO_PARENT = -1 // A mark coded on its own
+	NON_MARK  = -2 // Non-mark data, such as a halftoned picture
+)
+
+// Bounds of the numbers coded by the stream
+const (
+	bigPositive = 262142
+	bigNegative = -262143
+)
+
+// Number of cells of the number coder beyond which the encoder resets it
+const cellChunk = 20000
@@ -0,0 +1,47 @@
+package jb2
+
+import (
+	"io"
+
+	"github.com/janreggie/go-djvulibre/djvu/zp"
+	"github.com/pkg/errors"
+)
+
+// Decode decodes an image from the data of a `Sjbz` chunk.
+// `inherited` is the dictionary offered to images which require one,
+// usually decoded from the `Djbz` chunk of a file included by the page.
+func Decode(r io.Reader, inherited *Dict) (*Image, error) {
+	img := &Image{}
+	if err := decode(r, inherited, &img.Dict, img); err != nil {
+		return nil, errors.Wrap(err, "could not decode JB2 image")
+	}
+	return img, nil
+}
+
+// DecodeDict decodes a dictionary from the data of a `Djbz` chunk.
+// `inherited` is the dictionary offered to dictionaries which require one.
+func DecodeDict(r io.Reader, inherited *Dict) (*Dict, error) {
+	d := &Dict{}
+	if err := decode(r, inherited, d, nil); err != nil {
+		return nil, errors.Wrap(err, "could not decode JB2 dictionary")
+	}
+	return d, nil
+}
+
+func decode(r io.Reader, inherited *Dict, d *Dict, img *Image) error {
+	c := newCodec(false)
+	c.zd = zp.NewDecoder(r)
+	c.inherited = inherited
+	for {
+		rectype, err := c.codeRecord(0, d, img, 0, Blit{})
+		if err != nil {
+			return err
+		}
+		if err := c.zd.Err(); err != nil {
+			return err
+		}
+		if rectype == END_OF_DATA {
+			return nil
+		}
+	}
+}
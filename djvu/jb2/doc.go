@@ -0,0 +1,13 @@
+// Package jb2 implements the JB2 codec,
+// which compresses the bilevel images of DjVu documents:
+// the `Sjbz` mask of pages and the `Djbz` shape dictionaries shared between pages.
+//
+// A JB2 image is made of shapes, the bitmaps of marks such as letters,
+// and of blits, which place shapes on the page.
+// Similar marks are coded once as a shape blitted many times,
+// or as a refinement of another shape.
+// Shapes may come from a dictionary inherited from another chunk.
+//
+// Images and dictionaries are coded as a sequence of records
+// with the ZP-Coder, and the same code path encodes and decodes them.
+package jb2
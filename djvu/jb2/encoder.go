@@ -0,0 +1,156 @@
+package jb2
+
+import (
+	"io"
+
+	"github.com/janreggie/go-djvulibre/djvu/zp"
+	"github.com/pkg/errors"
+)
+
+// Encode encodes the image into the data of a `Sjbz` chunk.
+//
+// Shapes blitted more than once, and shapes refined by others,
+// go to the library of the stream so that they can be matched.
+// Shapes which are never blitted are only added to the library.
+// The numbers of the shapes of the decoded image follow the order of the stream,
+// so they may differ from the numbers of the shapes of img.
+func (img *Image) Encode(w io.Writer) error {
+	if img.Width <= 0 || img.Height <= 0 || img.Width > bigPositive || img.Height > bigPositive {
+		return errors.Errorf("cannot encode JB2 image of %dx%d", img.Width, img.Height)
+	}
+	if err := img.check(); err != nil {
+		return errors.Wrap(err, "could not encode JB2 image")
+	}
+	for ii, blit := range img.Blits {
+		if blit.Shape < 0 || blit.Shape >= img.ShapeCount() {
+			return errors.Errorf("blit %d has bad shape %d", ii, blit.Shape)
+		}
+	}
+
+	e := newEncoder(w, &img.Dict, img)
+	inherited := img.InheritedShapeCount()
+
+	// Shapes which must be in the library
+	uses := make([]int, len(img.Shapes))
+	library := make([]bool, len(img.Shapes))
+	for _, blit := range img.Blits {
+		if blit.Shape >= inherited {
+			uses[blit.Shape-inherited]++
+		}
+	}
+	for ii, s := range img.Shapes {
+		library[ii] = uses[ii] > 1
+		if s.Parent >= inherited {
+			library[s.Parent-inherited] = true
+		}
+	}
+	coded := make([]bool, len(img.Shapes))
+
+	var codeShape func(shapeno int, blit *Blit)
+	codeShape = func(shapeno int, blit *Blit) {
+		own := shapeno - inherited
+		s := img.Shapes[own]
+		if s.Parent >= inherited && !coded[s.Parent-inherited] {
+			codeShape(s.Parent, nil)
+		}
+		coded[own] = true
+
+		var rectype RecordType
+		switch {
+		case s.Parent == NON_MARK && blit != nil && !library[own]:
+			rectype = NON_MARK_DATA
+		case s.Parent >= 0 && blit == nil:
+			rectype = MATCHED_REFINE_LIBRARY_ONLY
+		case s.Parent >= 0 && library[own]:
+			rectype = MATCHED_REFINE
+		case s.Parent >= 0:
+			rectype = MATCHED_REFINE_IMAGE_ONLY
+		case blit == nil:
+			rectype = NEW_MARK_LIBRARY_ONLY
+		case library[own]:
+			rectype = NEW_MARK
+		default:
+			rectype = NEW_MARK_IMAGE_ONLY
+		}
+		var b Blit
+		if blit != nil {
+			b = *blit
+		}
+		e.record(rectype, shapeno, b)
+	}
+
+	for _, blit := range img.Blits {
+		if blit.Shape < inherited || coded[blit.Shape-inherited] {
+			e.record(MATCHED_COPY, -1, blit)
+		} else {
+			codeShape(blit.Shape, &blit)
+		}
+	}
+	for ii := range img.Shapes {
+		if !coded[ii] && img.Shapes[ii].Parent != NON_MARK {
+			codeShape(inherited+ii, nil)
+		}
+	}
+	return e.close()
+}
+
+// Encode encodes the dictionary into the data of a `Djbz` chunk.
+func (d *Dict) Encode(w io.Writer) error {
+	if err := d.check(); err != nil {
+		return errors.Wrap(err, "could not encode JB2 dictionary")
+	}
+	e := newEncoder(w, d, nil)
+	inherited := d.InheritedShapeCount()
+	for ii, s := range d.Shapes {
+		rectype := NEW_MARK_LIBRARY_ONLY
+		if s.Parent >= 0 {
+			rectype = MATCHED_REFINE_LIBRARY_ONLY
+		}
+		e.record(rectype, inherited+ii, Blit{})
+	}
+	return e.close()
+}
+
+// encoder codes records until the first error
+type encoder struct {
+	c   *codec
+	d   *Dict
+	img *Image
+	err error
+}
+
+// Returns an encoder which already coded the records starting the stream
+func newEncoder(w io.Writer, d *Dict, img *Image) *encoder {
+	c := newCodec(true)
+	c.ze = zp.NewEncoder(w)
+	e := &encoder{c: c, d: d, img: img}
+	if d.InheritedShapeCount() > 0 {
+		e.record(REQUIRED_DICT_OR_RESET, -1, Blit{})
+	}
+	e.record(START_OF_DATA, -1, Blit{})
+	if d.Comment != "" {
+		e.record(PRESERVED_COMMENT, -1, Blit{})
+	}
+	return e
+}
+
+func (e *encoder) record(rectype RecordType, shapeno int, blit Blit) {
+	if e.err != nil {
+		return
+	}
+	// Keeps the number coder small
+	if e.c.gotStart && len(e.c.bitcells) > cellChunk {
+		if _, e.err = e.c.codeRecord(REQUIRED_DICT_OR_RESET, e.d, e.img, -1, Blit{}); e.err != nil {
+			return
+		}
+	}
+	_, e.err = e.c.codeRecord(rectype, e.d, e.img, shapeno, blit)
+}
+
+func (e *encoder) close() error {
+	e.record(END_OF_DATA, -1, Blit{})
+	if err := e.c.ze.Close(); err != nil && e.err == nil {
+		e.err = err
+	}
+	return e.err
+}
@@ -0,0 +1,109 @@
+package jb2
+
+import (
+	"github.com/janreggie/go-djvulibre/djvu/image"
+	"github.com/pkg/errors"
+)
+
+// Shape is a bilevel bitmap, usually the picture of a mark.
+type Shape struct {
+	// Black pixels have value 1
+	Bits *image.Bitmap
+	// Number of the shape it refines, NO_PARENT or NON_MARK
+	Parent int
+}
+
+// Blit places a shape on the image.
+type Blit struct {
+	// Position of the bottom left corner of the shape,
+	// in pixels from the bottom left corner of the image
+	Left, Bottom int
+	// Number of the shape
+	Shape int
+}
+
+// Dict is a dictionary of shapes, as found in `Djbz` chunks.
+// The shapes of the Inherited dictionary come first:
+// shape n of a dictionary inheriting m shapes is its own shape n-m.
+type Dict struct {
+	Inherited *Dict
+	Shapes    []Shape
+	Comment   string
+}
+
+// Image is a bilevel image, as found in `Sjbz` chunks.
+// Its shapes are those of its embedded dictionary.
+type Image struct {
+	Dict
+	Width, Height int
+	Blits         []Blit
+}
+
+// InheritedShapeCount returns the number of shapes of the inherited dictionary
+func (d *Dict) InheritedShapeCount() int {
+	if d.Inherited == nil {
+		return 0
+	}
+	return d.Inherited.ShapeCount()
+}
+
+// ShapeCount returns the number of shapes, including inherited ones
+func (d *Dict) ShapeCount() int {
+	return d.InheritedShapeCount() + len(d.Shapes)
+}
+
+// Shape returns shape number n
+func (d *Dict) Shape(n int) *Shape {
+	inherited := d.InheritedShapeCount()
+	if n < inherited {
+		return d.Inherited.Shape(n)
+	}
+	return &d.Shapes[n-inherited]
+}
+
+// AddShape appends a shape and returns its number
+func (d *Dict) AddShape(s Shape) int {
+	d.Shapes = append(d.Shapes, s)
+	return d.ShapeCount() - 1
+}
+
+// Checks the shape numbers and parents
+func (d *Dict) check() error {
+	inherited := d.InheritedShapeCount()
+	for ii, s := range d.Shapes {
+		if s.Bits == nil {
+			return errors.Errorf("shape %d has no bitmap", inherited+ii)
+		}
+		if s.Parent < NON_MARK || s.Parent >= inherited+ii {
+			return errors.Errorf("shape %d has bad parent %d", inherited+ii, s.Parent)
+		}
+	}
+	return nil
+}
+
+// Bitmap renders the image into a bilevel Bitmap.
+func (img *Image) Bitmap() (*image.Bitmap, error) {
+	bm, err := image.NewBitmap(uint16(img.Height), uint16(img.Width), 0)
+	if err != nil || int(bm.Rows()) != img.Height || int(bm.Cols()) != img.Width {
+		return nil, errors.Errorf("JB2 image of %dx%d is too large for a Bitmap", img.Width, img.Height)
+	}
+	for ii, blit := range img.Blits {
+		if blit.Shape < 0 || blit.Shape >= img.ShapeCount() {
+			return nil, errors.Errorf("blit %d has bad shape %d", ii, blit.Shape)
+		}
+		bits := img.Shape(blit.Shape).Bits
+		for rr := 0; rr < int(bits.Rows()); rr++ {
+			y := blit.Bottom + rr
+			if y < 0 || y >= img.Height {
+				continue
+			}
+			line := bm.GetLine(y)
+			for cc, v := range bits.GetLine(rr) {
+				if x := blit.Left + cc; v != 0 && x >= 0 && x < img.Width {
+					line[x] = 1
+				}
+			}
+		}
+	}
+	return bm, nil
+}
@@ -0,0 +1,239 @@
+package jb2
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/janreggie/go-djvulibre/djvu/image"
+	"github.com/janreggie/go-djvulibre/djvu/zp"
+	"github.com/stretchr/testify/suite"
+)
+
+type JB2TestSuite struct {
+	suite.Suite
+	Rand *rand.Rand
+}
+
+func TestJB2Suite(t *testing.T) {
+	suite.Run(t, new(JB2TestSuite))
+}
+
+func (s *JB2TestSuite) SetupTest() {
+	s.Rand = rand.New(rand.NewSource(1))
+}
+
+// Returns a w by h bitmap of a blob with some noise
+func (s *JB2TestSuite) shape(w, h int) *image.Bitmap {
+	bm, err := image.NewBitmap(uint16(h), uint16(w), 0)
+	s.Require().NoError(err)
+	for rr := 0; rr < h; rr++ {
+		line := bm.GetLine(rr)
+		for cc := range line {
+			dx, dy := 2*cc-w+1, 2*rr-h+1
+			if dx*dx*h*h+dy*dy*w*w < w*w*h*h || s.Rand.Intn(20) == 0 {
+				line[cc] = 1
+			}
+		}
+	}
+	return bm
+}
+
+func (s *JB2TestSuite) equalBitmaps(expected, actual *image.Bitmap) {
+	s.Require().Equal(expected.Rows(), actual.Rows())
+	s.Require().Equal(expected.Cols(), actual.Cols())
+	for rr := 0; rr < int(expected.Rows()); rr++ {
+		s.Require().Equal(expected.GetLine(rr), actual.GetLine(rr), "row %d", rr)
+	}
+}
+
+func (s *JB2TestSuite) roundTrip(img *Image, inherited *Dict) *Image {
+	var buf bytes.Buffer
+	s.Require().NoError(img.Encode(&buf))
+	decoded, err := Decode(&buf, inherited)
+	s.Require().NoError(err)
+	s.Equal(img.Width, decoded.Width)
+	s.Equal(img.Height, decoded.Height)
+	s.Equal(img.Comment, decoded.Comment)
+
+	expected, err := img.Bitmap()
+	s.Require().NoError(err)
+	actual, err := decoded.Bitmap()
+	s.Require().NoError(err)
+	s.equalBitmaps(expected, actual)
+	return decoded
+}
+
+func (s *JB2TestSuite) TestCodeNum() {
+	values := []int{0, 1, -1, 5, 100, -100, 262142, -262143, 77, 3}
+	var buf bytes.Buffer
+	c := newCodec(true)
+	c.ze = zp.NewEncoder(&buf)
+	var root int
+	for _, v := range values {
+		s.Equal(v, c.codeNum(bigNegative, bigPositive, &root, v))
+	}
+	var small int
+	s.Equal(2, c.codeNum(2, 2, &small, 2))
+	s.Require().NoError(c.ze.Close())
+
+	c = newCodec(false)
+	c.zd = zp.NewDecoder(&buf)
+	root, small = 0, 0
+	for _, v := range values {
+		s.Equal(v, c.codeNum(bigNegative, bigPositive, &root, 0))
+	}
+	s.Equal(2, c.codeNum(2, 2, &small, 0))
+	s.NoError(c.zd.Err())
+}
+
+func (s *JB2TestSuite) TestRoundTrip() {
+	img := &Image{Width: 300, Height: 200}
+	img.Comment = "scanned"
+	a := img.AddShape(Shape{Bits: s.shape(12, 15), Parent: NO_PARENT})
+	b := img.AddShape(Shape{Bits: s.shape(9, 20), Parent: NO_PARENT})
+	refined := img.AddShape(Shape{Bits: s.shape(13, 15), Parent: a})
+	picture := img.AddShape(Shape{Bits: s.shape(40, 30), Parent: NON_MARK})
+	img.AddShape(Shape{Bits: s.shape(5, 5), Parent: NO_PARENT}) // Unused
+
+	// Lines of text
+	for y := 180; y > 40; y -= 25 {
+		for x := 5; x < 280; x += 16 + s.Rand.Intn(4) {
+			shape := a
+			switch s.Rand.Intn(4) {
+			case 0:
+				shape = b
+			case 1:
+				shape = refined
+			}
+			img.Blits = append(img.Blits, Blit{Left: x, Bottom: y - s.Rand.Intn(3), Shape: shape})
+		}
+	}
+	img.Blits = append(img.Blits, Blit{Left: 250, Bottom: 5, Shape: picture})
+	// Partly outside of the image
+	img.Blits = append(img.Blits, Blit{Left: 295, Bottom: 195, Shape: b})
+
+	decoded := s.roundTrip(img, nil)
+	s.Len(decoded.Blits, len(img.Blits))
+	s.Len(decoded.Shapes, len(img.Shapes))
+	parents := map[int]int{}
+	for _, shape := range decoded.Shapes {
+		parents[shape.Parent]++
+	}
+	s.Equal(1, parents[NON_MARK])
+}
+
+func (s *JB2TestSuite) TestDict() {
+	dict := &Dict{Comment: "fonts"}
+	a := dict.AddShape(Shape{Bits: s.shape(10, 14), Parent: NO_PARENT})
+	dict.AddShape(Shape{Bits: s.shape(11, 14), Parent: a})
+
+	var buf bytes.Buffer
+	s.Require().NoError(dict.Encode(&buf))
+	decodedDict, err := DecodeDict(bytes.NewReader(buf.Bytes()), nil)
+	s.Require().NoError(err)
+	s.Equal("fonts", decodedDict.Comment)
+	s.Require().Len(decodedDict.Shapes, 2)
+	s.Equal(a, decodedDict.Shapes[1].Parent)
+	for ii := range dict.Shapes {
+		s.equalBitmaps(dict.Shapes[ii].Bits, decodedDict.Shapes[ii].Bits)
+	}
+
+	// A page using the shapes of the dictionary
+	img := &Image{Width: 100, Height: 50}
+	img.Inherited = dict
+	own := img.AddShape(Shape{Bits: s.shape(8, 8), Parent: 1})
+	s.Equal(2, own)
+	img.Blits = []Blit{{10, 10, 0}, {25, 12, 1}, {40, 10, own}, {60, 10, 0}}
+	decoded := s.roundTrip(img, decodedDict)
+	s.Equal(decodedDict, decoded.Inherited)
+	s.Equal(2, decoded.InheritedShapeCount())
+
+	// The dictionary is required
+	buf.Reset()
+	s.Require().NoError(img.Encode(&buf))
+	_, err = Decode(bytes.NewReader(buf.Bytes()), nil)
+	s.Error(err)
+	_, err = Decode(bytes.NewReader(buf.Bytes()), &Dict{})
+	s.Error(err)
+
+	// Dictionaries hold no blits
+	_, err = DecodeDict(bytes.NewReader(buf.Bytes()), decodedDict)
+	s.Error(err)
+}
+
+func (s *JB2TestSuite) TestManyShapes() {
+	img := &Image{Width: 2000, Height: 2000}
+	for ii := 0; ii < 400; ii++ {
+		shape := img.AddShape(Shape{Bits: s.shape(5+s.Rand.Intn(20), 5+s.Rand.Intn(20)), Parent: NO_PARENT})
+		img.Blits = append(img.Blits, Blit{Left: s.Rand.Intn(1990), Bottom: s.Rand.Intn(1990), Shape: shape})
+	}
+	s.roundTrip(img, nil)
+}
+
+func (s *JB2TestSuite) TestResetNumcoder() {
+	// Blits scattered enough to fill the number coder
+	img := &Image{Width: 200000, Height: 200000}
+	shape := img.AddShape(Shape{Bits: s.shape(4, 4), Parent: NO_PARENT})
+	for ii := 0; ii < 5000; ii++ {
+		img.Blits = append(img.Blits, Blit{Left: s.Rand.Intn(200000), Bottom: s.Rand.Intn(200000), Shape: shape})
+	}
+
+	var buf bytes.Buffer
+	s.Require().NoError(img.Encode(&buf))
+	decoded, err := Decode(&buf, nil)
+	s.Require().NoError(err)
+	s.Equal(img.Blits, decoded.Blits)
+}
+
+func (s *JB2TestSuite) TestErrors() {
+	img := &Image{Width: 10, Height: 10}
+	img.AddShape(Shape{Bits: s.shape(3, 3), Parent: 4})
+	s.Error(img.Encode(&bytes.Buffer{}))
+
+	img = &Image{Width: 10, Height: 10, Blits: []Blit{{0, 0, 3}}}
+	s.Error(img.Encode(&bytes.Buffer{}))
+	_, err := img.Bitmap()
+	s.Error(err)
+
+	img = &Image{}
+	s.Error(img.Encode(&bytes.Buffer{}))
+
+	_, err = Decode(bytes.NewReader([]byte{0xff, 0xff, 0xff}), nil)
+	s.Error(err)
+	s.Equal("MATCHED_COPY", MATCHED_COPY.String())
+}
+
+func (s *JB2TestSuite) TestFromBitmap() {
+	bm, err := image.NewBitmap(40, 60, 0)
+	s.Require().NoError(err)
+	for rr := 0; rr < 40; rr++ {
+		line := bm.GetLine(rr)
+		for cc := range line {
+			// Two identical squares, a diagonal touching by corners and noise
+			if (rr >= 5 && rr < 10 && (cc >= 5 && cc < 10 || cc >= 20 && cc < 25)) ||
+				(rr >= 20 && rr < 30 && cc == rr+10) || s.Rand.Intn(50) == 0 {
+				line[cc] = 1
+			}
+		}
+	}
+
+	img, err := NewImageFromBitmap(bm)
+	s.Require().NoError(err)
+	s.Equal(60, img.Width)
+	s.Equal(40, img.Height)
+	actual, err := img.Bitmap()
+	s.Require().NoError(err)
+	s.equalBitmaps(bm, actual)
+	s.Less(img.ShapeCount(), len(img.Blits))
+
+	// Blits go from the top
+	for i := 1; i < len(img.Blits); i++ {
+		s.GreaterOrEqual(img.Blits[i-1].Bottom+int(img.Shape(img.Blits[i-1].Shape).Bits.Rows())-1,
+			img.Blits[i].Bottom+int(img.Shape(img.Blits[i].Shape).Bits.Rows())-1)
+	}
+	s.roundTrip(img, nil)
+
+	_, err = NewImageFromBitmap(&image.Bitmap{})
+	s.Error(err)
+}
@@ -0,0 +1,487 @@
+package djvu
+
+import (
+	"image/color"
+	"math"
+
+	"github.com/janreggie/go-djvulibre/djvu/image"
+	"github.com/janreggie/go-djvulibre/djvu/miniexp"
+	"github.com/pkg/errors"
+)
+
+// MapArea is a hyperlink or a highlighted area of a page,
+// as found in the `maparea` annotations.
+//
+//     (maparea "url" "comment" (rect 10 10 100 50) (border #FF0000) (hilite #FFFF00))
+//
+// Optional colors are nil when not given,
+// and optional numbers are 0 when not given.
+type MapArea struct {
+	URL     string
+	Target  string
+	Comment string
+	Shape   MapShape
+
+	Border      BorderType
+	BorderColor *color.RGBA
+
+	// Thickness of the shadow borders
+	BorderWidth int
+
+	// Whether the border is shown even if the mouse is not over the area
+	BorderAlwaysVisible bool
+
+	// Color the area is filled with, for rectangles
+	Hilite *color.RGBA
+
+	// Opacity of Hilite in percent, for rectangles
+	Opacity *int
+
+	// Options of lines
+	Arrow     bool
+	LineWidth int
+	LineColor *color.RGBA
+
+	// Options of text areas
+	BackColor *color.RGBA
+	TextColor *color.RGBA
+	Pushpin   bool
+
+	// Options which are not known, verbatim
+	Other []string
+}
+
+// MapShape is the area of a MapArea
+type MapShape struct {
+	Type MapShapeType
+
+	// Coordinates of the shape: x, y, width and height for MAPAREA_RECT, MAPAREA_OVAL and MAPAREA_TEXT,
+	// the two ends for MAPAREA_LINE and the vertices for MAPAREA_POLY.
+	Coords []int
+}
+
+type MapShapeType uint8
+
+const (
+	MAPAREA_RECT MapShapeType = iota
+	MAPAREA_OVAL
+	MAPAREA_POLY
+	MAPAREA_LINE
+	MAPAREA_TEXT
+)
+
+var mapShapeNames = []string{"rect", "oval", "poly", "line", "text"}
+
+func (t MapShapeType) String() string { return enumName(mapShapeNames, int(t)) }
+
+// BorderType tells how the border of a MapArea is drawn
+type BorderType uint8
+
+const (
+	BORDER_NONE BorderType = iota
+	BORDER_XOR
+	BORDER_SOLID
+	BORDER_SHADOW_IN
+	BORDER_SHADOW_OUT
+	BORDER_SHADOW_EIN
+	BORDER_SHADOW_EOUT
+)
+
+var borderNames = []string{"none", "xor", "border", "shadow_in", "shadow_out", "shadow_ein", "shadow_eout"}
+
+func (t BorderType) String() string { return enumName(borderNames, int(t)) }
+
+// Reads the arguments of a `maparea` expression
+func parseMapArea(args miniexp.List) (*MapArea, error) {
+	if len(args) < 3 {
+		return nil, errors.New("maparea needs a URL, a comment and a shape")
+	}
+	area := &MapArea{}
+
+	switch url := args[0].(type) {
+	case miniexp.String:
+		area.URL = string(url)
+	case miniexp.List:
+		var link struct {
+			_      struct{} `miniexp:"url,head"`
+			URL    miniexp.String
+			Target miniexp.String
+		}
+		if err := miniexp.Unmarshal(url, &link); err != nil {
+			return nil, errors.Wrapf(err, "bad maparea URL %s", url)
+		}
+		area.URL, area.Target = string(link.URL), string(link.Target)
+	default:
+		return nil, errors.Errorf("bad maparea URL %s", url)
+	}
+
+	comment, ok := args[1].(miniexp.String)
+	if !ok {
+		return nil, errors.Errorf("bad maparea comment %s", args[1])
+	}
+	area.Comment = string(comment)
+
+	shape, _ := args[2].(miniexp.List)
+	typ := enumIndex(mapShapeNames, string(shape.Head()))
+	if typ < 0 {
+		return nil, errors.Errorf("bad maparea shape %s", args[2])
+	}
+	area.Shape.Type = MapShapeType(typ)
+	if err := miniexp.Unmarshal(shape.Args(), &area.Shape.Coords); err != nil {
+		return nil, errors.Wrapf(err, "bad maparea shape %s", shape)
+	}
+	if err := area.Shape.Validate(); err != nil {
+		return nil, errors.Wrapf(err, "bad maparea shape %s", shape)
+	}
+
+	for _, opt := range args[3:] {
+		if err := area.setOption(opt); err != nil {
+			return nil, errors.Wrapf(err, "bad maparea option %s", opt)
+		}
+	}
+	return area, nil
+}
+
+func (area *MapArea) setOption(opt miniexp.Expr) error {
+	l, _ := opt.(miniexp.List)
+	args := l.Args()
+	// Reads the only argument of opt, if any
+	intArg := func() (int, error) {
+		var n int
+		if len(args) == 0 {
+			return n, nil
+		}
+		err := miniexp.Unmarshal(args[0], &n)
+		return n, err
+	}
+	colorArg := func() (*color.RGBA, error) {
+		if len(args) == 1 {
+			if s, ok := args[0].(miniexp.Symbol); ok {
+				return parseColor(string(s))
+			}
+		}
+		return nil, errors.New("expected a color")
+	}
+
+	var err error
+	switch name := string(l.Head()); name {
+	case "none", "xor", "border", "shadow_in", "shadow_out", "shadow_ein", "shadow_eout":
+		area.Border = BorderType(enumIndex(borderNames, name))
+		switch area.Border {
+		case BORDER_SOLID:
+			area.BorderColor, err = colorArg()
+		case BORDER_SHADOW_IN, BORDER_SHADOW_OUT, BORDER_SHADOW_EIN, BORDER_SHADOW_EOUT:
+			area.BorderWidth, err = intArg()
+		}
+	case "border_avis":
+		area.BorderAlwaysVisible = true
+	case "hilite":
+		area.Hilite, err = colorArg()
+	case "opacity":
+		var opacity int
+		if opacity, err = intArg(); err == nil {
+			area.Opacity = &opacity
+		}
+	case "arrow":
+		area.Arrow = true
+	case "width":
+		area.LineWidth, err = intArg()
+	case "lineclr":
+		area.LineColor, err = colorArg()
+	case "backclr":
+		area.BackColor, err = colorArg()
+	case "textclr":
+		area.TextColor, err = colorArg()
+	case "pushpin":
+		area.Pushpin = true
+	default:
+		area.Other = append(area.Other, opt.String())
+	}
+	return err
+}
+
+// Returns the `maparea` expression of the area
+func (area *MapArea) expr() (miniexp.List, error) {
+	var url miniexp.Expr = miniexp.String(area.URL)
+	if area.Target != "" {
+		url = miniexp.Call("url", miniexp.String(area.URL), miniexp.String(area.Target))
+	}
+	if err := area.Shape.Validate(); err != nil {
+		return nil, err
+	}
+	coords := make(miniexp.List, len(area.Shape.Coords))
+	for ii, n := range area.Shape.Coords {
+		coords[ii] = miniexp.Int(n)
+	}
+	e := miniexp.Call("maparea", url, miniexp.String(area.Comment), miniexp.Call(area.Shape.Type.String(), coords...))
+	add := func(name string, args ...miniexp.Expr) { e = append(e, miniexp.Call(name, args...)) }
+	addColor := func(name string, c *color.RGBA) {
+		if c != nil {
+			add(name, miniexp.Symbol(formatColor(c)))
+		}
+	}
+
+	switch area.Border {
+	case BORDER_NONE:
+	case BORDER_XOR:
+		add(area.Border.String())
+	case BORDER_SOLID:
+		if area.BorderColor == nil {
+			return nil, errors.New("solid maparea border without color")
+		}
+		addColor(area.Border.String(), area.BorderColor)
+	case BORDER_SHADOW_IN, BORDER_SHADOW_OUT, BORDER_SHADOW_EIN, BORDER_SHADOW_EOUT:
+		if area.BorderWidth > 0 {
+			add(area.Border.String(), miniexp.Int(area.BorderWidth))
+		} else {
+			add(area.Border.String())
+		}
+	default:
+		return nil, errors.Errorf("unknown maparea border %d", area.Border)
+	}
+	if area.BorderAlwaysVisible {
+		add("border_avis")
+	}
+	addColor("hilite", area.Hilite)
+	if area.Opacity != nil {
+		add("opacity", miniexp.Int(*area.Opacity))
+	}
+	if area.Arrow {
+		add("arrow")
+	}
+	if area.LineWidth > 0 {
+		add("width", miniexp.Int(area.LineWidth))
+	}
+	addColor("lineclr", area.LineColor)
+	addColor("backclr", area.BackColor)
+	addColor("textclr", area.TextColor)
+	if area.Pushpin {
+		add("pushpin")
+	}
+	for _, other := range area.Other {
+		opt, err := miniexp.ParseString(other)
+		if err != nil {
+			return nil, errors.Wrapf(err, "bad maparea option %q", other)
+		}
+		e = append(e, opt)
+	}
+	return e, nil
+}
+
+// ParseMapArea reads a map area written as a `maparea` expression.
+func ParseMapArea(text string) (*MapArea, error) {
+	e, err := miniexp.ParseString(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse maparea")
+	}
+	l, _ := e.(miniexp.List)
+	if l.Head() != "maparea" {
+		return nil, errors.Errorf("expected a maparea instead of %s", e)
+	}
+	return parseMapArea(l.Args())
+}
+
+// Format returns the `maparea` expression of the area.
+func (area *MapArea) Format() (string, error) {
+	e, err := area.expr()
+	if err != nil {
+		return "", err
+	}
+	return e.String(), nil
+}
+
+// Contains returns whether the point (x,y) of the page is inside the area,
+// so that clicking there follows its link.
+// Points are on a line if they are within half its width of it.
+func (area *MapArea) Contains(x, y int) bool {
+	if area.Shape.Type == MAPAREA_LINE {
+		return area.Shape.nearLine(x, y, area.LineWidth)
+	}
+	return area.Shape.Contains(x, y)
+}
+
+// Validate returns an error if the coordinates do not make up a shape of its type.
+// Rectangles may be empty, but ovals may not.
+// Polygons need at least three vertices and their sides may not cross,
+// and the two ends of a line must be different.
+func (s MapShape) Validate() error {
+	switch s.Type {
+	case MAPAREA_RECT, MAPAREA_OVAL, MAPAREA_TEXT:
+		if len(s.Coords) != 4 {
+			return errors.Errorf("%s needs x, y, width and height instead of %d numbers", s.Type, len(s.Coords))
+		}
+		if w, h := s.Coords[2], s.Coords[3]; w < 0 || h < 0 || (s.Type == MAPAREA_OVAL && (w == 0 || h == 0)) {
+			return errors.Errorf("bad %s size %dx%d", s.Type, w, h)
+		}
+
+	case MAPAREA_LINE:
+		if len(s.Coords) != 4 {
+			return errors.Errorf("line needs two ends instead of %d numbers", len(s.Coords))
+		}
+		if s.Coords[0] == s.Coords[2] && s.Coords[1] == s.Coords[3] {
+			return errors.New("line has no length")
+		}
+
+	case MAPAREA_POLY:
+		if len(s.Coords)%2 != 0 || len(s.Coords) < 6 {
+			return errors.Errorf("poly needs at least three vertices instead of %d numbers", len(s.Coords))
+		}
+		n := len(s.Coords) / 2
+		for ii := 0; ii < n; ii++ {
+			// Sides next to each other share a vertex, so only the others are checked
+			for jj := ii + 2; jj < n; jj++ {
+				if ii == 0 && jj == n-1 {
+					continue
+				}
+				if s.sidesCross(ii, jj) {
+					return errors.Errorf("poly sides %d and %d cross", ii, jj)
+				}
+			}
+		}
+
+	default:
+		return errors.Errorf("unknown maparea shape %d", s.Type)
+	}
+	return nil
+}
+
+// Returns the vertex ii of a polygon, wrapping around
+func (s MapShape) vertex(ii int) (x, y int64) {
+	ii %= len(s.Coords) / 2
+	return int64(s.Coords[2*ii]), int64(s.Coords[2*ii+1])
+}
+
+// Returns whether side ii, from vertex ii to vertex ii+1, crosses or touches side jj
+func (s MapShape) sidesCross(ii, jj int) bool {
+	ax, ay := s.vertex(ii)
+	bx, by := s.vertex(ii + 1)
+	cx, cy := s.vertex(jj)
+	dx, dy := s.vertex(jj + 1)
+
+	// Sign of the turn from (px,py) to (qx,qy) around (ox,oy)
+	turn := func(ox, oy, px, py, qx, qy int64) int64 {
+		cross := (px-ox)*(qy-oy) - (py-oy)*(qx-ox)
+		switch {
+		case cross > 0:
+			return 1
+		case cross < 0:
+			return -1
+		}
+		return 0
+	}
+	// Whether (px,py), on the line through (ox,oy) and (qx,qy), is between them
+	between := func(ox, oy, px, py, qx, qy int64) bool {
+		return px >= min64(ox, qx) && px <= max64(ox, qx) && py >= min64(oy, qy) && py <= max64(oy, qy)
+	}
+
+	t1, t2 := turn(ax, ay, bx, by, cx, cy), turn(ax, ay, bx, by, dx, dy)
+	t3, t4 := turn(cx, cy, dx, dy, ax, ay), turn(cx, cy, dx, dy, bx, by)
+	if t1*t2 < 0 && t3*t4 < 0 {
+		return true
+	}
+	return (t1 == 0 && between(ax, ay, cx, cy, bx, by)) ||
+		(t2 == 0 && between(ax, ay, dx, dy, bx, by)) ||
+		(t3 == 0 && between(cx, cy, ax, ay, dx, dy)) ||
+		(t4 == 0 && between(cx, cy, bx, by, dx, dy))
+}
+
+func min64(x, y int64) int64 {
+	if x < y {
+		return x
+	}
+	return y
+}
+
+func max64(x, y int64) int64 {
+	if x > y {
+		return x
+	}
+	return y
+}
+
+// Bounds returns the smallest rectangle enclosing the shape.
+// The shape should be valid.
+func (s MapShape) Bounds() image.Rect {
+	switch s.Type {
+	case MAPAREA_RECT, MAPAREA_OVAL, MAPAREA_TEXT:
+		return image.NewRect(int32(s.Coords[0]), int32(s.Coords[1]), int32(s.Coords[2]), int32(s.Coords[3]))
+	}
+	var retval image.Rect
+	for ii := 0; ii+1 < len(s.Coords); ii += 2 {
+		retval = retval.Recthull(image.NewRect(int32(s.Coords[ii]), int32(s.Coords[ii+1]), 1, 1))
+	}
+	return retval
+}
+
+// Contains returns whether the point (x,y) is inside the shape.
+// Points are on a line if they are within half a pixel of it.
+// The shape should be valid.
+func (s MapShape) Contains(x, y int) bool {
+	switch s.Type {
+	case MAPAREA_RECT, MAPAREA_TEXT:
+		return s.Bounds().Contains(int32(x), int32(y))
+
+	case MAPAREA_OVAL:
+		// Compares the distance to the center with the semi-axes a and b,
+		// taking the center of the pixel (x,y)
+		a, b := float64(s.Coords[2])/2, float64(s.Coords[3])/2
+		dx := (float64(x) + 0.5 - float64(s.Coords[0]) - a) / a
+		dy := (float64(y) + 0.5 - float64(s.Coords[1]) - b) / b
+		return dx*dx+dy*dy <= 1
+
+	case MAPAREA_POLY:
+		// Counts the sides crossed by a ray going right from the center of the pixel
+		px, py := float64(x)+0.5, float64(y)+0.5
+		inside := false
+		n := len(s.Coords) / 2
+		for ii := 0; ii < n; ii++ {
+			x0, y0 := s.vertex(ii)
+			x1, y1 := s.vertex(ii + 1)
+			if (float64(y0) > py) == (float64(y1) > py) {
+				continue
+			}
+			cross := float64(x0) + (py-float64(y0))*float64(x1-x0)/float64(y1-y0)
+			if px < cross {
+				inside = !inside
+			}
+		}
+		return inside
+
+	case MAPAREA_LINE:
+		return s.nearLine(x, y, 1)
+	}
+	return false
+}
+
+// Returns whether the point (x,y) is within width/2 of a line
+func (s MapShape) nearLine(x, y int, width int) bool {
+	if width < 1 {
+		width = 1
+	}
+	x0, y0 := float64(s.Coords[0]), float64(s.Coords[1])
+	dx, dy := float64(s.Coords[2])-x0, float64(s.Coords[3])-y0
+	px, py := float64(x)-x0, float64(y)-y0
+
+	// Projects the point on the line, staying between its ends
+	t := math.Max(0, math.Min(1, (px*dx+py*dy)/(dx*dx+dy*dy)))
+	return math.Hypot(px-t*dx, py-t*dy) <= float64(width)/2
+}
+
+// Map returns the shape with its points mapped by mapper,
+// such as when the page is rotated or scaled for display.
+// Rectangles, ovals and text areas stay upright,
+// their corners mapped through mapper.MapRect.
+func (s MapShape) Map(mapper *image.RectMapper) MapShape {
+	retval := MapShape{Type: s.Type, Coords: make([]int, len(s.Coords))}
+	switch s.Type {
+	case MAPAREA_RECT, MAPAREA_OVAL, MAPAREA_TEXT:
+		r := mapper.MapRect(s.Bounds())
+		copy(retval.Coords, []int{int(r.Xmin()), int(r.Ymin()), int(r.Width()), int(r.Height())})
+	default:
+		for ii := 0; ii+1 < len(s.Coords); ii += 2 {
+			x, y := mapper.Map(int32(s.Coords[ii]), int32(s.Coords[ii+1]))
+			retval.Coords[ii], retval.Coords[ii+1] = int(x), int(y)
+		}
+	}
+	return retval
+}
@@ -0,0 +1,108 @@
+package djvu
+
+import (
+	"testing"
+
+	"github.com/janreggie/go-djvulibre/djvu/image"
+	"github.com/stretchr/testify/suite"
+)
+
+type MapAreaTestSuite struct {
+	suite.Suite
+}
+
+func TestMapAreaSuite(t *testing.T) {
+	suite.Run(t, new(MapAreaTestSuite))
+}
+
+func (s *MapAreaTestSuite) parse(text string) *MapArea {
+	area, err := ParseMapArea(text)
+	s.Require().NoError(err, text)
+	return area
+}
+
+func (s *MapAreaTestSuite) TestParseFormat() {
+	for _, text := range []string{
+		`(maparea "http://example.com/" "Example" (rect 10 20 100 50) (xor))`,
+		`(maparea (url "#5" "_self") "" (oval 0 0 30 10) (border #00FF00) (border_avis))`,
+		`(maparea "" "Triangle" (poly 0 0 100 0 50 80) (hilite #FFFF00) (opacity 25))`,
+		`(maparea "" "" (line 0 0 50 50) (arrow) (width 3) (lineclr #0000FF))`,
+		`(maparea "" "Note" (text 5 5 80 20) (shadow_out 4) (pushpin))`,
+	} {
+		formatted, err := s.parse(text).Format()
+		s.NoError(err)
+		s.Equal(text, formatted)
+	}
+
+	for _, text := range []string{
+		`(zoom page)`,
+		`(maparea "" "" (rect 1 2 3 4)) (xor)`,
+		`(maparea "" "" (rect 1 2 3))`,
+		`(maparea "" "" (rect 1 2 -3 4))`,
+		`(maparea "" "" (oval 1 2 0 4))`,
+		`(maparea "" "" (line 1 2 1 2))`,
+		`(maparea "" "" (poly 0 0 10 10))`,
+		`(maparea "" "" (poly 0 0 10 10 20))`,
+		`(maparea "" "" (poly 0 0 10 10 10 0 0 10))`,
+		`(maparea "" "" (poly 0 0 10 0 20 0 10 10 10 0))`,
+	} {
+		_, err := ParseMapArea(text)
+		s.Error(err, text)
+	}
+
+	_, err := (&MapArea{Shape: MapShape{Type: MAPAREA_POLY, Coords: []int{0, 0, 1, 1}}}).Format()
+	s.Error(err)
+}
+
+func (s *MapAreaTestSuite) TestContains() {
+	rect := s.parse(`(maparea "" "" (rect 10 20 100 50))`)
+	s.True(rect.Contains(10, 20))
+	s.True(rect.Contains(109, 69))
+	s.False(rect.Contains(110, 69))
+	s.False(rect.Contains(9, 30))
+
+	oval := s.parse(`(maparea "" "" (oval 0 0 100 50))`)
+	s.True(oval.Contains(50, 25))
+	s.True(oval.Contains(1, 25))
+	s.False(oval.Contains(5, 5))
+	s.False(oval.Contains(99, 49))
+
+	// A concave polygon shaped like a U
+	poly := s.parse(`(maparea "" "" (poly 0 0 30 0 30 30 20 30 20 10 10 10 10 30 0 30))`)
+	s.True(poly.Contains(5, 25))
+	s.True(poly.Contains(25, 25))
+	s.True(poly.Contains(15, 5))
+	s.False(poly.Contains(15, 20))
+	s.False(poly.Contains(35, 5))
+
+	line := s.parse(`(maparea "" "" (line 0 0 100 0) (width 6))`)
+	s.True(line.Contains(50, 3))
+	s.True(line.Contains(102, 0))
+	s.False(line.Contains(50, 4))
+	s.False(line.Contains(-5, 0))
+	s.True(line.Shape.Contains(50, 0))
+	s.False(line.Shape.Contains(50, 1))
+
+	s.Equal(image.NewRect(0, 0, 31, 31), poly.Shape.Bounds())
+	s.Equal(image.NewRect(10, 20, 100, 50), rect.Shape.Bounds())
+}
+
+func (s *MapAreaTestSuite) TestMap() {
+	// A page of 100x200 turned a quarter counter-clockwise
+	mapper, err := image.NewRectMapper(image.NewRect(0, 0, 100, 200), image.NewRect(0, 0, 200, 100))
+	s.Require().NoError(err)
+	mapper.Rotate(1)
+
+	rect := MapShape{Type: MAPAREA_RECT, Coords: []int{10, 20, 30, 40}}
+	s.Equal(MapShape{Type: MAPAREA_RECT, Coords: []int{140, 10, 40, 30}}, rect.Map(mapper))
+
+	poly := MapShape{Type: MAPAREA_POLY, Coords: []int{0, 0, 100, 0, 100, 200}}
+	s.Equal(MapShape{Type: MAPAREA_POLY, Coords: []int{200, 0, 200, 100, 0, 100}}, poly.Map(mapper))
+
+	// The same page shown at half its size
+	mapper, err = image.NewRectMapper(image.NewRect(0, 0, 100, 200), image.NewRect(0, 0, 50, 100))
+	s.Require().NoError(err)
+	s.Equal(MapShape{Type: MAPAREA_RECT, Coords: []int{5, 10, 15, 20}}, rect.Map(mapper))
+	line := MapShape{Type: MAPAREA_LINE, Coords: []int{0, 0, 100, 50}}
+	s.Equal(MapShape{Type: MAPAREA_LINE, Coords: []int{0, 0, 50, 25}}, line.Map(mapper))
+}
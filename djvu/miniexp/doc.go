@@ -0,0 +1,16 @@
+// Package miniexp reads and prints the Lisp S-expressions
+// used throughout DjVu for annotations, hidden text and outlines
+// (see the `djvused` manual).
+//
+// An expression is a Symbol, a String, an Int, a Float or a List:
+//
+//     (maparea "http://example.com" "Example" (rect 10 10 100 50) (xor))
+//
+// Symbols are runs of characters other than spaces, parentheses and double quotes,
+// or any text between vertical bars such as `|two words|`.
+// Strings use the C escapes, including octal escapes like `\003`.
+// Comments start with a semicolon and run until the end of the line.
+//
+// Marshal and Unmarshal map expressions to Go values
+// following the struct tags described in Unmarshal.
+package miniexp
@@ -0,0 +1,157 @@
+package miniexp
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Expr is an S-expression:
+// a Symbol, a String, an Int, a Float or a List.
+// String returns the expression as it is written.
+type Expr interface {
+	String() string
+	expr()
+}
+
+// Symbol is a name, such as `maparea` or `#FF0000`
+type Symbol string
+
+// String is a double quoted string, holding UTF-8 text
+type String string
+
+// Int is an integer
+type Int int
+
+// Float is a number which is not an integer
+type Float float64
+
+// List is a list of expressions.
+// The empty list, written `()`, is the nil List.
+type List []Expr
+
+func (Symbol) expr() {}
+func (String) expr() {}
+func (Int) expr()    {}
+func (Float) expr()  {}
+func (List) expr()   {}
+
+// Symbols which need not be quoted are made of these characters
+func isSymbolChar(c byte) bool {
+	return c > ' ' && c < 0x7f && !strings.ContainsRune(`()"|;\`, rune(c))
+}
+
+func (s Symbol) String() string {
+	plain := len(s) > 0
+	for ii := 0; ii < len(s) && plain; ii++ {
+		plain = isSymbolChar(s[ii])
+	}
+	if plain {
+		if _, isNumber := parseNumber(string(s)); !isNumber {
+			return string(s)
+		}
+	}
+	return quote(string(s), '|')
+}
+
+func (s String) String() string { return quote(string(s), '"') }
+
+func (n Int) String() string { return strconv.Itoa(int(n)) }
+
+// Floats are always written with a decimal point or an exponent,
+// so that they are read back as Floats.
+func (f Float) String() string {
+	s := strconv.FormatFloat(float64(f), 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
+func (l List) String() string {
+	var sb strings.Builder
+	sb.WriteByte('(')
+	for ii, e := range l {
+		if ii > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(e.String())
+	}
+	sb.WriteByte(')')
+	return sb.String()
+}
+
+// Head returns the symbol starting the list,
+// such as `zoom` in `(zoom page)`, or an empty Symbol.
+func (l List) Head() Symbol {
+	if len(l) == 0 {
+		return ""
+	}
+	head, _ := l[0].(Symbol)
+	return head
+}
+
+// Args returns the elements of the list after its head.
+func (l List) Args() List {
+	if len(l) == 0 {
+		return nil
+	}
+	return l[1:]
+}
+
+// Call returns the list `(name args...)`.
+func Call(name string, args ...Expr) List {
+	return append(List{Symbol(name)}, args...)
+}
+
+// Quotes s between delimiters with C-like escapes.
+// Valid UTF-8 is kept as is, and other bytes are written as octal escapes.
+func quote(s string, delim byte) string {
+	var sb strings.Builder
+	sb.WriteByte(delim)
+	for ii := 0; ii < len(s); {
+		c := s[ii]
+		switch c {
+		case delim, '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\r':
+			sb.WriteString(`\r`)
+		default:
+			if c >= utf8.RuneSelf {
+				r, size := utf8.DecodeRuneInString(s[ii:])
+				if r != utf8.RuneError || size > 1 {
+					sb.WriteString(s[ii : ii+size])
+					ii += size
+					continue
+				}
+			}
+			if c < ' ' || c >= 0x7f {
+				sb.WriteString(`\` + strconv.FormatInt(int64(c)+0o1000, 8)[1:])
+			} else {
+				sb.WriteByte(c)
+			}
+		}
+		ii++
+	}
+	sb.WriteByte(delim)
+	return sb.String()
+}
+
+// Parses the numbers allowed in expressions
+func parseNumber(s string) (Expr, bool) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return Int(n), true
+	}
+	if !strings.ContainsAny(s, "0123456789") || strings.ContainsAny(s, "xXpP_") {
+		return nil, false // Not `inf`, `nan`, hexadecimal floats and the like
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return Float(f), true
+	}
+	return nil, false
+}
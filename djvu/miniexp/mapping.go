@@ -0,0 +1,390 @@
+package miniexp
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Unmarshal stores the expression e in the value pointed to by v.
+//
+// Strings are read from String or Symbol expressions,
+// numbers from Int or Float expressions,
+// slices from lists,
+// and maps with string keys from lists of `(key value)` entries.
+// Pointers are allocated as needed,
+// and fields of type Expr receive the expression as is.
+//
+// Structs are read from lists, following the `miniexp` tags of their fields:
+//
+//     type MapArea struct {
+//         _       struct{} `miniexp:"maparea,head"` // The list starts with the symbol `maparea`
+//         URL     string                           // Positional elements, in the order of the fields
+//         Comment string
+//         Border  *Border  `miniexp:"border"`      // An element `(border ...)` anywhere after them
+//         Arrow   bool     `miniexp:"arrow"`       // Whether there is an element `(arrow)`
+//         Rest    []Expr   `miniexp:",rest"`       // All the elements which are left
+//         Skipped int      `miniexp:"-"`
+//     }
+//
+// Positional fields which are pointers are optional.
+// The arguments of named elements are read as a whole when the field is a slice or a struct,
+// and as a single value otherwise.
+// Elements which are not stored in any field are an error unless there is a `rest` field.
+func Unmarshal(e Expr, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("miniexp: Unmarshal needs a non-nil pointer")
+	}
+	return unmarshal(e, rv.Elem())
+}
+
+// Marshal returns the expression of v,
+// following the rules of Unmarshal.
+// Named struct fields holding zero values are left out.
+func Marshal(v interface{}) (Expr, error) {
+	return marshal(reflect.ValueOf(v))
+}
+
+var exprType = reflect.TypeOf((*Expr)(nil)).Elem()
+
+// Describes the `miniexp` tag of a struct field
+type fieldInfo struct {
+	index int
+	name  string
+	head  bool
+	rest  bool
+}
+
+func structFields(t reflect.Type) (fields []fieldInfo, err error) {
+	for ii := 0; ii < t.NumField(); ii++ {
+		f := t.Field(ii)
+		tag := f.Tag.Get("miniexp")
+		if tag == "-" || (f.PkgPath != "" && !strings.Contains(tag, ",head")) {
+			continue // Skipped or unexported
+		}
+		info := fieldInfo{index: ii}
+		parts := strings.Split(tag, ",")
+		info.name = parts[0]
+		for _, opt := range parts[1:] {
+			switch opt {
+			case "head":
+				info.head = true
+			case "rest":
+				info.rest = true
+			default:
+				return nil, errors.Errorf("miniexp: unknown option %q in tag of %s.%s", opt, t, f.Name)
+			}
+		}
+		if info.head && (info.name == "" || ii != 0) {
+			return nil, errors.Errorf("miniexp: the head of %s must be the first field and have a name", t)
+		}
+		fields = append(fields, info)
+	}
+	return fields, nil
+}
+
+func typeError(e Expr, v reflect.Value) error {
+	return errors.Errorf("miniexp: cannot store %s in %s", e, v.Type())
+}
+
+func unmarshal(e Expr, v reflect.Value) error {
+	if v.Type() == exprType {
+		v.Set(reflect.ValueOf(&e).Elem())
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return unmarshal(e, v.Elem())
+
+	case reflect.String:
+		switch e := e.(type) {
+		case String:
+			v.SetString(string(e))
+		case Symbol:
+			v.SetString(string(e))
+		default:
+			return typeError(e, v)
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := e.(Int)
+		if !ok || v.OverflowInt(int64(n)) {
+			return typeError(e, v)
+		}
+		v.SetInt(int64(n))
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, ok := e.(Int)
+		if !ok || n < 0 || v.OverflowUint(uint64(n)) {
+			return typeError(e, v)
+		}
+		v.SetUint(uint64(n))
+
+	case reflect.Float32, reflect.Float64:
+		switch e := e.(type) {
+		case Int:
+			v.SetFloat(float64(e))
+		case Float:
+			v.SetFloat(float64(e))
+		default:
+			return typeError(e, v)
+		}
+
+	case reflect.Slice:
+		l, ok := e.(List)
+		if !ok {
+			return typeError(e, v)
+		}
+		return unmarshalSlice(l, v)
+
+	case reflect.Map:
+		l, ok := e.(List)
+		if !ok || v.Type().Key().Kind() != reflect.String {
+			return typeError(e, v)
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		for _, entry := range l {
+			entry, ok := entry.(List)
+			if !ok || entry.Head() == "" || len(entry) != 2 {
+				return errors.Errorf("miniexp: expected a (key value) entry instead of %s", entry)
+			}
+			value := reflect.New(v.Type().Elem()).Elem()
+			if err := unmarshal(entry[1], value); err != nil {
+				return err
+			}
+			v.SetMapIndex(reflect.ValueOf(string(entry.Head())).Convert(v.Type().Key()), value)
+		}
+
+	case reflect.Struct:
+		l, ok := e.(List)
+		if !ok {
+			return typeError(e, v)
+		}
+		return unmarshalStruct(l, v)
+
+	default:
+		return typeError(e, v)
+	}
+	return nil
+}
+
+func unmarshalSlice(l List, v reflect.Value) error {
+	slice := reflect.MakeSlice(v.Type(), len(l), len(l))
+	for ii, elem := range l {
+		if err := unmarshal(elem, slice.Index(ii)); err != nil {
+			return err
+		}
+	}
+	v.Set(slice)
+	return nil
+}
+
+func unmarshalStruct(l List, v reflect.Value) error {
+	fields, err := structFields(v.Type())
+	if err != nil {
+		return err
+	}
+	elems := l
+	var rest *fieldInfo
+	var named []fieldInfo
+	for ii := range fields {
+		f := fields[ii]
+		field := v.Field(f.index)
+		switch {
+		case f.head:
+			if l.Head() != Symbol(f.name) {
+				return errors.Errorf("miniexp: expected a list starting with %s instead of %s", Symbol(f.name), l)
+			}
+			elems = elems[1:]
+		case f.rest:
+			rest = &fields[ii]
+		case f.name != "":
+			named = append(named, f)
+		case len(elems) == 0:
+			if field.Kind() != reflect.Ptr {
+				return errors.Errorf("miniexp: missing %s in %s", v.Type().Field(f.index).Name, l)
+			}
+		default:
+			if err := unmarshal(elems[0], field); err != nil {
+				return err
+			}
+			elems = elems[1:]
+		}
+	}
+
+	var left List
+	for _, elem := range elems {
+		sub, _ := elem.(List)
+		found := false
+		for _, f := range named {
+			if sub.Head() == Symbol(f.name) {
+				if err := unmarshalNamed(sub.Args(), v.Field(f.index)); err != nil {
+					return err
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			left = append(left, elem)
+		}
+	}
+	if rest != nil {
+		return unmarshalSlice(left, v.Field(rest.index))
+	}
+	if len(left) > 0 {
+		return errors.Errorf("miniexp: unexpected %s in %s", left[0], l)
+	}
+	return nil
+}
+
+// Stores the arguments of a named element
+func unmarshalNamed(args List, v reflect.Value) error {
+	t := v.Type()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t.Kind() == reflect.Bool:
+		if len(args) != 0 {
+			return errors.Errorf("miniexp: unexpected %s", args)
+		}
+		for v.Kind() == reflect.Ptr {
+			v.Set(reflect.New(v.Type().Elem()))
+			v = v.Elem()
+		}
+		v.SetBool(true)
+		return nil
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Struct || t.Kind() == reflect.Map:
+		return unmarshal(args, v)
+	case len(args) != 1:
+		return errors.Errorf("miniexp: expected a single value instead of %s", args)
+	}
+	return unmarshal(args[0], v)
+}
+
+func marshal(v reflect.Value) (Expr, error) {
+	if v.Type() == exprType {
+		if v.IsNil() {
+			return List(nil), nil
+		}
+		return v.Interface().(Expr), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return List(nil), nil
+		}
+		return marshal(v.Elem())
+	case reflect.String:
+		if v.Type() == reflect.TypeOf(Symbol("")) {
+			return Symbol(v.String()), nil
+		}
+		return String(v.String()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return Int(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Int(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return Float(v.Float()), nil
+	case reflect.Slice, reflect.Array:
+		l := make(List, v.Len())
+		for ii := range l {
+			elem, err := marshal(v.Index(ii))
+			if err != nil {
+				return nil, err
+			}
+			l[ii] = elem
+		}
+		return l, nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			break
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		l := make(List, len(keys))
+		for ii, key := range keys {
+			value, err := marshal(v.MapIndex(key))
+			if err != nil {
+				return nil, err
+			}
+			l[ii] = List{Symbol(key.String()), value}
+		}
+		return l, nil
+	case reflect.Struct:
+		return marshalStruct(v)
+	}
+	return nil, errors.Errorf("miniexp: cannot marshal %s", v.Type())
+}
+
+func marshalStruct(v reflect.Value) (Expr, error) {
+	fields, err := structFields(v.Type())
+	if err != nil {
+		return nil, err
+	}
+	var l, named, rest List
+	for _, f := range fields {
+		field := v.Field(f.index)
+		switch {
+		case f.head:
+			l = append(l, Symbol(f.name))
+		case f.rest:
+			e, err := marshal(field)
+			if err != nil {
+				return nil, err
+			}
+			rest = e.(List)
+		case f.name != "":
+			if field.IsZero() {
+				continue
+			}
+			args, err := marshalNamed(field)
+			if err != nil {
+				return nil, err
+			}
+			named = append(named, append(List{Symbol(f.name)}, args...))
+		case field.Kind() == reflect.Ptr && field.IsNil():
+		default:
+			e, err := marshal(field)
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, e)
+		}
+	}
+	l = append(l, named...)
+	return append(l, rest...), nil
+}
+
+// Returns the arguments of a named element
+func marshalNamed(v reflect.Value) (List, error) {
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return nil, nil
+	case reflect.Slice, reflect.Struct, reflect.Map:
+		e, err := marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return e.(List), nil
+	}
+	e, err := marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return List{e}, nil
+}
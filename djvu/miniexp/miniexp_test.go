@@ -0,0 +1,196 @@
+package miniexp
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type MiniexpTestSuite struct {
+	suite.Suite
+}
+
+func TestMiniexpSuite(t *testing.T) {
+	suite.Run(t, new(MiniexpTestSuite))
+}
+
+func (s *MiniexpTestSuite) parse(text string) Expr {
+	e, err := ParseString(text)
+	s.Require().NoError(err, text)
+	return e
+}
+
+func (s *MiniexpTestSuite) TestRead() {
+	s.Equal(List{
+		Symbol("maparea"), String("http://example.com"), String(""),
+		List{Symbol("rect"), Int(10), Int(-20), Int(100), Int(50)},
+		List{Symbol("border"), Symbol("#FF0000")},
+		List{Symbol("opacity"), Float(0.5)},
+		List(nil),
+	}, s.parse(`(maparea "http://example.com" "" (rect 10 -20 100 50) (border #FF0000) (opacity .5) ())`))
+
+	for text, want := range map[string]Expr{
+		`"a\"b\\c\n\td"`:               String("a\"b\\c\n\td"),
+		`"\101\x42é"`:                  String("ABé"),
+		`"\0012"`:                      String("\x012"),
+		`"caf` + "é" + `"`:             String("café"),
+		`"line\` + "\n" + `continued"`: String("linecontinued"),
+		`|two words|`:                  Symbol("two words"),
+		`|a\|b|`:                       Symbol("a|b"),
+		`1e3`:                          Float(1000),
+		`-0.25`:                        Float(-0.25),
+		`+7`:                           Int(7),
+		`1.2.3`:                        Symbol("1.2.3"),
+		`inf`:                          Symbol("inf"),
+		`0x10`:                         Symbol("0x10"),
+		`d300`:                         Symbol("d300"),
+		`sym` + "é":                    Symbol("symé"),
+		"( a ; comment )\n b)":         List{Symbol("a"), Symbol("b")},
+	} {
+		s.Equal(want, s.parse(text), text)
+	}
+}
+
+func (s *MiniexpTestSuite) TestReadErrors() {
+	for _, text := range []string{`(a b`, `"unterminated`, `)`, `a\b`, `|sym`, `"\xzz"`} {
+		_, err := ParseString(text)
+		s.Error(err, text)
+	}
+	_, err := ParseString(`a b`)
+	s.ErrorIs(err, ErrSyntax)
+
+	r := NewReader(strings.NewReader("(a) b ; end"))
+	e, err := r.Read()
+	s.NoError(err)
+	s.Equal(List{Symbol("a")}, e)
+	e, err = r.Read()
+	s.NoError(err)
+	s.Equal(Symbol("b"), e)
+	_, err = r.Read()
+	s.Equal(io.EOF, err)
+
+	exprs, err := Parse([]byte("(zoom page)\n\x00\x00(garbage"))
+	s.NoError(err)
+	s.Len(exprs, 1)
+}
+
+func (s *MiniexpTestSuite) TestPrint() {
+	for _, e := range []Expr{
+		String("a\"b\\c\n\x03é\xff"),
+		Symbol("two words"),
+		Symbol("12"),
+		Symbol(""),
+		Symbol("a|b"),
+		Symbol("#FF0000"),
+		Float(2),
+		Float(-0.125),
+		Int(-3),
+		List{Symbol("a"), List(nil), String("x")},
+	} {
+		s.Equal(e, s.parse(e.String()), e.String())
+	}
+	s.Equal(`"a\"b\\c\n\003é\377"`, String("a\"b\\c\n\x03é\xff").String())
+	s.Equal(`|two words|`, Symbol("two words").String())
+	s.Equal(`|12|`, Symbol("12").String())
+	s.Equal(`2.0`, Float(2).String())
+	s.Equal(`(a () "x")`, List{Symbol("a"), List(nil), String("x")}.String())
+	s.Equal(Symbol("maparea"), Call("maparea", String("u")).Head())
+	s.Equal(List{String("u")}, Call("maparea", String("u")).Args())
+	s.Equal(Symbol(""), List{String("x")}.Head())
+}
+
+type testBorder struct {
+	Color Symbol
+	Width *int
+}
+
+type testArea struct {
+	_       struct{} `miniexp:"maparea,head"`
+	URL     string
+	Comment string
+	Shape   []Expr
+	Border  *testBorder       `miniexp:"border"`
+	Arrow   bool              `miniexp:"arrow"`
+	Width   int               `miniexp:"width"`
+	Meta    map[string]string `miniexp:"meta"`
+	Rest    []Expr            `miniexp:",rest"`
+	Skipped int               `miniexp:"-"`
+}
+
+type testPoint struct {
+	X, Y float64
+	Name *string
+}
+
+func (s *MiniexpTestSuite) TestUnmarshal() {
+	var area testArea
+	s.Require().NoError(Unmarshal(s.parse(
+		`(maparea "url" "comment" (rect 1 2 3 4) (arrow) (custom) (border #FF0000 2) (meta (a "1") (b "2")) (width 3))`), &area))
+	width := 2
+	s.Equal(testArea{
+		URL:     "url",
+		Comment: "comment",
+		Shape:   []Expr{Symbol("rect"), Int(1), Int(2), Int(3), Int(4)},
+		Border:  &testBorder{Color: "#FF0000", Width: &width},
+		Arrow:   true,
+		Width:   3,
+		Meta:    map[string]string{"a": "1", "b": "2"},
+		Rest:    []Expr{List{Symbol("custom")}},
+	}, area)
+
+	var point testPoint
+	s.NoError(Unmarshal(s.parse(`(1 2.5)`), &point))
+	s.Equal(testPoint{X: 1, Y: 2.5}, point)
+
+	var points []testPoint
+	s.NoError(Unmarshal(s.parse(`((1 2 "a") (3 4))`), &points))
+	name := "a"
+	s.Equal([]testPoint{{1, 2, &name}, {3, 4, nil}}, points)
+
+	for text, v := range map[string]interface{}{
+		`(rect 1 2)`:                     &testArea{},
+		`(maparea "url")`:                &testArea{},
+		`(maparea "url" "c" x)`:          &testArea{},
+		`(1)`:                            &testPoint{},
+		`(1 2 "a" 4)`:                    &testPoint{},
+		`(1 x)`:                          &testPoint{},
+		`300`:                            new(uint8),
+		`-1`:                             new(uint),
+		`"x"`:                            new(int),
+		`(maparea "u" "c" () (arrow 1))`: &testArea{},
+		`((a))`:                          &map[string]string{},
+	} {
+		s.Error(Unmarshal(s.parse(text), v), text)
+	}
+	s.Error(Unmarshal(Int(1), testPoint{}))
+}
+
+func (s *MiniexpTestSuite) TestMarshal() {
+	width := 2
+	area := testArea{
+		URL:     "url",
+		Shape:   []Expr{Symbol("oval"), Int(1), Int(2), Int(3), Int(4)},
+		Border:  &testBorder{Color: "#00FF00", Width: &width},
+		Arrow:   true,
+		Meta:    map[string]string{"b": "2", "a": "1"},
+		Rest:    []Expr{List{Symbol("custom")}},
+		Skipped: 1,
+	}
+	e, err := Marshal(area)
+	s.Require().NoError(err)
+	s.Equal(`(maparea "url" "" (oval 1 2 3 4) (border #00FF00 2) (arrow) (meta (a "1") (b "2")) (custom))`, e.String())
+
+	var back testArea
+	s.NoError(Unmarshal(e, &back))
+	area.Skipped = 0
+	s.Equal(area, back)
+
+	e, err = Marshal([]testPoint{{X: 1, Y: 0.5}})
+	s.NoError(err)
+	s.Equal(`((1.0 0.5))`, e.String())
+
+	_, err = Marshal(struct{ C chan int }{})
+	s.Error(err)
+}
@@ -0,0 +1,256 @@
+package miniexp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// ErrSyntax is returned for text which is not a valid expression
+var ErrSyntax = errors.New("miniexp: syntax error")
+
+// Reader reads expressions one after the other.
+type Reader struct {
+	r      *bufio.Reader
+	offset int64
+}
+
+// NewReader returns a Reader of the expressions in r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Parse returns all the expressions in data.
+// Parsing stops at the first NUL byte,
+// which some encoders leave at the end of annotation chunks.
+func Parse(data []byte) ([]Expr, error) {
+	if ii := bytes.IndexByte(data, 0); ii >= 0 {
+		data = data[:ii]
+	}
+	r := NewReader(bytes.NewReader(data))
+	var retval []Expr
+	for {
+		e, err := r.Read()
+		if err == io.EOF {
+			return retval, nil
+		}
+		if err != nil {
+			return retval, err
+		}
+		retval = append(retval, e)
+	}
+}
+
+// ParseString returns the single expression in s.
+func ParseString(s string) (Expr, error) {
+	exprs, err := Parse([]byte(s))
+	if err != nil {
+		return nil, err
+	}
+	if len(exprs) != 1 {
+		return nil, errors.Wrapf(ErrSyntax, "expected one expression, found %d", len(exprs))
+	}
+	return exprs[0], nil
+}
+
+// Read returns the next expression,
+// or io.EOF if there are none left.
+// Syntax errors wrap ErrSyntax.
+func (r *Reader) Read() (Expr, error) {
+	c, err := r.skipSpace()
+	if err != nil {
+		return nil, err
+	}
+	if c == ')' {
+		return nil, r.syntaxError("unexpected `)`")
+	}
+	e, err := r.read()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return e, err
+}
+
+func (r *Reader) syntaxError(msg string) error {
+	return errors.Wrapf(ErrSyntax, "%s at offset %d", msg, r.offset)
+}
+
+func (r *Reader) readByte() (byte, error) {
+	c, err := r.r.ReadByte()
+	if err == nil {
+		r.offset++
+	}
+	return c, err
+}
+
+func (r *Reader) unreadByte() {
+	r.r.UnreadByte()
+	r.offset--
+}
+
+// Skips spaces and comments, and returns the next byte without consuming it
+func (r *Reader) skipSpace() (byte, error) {
+	for {
+		c, err := r.readByte()
+		if err != nil {
+			return 0, err
+		}
+		switch c {
+		case ' ', '\t', '\n', '\r', '\f', '\v':
+		case ';':
+			line, err := r.r.ReadString('\n')
+			r.offset += int64(len(line))
+			if err != nil {
+				return 0, err
+			}
+		default:
+			r.unreadByte()
+			return c, nil
+		}
+	}
+}
+
+// Reads the expression starting at the next byte, which is not a space
+func (r *Reader) read() (Expr, error) {
+	c, err := r.readByte()
+	if err != nil {
+		return nil, err
+	}
+	switch c {
+	case '(':
+		var l List
+		for {
+			c, err := r.skipSpace()
+			if err != nil {
+				return nil, err
+			}
+			if c == ')' {
+				r.readByte()
+				return l, nil
+			}
+			e, err := r.read()
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, e)
+		}
+	case '"':
+		s, err := r.readQuoted('"')
+		return String(s), err
+	case '|':
+		s, err := r.readQuoted('|')
+		return Symbol(s), err
+	}
+
+	var sb strings.Builder
+	for {
+		if c == '\\' || c == '|' {
+			return nil, r.syntaxError("unexpected `" + string(c) + "`")
+		}
+		sb.WriteByte(c)
+		c, err = r.readByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !isSymbolChar(c) && c < utf8.RuneSelf {
+			r.unreadByte()
+			break
+		}
+	}
+	if n, ok := parseNumber(sb.String()); ok {
+		return n, nil
+	}
+	return Symbol(sb.String()), nil
+}
+
+// Reads until delim, expanding escapes
+func (r *Reader) readQuoted(delim byte) (string, error) {
+	var sb strings.Builder
+	for {
+		c, err := r.readByte()
+		if err != nil {
+			return "", err
+		}
+		if c == delim {
+			return sb.String(), nil
+		}
+		if c != '\\' {
+			sb.WriteByte(c)
+			continue
+		}
+
+		c, err = r.readByte()
+		if err != nil {
+			return "", err
+		}
+		switch c {
+		case 'n':
+			sb.WriteByte('\n')
+		case 't':
+			sb.WriteByte('\t')
+		case 'r':
+			sb.WriteByte('\r')
+		case 'b':
+			sb.WriteByte('\b')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'v':
+			sb.WriteByte('\v')
+		case 'a':
+			sb.WriteByte('\a')
+		case '\n': // Line continuation
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			n, err := r.readDigits(string(c), 8, 3)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteByte(byte(n))
+		case 'x':
+			n, err := r.readDigits("", 16, 2)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteByte(byte(n))
+		case 'u':
+			n, err := r.readDigits("", 16, 4)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteRune(rune(n))
+		default:
+			sb.WriteByte(c)
+		}
+	}
+}
+
+// Reads up to max digits in base, after those in prefix
+func (r *Reader) readDigits(prefix string, base int, max int) (int, error) {
+	digits := prefix
+	for len(digits) < max {
+		c, err := r.readByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		if _, err := strconv.ParseUint(string(c), base, 8); err != nil {
+			r.unreadByte()
+			break
+		}
+		digits += string(c)
+	}
+	n, err := strconv.ParseUint(digits, base, 32)
+	if err != nil {
+		return 0, r.syntaxError("bad escape sequence")
+	}
+	return int(n), nil
+}
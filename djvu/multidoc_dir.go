@@ -0,0 +1,356 @@
+package djvu
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+
+	"github.com/janreggie/go-djvulibre/djvu/bzz"
+	"github.com/pkg/errors"
+)
+
+// MultiDir implements the DjVu multipage document directory,
+// which is stored in the DIRM chunk at the start of a FORM:DJVM.
+//
+// The directory lists every file composing the document,
+// both pages and included files,
+// in the order they appear in a bundled document.
+// For bundled documents, it also records the offset of every file.
+type MultiDir struct {
+	files   []*MultiDirFile
+	bundled bool
+}
+
+// MultiDirFileType tells what a file of a multipage document is for.
+type MultiDirFileType uint8
+
+const (
+	// File included by other files with an INCL chunk
+	FILE_INCLUDE MultiDirFileType = iota
+	// Page of the document
+	FILE_PAGE
+	// Thumbnails of pages
+	FILE_THUMBNAILS
+	// Annotations shared by all pages
+	FILE_SHARED_ANNO
+)
+
+// Version of the directory format written by Encode
+const multiDirVersion = 1
+
+// Flags of each file record
+const (
+	multiDirTypeMask = 0x3f
+	multiDirHasName  = 0x80
+	multiDirHasTitle = 0x40
+)
+
+// MultiDirFile describes a file of a multipage document.
+type MultiDirFile struct {
+	// Identifier used by INCL chunks, unique in the document.
+	// Also the name the file is loaded from in an indirect document.
+	ID string
+	// Name the file is saved under.
+	// Empty if the same as ID.
+	Name string
+	// Title of the page, shown by viewers.
+	// Empty if the same as ID.
+	Title string
+
+	Type MultiDirFileType
+
+	// Offset of the file in a bundled document
+	Offset int
+	// Size of the file in a bundled document
+	Size int
+}
+
+// IsPage returns whether the file is a page.
+func (f *MultiDirFile) IsPage() bool {
+	return f.Type == FILE_PAGE
+}
+
+// GetLoadName returns the name the file is loaded from.
+func (f *MultiDirFile) GetLoadName() string {
+	return f.ID
+}
+
+// GetSaveName returns the name the file is saved under.
+func (f *MultiDirFile) GetSaveName() string {
+	if f.Name != "" {
+		return f.Name
+	}
+	return f.ID
+}
+
+// GetTitle returns the title of the file.
+func (f *MultiDirFile) GetTitle() string {
+	if f.Title != "" {
+		return f.Title
+	}
+	return f.ID
+}
+
+// NewMultiDir creates an empty directory.
+func NewMultiDir() *MultiDir { return &MultiDir{} }
+
+// IsBundled returns whether the decoded directory was for a bundled document.
+func (dir *MultiDir) IsBundled() bool {
+	return dir.bundled
+}
+
+// GetFiles returns the files, in document order.
+func (dir *MultiDir) GetFiles() []*MultiDirFile {
+	return dir.files
+}
+
+// GetFilesNum returns the number of files.
+func (dir *MultiDir) GetFilesNum() int {
+	return len(dir.files)
+}
+
+// GetPagesNum returns the number of pages.
+func (dir *MultiDir) GetPagesNum() int {
+	n := 0
+	for _, f := range dir.files {
+		if f.IsPage() {
+			n++
+		}
+	}
+	return n
+}
+
+// PageToFile returns the file of page `page` (counted from 0), or nil.
+func (dir *MultiDir) PageToFile(page int) *MultiDirFile {
+	if page < 0 {
+		return nil
+	}
+	for _, f := range dir.files {
+		if f.IsPage() {
+			if page == 0 {
+				return f
+			}
+			page--
+		}
+	}
+	return nil
+}
+
+// FileToPage returns the page number of `file`,
+// or -1 if it is not a page of this directory.
+func (dir *MultiDir) FileToPage(file *MultiDirFile) int {
+	page := 0
+	for _, f := range dir.files {
+		if f == file {
+			if f.IsPage() {
+				return page
+			}
+			return -1
+		}
+		if f.IsPage() {
+			page++
+		}
+	}
+	return -1
+}
+
+// IdToFile returns the file with the given ID, or nil.
+func (dir *MultiDir) IdToFile(id string) *MultiDirFile {
+	for _, f := range dir.files {
+		if f.ID == id {
+			return f
+		}
+	}
+	return nil
+}
+
+// NameToFile returns the file with the given save name, or nil.
+func (dir *MultiDir) NameToFile(name string) *MultiDirFile {
+	for _, f := range dir.files {
+		if f.GetSaveName() == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// TitleToFile returns the file with the given title, or nil.
+func (dir *MultiDir) TitleToFile(title string) *MultiDirFile {
+	for _, f := range dir.files {
+		if f.GetTitle() == title {
+			return f
+		}
+	}
+	return nil
+}
+
+// InsertFile inserts `file` before position `pos`,
+// or at the end if `pos` is negative.
+// The ID must not be in use.
+func (dir *MultiDir) InsertFile(file *MultiDirFile, pos int) error {
+	if file.ID == "" {
+		return errors.New("file ID cannot be empty")
+	}
+	if dir.IdToFile(file.ID) != nil {
+		return errors.Errorf("file ID %q already in use", file.ID)
+	}
+	if pos < 0 || pos > len(dir.files) {
+		pos = len(dir.files)
+	}
+	dir.files = append(dir.files, nil)
+	copy(dir.files[pos+1:], dir.files[pos:])
+	dir.files[pos] = file
+	return nil
+}
+
+// DeleteFile removes the file with the given ID.
+// Does nothing if there is no such file.
+func (dir *MultiDir) DeleteFile(id string) {
+	for ii, f := range dir.files {
+		if f.ID == id {
+			dir.files = append(dir.files[:ii], dir.files[ii+1:]...)
+			return
+		}
+	}
+}
+
+// Decode decodes the contents of a DIRM chunk.
+func (dir *MultiDir) Decode(r io.Reader) error {
+	dir.files = nil
+	br := bufio.NewReader(r)
+
+	var header [3]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return errors.Wrap(err, "could not read DIRM header")
+	}
+	dir.bundled = header[0]&0x80 != 0
+	if version := header[0] & 0x7f; version > multiDirVersion {
+		return errors.Errorf("unsupported DIRM version %d", version)
+	}
+	count := int(header[1])<<8 | int(header[2])
+
+	files := make([]*MultiDirFile, count)
+	for ii := range files {
+		files[ii] = &MultiDirFile{}
+	}
+	if dir.bundled {
+		var offset [4]byte
+		for _, f := range files {
+			if _, err := io.ReadFull(br, offset[:]); err != nil {
+				return errors.Wrap(err, "could not read DIRM offsets")
+			}
+			f.Offset = int(offset[0])<<24 | int(offset[1])<<16 | int(offset[2])<<8 | int(offset[3])
+		}
+	}
+
+	// The rest is compressed
+	bs := bufio.NewReader(bzz.NewReader(br))
+	var size [3]byte
+	for _, f := range files {
+		if _, err := io.ReadFull(bs, size[:]); err != nil {
+			return errors.Wrap(err, "could not read DIRM sizes")
+		}
+		f.Size = int(size[0])<<16 | int(size[1])<<8 | int(size[2])
+	}
+	flags := make([]byte, count)
+	if _, err := io.ReadFull(bs, flags); err != nil {
+		return errors.Wrap(err, "could not read DIRM flags")
+	}
+	readString := func() (string, error) {
+		s, err := bs.ReadString(0)
+		if err != nil {
+			return "", errors.Wrap(err, "could not read DIRM names")
+		}
+		return s[:len(s)-1], nil
+	}
+	for ii, f := range files {
+		var err error
+		f.Type = MultiDirFileType(flags[ii] & multiDirTypeMask)
+		if f.ID, err = readString(); err != nil {
+			return err
+		}
+		if flags[ii]&multiDirHasName != 0 {
+			if f.Name, err = readString(); err != nil {
+				return err
+			}
+		}
+		if flags[ii]&multiDirHasTitle != 0 {
+			if f.Title, err = readString(); err != nil {
+				return err
+			}
+		}
+		if f.Name == f.ID {
+			f.Name = ""
+		}
+		if f.Title == f.ID {
+			f.Title = ""
+		}
+	}
+
+	for _, f := range files {
+		if f.ID == "" {
+			return errors.New("DIRM has a file without ID")
+		}
+		if dir.IdToFile(f.ID) != nil {
+			return errors.Errorf("DIRM has duplicate file ID %q", f.ID)
+		}
+		dir.files = append(dir.files, f)
+	}
+	return nil
+}
+
+// Encode encodes the directory into the contents of a DIRM chunk.
+// Offsets are only written for bundled documents.
+func (dir *MultiDir) Encode(w io.Writer, bundled bool) error {
+	var buf bytes.Buffer
+	version := byte(multiDirVersion)
+	if bundled {
+		version |= 0x80
+	}
+	buf.Write([]byte{version, byte(len(dir.files) >> 8), byte(len(dir.files))})
+	if bundled {
+		for _, f := range dir.files {
+			if f.Offset == 0 {
+				return errors.Errorf("file %q has no offset in a bundled document", f.ID)
+			}
+			buf.Write([]byte{byte(f.Offset >> 24), byte(f.Offset >> 16), byte(f.Offset >> 8), byte(f.Offset)})
+		}
+	}
+
+	var raw bytes.Buffer
+	for _, f := range dir.files {
+		raw.Write([]byte{byte(f.Size >> 16), byte(f.Size >> 8), byte(f.Size)})
+	}
+	for _, f := range dir.files {
+		flags := byte(f.Type) & multiDirTypeMask
+		if f.Name != "" && f.Name != f.ID {
+			flags |= multiDirHasName
+		}
+		if f.Title != "" && f.Title != f.ID {
+			flags |= multiDirHasTitle
+		}
+		raw.WriteByte(flags)
+	}
+	for _, f := range dir.files {
+		raw.WriteString(f.ID)
+		raw.WriteByte(0)
+		if f.Name != "" && f.Name != f.ID {
+			raw.WriteString(f.Name)
+			raw.WriteByte(0)
+		}
+		if f.Title != "" && f.Title != f.ID {
+			raw.WriteString(f.Title)
+			raw.WriteByte(0)
+		}
+	}
+
+	bw := bzz.NewWriter(&buf, 50)
+	if _, err := bw.Write(raw.Bytes()); err != nil {
+		return err
+	}
+	if err := bw.Close(); err != nil {
+		return err
+	}
+	_, err := w.Write(buf.Bytes())
+	return errors.Wrap(err, "could not write DIRM chunk")
+}
@@ -0,0 +1,66 @@
+package djvu
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type MultiDirTestSuite struct {
+	suite.Suite
+	Dir *MultiDir
+}
+
+func TestMultiDirSuite(t *testing.T) {
+	suite.Run(t, new(MultiDirTestSuite))
+}
+
+func (s *MultiDirTestSuite) SetupTest() {
+	s.Dir = NewMultiDir()
+	s.NoError(s.Dir.InsertFile(&MultiDirFile{ID: "shared.djvi", Type: FILE_INCLUDE, Offset: 100, Size: 10}, -1))
+	s.NoError(s.Dir.InsertFile(&MultiDirFile{ID: "p1.djvu", Title: "Cover", Type: FILE_PAGE, Offset: 110, Size: 20}, -1))
+	s.NoError(s.Dir.InsertFile(&MultiDirFile{ID: "p2.djvu", Name: "second.djvu", Type: FILE_PAGE, Offset: 130, Size: 30}, -1))
+}
+
+func (s *MultiDirTestSuite) TestLookup() {
+	s.Equal(2, s.Dir.GetPagesNum())
+	s.Equal("p2.djvu", s.Dir.PageToFile(1).ID)
+	s.Nil(s.Dir.PageToFile(2))
+	s.Equal(0, s.Dir.FileToPage(s.Dir.IdToFile("p1.djvu")))
+	s.Equal(-1, s.Dir.FileToPage(s.Dir.IdToFile("shared.djvi")))
+	s.Equal("p2.djvu", s.Dir.NameToFile("second.djvu").ID)
+	s.Equal("p1.djvu", s.Dir.TitleToFile("Cover").ID)
+	s.Equal("p2.djvu", s.Dir.PageToFile(1).GetTitle())
+
+	s.Error(s.Dir.InsertFile(&MultiDirFile{ID: "p1.djvu"}, 0))
+	s.Dir.DeleteFile("p1.djvu")
+	s.Equal(1, s.Dir.GetPagesNum())
+}
+
+func (s *MultiDirTestSuite) TestRoundTrip() {
+	for _, bundled := range []bool{true, false} {
+		var buf bytes.Buffer
+		s.NoError(s.Dir.Encode(&buf, bundled))
+
+		decoded := NewMultiDir()
+		s.NoError(decoded.Decode(&buf))
+		s.Equal(bundled, decoded.IsBundled())
+		s.Equal(len(s.Dir.GetFiles()), len(decoded.GetFiles()))
+		for ii, f := range decoded.GetFiles() {
+			expected := *s.Dir.GetFiles()[ii]
+			if !bundled {
+				expected.Offset = 0
+			}
+			s.Equal(expected, *f)
+		}
+	}
+}
+
+func (s *MultiDirTestSuite) TestErrors() {
+	s.Error(NewMultiDir().Decode(bytes.NewReader([]byte{0x81})))
+	s.Error(NewMultiDir().Decode(bytes.NewReader([]byte{0x05, 0, 0})))
+
+	s.Dir.GetFiles()[0].Offset = 0
+	s.Error(s.Dir.Encode(&bytes.Buffer{}, true))
+}
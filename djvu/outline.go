@@ -0,0 +1,297 @@
+package djvu
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/janreggie/go-djvulibre/djvu/bzz"
+	"github.com/janreggie/go-djvulibre/djvu/iff"
+	"github.com/janreggie/go-djvulibre/djvu/miniexp"
+	"github.com/pkg/errors"
+)
+
+// Outline represents the table of contents of a document,
+// as found in the `NAVM` chunk of multipage documents.
+// The `djvused` tool writes outlines as S-expressions:
+//
+//     (bookmarks
+//      ("Chapter 1" "#3"
+//       ("Section 1.1" "#4"))
+//      ("Errata" "http://www.example.com/errata"))
+type Outline struct {
+	_         struct{}    `miniexp:"bookmarks,head"`
+	Bookmarks []*Bookmark `miniexp:",rest"`
+}
+
+// Bookmark is an entry of an Outline
+type Bookmark struct {
+	Title string
+
+	// Target of the bookmark: `#` followed by a page number counted from 1
+	// or by the ID, name or title of a page, or any URL
+	URL string
+
+	Children []*Bookmark `miniexp:",rest"`
+}
+
+func NewOutline() *Outline { return &Outline{} }
+
+// IsEmpty returns whether there are no bookmarks.
+func (o *Outline) IsEmpty() bool { return len(o.Bookmarks) == 0 }
+
+// Decode decodes the contents of a `NAVM` chunk, replacing the bookmarks of o.
+// The bookmarks are compressed with BZZ,
+// and listed parents first with the number of their children.
+func (o *Outline) Decode(r io.Reader) error {
+	*o = Outline{}
+	data, err := io.ReadAll(bzz.NewReader(r))
+	if err != nil {
+		return errors.Wrap(err, "could not read outline")
+	}
+	if len(data) < 2 {
+		return errors.Wrap(io.ErrUnexpectedEOF, "could not read outline")
+	}
+	count := int(data[0])<<8 | int(data[1])
+	d := &textDecoder{data: data[2:]}
+
+	// Bookmarks missing children, innermost last
+	var parents []*Bookmark
+	var missing []int
+	for ii := 0; ii < count; ii++ {
+		children := d.read8()
+		title := d.string()
+		url := d.string()
+		if d.err != nil {
+			return errors.Wrapf(d.err, "could not read bookmark %d", ii)
+		}
+		bookmark := &Bookmark{Title: title, URL: url}
+
+		if n := len(parents); n > 0 {
+			parents[n-1].Children = append(parents[n-1].Children, bookmark)
+			missing[n-1]--
+		} else {
+			o.Bookmarks = append(o.Bookmarks, bookmark)
+		}
+		if children > 0 {
+			parents, missing = append(parents, bookmark), append(missing, children)
+		}
+		for n := len(parents); n > 0 && missing[n-1] == 0; n-- {
+			parents, missing = parents[:n-1], missing[:n-1]
+		}
+	}
+	if len(parents) > 0 {
+		return errors.Errorf("bookmark %q misses children", parents[len(parents)-1].Title)
+	}
+	return nil
+}
+
+// Reads a string preceded by its length on 24 bits
+func (d *textDecoder) string() string {
+	size := d.read24()
+	if d.err != nil {
+		return ""
+	}
+	if size > len(d.data) {
+		d.err = errors.Wrap(io.ErrUnexpectedEOF, "could not read string")
+		return ""
+	}
+	s := string(d.data[:size])
+	d.data = d.data[size:]
+	return s
+}
+
+// Encode writes the bookmarks as the contents of a `NAVM` chunk.
+func (o *Outline) Encode(w io.Writer) error {
+	e := &textEncoder{}
+	count := 0
+	var encode func(bookmarks []*Bookmark) error
+	encode = func(bookmarks []*Bookmark) error {
+		for _, bookmark := range bookmarks {
+			if len(bookmark.Children) > 0xff {
+				return errors.Errorf("bookmark %q has more than 255 children", bookmark.Title)
+			}
+			if len(bookmark.Title) >= 1<<24 || len(bookmark.URL) >= 1<<24 {
+				return errors.Errorf("bookmark %q is too long", bookmark.Title)
+			}
+			count++
+			e.write(len(bookmark.Children), 1)
+			e.write(len(bookmark.Title), 3)
+			e.data = append(e.data, bookmark.Title...)
+			e.write(len(bookmark.URL), 3)
+			e.data = append(e.data, bookmark.URL...)
+			if err := encode(bookmark.Children); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := encode(o.Bookmarks); err != nil {
+		return err
+	}
+	if count > 0xffff {
+		return errors.Errorf("outline of %d bookmarks is too long", count)
+	}
+
+	data, err := bzz.Compress(append([]byte{byte(count >> 8), byte(count)}, e.data...))
+	if err != nil {
+		return errors.Wrap(err, "could not compress outline")
+	}
+	_, err = w.Write(data)
+	return errors.Wrap(err, "could not write outline")
+}
+
+// DecodeChunk decodes a `NAVM` chunk, replacing the bookmarks of o.
+func (o *Outline) DecodeChunk(chunk *iff.Chunk) error {
+	if chunk.ID != "NAVM" {
+		return errors.Errorf("%q is not an outline chunk", chunk.ID)
+	}
+	return o.Decode(bytes.NewReader(chunk.Data))
+}
+
+// Chunk returns the bookmarks as a `NAVM` chunk.
+func (o *Outline) Chunk() (*iff.Chunk, error) {
+	var buf bytes.Buffer
+	if err := o.Encode(&buf); err != nil {
+		return nil, err
+	}
+	return &iff.Chunk{ID: "NAVM", Data: buf.Bytes()}, nil
+}
+
+// ParseOutline reads an outline written as a `bookmarks` expression.
+func ParseOutline(text string) (*Outline, error) {
+	e, err := miniexp.ParseString(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse bookmarks")
+	}
+	o := NewOutline()
+	if err := miniexp.Unmarshal(e, o); err != nil {
+		return nil, errors.Wrap(err, "bad bookmarks")
+	}
+	// Bookmarks without children have none rather than an empty list, as when decoded
+	var trim func(bookmarks []*Bookmark) []*Bookmark
+	trim = func(bookmarks []*Bookmark) []*Bookmark {
+		if len(bookmarks) == 0 {
+			return nil
+		}
+		for _, bookmark := range bookmarks {
+			bookmark.Children = trim(bookmark.Children)
+		}
+		return bookmarks
+	}
+	o.Bookmarks = trim(o.Bookmarks)
+	return o, nil
+}
+
+// Format returns the `bookmarks` expression of the outline.
+func (o *Outline) Format() (string, error) {
+	e, err := miniexp.Marshal(o)
+	if err != nil {
+		return "", err
+	}
+	return e.String(), nil
+}
+
+// Validate returns an error if a bookmark points to a page which is not in dir.
+// Targets which do not start with `#` are not checked.
+func (o *Outline) Validate(dir *MultiDir) error {
+	var validate func(bookmarks []*Bookmark) error
+	validate = func(bookmarks []*Bookmark) error {
+		for _, bookmark := range bookmarks {
+			if strings.HasPrefix(bookmark.URL, "#") && !hasTarget(dir, bookmark.URL[1:]) {
+				return errors.Errorf("bookmark %q points to %s, which is not a page", bookmark.Title, bookmark.URL)
+			}
+			if err := validate(bookmark.Children); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return validate(o.Bookmarks)
+}
+
+// Returns whether target, a page number counted from 1 or the ID, name or title of a page, is in dir
+func hasTarget(dir *MultiDir, target string) bool {
+	if page, err := strconv.Atoi(target); err == nil {
+		return page >= 1 && page <= dir.GetPagesNum()
+	}
+	for _, file := range []*MultiDirFile{dir.IdToFile(target), dir.NameToFile(target), dir.TitleToFile(target)} {
+		if file != nil && file.IsPage() {
+			return true
+		}
+	}
+	return false
+}
+
+// DecodeOutlineChunk decodes the outline of a multipage document,
+// given by its `FORM:DJVM` chunk.
+// The Outline is empty if there is none.
+func DecodeOutlineChunk(form *iff.Chunk) (*Outline, error) {
+	retval := NewOutline()
+	if chunk := form.Find("NAVM"); chunk != nil {
+		return retval, retval.DecodeChunk(chunk)
+	}
+	return retval, nil
+}
+
+// SetOutlineChunk replaces the outline of a multipage document,
+// given by its `FORM:DJVM` chunk.
+// The `NAVM` chunk follows the `DIRM` chunk, and is removed if the outline is empty.
+func SetOutlineChunk(form *iff.Chunk, outline *Outline) error {
+	var chunk *iff.Chunk
+	if !outline.IsEmpty() {
+		var err error
+		if chunk, err = outline.Chunk(); err != nil {
+			return err
+		}
+	}
+
+	children := make([]*iff.Chunk, 0, len(form.Children)+1)
+	for _, child := range form.Children {
+		if child.ID == "NAVM" {
+			continue
+		}
+		children = append(children, child)
+		if child.ID == "DIRM" && chunk != nil {
+			children = append(children, chunk)
+			chunk = nil
+		}
+	}
+	if chunk != nil {
+		return errors.New("document has no DIRM chunk to put the outline after")
+	}
+	form.Children = children
+	return nil
+}
+
+// GetOutline returns the outline of the document.
+// It is empty for documents of a single page, which have none.
+func (doc *Document) GetOutline(ctx context.Context) (*Outline, error) {
+	retval := NewOutline()
+	f := iff.NewReader(doc.pool.NewReader(ctx))
+	id, err := f.GetChunk()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read document header")
+	}
+	if id != "FORM:DJVM" {
+		return retval, nil
+	}
+	for {
+		// The outline comes before the files of bundled documents
+		id, err := f.GetChunk()
+		if err == io.EOF || iff.IsComposite(id) {
+			return retval, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read document")
+		}
+		if id == "NAVM" {
+			return retval, retval.Decode(f)
+		}
+		if err := f.CloseChunk(); err != nil {
+			return nil, errors.Wrap(err, "could not read document")
+		}
+	}
+}
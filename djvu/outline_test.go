@@ -0,0 +1,145 @@
+package djvu
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/janreggie/go-djvulibre/djvu/bzz"
+	"github.com/janreggie/go-djvulibre/djvu/iff"
+	"github.com/stretchr/testify/suite"
+)
+
+type OutlineTestSuite struct {
+	suite.Suite
+}
+
+func TestOutlineSuite(t *testing.T) {
+	suite.Run(t, new(OutlineTestSuite))
+}
+
+const testBookmarks = `(bookmarks ("Chapter 1" "#1" ("Section 1.1" "#b.djvu" ("Note" "#2")) ("Section 1.2" "#Third")) ("Errata" "http://www.example.com/errata"))`
+
+func testOutline() *Outline {
+	return &Outline{Bookmarks: []*Bookmark{
+		{Title: "Chapter 1", URL: "#1", Children: []*Bookmark{
+			{Title: "Section 1.1", URL: "#b.djvu", Children: []*Bookmark{{Title: "Note", URL: "#2"}}},
+			{Title: "Section 1.2", URL: "#Third"},
+		}},
+		{Title: "Errata", URL: "http://www.example.com/errata"},
+	}}
+}
+
+// Returns a directory of three pages a.djvu, b.djvu and c.djvu, the last titled "Third"
+func testOutlineDir(s *suite.Suite) *MultiDir {
+	dir := NewMultiDir()
+	for _, id := range []string{"a.djvu", "b.djvu", "c.djvu"} {
+		file := &MultiDirFile{ID: id, Type: FILE_PAGE}
+		if id == "c.djvu" {
+			file.Title = "Third"
+		}
+		s.Require().NoError(dir.InsertFile(file, -1))
+	}
+	return dir
+}
+
+func (s *OutlineTestSuite) TestDecode() {
+	// One bookmark with one child
+	data, err := bzz.Compress([]byte{
+		0, 2,
+		1, 0, 0, 1, 'A', 0, 0, 2, '#', '1',
+		0, 0, 0, 1, 'B', 0, 0, 0,
+	})
+	s.Require().NoError(err)
+	outline := NewOutline()
+	s.Require().NoError(outline.Decode(bytes.NewReader(data)))
+	s.Equal(&Outline{Bookmarks: []*Bookmark{{Title: "A", URL: "#1", Children: []*Bookmark{{Title: "B"}}}}}, outline)
+
+	for _, bad := range [][]byte{
+		{0},
+		{0, 1, 1, 0, 0, 1, 'A', 0, 0, 0},
+		{0, 1, 0, 0, 0, 9, 'A'},
+	} {
+		data, err := bzz.Compress(bad)
+		s.Require().NoError(err)
+		s.Error(NewOutline().Decode(bytes.NewReader(data)), "%v", bad)
+	}
+	s.Error(NewOutline().DecodeChunk(&iff.Chunk{ID: "DIRM"}))
+}
+
+func (s *OutlineTestSuite) TestRoundTrip() {
+	chunk, err := testOutline().Chunk()
+	s.Require().NoError(err)
+	s.Equal("NAVM", chunk.ID)
+	outline := NewOutline()
+	s.Require().NoError(outline.DecodeChunk(chunk))
+	s.Equal(testOutline(), outline)
+
+	many := &Bookmark{Title: "Many"}
+	for ii := 0; ii < 256; ii++ {
+		many.Children = append(many.Children, &Bookmark{})
+	}
+	_, err = (&Outline{Bookmarks: []*Bookmark{many}}).Chunk()
+	s.Error(err)
+}
+
+func (s *OutlineTestSuite) TestParseFormat() {
+	outline, err := ParseOutline(testBookmarks)
+	s.Require().NoError(err)
+	s.Equal(testOutline(), outline)
+	text, err := outline.Format()
+	s.NoError(err)
+	s.Equal(testBookmarks, text)
+
+	outline, err = ParseOutline(`(bookmarks)`)
+	s.NoError(err)
+	s.True(outline.IsEmpty())
+
+	for _, bad := range []string{`(bookmarks ("A"))`, `(bookmarks ("A" "#1" "extra"))`, `(outline ("A" "#1"))`, `(bookmarks`} {
+		_, err := ParseOutline(bad)
+		s.Error(err, bad)
+	}
+}
+
+func (s *OutlineTestSuite) TestValidate() {
+	dir := testOutlineDir(&s.Suite)
+	s.NoError(testOutline().Validate(dir))
+	for _, url := range []string{"#0", "#4", "#d.djvu", "#"} {
+		outline := testOutline()
+		outline.Bookmarks[0].Children[0].Children[0].URL = url
+		s.Error(outline.Validate(dir), url)
+	}
+}
+
+func (s *OutlineTestSuite) TestDocument() {
+	dir := testOutlineDir(&s.Suite)
+	var dirm bytes.Buffer
+	s.Require().NoError(dir.Encode(&dirm, false))
+	index := &iff.Chunk{ID: "FORM:DJVM", Children: []*iff.Chunk{{ID: "DIRM", Data: dirm.Bytes()}}}
+
+	s.Require().NoError(SetOutlineChunk(index, testOutline()))
+	s.Equal([]string{"DIRM", "NAVM"}, chunkIDs(index))
+	outline, err := DecodeOutlineChunk(index)
+	s.Require().NoError(err)
+	s.Equal(testOutline(), outline)
+
+	mem := NewMemoryPort()
+	data, err := index.Bytes()
+	s.Require().NoError(err)
+	url, err := NewUrl("memory:index.djvu")
+	s.Require().NoError(err)
+	mem.Add(url, data)
+	doc, err := OpenDocument(context.Background(), url, mem)
+	s.Require().NoError(err)
+	defer doc.Close()
+	outline, err = doc.GetOutline(context.Background())
+	s.Require().NoError(err)
+	s.Equal(testOutline(), outline)
+
+	s.Require().NoError(SetOutlineChunk(index, NewOutline()))
+	s.Equal([]string{"DIRM"}, chunkIDs(index))
+	outline, err = DecodeOutlineChunk(index)
+	s.NoError(err)
+	s.True(outline.IsEmpty())
+	s.Error(SetOutlineChunk(&iff.Chunk{ID: "FORM:DJVM"}, testOutline()))
+}
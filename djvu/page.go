@@ -0,0 +1,198 @@
+package djvu
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"unsafe"
+
+	"github.com/janreggie/go-djvulibre/djvu/iff"
+	"github.com/janreggie/go-djvulibre/djvu/image"
+	"github.com/pkg/errors"
+)
+
+// Page is a decoded page of a Document
+type Page struct {
+	// Number of the page, counted from 0
+	Number int
+
+	// The `FORM:DJVU` chunk of the page
+	Form *iff.Chunk
+
+	// Size and resolution of the page
+	Info *Info
+
+	// Hidden text of the page, which may be empty
+	HiddenText *Text
+
+	// The `FORM:DJVI` chunks of the files included by the page,
+	// such as the one holding the shapes shared by several pages.
+	// Only filled by Document.GetPage.
+	Included []*iff.Chunk
+}
+
+// GetPage decodes page `page`, counted from 0.
+// If a FileCache was set with SetFileCache,
+// a page found in it is returned without decoding it again.
+// Every call returns a new Page, whose fields may be set freely,
+// but its chunks and hidden text are shared with the cached page:
+// they must be replaced rather than modified in place.
+func (doc *Document) GetPage(ctx context.Context, page int) (*Page, error) {
+	cache, url := doc.getFileCache(), doc.PageToUrl(page)
+	if cache != nil && url != nil {
+		if p := cache.GetPage(url); p != nil {
+			return p.copy(), nil
+		}
+	}
+	pool, err := doc.GetPageData(ctx, page)
+	if err != nil {
+		return nil, err
+	}
+	form, err := iff.Decode(pool.NewReader(ctx))
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read page %d", page)
+	}
+	p, err := NewPage(page, form)
+	if err != nil {
+		return nil, err
+	}
+	for _, chunk := range form.FindAll("INCL") {
+		id := strings.TrimSpace(string(chunk.Data))
+		pool, err := doc.includedData(ctx, id)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not read page %d", page)
+		}
+		included, err := iff.Decode(pool.NewReader(ctx))
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not read included file %q", id)
+		}
+		p.Included = append(p.Included, included)
+	}
+	if cache != nil && url != nil {
+		cache.Add(url, p)
+		return p.copy(), nil
+	}
+	return p, nil
+}
+
+// Returns a copy of the page sharing its chunks and hidden text
+func (p *Page) copy() *Page {
+	retval := *p
+	retval.Included = append([]*iff.Chunk(nil), p.Included...)
+	return &retval
+}
+
+// NewPage returns the page of number `number` held by a `FORM:DJVU` chunk.
+func NewPage(number int, form *iff.Chunk) (*Page, error) {
+	if form.ID != "FORM:DJVU" {
+		return nil, errors.Errorf("%q is not a page", form.ID)
+	}
+	chunk := form.Find("INFO")
+	if chunk == nil {
+		return nil, errors.New("page has no INFO chunk")
+	}
+	info := NewInfo()
+	if err := info.Decode(bytes.NewReader(chunk.Data)); err != nil {
+		return nil, err
+	}
+	text, err := DecodeTextChunk(form)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read hidden text")
+	}
+	return &Page{Number: number, Form: form, Info: info, HiddenText: text}, nil
+}
+
+// Returns the number of bytes used by this object,
+// which is mostly the data of its chunks and of the files it includes
+func (p *Page) GetMemoryUsage() uintptr {
+	size := unsafe.Sizeof(*p) + chunkMemoryUsage(p.Form)
+	for _, included := range p.Included {
+		size += chunkMemoryUsage(included)
+	}
+	return size
+}
+
+// Returns the number of bytes used by a chunk and its children
+func chunkMemoryUsage(chunk *iff.Chunk) uintptr {
+	if chunk == nil {
+		return 0
+	}
+	size := unsafe.Sizeof(*chunk) + uintptr(len(chunk.ID)+len(chunk.Data))
+	for _, child := range chunk.Children {
+		size += chunkMemoryUsage(child)
+	}
+	return size
+}
+
+// Rect returns the bounds of the page
+func (p *Page) Rect() image.Rect {
+	return image.NewRect(0, 0, int32(p.Info.Width), int32(p.Info.Height))
+}
+
+// Text returns the hidden text within rect, an empty rect standing for the whole page.
+// The text is that of the zones of the given level which meet rect,
+// or of the zones without children above that level,
+// in the order of the text layer, which is the reading order.
+// Words are separated by spaces, lines by newlines,
+// and paragraphs and larger zones by blank lines.
+func (p *Page) Text(rect image.Rect, level ZoneLevel) (string, []*Zone) {
+	if p.HiddenText == nil || p.HiddenText.Page == nil {
+		return "", nil
+	}
+	w := &plainTextWriter{}
+	var zones []*Zone
+	w.find(p.HiddenText.Page, rect, level, &zones)
+	return w.sb.String(), zones
+}
+
+// Joins the text of zones with separators fit for reading
+type plainTextWriter struct {
+	sb strings.Builder
+
+	// Largest zone which ended since the last text, or 0
+	pending ZoneLevel
+}
+
+// Writes the text of the zones of the given level which meet rect
+func (w *plainTextWriter) find(zone *Zone, rect image.Rect, level ZoneLevel, zones *[]*Zone) {
+	if zone.Level >= level || len(zone.Children) == 0 {
+		if rect.IsEmpty() || !rect.Intersect(zone.Rect).IsEmpty() {
+			*zones = append(*zones, zone)
+			w.zone(zone)
+		}
+	} else {
+		for _, child := range zone.Children {
+			w.find(child, rect, level, zones)
+		}
+	}
+	w.end(zone.Level)
+}
+
+// Writes the text of zone and its children
+func (w *plainTextWriter) zone(zone *Zone) {
+	if len(zone.Children) == 0 && zone.Text != "" {
+		if w.sb.Len() > 0 {
+			switch {
+			case w.pending == ZONE_CHARACTER || w.pending == 0:
+			case w.pending == ZONE_WORD:
+				w.sb.WriteByte(' ')
+			case w.pending == ZONE_LINE:
+				w.sb.WriteByte('\n')
+			default:
+				w.sb.WriteString("\n\n")
+			}
+		}
+		w.sb.WriteString(zone.Text)
+		w.pending = 0
+	}
+	for _, child := range zone.Children {
+		w.zone(child)
+	}
+	w.end(zone.Level)
+}
+
+func (w *plainTextWriter) end(level ZoneLevel) {
+	if w.pending == 0 || level < w.pending {
+		w.pending = level
+	}
+}
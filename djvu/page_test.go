@@ -0,0 +1,77 @@
+package djvu
+
+import (
+	"testing"
+
+	"github.com/janreggie/go-djvulibre/djvu/iff"
+	"github.com/janreggie/go-djvulibre/djvu/image"
+	"github.com/stretchr/testify/suite"
+)
+
+type PageTestSuite struct {
+	suite.Suite
+}
+
+func TestPageSuite(t *testing.T) {
+	suite.Run(t, new(PageTestSuite))
+}
+
+// Returns page 0 of 1000x800 holding testText
+func testTextPage() *Page {
+	form := testPage(1000, 800)
+	if err := SetTextChunk(form, testText(), false); err != nil {
+		panic(err)
+	}
+	page, err := NewPage(0, form)
+	if err != nil {
+		panic(err)
+	}
+	return page
+}
+
+func (s *PageTestSuite) TestNewPage() {
+	page := testTextPage()
+	s.Equal(uint16(1000), page.Info.Width)
+	s.Equal(testText(), page.HiddenText)
+	s.Equal(image.NewRect(0, 0, 1000, 800), page.Rect())
+
+	_, err := NewPage(0, &iff.Chunk{ID: "FORM:DJVI"})
+	s.Error(err)
+	_, err = NewPage(0, &iff.Chunk{ID: "FORM:DJVU"})
+	s.Error(err)
+}
+
+func (s *PageTestSuite) TestText() {
+	page := testTextPage()
+	text, zones := page.Text(image.Rect{}, ZONE_CHARACTER)
+	s.Equal("Hello wö\nagain\n\nEnd.", text)
+	s.Len(zones, 5)
+
+	text, zones = page.Text(image.Rect{}, ZONE_WORD)
+	s.Equal("Hello wö\nagain\n\nEnd.", text)
+	s.Len(zones, 4)
+
+	text, zones = page.Text(image.Rect{}, ZONE_PARAGRAPH)
+	s.Equal("Hello wö\nagain\n\nEnd.", text)
+	s.Len(zones, 2)
+
+	// The right of the first line
+	text, zones = page.Text(image.NewRect(650, 400, 350, 400), ZONE_WORD)
+	s.Equal("wö", text)
+	s.Len(zones, 1)
+	text, _ = page.Text(image.NewRect(650, 400, 350, 400), ZONE_CHARACTER)
+	s.Equal("ö", text)
+	text, _ = page.Text(image.NewRect(650, 400, 350, 400), ZONE_LINE)
+	s.Equal("Hello wö", text)
+
+	// The left side, across paragraphs
+	text, _ = page.Text(image.NewRect(0, 0, 200, 600), ZONE_WORD)
+	s.Equal("again\n\nEnd.", text)
+
+	text, zones = page.Text(image.NewRect(950, 0, 50, 50), ZONE_WORD)
+	s.Equal("", text)
+	s.Empty(zones)
+
+	text, _ = (&Page{}).Text(image.Rect{}, ZONE_WORD)
+	s.Equal("", text)
+}
@@ -0,0 +1,128 @@
+package djvu
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/janreggie/go-djvulibre/djvu/bzz"
+	"github.com/janreggie/go-djvulibre/djvu/image"
+	"github.com/pkg/errors"
+)
+
+// Palette holds the foreground colors of a page, as found in its `FGbz` chunk.
+// Each blit of the mask of the page may be given one of the colors,
+// so that the foreground of a page with few colors needs no image.
+type Palette struct {
+	Colors []image.Pixel
+
+	// Index in Colors of the color of each blit of the mask,
+	// in the order of the blits.
+	// Empty when the page only uses the first color.
+	Indices []int
+}
+
+// Version of the palette format
+const paletteVersion = 0
+
+// Largest number of colors of a palette, whose indices are 16 bits signed
+const maxPaletteColors = 0x7fff
+
+func NewPalette() *Palette { return &Palette{} }
+
+// Decode decodes the contents of a `FGbz` chunk, replacing the palette.
+// The colors are followed by the number of indices of the blits,
+// then by the indices themselves compressed with BZZ.
+func (p *Palette) Decode(r io.Reader) error {
+	p.Colors, p.Indices = nil, nil
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return errors.Wrap(err, "could not read palette")
+	}
+	if len(data) < 3 {
+		return errors.New("palette is too short")
+	}
+	if version := data[0] & 0x7f; version != paletteVersion {
+		return errors.Errorf("unsupported palette version %d", version)
+	}
+	hasIndices := data[0]&0x80 != 0
+	n := int(data[1])<<8 | int(data[2])
+	data = data[3:]
+	if len(data) < 3*n {
+		return errors.New("palette is too short")
+	}
+	for ii := 0; ii < n; ii++ {
+		p.Colors = append(p.Colors, image.Pixel{B: data[3*ii], G: data[3*ii+1], R: data[3*ii+2]})
+	}
+	if !hasIndices {
+		return nil
+	}
+
+	data = data[3*n:]
+	if len(data) < 3 {
+		return errors.New("palette indices are too short")
+	}
+	count := int(data[0])<<16 | int(data[1])<<8 | int(data[2])
+	indices, err := io.ReadAll(bzz.NewReader(bytes.NewReader(data[3:])))
+	if err != nil {
+		return errors.Wrap(err, "could not read palette indices")
+	}
+	if len(indices) < 2*count {
+		return errors.New("palette indices are too short")
+	}
+	p.Indices = make([]int, count)
+	for ii := range p.Indices {
+		index := int(int16(uint16(indices[2*ii])<<8 | uint16(indices[2*ii+1])))
+		if index < 0 || index >= n {
+			return errors.Errorf("palette index %d out of range", index)
+		}
+		p.Indices[ii] = index
+	}
+	return nil
+}
+
+// Encode encodes the palette into the contents of a `FGbz` chunk.
+func (p *Palette) Encode(w io.Writer) error {
+	if len(p.Colors) > maxPaletteColors {
+		return errors.Errorf("palette of %d colors has more than %d", len(p.Colors), maxPaletteColors)
+	}
+	if len(p.Indices) > 0xffffff {
+		return errors.Errorf("palette of %d indices has more than %d", len(p.Indices), 0xffffff)
+	}
+	version := byte(paletteVersion)
+	if len(p.Indices) > 0 {
+		version |= 0x80
+	}
+	data := []byte{version, byte(len(p.Colors) >> 8), byte(len(p.Colors))}
+	for _, c := range p.Colors {
+		data = append(data, c.B, c.G, c.R)
+	}
+	if len(p.Indices) > 0 {
+		data = append(data, byte(len(p.Indices)>>16), byte(len(p.Indices)>>8), byte(len(p.Indices)))
+		indices := make([]byte, 0, 2*len(p.Indices))
+		for _, index := range p.Indices {
+			if index < 0 || index >= len(p.Colors) {
+				return errors.Errorf("palette index %d out of range", index)
+			}
+			indices = append(indices, byte(index>>8), byte(index))
+		}
+		compressed, err := bzz.Compress(indices)
+		if err != nil {
+			return errors.Wrap(err, "could not compress palette indices")
+		}
+		data = append(data, compressed...)
+	}
+	_, err := w.Write(data)
+	return errors.Wrap(err, "could not write palette")
+}
+
+// Color returns the color of blit n.
+// It is black if the palette is empty.
+func (p *Palette) Color(n int) image.Pixel {
+	if len(p.Colors) == 0 {
+		return image.BlackPixel
+	}
+	if n >= 0 && n < len(p.Indices) {
+		return p.Colors[p.Indices[n]]
+	}
+	return p.Colors[0]
+}
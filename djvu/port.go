@@ -1,5 +1,7 @@
 package djvu
 
+import "context"
+
 // Port is an interface for sending and receiving messages generated during decoding process.
 // In order for clases to send or receive requests,
 // they must embed a *SimplePort, which implements Port.
@@ -24,7 +26,7 @@ type Port interface {
 	// which may be used to request data associated with included file.
 	// A Document usually intercepts all such requests,
 	// and the user doesn't have to worry about the translation.
-	IdToUrl(source Port, id string) Url
+	IdToUrl(source Port, id string) *Url
 
 	// This request is used to get a file corresponding to the given ID.
 	// A Document is supposed to intercept it
@@ -36,8 +38,8 @@ type Port interface {
 	// As soon as they need the data, they call this function,
 	// whose responsibility is to locate the source of the data basing on the Url passed
 	// and return it back in the form of the DataPool.
-	// If this particular receiver is unable to fullfil the request, it should return false.
-	RequestData(source Port, url *Url) *DataPool
+	// If this particular receiver is unable to fullfil the request, it should return nil.
+	RequestData(ctx context.Context, source Port, url *Url) *DataPool
 
 	// This notification is sent when an error occurs
 	// and the error message should be shown to the user.
@@ -1,6 +1,11 @@
 package djvu
 
-import "sync"
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+)
 
 // PortCaster maintains associations between ports.
 //
@@ -8,7 +13,7 @@ import "sync"
 // accepts requests and notifications from them and forwards them to
 // destinations according to internally maintained map of routes.
 //
-// The caller can modify the route map any way he likes
+// The caller can modify the route map any way they like
 // (see AddRoute, DelRoute, CopyRoutes, etc. methods).
 // Any port can be either a sender of a message,
 // an intermediary receiver or a final destination.
@@ -16,11 +21,10 @@ import "sync"
 // When a request is sent,
 // the PortCaster computes the list of destinations by consulting with the route map.
 // Notifications are only sent to ``alive'' ports.
-// A port is alive if it is referenced by a valid pointer.
-//  As a consequence, a port usually becomes alive after running the constructor
-// (since the returned pointer is then assigned to a smartpointer)
-// and is no longer alive when the port is destroyed
-// (because it would not be destroyed if a smartpointer was referencing it).
+// Since Go is garbage collected, there is no destructor to tell us when a port dies.
+// Instead, a port becomes alive when it is registered with AddPort,
+// or when it is given a route or an alias,
+// and is no longer alive once it is removed with DelPort.
 //
 // Destination ports are sorted according to their distance from the source.
 // For example, if port `A` is connected to ports `B` and `C` directly,
@@ -28,19 +32,21 @@ import "sync"
 // then `B` and `C` are assumed to be one hop away from `A`,
 // while `D` is two hops away from `A`.
 //
-// In some cases the requests and notifications are sent to every possible destination,
-// and the order is not significant (like it is for NotifyFileFlagsChanged request).
-// Others should be sent to the closest destinations first,
+// Notifications are sent to every possible destination, closest first,
+// like NotifyFileFlagsChanged, NotifyError and NotifyStatus.
+// Requests are sent to the closest destinations first,
 // and only then to the farthest, in case if they have not been processed by the closest.
-// The examples are RequestData, NotifyError, and NotifyStatus.
+// The example is RequestData.
 //
 // The user is not expected to create the PortCaster itself.
-// He should use the global function `GetPortCaster()` instead.
+// They should use the global function `GetPortCaster()` instead.
 type PortCaster struct {
-	mtx      sync.Mutex
+	mtx sync.Mutex
+
+	// Map of ports to their direct destinations, in the order they were added
 	routeMap map[Port][]Port
 
-	// TODO: Do we even need this though?
+	// Set of ports which are alive
 	contMap map[Port]struct{}
 
 	// Map of aliases to their respective ports
@@ -48,17 +54,29 @@ type PortCaster struct {
 }
 
 // Global port caster
-var globalPortCaster = &PortCaster{
-	mtx:      sync.Mutex{},
-	routeMap: make(map[Port][]Port),
-	contMap:  make(map[Port]struct{}),
-	a2pMap:   make(map[string]Port),
+var globalPortCaster = newPortCaster()
+
+func newPortCaster() *PortCaster {
+	return &PortCaster{
+		mtx:      sync.Mutex{},
+		routeMap: make(map[Port][]Port),
+		contMap:  make(map[Port]struct{}),
+		a2pMap:   make(map[string]Port),
+	}
 }
 
 func GetPortCaster() *PortCaster {
 	return globalPortCaster
 }
 
+// Marks the specified port as alive.
+// Ports are also marked alive when they are given a route or an alias.
+func (c *PortCaster) AddPort(p Port) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.contMap[p] = struct{}{}
+}
+
 // Removes the specified port from all routes.
 // It will no longer be able to receive or generate messages
 // and will be considered "dead" by `IsPortAlive`.
@@ -67,46 +85,107 @@ func (c *PortCaster) DelPort(p Port) {
 	defer c.mtx.Unlock()
 	c.clearAliases(p)
 	delete(c.contMap, p)
+	delete(c.routeMap, p)
+	for src, dests := range c.routeMap {
+		c.routeMap[src] = removePort(dests, p)
+		if len(c.routeMap[src]) == 0 {
+			delete(c.routeMap, src)
+		}
+	}
 }
 
 // Adds route from `source` to `dest`.
 // Whenever a request is sent or received by `source`,
 // it will be forwarded to `dest` as well.
 func (c *PortCaster) AddRoute(source Port, dest Port) {
-	// Do things here...
-	panic("unimplemented")
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.contMap[source] = struct{}{}
+	c.contMap[dest] = struct{}{}
+	c.addRoute(source, dest)
+}
+
+// Unsafe. Must only be called while holding the lock.
+func (c *PortCaster) addRoute(source Port, dest Port) {
+	for _, p := range c.routeMap[source] {
+		if p == dest {
+			return
+		}
+	}
+	c.routeMap[source] = append(c.routeMap[source], dest)
 }
 
 // The opposite of `AddRoute`.
 // Removes the association between `source` and `dest`.
 func (c *PortCaster) DelRoute(source Port, dest Port) {
-	panic("unimplemented")
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.routeMap[source] = removePort(c.routeMap[source], dest)
+	if len(c.routeMap[source]) == 0 {
+		delete(c.routeMap, source)
+	}
 }
 
 // Copies all incoming and outgoing routes from `source` to `dest`.
 // This function should be called when a Port is copied,
 // if you want to preserve the connectivity.
 func (c *PortCaster) CopyRoutes(dest Port, source Port) {
-	panic("unimplemented")
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if _, ok := c.contMap[source]; !ok {
+		return
+	}
+	c.contMap[dest] = struct{}{}
+
+	// Collect first since addRoute modifies the map
+	var outgoing, incoming []Port
+	for src, dests := range c.routeMap {
+		if src == source {
+			outgoing = append(outgoing, dests...)
+		}
+		for _, d := range dests {
+			if d == source {
+				incoming = append(incoming, src)
+			}
+		}
+	}
+	for _, d := range outgoing {
+		c.addRoute(dest, d)
+	}
+	for _, src := range incoming {
+		c.addRoute(src, dest)
+	}
 }
 
-// Returns a smart pointer to the port if `p` is a valid pointer to an existing Port.
-// Returns a null pointer otherwise.
-//
-// TODO: How do you translate this in a garbage-collected language like Go?
+// Returns the port if `p` refers to a Port which is still alive,
+// that is, it has been registered and not deleted with DelPort.
+// Returns nil otherwise.
 func (c *PortCaster) IsPortAlive(p Port) Port {
-	panic("unimplemented")
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.isPortAlive(p)
+}
+
+// Unsafe. Must only be called while holding the lock.
+func (c *PortCaster) isPortAlive(p Port) Port {
+	if _, ok := c.contMap[p]; ok {
+		return p
+	}
+	return nil
 }
 
-// Assigns one more {\em alias} for the specified \Ref{DjVuPort}.
-// {\em Aliases} are names, which can be used later to retrieve this
-// \Ref{DjVuPort}, if it still exists. Any \Ref{DjVuPort} may have
-// more than one {\em alias}. But every {\em alias} must correspond
-// to only one \Ref{DjVuPort}. Thus, if the specified alias is
-// already associated with another port, this association will be
-// removed.
+// Assigns one more alias for the specified Port.
+// Aliases are names, which can be used later to retrieve this Port,
+// if it still exists.
+// Any Port may have more than one alias.
+// But every alias must correspond to only one Port.
+// Thus, if the specified alias is already associated with another port,
+// this association will be removed.
 func (c *PortCaster) AddAlias(p Port, alias string) {
-	panic("unimplemented")
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.contMap[p] = struct{}{}
+	c.a2pMap[alias] = p
 }
 
 // Removes all the aliases
@@ -137,89 +216,206 @@ func (c *PortCaster) clearAliases(p Port) {
 // or the port associated with it has already been destroyed,
 // a nil pointer will be returned.
 func (c *PortCaster) AliasToPort(alias string) Port {
-	panic("unimplemented")
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	p, ok := c.a2pMap[alias]
+	if !ok {
+		return nil
+	}
+	return c.isPortAlive(p)
 }
 
-// Returns a list of Ports with aliases starting with `prefix`.
+// Returns a list of Ports with aliases starting with `prefix`,
+// ordered by their aliases.
 // If no Ports have been found, an empty list is returned.
-func (c *PortCaster) PrefixToPorts(alias string) []Port {
-	panic("unimplemented")
+func (c *PortCaster) PrefixToPorts(prefix string) []Port {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	aliases := make([]string, 0)
+	for alias := range c.a2pMap {
+		if strings.HasPrefix(alias, prefix) {
+			aliases = append(aliases, alias)
+		}
+	}
+	sort.Strings(aliases)
+
+	retval := make([]Port, 0)
+	seen := make(map[Port]struct{})
+	for _, alias := range aliases {
+		p := c.isPortAlive(c.a2pMap[alias])
+		if p == nil {
+			continue
+		}
+		if _, ok := seen[p]; ok {
+			continue
+		}
+		seen[p] = struct{}{}
+		retval = append(retval, p)
+	}
+	return retval
+}
+
+// Computes the list of alive ports reachable from `source`,
+// sorted by their distance from `source`.
+// Ports at the same distance are in the order their routes were added.
+// `source` itself is only included if there is a route to itself.
+func (c *PortCaster) closure(source Port) []Port {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	retval := make([]Port, 0)
+	seen := map[Port]struct{}{source: {}}
+	queue := []Port{source}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		for _, dest := range c.routeMap[p] {
+			if dest == source && p == source {
+				retval = append([]Port{source}, retval...)
+				continue
+			}
+			if _, ok := seen[dest]; ok {
+				continue
+			}
+			seen[dest] = struct{}{}
+			queue = append(queue, dest)
+			if c.isPortAlive(dest) != nil {
+				retval = append(retval, dest)
+			}
+		}
+	}
+	return retval
 }
 
 // Computes destination list for `source`
 // and calls the corresponding function in each of the ports from the destination list
 // starting from the closest until one of them returns non-empty URL.
-func (c *PortCaster) IdToUrl(source Port, id string) Url {
-	panic("unimplemented")
+func (c *PortCaster) IdToUrl(source Port, id string) *Url {
+	for _, p := range c.closure(source) {
+		if url := p.IdToUrl(source, id); url != nil && !url.IsEmpty() {
+			return url
+		}
+	}
+	return nil
 }
 
 // Computes destination list for `source`
 // and calls the corresponding function in each of the ports from the destination list
 // starting from the closest until one of them returns non-zero pointer to a File.
 func (c *PortCaster) IdToFile(source Port, id string) *File {
-	panic("unimplemented")
+	for _, p := range c.closure(source) {
+		if file := p.IdToFile(source, id); file != nil {
+			return file
+		}
+	}
+	return nil
 }
 
 // This request is issued when decoder needs additional data for decoding.
 // Both File and Document are initialized with a URL, not the document data.
 // As soon as they need the data, they call this function,
 // whose responsibility is to locate the source of the data based on the URL passed
-// and return it back in the form of the Pool.
-// If this particular receiver is unable to fullfil the request, it should return nil.
-func (c *PortCaster) RequestData(source Port, url *Url) *sync.Pool {
-	panic("unimplemented")
+// and return it back in the form of the DataPool.
+// The ports are asked starting from the closest until one of them returns a DataPool.
+// If no port is able to fullfil the request, nil is returned.
+func (c *PortCaster) RequestData(ctx context.Context, source Port, url *Url) *DataPool {
+	for _, p := range c.closure(source) {
+		if pool := p.RequestData(ctx, source, url); pool != nil {
+			return pool
+		}
+	}
+	return nil
 }
 
 // This notification is sent when an error occurs
 // and the error message should be shown to the user.
-// Returns whether the request is successful.
+// Every port is notified, starting from the closest,
+// so that a port logging errors does not hide them from the others.
+// Returns whether any of them processed it.
 func (c *PortCaster) NotifyError(source Port, message string) bool {
-	panic("unimplemented")
+	processed := false
+	for _, p := range c.closure(source) {
+		if p.NotifyError(source, message) {
+			processed = true
+		}
+	}
+	return processed
 }
 
 // This notification is sent to update the decoding status.
-// Returns whether the request is successful.
+// Every port is notified, starting from the closest.
+// Returns whether any of them processed it.
 func (c *PortCaster) NotifyStatus(source Port, message string) bool {
-	panic("unimplemented")
+	processed := false
+	for _, p := range c.closure(source) {
+		if p.NotifyStatus(source, message) {
+			processed = true
+		}
+	}
+	return processed
 }
 
 // This notification is sent by an Image when it should be redrawn.
 // It may be used to implement progressive redisplay.
 func (c *PortCaster) NotifyRedisplay(source *Image) {
-	panic("unimplemented")
+	for _, p := range c.closure(source) {
+		p.NotifyRedisplay(source)
+	}
 }
 
 // This notification is sent by Image
 // when its geometry has been changed as a result of decoding.
 // It may be used to implement progressive redisplay.
 func (c *PortCaster) NotifyRelayout(source *Image) {
-	panic("unimplemented")
+	for _, p := range c.closure(source) {
+		p.NotifyRelayout(source)
+	}
 }
 
 // Computes destination list for `source`
 // and calls the corresponding function in each of the ports from the destination list
 // starting from the closest.
-func (c *PortCaster) NotifyChunkDone(source *Image, name string) {
-	panic("unimplemented")
+func (c *PortCaster) NotifyChunkDone(source Port, name string) {
+	for _, p := range c.closure(source) {
+		p.NotifyChunkDone(source, name)
+	}
 }
 
 // Computes destination list for `source`
 // and calls the corresponding function in each of the ports from the destination list
 // starting from the closest.
 func (c *PortCaster) NotifyFileFlagsChanged(source *File, setMask uint64, clearMask uint64) {
-	panic("unimplemented")
+	for _, p := range c.closure(source) {
+		p.NotifyFileFlagsChanged(source, setMask, clearMask)
+	}
 }
 
 // Computes destination list for `source`
 // and calls the corresponding function in each of the ports from the destination list
 // starting from the closest.
 func (c *PortCaster) NotifyDocFlagsChanged(source *Document, setMask uint64, clearMask uint64) {
-	panic("unimplemented")
+	for _, p := range c.closure(source) {
+		p.NotifyDocFlagsChanged(source, setMask, clearMask)
+	}
 }
 
 // Computes destination list for `source`
 // and calls the corresponding function in each of the ports from the destination list
 // starting from the closest.
-func (c *PortCaster) NotifyDecodeProgress(source *Port, done float64) {
-	panic("unimplemented")
+func (c *PortCaster) NotifyDecodeProgress(source Port, done float64) {
+	for _, p := range c.closure(source) {
+		p.NotifyDecodeProgress(source, done)
+	}
+}
+
+// Returns `ports` without `p`
+func removePort(ports []Port, p Port) []Port {
+	retval := ports[:0]
+	for _, q := range ports {
+		if q != p {
+			retval = append(retval, q)
+		}
+	}
+	return retval
 }
@@ -0,0 +1,149 @@
+package djvu
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type PortCasterTestSuite struct {
+	suite.Suite
+	Caster *PortCaster
+	Log    []string // What the recording ports have received
+}
+
+func TestPortCasterSuite(t *testing.T) {
+	suite.Run(t, new(PortCasterTestSuite))
+}
+
+func (s *PortCasterTestSuite) SetupTest() {
+	s.Caster = newPortCaster()
+	s.Log = nil
+}
+
+// recordingPort logs the notifications it receives into its suite
+type recordingPort struct {
+	port
+	name    string
+	s       *PortCasterTestSuite
+	handles bool // Whether NotifyError, NotifyStatus and RequestData are processed
+	pool    *DataPool
+}
+
+func (s *PortCasterTestSuite) newPort(name string, handles bool) *recordingPort {
+	return &recordingPort{name: name, s: s, handles: handles, pool: NewDataPool()}
+}
+
+func (p *recordingPort) NotifyError(source Port, msg string) bool {
+	p.s.Log = append(p.s.Log, p.name+":error:"+msg)
+	return p.handles
+}
+
+func (p *recordingPort) NotifyStatus(source Port, msg string) bool {
+	p.s.Log = append(p.s.Log, p.name+":status:"+msg)
+	return p.handles
+}
+
+func (p *recordingPort) NotifyDecodeProgress(source Port, done float64) {
+	p.s.Log = append(p.s.Log, p.name+":progress")
+}
+
+func (p *recordingPort) RequestData(ctx context.Context, source Port, url *Url) *DataPool {
+	p.s.Log = append(p.s.Log, p.name+":request")
+	if p.handles {
+		return p.pool
+	}
+	return nil
+}
+
+func (s *PortCasterTestSuite) TestClosureOrder() {
+	a, b, c, d := s.newPort("a", false), s.newPort("b", false), s.newPort("c", false), s.newPort("d", false)
+	s.Caster.AddRoute(a, b)
+	s.Caster.AddRoute(b, d)
+	s.Caster.AddRoute(a, c)
+	s.Caster.AddRoute(d, a) // Cycles are fine
+
+	s.Caster.NotifyDecodeProgress(a, 0.5)
+	s.Equal([]string{"b:progress", "c:progress", "d:progress"}, s.Log)
+}
+
+func (s *PortCasterTestSuite) TestFirstResponder() {
+	a, b, c, d := s.newPort("a", false), s.newPort("b", false), s.newPort("c", true), s.newPort("d", true)
+	s.Caster.AddRoute(a, b)
+	s.Caster.AddRoute(b, d)
+	s.Caster.AddRoute(a, c)
+
+	s.Same(c.pool, s.Caster.RequestData(context.Background(), a, nil))
+	s.Equal([]string{"b:request", "c:request"}, s.Log)
+}
+
+func (s *PortCasterTestSuite) TestNotifyEveryPort() {
+	a, b, c, d := s.newPort("a", false), s.newPort("b", false), s.newPort("c", true), s.newPort("d", true)
+	s.Caster.AddRoute(a, b)
+	s.Caster.AddRoute(b, d)
+	s.Caster.AddRoute(a, c)
+
+	s.True(s.Caster.NotifyError(a, "oops"))
+	s.Equal([]string{"b:error:oops", "c:error:oops", "d:error:oops"}, s.Log)
+
+	s.Log = nil
+	s.True(s.Caster.NotifyStatus(a, "50%"))
+	s.Equal([]string{"b:status:50%", "c:status:50%", "d:status:50%"}, s.Log)
+
+	// b only routes to d
+	s.Log = nil
+	s.True(s.Caster.NotifyStatus(b, "done"))
+	s.Equal([]string{"d:status:done"}, s.Log)
+	s.False(s.Caster.NotifyError(d, "oops")) // No destinations
+}
+
+func (s *PortCasterTestSuite) TestDelRouteAndPort() {
+	a, b, c := s.newPort("a", false), s.newPort("b", false), s.newPort("c", false)
+	s.Caster.AddRoute(a, b)
+	s.Caster.AddRoute(b, c)
+	s.Caster.DelRoute(b, c)
+	s.Caster.NotifyDecodeProgress(a, 1)
+	s.Equal([]string{"b:progress"}, s.Log)
+
+	s.Log = nil
+	s.Caster.AddRoute(b, c)
+	s.Caster.DelPort(b)
+	s.Nil(s.Caster.IsPortAlive(b))
+	s.NotNil(s.Caster.IsPortAlive(a))
+	s.Caster.NotifyDecodeProgress(a, 1)
+	s.Empty(s.Log)
+}
+
+func (s *PortCasterTestSuite) TestCopyRoutes() {
+	a, b, c, copied := s.newPort("a", false), s.newPort("b", false), s.newPort("c", false), s.newPort("copy", false)
+	s.Caster.AddRoute(a, b)
+	s.Caster.AddRoute(b, c)
+	s.Caster.CopyRoutes(copied, b)
+
+	s.Caster.NotifyDecodeProgress(copied, 1)
+	s.Equal([]string{"c:progress"}, s.Log)
+
+	s.Log = nil
+	s.Caster.NotifyDecodeProgress(a, 1)
+	s.Equal([]string{"b:progress", "copy:progress", "c:progress"}, s.Log)
+}
+
+func (s *PortCasterTestSuite) TestAliases() {
+	a, b := s.newPort("a", false), s.newPort("b", false)
+	s.Caster.AddAlias(a, "doc:1")
+	s.Caster.AddAlias(b, "doc:2")
+	s.Caster.AddAlias(b, "file:1")
+	s.Same(a, s.Caster.AliasToPort("doc:1"))
+	s.Nil(s.Caster.AliasToPort("doc:3"))
+	s.Equal([]Port{a, b}, s.Caster.PrefixToPorts("doc:"))
+
+	// An alias only points to one port
+	s.Caster.AddAlias(b, "doc:1")
+	s.Same(b, s.Caster.AliasToPort("doc:1"))
+	s.Equal([]Port{b}, s.Caster.PrefixToPorts("doc:"))
+
+	s.Caster.DelPort(b)
+	s.Nil(s.Caster.AliasToPort("file:1"))
+	s.Empty(s.Caster.PrefixToPorts(""))
+}
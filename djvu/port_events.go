@@ -0,0 +1,391 @@
+package djvu
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// EventKind identifies the notification an Event was made from.
+// Kinds can be combined with `|` to filter subscriptions.
+type EventKind uint16
+
+const (
+	EVENT_ERROR EventKind = 1 << iota
+	EVENT_STATUS
+	EVENT_CHUNK_DONE
+	EVENT_DECODE_PROGRESS
+	EVENT_FILE_FLAGS_CHANGED
+	EVENT_DOC_FLAGS_CHANGED
+	EVENT_REDISPLAY
+	EVENT_RELAYOUT
+
+	EVENT_ALL EventKind = 1<<iota - 1
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EVENT_ERROR:
+		return "error"
+	case EVENT_STATUS:
+		return "status"
+	case EVENT_CHUNK_DONE:
+		return "chunk done"
+	case EVENT_DECODE_PROGRESS:
+		return "decode progress"
+	case EVENT_FILE_FLAGS_CHANGED:
+		return "file flags changed"
+	case EVENT_DOC_FLAGS_CHANGED:
+		return "doc flags changed"
+	case EVENT_REDISPLAY:
+		return "redisplay"
+	case EVENT_RELAYOUT:
+		return "relayout"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is a Port notification delivered through a channel.
+// Use a type switch to get to the fields of the concrete event.
+type Event interface {
+	// Kind of notification this event was made from
+	Kind() EventKind
+	// Port which sent the notification
+	Source() Port
+}
+
+// ErrorEvent is made from Port.NotifyError
+type ErrorEvent struct {
+	Src     Port
+	Message string
+}
+
+// StatusEvent is made from Port.NotifyStatus
+type StatusEvent struct {
+	Src     Port
+	Message string
+}
+
+// ChunkDoneEvent is made from Port.NotifyChunkDone
+type ChunkDoneEvent struct {
+	Src Port
+	// Name of the chunk which has been decoded
+	Name string
+}
+
+// DecodeProgressEvent is made from Port.NotifyDecodeProgress
+type DecodeProgressEvent struct {
+	Src Port
+	// Number from 0 to 1 reflecting the progress
+	Done float64
+}
+
+// FileFlagsChangedEvent is made from Port.NotifyFileFlagsChanged
+type FileFlagsChangedEvent struct {
+	File      *File
+	SetMask   uint64
+	ClearMask uint64
+}
+
+// DocFlagsChangedEvent is made from Port.NotifyDocFlagsChanged
+type DocFlagsChangedEvent struct {
+	Document  *Document
+	SetMask   uint64
+	ClearMask uint64
+}
+
+// RedisplayEvent is made from Port.NotifyRedisplay
+type RedisplayEvent struct {
+	Image *Image
+}
+
+// RelayoutEvent is made from Port.NotifyRelayout
+type RelayoutEvent struct {
+	Image *Image
+}
+
+func (ev ErrorEvent) Kind() EventKind            { return EVENT_ERROR }
+func (ev StatusEvent) Kind() EventKind           { return EVENT_STATUS }
+func (ev ChunkDoneEvent) Kind() EventKind        { return EVENT_CHUNK_DONE }
+func (ev DecodeProgressEvent) Kind() EventKind   { return EVENT_DECODE_PROGRESS }
+func (ev FileFlagsChangedEvent) Kind() EventKind { return EVENT_FILE_FLAGS_CHANGED }
+func (ev DocFlagsChangedEvent) Kind() EventKind  { return EVENT_DOC_FLAGS_CHANGED }
+func (ev RedisplayEvent) Kind() EventKind        { return EVENT_REDISPLAY }
+func (ev RelayoutEvent) Kind() EventKind         { return EVENT_RELAYOUT }
+
+func (ev ErrorEvent) Source() Port            { return ev.Src }
+func (ev StatusEvent) Source() Port           { return ev.Src }
+func (ev ChunkDoneEvent) Source() Port        { return ev.Src }
+func (ev DecodeProgressEvent) Source() Port   { return ev.Src }
+func (ev FileFlagsChangedEvent) Source() Port { return ev.File }
+func (ev DocFlagsChangedEvent) Source() Port  { return ev.Document }
+func (ev RedisplayEvent) Source() Port        { return ev.Image }
+func (ev RelayoutEvent) Source() Port         { return ev.Image }
+
+// EventPort is a Port which turns every notification it receives
+// into an Event delivered to its subscriptions.
+// Route any Port to an EventPort using the PortCaster
+// to observe it without implementing the whole Port interface:
+//
+//	events := NewEventPort()
+//	GetPortCaster().AddRoute(file, events)
+//	sub := events.Subscribe(WithKinds(EVENT_ERROR | EVENT_DECODE_PROGRESS))
+//	defer sub.Close()
+//	for ev := range sub.C {
+//	    switch ev := ev.(type) {
+//	    case ErrorEvent:
+//	        log.Println(ev.Message)
+//	    case DecodeProgressEvent:
+//	        bar.Set(ev.Done)
+//	    }
+//	}
+//
+// Requests (IdToUrl, IdToFile and RequestData) are not answered.
+type EventPort struct {
+	port
+
+	mtx  sync.RWMutex
+	subs []*Subscription
+}
+
+// NewEventPort creates an EventPort without subscriptions.
+func NewEventPort() *EventPort {
+	return &EventPort{}
+}
+
+// OverflowPolicy determines what happens to an Event
+// when the channel of a Subscription is full.
+type OverflowPolicy uint8
+
+const (
+	// The event is dropped and counted in Subscription.Dropped.
+	// Decoding is never slowed down by a slow consumer.
+	OVERFLOW_DROP OverflowPolicy = iota
+
+	// The notification blocks until the consumer receives the event
+	// or the subscription is closed.
+	OVERFLOW_BLOCK
+)
+
+// Subscription receives Events from an EventPort on channel C.
+// C is closed when the subscription is closed.
+type Subscription struct {
+	dropped uint64 // Accessed atomically, kept first for alignment
+
+	// Channel on which events are delivered
+	C <-chan Event
+
+	c         chan Event
+	done      chan struct{}
+	mtx       sync.RWMutex // Held for reading while sending, for writing while closing
+	closed    bool
+	closeOnce sync.Once
+	kinds     EventKind
+	source    Port
+	filter    func(Event) bool
+	overflow  OverflowPolicy
+	owner     *EventPort
+}
+
+// SubscribeOption configures a Subscription.
+type SubscribeOption func(*Subscription)
+
+// WithKinds only delivers events of the given kinds.
+// By default, events of all kinds are delivered.
+func WithKinds(kinds EventKind) SubscribeOption {
+	return func(s *Subscription) { s.kinds = kinds }
+}
+
+// WithSource only delivers events sent by `source`.
+func WithSource(source Port) SubscribeOption {
+	return func(s *Subscription) { s.source = source }
+}
+
+// WithFilter only delivers events for which `filter` returns true.
+func WithFilter(filter func(Event) bool) SubscribeOption {
+	return func(s *Subscription) { s.filter = filter }
+}
+
+// WithBuffer sets the capacity of the channel. The default is 64.
+func WithBuffer(size int) SubscribeOption {
+	return func(s *Subscription) { s.c = make(chan Event, size) }
+}
+
+// WithOverflow sets what happens when the channel is full.
+// The default is OVERFLOW_DROP.
+func WithOverflow(policy OverflowPolicy) SubscribeOption {
+	return func(s *Subscription) { s.overflow = policy }
+}
+
+// Subscribe creates a new Subscription receiving the events of this port.
+func (p *EventPort) Subscribe(opts ...SubscribeOption) *Subscription {
+	sub := &Subscription{
+		done:  make(chan struct{}),
+		kinds: EVENT_ALL,
+		owner: p,
+	}
+	for _, opt := range opts {
+		opt(sub)
+	}
+	if sub.c == nil {
+		sub.c = make(chan Event, 64)
+	}
+	sub.C = sub.c
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.subs = append(p.subs, sub)
+	return sub
+}
+
+// Close closes all subscriptions.
+func (p *EventPort) Close() {
+	p.mtx.Lock()
+	subs := p.subs
+	p.subs = nil
+	p.mtx.Unlock()
+
+	for _, sub := range subs {
+		sub.close()
+	}
+}
+
+// Close stops the delivery of events and closes C.
+func (s *Subscription) Close() {
+	p := s.owner
+	p.mtx.Lock()
+	for ii, sub := range p.subs {
+		if sub == s {
+			p.subs = append(p.subs[:ii], p.subs[ii+1:]...)
+			break
+		}
+	}
+	p.mtx.Unlock()
+
+	s.close()
+}
+
+// Dropped returns the number of events dropped because C was full.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+func (s *Subscription) close() {
+	s.closeOnce.Do(func() {
+		close(s.done) // Unblocks any blocked sender
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		s.closed = true
+		close(s.c)
+	})
+}
+
+func (s *Subscription) wants(ev Event) bool {
+	if ev.Kind()&s.kinds == 0 {
+		return false
+	}
+	if s.source != nil && ev.Source() != s.source {
+		return false
+	}
+	return s.filter == nil || s.filter(ev)
+}
+
+// Returns whether the event has been delivered
+func (s *Subscription) send(ev Event) bool {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	if s.closed {
+		return false
+	}
+
+	if s.overflow == OVERFLOW_BLOCK {
+		select {
+		case s.c <- ev:
+			return true
+		case <-s.done:
+			return false
+		}
+	}
+
+	select {
+	case s.c <- ev:
+		return true
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+		return false
+	}
+}
+
+// Sends the event to all interested subscriptions.
+// Returns whether at least one of them wanted it.
+func (p *EventPort) publish(ev Event) bool {
+	p.mtx.RLock()
+	subs := make([]*Subscription, len(p.subs))
+	copy(subs, p.subs)
+	p.mtx.RUnlock()
+
+	wanted := false
+	for _, sub := range subs {
+		if sub.wants(ev) {
+			wanted = true
+			sub.send(ev)
+		}
+	}
+	return wanted
+}
+
+// Copy implements Port.
+// The copy has no subscriptions.
+func (p *EventPort) Copy() Port {
+	return NewEventPort()
+}
+
+// Inherits implements Port
+func (p *EventPort) Inherits(className string) bool {
+	return className == "EventPort" || p.port.Inherits(className)
+}
+
+// NotifyError implements Port.
+// Returns whether a subscription is interested in errors.
+func (p *EventPort) NotifyError(source Port, msg string) bool {
+	return p.publish(ErrorEvent{Src: source, Message: msg})
+}
+
+// NotifyStatus implements Port.
+// Returns whether a subscription is interested in status messages.
+func (p *EventPort) NotifyStatus(source Port, msg string) bool {
+	return p.publish(StatusEvent{Src: source, Message: msg})
+}
+
+// NotifyRedisplay implements Port
+func (p *EventPort) NotifyRedisplay(source *Image) {
+	p.publish(RedisplayEvent{Image: source})
+}
+
+// NotifyRelayout implements Port
+func (p *EventPort) NotifyRelayout(source *Image) {
+	p.publish(RelayoutEvent{Image: source})
+}
+
+// NotifyChunkDone implements Port
+func (p *EventPort) NotifyChunkDone(source Port, name string) {
+	p.publish(ChunkDoneEvent{Src: source, Name: name})
+}
+
+// NotifyFileFlagsChanged implements Port
+func (p *EventPort) NotifyFileFlagsChanged(source *File, setMask uint64, clearMask uint64) {
+	p.publish(FileFlagsChangedEvent{File: source, SetMask: setMask, ClearMask: clearMask})
+}
+
+// NotifyDocFlagsChanged implements Port
+func (p *EventPort) NotifyDocFlagsChanged(source *Document, setMask uint64, clearMask uint64) {
+	p.publish(DocFlagsChangedEvent{Document: source, SetMask: setMask, ClearMask: clearMask})
+}
+
+// NotifyDecodeProgress implements Port
+func (p *EventPort) NotifyDecodeProgress(source Port, done float64) {
+	p.publish(DecodeProgressEvent{Src: source, Done: done})
+}
+
+// Enforces interface in the compiler level
+var _ Port = &EventPort{}
@@ -0,0 +1,122 @@
+package djvu
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type EventPortTestSuite struct {
+	suite.Suite
+	Caster *PortCaster
+	Events *EventPort
+	Source *SimplePort // Routed to Events
+}
+
+func TestEventPortSuite(t *testing.T) {
+	suite.Run(t, new(EventPortTestSuite))
+}
+
+func (s *EventPortTestSuite) SetupTest() {
+	s.Caster = newPortCaster()
+	s.Events = NewEventPort()
+	s.Source = &SimplePort{}
+	s.Caster.AddRoute(s.Source, s.Events)
+}
+
+func (s *EventPortTestSuite) TestAllKinds() {
+	sub := s.Events.Subscribe()
+	defer sub.Close()
+
+	file, doc, img := &File{}, &Document{}, &Image{}
+	s.True(s.Caster.NotifyError(s.Source, "bad"))
+	s.True(s.Caster.NotifyStatus(s.Source, "ok"))
+	s.Caster.NotifyChunkDone(s.Source, "INFO")
+	s.Caster.NotifyDecodeProgress(s.Source, 0.25)
+	s.Caster.AddRoute(file, s.Events)
+	s.Caster.NotifyFileFlagsChanged(file, 1, 2)
+	s.Caster.AddRoute(doc, s.Events)
+	s.Caster.NotifyDocFlagsChanged(doc, 3, 4)
+	s.Caster.AddRoute(img, s.Events)
+	s.Caster.NotifyRedisplay(img)
+	s.Caster.NotifyRelayout(img)
+
+	s.Equal(ErrorEvent{Src: s.Source, Message: "bad"}, <-sub.C)
+	s.Equal(StatusEvent{Src: s.Source, Message: "ok"}, <-sub.C)
+	s.Equal(ChunkDoneEvent{Src: s.Source, Name: "INFO"}, <-sub.C)
+	s.Equal(DecodeProgressEvent{Src: s.Source, Done: 0.25}, <-sub.C)
+	s.Equal(FileFlagsChangedEvent{File: file, SetMask: 1, ClearMask: 2}, <-sub.C)
+	s.Equal(DocFlagsChangedEvent{Document: doc, SetMask: 3, ClearMask: 4}, <-sub.C)
+	s.Equal(RedisplayEvent{Image: img}, <-sub.C)
+	s.Equal(RelayoutEvent{Image: img}, <-sub.C)
+}
+
+func (s *EventPortTestSuite) TestFilters() {
+	errors := s.Events.Subscribe(WithKinds(EVENT_ERROR))
+	progress := s.Events.Subscribe(WithKinds(EVENT_DECODE_PROGRESS), WithFilter(func(ev Event) bool {
+		return ev.(DecodeProgressEvent).Done >= 0.5
+	}))
+	other := &SimplePort{}
+	s.Caster.AddRoute(other, s.Events)
+	fromOther := s.Events.Subscribe(WithSource(other))
+
+	s.False(s.Caster.NotifyStatus(s.Source, "nobody listens")) // Not handled, falls through
+	s.Caster.NotifyDecodeProgress(s.Source, 0.1)
+	s.Caster.NotifyDecodeProgress(s.Source, 0.6)
+	s.Caster.NotifyError(s.Source, "bad")
+	s.Caster.NotifyChunkDone(other, "Sjbz")
+
+	errors.Close()
+	progress.Close()
+	fromOther.Close()
+	s.Equal([]Event{ErrorEvent{Src: s.Source, Message: "bad"}}, drain(errors))
+	s.Equal([]Event{DecodeProgressEvent{Src: s.Source, Done: 0.6}}, drain(progress))
+	s.Equal([]Event{ChunkDoneEvent{Src: other, Name: "Sjbz"}}, drain(fromOther))
+}
+
+func (s *EventPortTestSuite) TestOverflow() {
+	dropping := s.Events.Subscribe(WithBuffer(1))
+	for ii := 0; ii < 3; ii++ {
+		s.Caster.NotifyDecodeProgress(s.Source, 0)
+	}
+	s.Equal(uint64(2), dropping.Dropped())
+	dropping.Close()
+
+	blocking := s.Events.Subscribe(WithBuffer(0), WithOverflow(OVERFLOW_BLOCK))
+	go func() {
+		for ii := 0; ii < 3; ii++ {
+			s.Caster.NotifyDecodeProgress(s.Source, float64(ii))
+		}
+		blocking.Close()
+	}()
+	s.Len(drain(blocking), 3)
+	s.Equal(uint64(0), blocking.Dropped())
+
+	// Closing unblocks senders
+	stuck := s.Events.Subscribe(WithBuffer(0), WithOverflow(OVERFLOW_BLOCK))
+	done := make(chan struct{})
+	go func() {
+		s.Caster.NotifyDecodeProgress(s.Source, 0)
+		close(done)
+	}()
+	time.Sleep(time.Millisecond)
+	stuck.Close()
+	<-done
+}
+
+func (s *EventPortTestSuite) TestDocumentEvents() {
+	doc := &Document{}
+	events := doc.Events(WithKinds(EVENT_ERROR))
+	GetPortCaster().NotifyError(doc, "from document")
+	s.Equal(ErrorEvent{Src: doc, Message: "from document"}, <-events)
+	GetPortCaster().DelPort(doc)
+}
+
+func drain(sub *Subscription) []Event {
+	var retval []Event
+	for ev := range sub.C {
+		retval = append(retval, ev)
+	}
+	return retval
+}
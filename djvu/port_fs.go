@@ -0,0 +1,149 @@
+package djvu
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	neturl "net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// FSPort answers RequestData with the files of an fs.FS,
+// such as os.DirFS, embed.FS or a zip.Reader.
+//
+// A Url is served if it is under the base Url of the port;
+// the rest of the Url, without arguments, is the path of the file in the FS.
+// For example, with base `memory:books/` or `memory:books`,
+// `memory:books/vol%201/p1.djvu` is served from `vol 1/p1.djvu`,
+// but `memory:booksX/p1.djvu` is not served.
+// Paths going out of the FS, such as `../secret`, are never served.
+//
+// Files which can be read at random offsets (see io.ReaderAt)
+// are read on demand and kept open until Close is called,
+// other files are read at once.
+type FSPort struct {
+	port
+
+	base string // Ends with a slash, unless it is only a protocol
+	fsys fs.FS
+
+	mtx   sync.Mutex
+	pools map[string]*DataPool // Pools already handed out, by path
+	files map[string]io.Closer // Files kept open for their pools, by path
+}
+
+// NewFSPort creates an FSPort serving the files of `fsys` under `base`.
+func NewFSPort(base *Url, fsys fs.FS) *FSPort {
+	dir := base.Raw()
+	if !strings.HasSuffix(dir, "/") && !strings.HasSuffix(dir, ":") {
+		dir += "/"
+	}
+	return &FSPort{base: dir, fsys: fsys, pools: make(map[string]*DataPool), files: make(map[string]io.Closer)}
+}
+
+// NewDirPort creates an FSPort serving the files of the local directory `dir` under `base`.
+func NewDirPort(base *Url, dir string) *FSPort {
+	return NewFSPort(base, os.DirFS(dir))
+}
+
+// Copy implements Port.
+// The copy serves the same FS.
+func (p *FSPort) Copy() Port {
+	return &FSPort{base: p.base, fsys: p.fsys, pools: make(map[string]*DataPool), files: make(map[string]io.Closer)}
+}
+
+// Close closes the files kept open for the DataPools handed out so far,
+// and stops these DataPools.
+// Reading from them fails afterwards,
+// but the port can still be used: RequestData opens the files again.
+func (p *FSPort) Close() error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	var err error
+	for name, f := range p.files {
+		if closeErr := f.Close(); closeErr != nil && err == nil {
+			err = errors.Wrapf(closeErr, "could not close %s", name)
+		}
+	}
+	for _, pool := range p.pools {
+		pool.Stop()
+	}
+	p.pools = make(map[string]*DataPool)
+	p.files = make(map[string]io.Closer)
+	return err
+}
+
+// Inherits implements Port
+func (p *FSPort) Inherits(className string) bool {
+	return className == "FSPort" || p.port.Inherits(className)
+}
+
+// Path returns the path in the FS `url` corresponds to,
+// and whether `url` is under the base of the port.
+func (p *FSPort) Path(url *Url) (string, bool) {
+	if url == nil {
+		return "", false
+	}
+	raw := url.Copy()
+	raw.ClearAllArguments()
+	rest := raw.Raw()
+	if !strings.HasPrefix(rest, p.base) {
+		return "", false
+	}
+	name, err := neturl.PathUnescape(rest[len(p.base):])
+	if err != nil || !fs.ValidPath(name) || name == "." {
+		return "", false
+	}
+	return name, true
+}
+
+// RequestData returns the data of the file `url` corresponds to,
+// or nil if there is no such file.
+func (p *FSPort) RequestData(ctx context.Context, source Port, url *Url) *DataPool {
+	name, ok := p.Path(url)
+	if !ok {
+		return nil
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if pool, ok := p.pools[name]; ok {
+		return pool
+	}
+	pool := p.open(name)
+	if pool != nil {
+		p.pools[name] = pool
+	}
+	return pool
+}
+
+// Unsafe. Must only be called while holding the lock.
+func (p *FSPort) open(name string) *DataPool {
+	f, err := p.fsys.Open(name)
+	if err != nil {
+		return nil
+	}
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		f.Close()
+		return nil
+	}
+	if r, ok := f.(io.ReaderAt); ok {
+		p.files[name] = f
+		return NewDataPoolFromReaderAt(r, info.Size())
+	}
+
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil
+	}
+	return NewDataPoolFromBytes(data)
+}
+
+// Enforces interface in the compiler level
+var _ Port = &FSPort{}
@@ -0,0 +1,159 @@
+package djvu
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type FSPortTestSuite struct {
+	suite.Suite
+	Index []byte
+	Files map[string][]byte // Pages of the indirect document
+	Base  *Url
+}
+
+func TestFSPortSuite(t *testing.T) {
+	suite.Run(t, new(FSPortTestSuite))
+}
+
+func (s *FSPortTestSuite) SetupTest() {
+	s.Index, s.Files = testIndirect("p1.djvu", "p 2.djvu")
+	var err error
+	s.Base, err = NewUrl("memory:books/")
+	s.NoError(err)
+}
+
+func (s *FSPortTestSuite) url(raw string) *Url {
+	url, err := NewUrl(raw)
+	s.Require().NoError(err)
+	return url
+}
+
+// Opens the indirect document at books/vol/index.djvu and checks its pages
+func (s *FSPortTestSuite) checkDocument(p Port) {
+	url, err := NewUrl("memory:books/vol/index.djvu")
+	s.Require().NoError(err)
+	doc, err := OpenDocument(context.Background(), url, p)
+	s.Require().NoError(err)
+	defer doc.Close()
+
+	s.Equal(INDIRECT, doc.GetDocType())
+	s.Equal(2, doc.GetPagesNum())
+	for ii := 0; ii < 2; ii++ {
+		pool, err := doc.GetPageData(context.Background(), ii)
+		s.Require().NoError(err)
+		data := make([]byte, pool.GetLength())
+		_, err = pool.NewReader(context.Background()).ReadAt(data, 0)
+		s.NoError(err)
+		s.Equal(s.Files[[]string{"p1.djvu", "p 2.djvu"}[ii]], data)
+	}
+}
+
+func (s *FSPortTestSuite) TestMapFS() {
+	fsys := fstest.MapFS{"vol/index.djvu": {Data: s.Index}}
+	for name, data := range s.Files {
+		fsys["vol/"+name] = &fstest.MapFile{Data: data}
+	}
+	s.checkDocument(NewFSPort(s.Base, fsys))
+}
+
+func (s *FSPortTestSuite) TestZip() {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	files := map[string][]byte{"vol/index.djvu": s.Index}
+	for name, data := range s.Files {
+		files["vol/"+name] = data
+	}
+	for name, data := range files {
+		w, err := zw.Create(name)
+		s.Require().NoError(err)
+		_, err = w.Write(data)
+		s.Require().NoError(err)
+	}
+	s.Require().NoError(zw.Close())
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	s.Require().NoError(err)
+	s.checkDocument(NewFSPort(s.Base, zr))
+}
+
+func (s *FSPortTestSuite) TestDir() {
+	dir := s.T().TempDir()
+	s.Require().NoError(os.Mkdir(filepath.Join(dir, "vol"), 0o755))
+	s.Require().NoError(os.WriteFile(filepath.Join(dir, "vol", "index.djvu"), s.Index, 0o644))
+	for name, data := range s.Files {
+		s.Require().NoError(os.WriteFile(filepath.Join(dir, "vol", name), data, 0o644))
+	}
+	s.Require().NoError(os.WriteFile(filepath.Join(dir, "..", "secret"), []byte("no"), 0o644))
+	defer os.Remove(filepath.Join(dir, "..", "secret"))
+
+	p := NewDirPort(s.Base, dir)
+	defer p.Close()
+	s.checkDocument(p)
+
+	for _, raw := range []string{"memory:books/../secret", "memory:books/%2e%2e/secret", "memory:other/vol/index.djvu", "memory:books/vol", "memory:books/"} {
+		url, err := NewUrl(raw)
+		s.NoError(err)
+		s.Nil(p.RequestData(context.Background(), nil, url), raw)
+	}
+}
+
+func (s *FSPortTestSuite) TestPath() {
+	p := NewFSPort(s.Base, fstest.MapFS{})
+	url, err := NewUrl("memory:books/vol%201/p1.djvu#page?DJVUOPTS&zoom=100")
+	s.NoError(err)
+	name, ok := p.Path(url)
+	s.True(ok)
+	s.Equal("vol 1/p1.djvu", name)
+}
+
+func (s *FSPortTestSuite) TestBase() {
+	fsys := fstest.MapFS{"p1.djvu": {Data: s.Files["p1.djvu"]}}
+	for _, raw := range []string{"memory:books", "memory:books/"} {
+		base, err := NewUrl(raw)
+		s.Require().NoError(err)
+		p := NewFSPort(base, fsys)
+		for url, served := range map[string]bool{
+			"memory:books/p1.djvu":  true,
+			"memory:booksp1.djvu":   false,
+			"memory:booksX/p1.djvu": false,
+		} {
+			name, ok := p.Path(s.url(url))
+			s.Equal(served, ok, "%s under %s", url, raw)
+			if served {
+				s.Equal("p1.djvu", name)
+			}
+		}
+	}
+}
+
+func (s *FSPortTestSuite) TestClose() {
+	dir := s.T().TempDir()
+	s.Require().NoError(os.WriteFile(filepath.Join(dir, "p1.djvu"), s.Files["p1.djvu"], 0o644))
+	p := NewDirPort(s.Base, dir)
+	url := s.url("memory:books/p1.djvu")
+	pool := p.RequestData(context.Background(), nil, url)
+	s.Require().NotNil(pool)
+	s.Same(pool, p.RequestData(context.Background(), nil, url))
+
+	s.NoError(p.Close())
+	_, err := pool.GetData(make([]byte, 4), 0)
+	s.Error(err)
+
+	// The file is opened again
+	reopened := p.RequestData(context.Background(), nil, url)
+	s.Require().NotNil(reopened)
+	s.NotSame(pool, reopened)
+	buf := make([]byte, 4)
+	_, err = reopened.GetData(buf, 0)
+	s.NoError(err)
+	s.Equal([]byte("AT&T"), buf)
+	s.NoError(p.Close())
+}
@@ -0,0 +1,399 @@
+package djvu
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// HTTPPort answers RequestData for `http:` and `https:` Urls.
+//
+// If the server answers HEAD requests and accepts Range requests,
+// the data is fetched in blocks as the decoder reads it,
+// so that opening a large bundled document
+// does not download the whole of it.
+// Blocks are only used if the file has not changed since the HEAD request,
+// as told by its ETag or Last-Modified date,
+// and reading fails otherwise.
+// Without HEAD or Range requests, the data is downloaded in the background
+// and made available as it arrives.
+//
+// Hash arguments are not sent to the server.
+// Failures are sent by the port itself with NotifyError:
+// route it to an EventPort to observe them.
+type HTTPPort struct {
+	port
+
+	// Client used for the requests. http.DefaultClient if nil.
+	Client *http.Client
+	// Header added to every request, such as authentication.
+	Header http.Header
+	// Size of the blocks fetched with Range requests. 64 KiB if not positive.
+	BlockSize int64
+	// Number of blocks kept for every file read with Range requests,
+	// the least recently used being dropped first. 64 if not positive.
+	CachedBlocks int
+}
+
+// NewHTTPPort creates an HTTPPort using `client`,
+// or http.DefaultClient if `client` is nil.
+func NewHTTPPort(client *http.Client) *HTTPPort {
+	return &HTTPPort{Client: client}
+}
+
+// Copy implements Port
+func (p *HTTPPort) Copy() Port {
+	return &HTTPPort{Client: p.Client, Header: p.Header.Clone(), BlockSize: p.BlockSize, CachedBlocks: p.CachedBlocks}
+}
+
+// Inherits implements Port
+func (p *HTTPPort) Inherits(className string) bool {
+	return className == "HTTPPort" || p.port.Inherits(className)
+}
+
+// RequestData fetches `url` if it is an HTTP Url.
+// Returns nil if it is not, or if the server reports an error.
+//
+// Cancelling `ctx` aborts the request.
+// A download started in the background is only tied to `ctx`
+// until the server answers:
+// it then goes on until the DataPool is stopped.
+func (p *HTTPPort) RequestData(ctx context.Context, source Port, url *Url) *DataPool {
+	if url == nil {
+		return nil
+	}
+	if proto := url.Protocol(); proto != "http" && proto != "https" {
+		return nil
+	}
+	target := url.Copy()
+	target.ClearHashArguments()
+
+	pool, err := p.fetch(ctx, target.Raw())
+	if err != nil {
+		GetPortCaster().NotifyError(p, err.Error())
+		return nil
+	}
+	return pool
+}
+
+func (p *HTTPPort) client() *http.Client {
+	if p.Client != nil {
+		return p.Client
+	}
+	return http.DefaultClient
+}
+
+func (p *HTTPPort) newRequest(ctx context.Context, method string, target string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not request %s", target)
+	}
+	for key, values := range p.Header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	return req, nil
+}
+
+func (p *HTTPPort) fetch(ctx context.Context, target string) (*DataPool, error) {
+	req, err := p.newRequest(ctx, http.MethodHead, target)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not request %s", target)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK { // Some servers only answer GET
+		return p.download(ctx, target)
+	}
+
+	if resp.Header.Get("Accept-Ranges") == "bytes" && resp.ContentLength >= 0 {
+		blockSize := p.BlockSize
+		if blockSize <= 0 {
+			blockSize = 64 << 10
+		}
+		maxBlocks := p.CachedBlocks
+		if maxBlocks <= 0 {
+			maxBlocks = 64
+		}
+		r := &httpReaderAt{
+			port:      p,
+			target:    target,
+			size:      resp.ContentLength,
+			version:   newHTTPVersion(resp.Header),
+			blockSize: blockSize,
+			maxBlocks: maxBlocks,
+			blocks:    make(map[int64]*httpBlock),
+			lru:       list.New(),
+		}
+		return NewDataPoolFromReaderAt(r, resp.ContentLength), nil
+	}
+	return p.download(ctx, target)
+}
+
+// httpVersion tells which version of a remote file a response is about.
+// Its fields are empty when the server does not give them.
+type httpVersion struct {
+	etag         string // Strong ETag only, since weak ones cannot be used with If-Range
+	lastModified string
+}
+
+func newHTTPVersion(header http.Header) httpVersion {
+	v := httpVersion{lastModified: header.Get("Last-Modified")}
+	if etag := header.Get("ETag"); !strings.HasPrefix(etag, "W/") {
+		v.etag = etag
+	}
+	return v
+}
+
+// Returns the value of the If-Range header asking for this version,
+// or "" if it cannot be told apart from others
+func (v httpVersion) ifRange() string {
+	if v.etag != "" {
+		return v.etag
+	}
+	return v.lastModified
+}
+
+// Returns whether `header` is about another version than v
+func (v httpVersion) differs(header http.Header) bool {
+	other := newHTTPVersion(header)
+	return (v.etag != "" && other.etag != "" && v.etag != other.etag) ||
+		(v.lastModified != "" && other.lastModified != "" && v.lastModified != other.lastModified)
+}
+
+// Returns whether `header` tells it is about version v,
+// using the validator of ifRange
+func (v httpVersion) same(header http.Header) bool {
+	if v.etag != "" {
+		return newHTTPVersion(header).etag == v.etag
+	}
+	return v.lastModified != "" && header.Get("Last-Modified") == v.lastModified
+}
+
+// Downloads `target` in the background
+func (p *HTTPPort) download(ctx context.Context, target string) (*DataPool, error) {
+	// The download outlives `ctx`, which only covers the wait for the response
+	downloadCtx, cancel := context.WithCancel(context.Background())
+	answered := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancel()
+		case <-answered:
+		}
+	}()
+
+	req, err := p.newRequest(downloadCtx, http.MethodGet, target)
+	if err != nil {
+		close(answered)
+		cancel()
+		return nil, err
+	}
+	resp, err := p.client().Do(req)
+	close(answered)
+	if err != nil {
+		cancel()
+		return nil, errors.Wrapf(err, "could not request %s", target)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		return nil, errors.Errorf("could not request %s: %s", target, resp.Status)
+	}
+
+	pool := NewDataPool()
+	go func() {
+		defer cancel()
+		defer resp.Body.Close()
+		buf := make([]byte, 32<<10)
+		for !pool.IsStopped() {
+			n, err := resp.Body.Read(buf)
+			if n > 0 {
+				pool.AddData(buf[:n])
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				GetPortCaster().NotifyError(p, fmt.Sprintf("could not download %s: %v", target, err))
+				pool.Stop()
+				return
+			}
+		}
+		pool.SetEOF()
+	}()
+	return pool, nil
+}
+
+// httpReaderAt reads a remote file with Range requests,
+// keeping the blocks it has fetched most recently.
+// Each block is fetched by a single reader at a time,
+// and readers of other blocks do not wait for it.
+type httpReaderAt struct {
+	port      *HTTPPort
+	target    string
+	size      int64
+	version   httpVersion // Of the file when the port was asked for it
+	blockSize int64
+	maxBlocks int
+
+	mtx    sync.Mutex
+	blocks map[int64]*httpBlock // Fetched and pending blocks, by index
+	lru    *list.List           // Fetched blocks, from the most recently used
+}
+
+// httpBlock is a block of a remote file, fetched or being fetched
+type httpBlock struct {
+	index int64
+	elem  *list.Element // Element of httpReaderAt.lru once fetched
+
+	// Closed once data or err is set
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+func (r *httpReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	return r.ReadAtContext(context.Background(), p, off)
+}
+
+// ReadAtContext is like ReadAt,
+// but gives up waiting for the server once `ctx` is done.
+func (r *httpReaderAt) ReadAtContext(ctx context.Context, p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= r.size {
+			return n, io.EOF
+		}
+		index := pos / r.blockSize
+		block, err := r.block(ctx, index)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], block[pos-index*r.blockSize:])
+	}
+	return n, nil
+}
+
+func (r *httpReaderAt) block(ctx context.Context, index int64) ([]byte, error) {
+	for {
+		r.mtx.Lock()
+		b, ok := r.blocks[index]
+		if !ok {
+			b = &httpBlock{index: index, done: make(chan struct{})}
+			r.blocks[index] = b
+			r.mtx.Unlock()
+
+			data, err := r.fetch(ctx, index)
+			r.mtx.Lock()
+			b.data, b.err = data, err
+			if err != nil {
+				delete(r.blocks, index)
+			} else {
+				b.elem = r.lru.PushFront(b)
+				r.evict()
+			}
+			close(b.done)
+			r.mtx.Unlock()
+			return data, err
+		}
+		if b.elem != nil {
+			r.lru.MoveToFront(b.elem)
+		}
+		r.mtx.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-b.done:
+		}
+		if b.err == nil {
+			return b.data, nil
+		}
+		// The reader fetching the block failed, maybe because of its own context:
+		// try again, fetching it ourselves if nobody else is
+	}
+}
+
+// Drops the least recently used blocks until at most maxBlocks are left.
+// Unsafe. Must only be called while holding the lock.
+func (r *httpReaderAt) evict() {
+	for r.lru.Len() > r.maxBlocks {
+		b := r.lru.Remove(r.lru.Back()).(*httpBlock)
+		delete(r.blocks, b.index)
+	}
+}
+
+var errHTTPChanged = errors.New("file changed on the server")
+
+// Fetches block `index` from the server
+func (r *httpReaderAt) fetch(ctx context.Context, index int64) ([]byte, error) {
+	start := index * r.blockSize
+	end := start + r.blockSize
+	if end > r.size {
+		end = r.size
+	}
+	req, err := r.port.newRequest(ctx, http.MethodGet, r.target)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+	ifRange := r.version.ifRange()
+	if ifRange != "" {
+		req.Header.Set("If-Range", ifRange)
+	}
+	resp, err := r.port.client().Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not request %s", r.target)
+	}
+	defer resp.Body.Close()
+
+	block := make([]byte, end-start)
+	contentRange := resp.Header.Get("Content-Range")
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if r.version.differs(resp.Header) {
+			err = errHTTPChanged
+		} else if !r.isRange(contentRange, start, end) {
+			err = errors.Errorf("server sent range %q", contentRange)
+		} else {
+			_, err = io.ReadFull(resp.Body, block)
+		}
+	case http.StatusOK:
+		// Either the file changed, so that If-Range failed, or Range was ignored
+		if (ifRange != "" && !r.version.same(resp.Header)) || r.version.differs(resp.Header) ||
+			(resp.ContentLength >= 0 && resp.ContentLength != r.size) {
+			err = errHTTPChanged
+		} else if _, err = io.CopyN(io.Discard, resp.Body, start); err == nil {
+			_, err = io.ReadFull(resp.Body, block)
+		}
+	default:
+		err = errors.New(resp.Status)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read bytes %d-%d of %s", start, end-1, r.target)
+	}
+	return block, nil
+}
+
+// Returns whether `contentRange`, the Content-Range header of a response,
+// covers bytes [start, end) of the file
+func (r *httpReaderAt) isRange(contentRange string, start, end int64) bool {
+	rest := strings.TrimPrefix(contentRange, fmt.Sprintf("bytes %d-%d/", start, end-1))
+	return rest != contentRange && (rest == "*" || rest == strconv.FormatInt(r.size, 10))
+}
+
+// Enforces interface in the compiler level
+var _ Port = &HTTPPort{}
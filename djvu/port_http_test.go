@@ -0,0 +1,278 @@
+package djvu
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type HTTPPortTestSuite struct {
+	suite.Suite
+	Files    map[string][]byte // Served under /books/
+	Ranges   int64             // Number of Range requests received
+	Server   *httptest.Server
+	NoRanges *httptest.Server // Ignores Range and HEAD
+}
+
+func TestHTTPPortSuite(t *testing.T) {
+	suite.Run(t, new(HTTPPortTestSuite))
+}
+
+func (s *HTTPPortTestSuite) SetupTest() {
+	index, files := testIndirect("p1.djvu", "p2.djvu")
+	files["index.djvu"] = index
+	s.Files = files
+	s.Ranges = 0
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "secret" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		data, ok := s.Files[strings.TrimPrefix(r.URL.Path, "/books/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Range") != "" {
+			atomic.AddInt64(&s.Ranges, 1)
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	s.NoRanges = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := s.Files[strings.TrimPrefix(r.URL.Path, "/books/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		for ii := 0; ii < len(data); ii += 7 { // Trickle
+			w.Write(data[ii:minInt(ii+7, len(data))])
+			w.(http.Flusher).Flush()
+		}
+	}))
+}
+
+func (s *HTTPPortTestSuite) TearDownTest() {
+	s.Server.Close()
+	s.NoRanges.Close()
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func (s *HTTPPortTestSuite) checkDocument(server string, p *HTTPPort) {
+	url, err := NewUrl(server + "/books/index.djvu")
+	s.Require().NoError(err)
+	doc, err := OpenDocument(context.Background(), url, p)
+	s.Require().NoError(err)
+	defer doc.Close()
+
+	s.Equal(INDIRECT, doc.GetDocType())
+	s.Equal(2, doc.GetPagesNum())
+	pool, err := doc.GetPageData(context.Background(), 1)
+	s.Require().NoError(err)
+	length, err := pool.GetLengthContext(context.Background())
+	s.Require().NoError(err)
+	data := make([]byte, length)
+	_, err = pool.NewReader(context.Background()).ReadAt(data, 0)
+	s.NoError(err)
+	s.Equal(s.Files["p2.djvu"], data)
+}
+
+func (s *HTTPPortTestSuite) TestRanges() {
+	p := NewHTTPPort(s.Server.Client())
+	p.Header = http.Header{"Authorization": {"secret"}}
+	p.BlockSize = 16
+	s.checkDocument(s.Server.URL, p)
+	s.Greater(atomic.LoadInt64(&s.Ranges), int64(2))
+}
+
+func (s *HTTPPortTestSuite) TestDownload() {
+	s.checkDocument(s.NoRanges.URL, NewHTTPPort(nil))
+}
+
+func (s *HTTPPortTestSuite) TestErrors() {
+	p := NewHTTPPort(s.Server.Client())
+	events := NewEventPort()
+	sub := events.Subscribe(WithKinds(EVENT_ERROR))
+	GetPortCaster().AddRoute(p, events)
+	defer GetPortCaster().DelPort(events)
+	defer GetPortCaster().DelPort(p)
+
+	url, err := NewUrl(s.Server.URL + "/books/index.djvu")
+	s.NoError(err)
+	s.Nil(p.RequestData(context.Background(), nil, url)) // Not authorized
+	ev := (<-sub.C).(ErrorEvent)
+	s.Contains(ev.Message, "403")
+
+	url, err = NewUrl("memory:books/index.djvu")
+	s.NoError(err)
+	s.Nil(p.RequestData(context.Background(), nil, url))
+}
+
+func (s *HTTPPortTestSuite) TestCachedBlocks() {
+	p := NewHTTPPort(s.Server.Client())
+	p.Header = http.Header{"Authorization": {"secret"}}
+	p.BlockSize, p.CachedBlocks = 16, 2
+	url, err := NewUrl(s.Server.URL + "/books/p1.djvu")
+	s.Require().NoError(err)
+	pool := p.RequestData(context.Background(), nil, url)
+	s.Require().NotNil(pool)
+
+	for _, read := range []struct {
+		offset int64
+		ranges int64
+	}{
+		{0, 1},
+		{16, 2},
+		{0, 2},
+		{32, 3}, // Drops the block at 16, used less recently than the one at 0
+		{0, 3},
+		{16, 4},
+	} {
+		_, err := pool.GetData(make([]byte, 2), read.offset)
+		s.NoError(err)
+		s.Equal(read.ranges, atomic.LoadInt64(&s.Ranges), "reading at %d", read.offset)
+	}
+}
+
+func (s *HTTPPortTestSuite) TestStalledBlock() {
+	// The first block is only served once stall is closed
+	stall := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.Header.Get("Range"), "bytes=0-") {
+			select {
+			case <-stall:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(s.Files["p1.djvu"]))
+	}))
+	defer server.Close()
+	defer close(stall)
+
+	p := NewHTTPPort(server.Client())
+	p.BlockSize = 16
+	url, err := NewUrl(server.URL + "/p1.djvu")
+	s.Require().NoError(err)
+	pool := p.RequestData(context.Background(), nil, url)
+	s.Require().NotNil(pool)
+
+	// The context of the reader cancels the request
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = pool.GetDataContext(ctx, make([]byte, 4), 0)
+	s.ErrorIs(err, context.DeadlineExceeded)
+
+	// Other blocks are read while the first one is pending
+	stalled := make(chan error, 1)
+	go func() {
+		_, err := pool.GetData(make([]byte, 4), 0)
+		stalled <- err
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	buf := make([]byte, 4)
+	n, err := pool.GetDataContext(ctx, buf, 16)
+	s.Require().NoError(err)
+	s.Equal(s.Files["p1.djvu"][16:20], buf[:n])
+	select {
+	case <-stalled:
+		s.Fail("first block read before being served")
+	default:
+	}
+}
+
+func (s *HTTPPortTestSuite) TestNoHead() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			http.Error(w, "HEAD not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		data, ok := s.Files[strings.TrimPrefix(r.URL.Path, "/books/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+	s.checkDocument(server.URL, NewHTTPPort(server.Client()))
+}
+
+func (s *HTTPPortTestSuite) TestChanged() {
+	original := s.Files["p1.djvu"]
+	changed := append([]byte(nil), original...)
+	changed[len(changed)-1]++
+	modified := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, etag := range []bool{true, false} {
+		var version int32 = 1
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			version, data := atomic.LoadInt32(&version), original
+			if version == 2 {
+				data = changed
+			}
+			if etag {
+				w.Header().Set("ETag", fmt.Sprintf(`"v%d"`, version))
+			}
+			http.ServeContent(w, r, "", modified.Add(time.Duration(version)*time.Hour), bytes.NewReader(data))
+		}))
+
+		p := NewHTTPPort(server.Client())
+		p.BlockSize = 16
+		url, err := NewUrl(server.URL + "/p1.djvu")
+		s.Require().NoError(err)
+		pool := p.RequestData(context.Background(), nil, url)
+		s.Require().NotNil(pool)
+		buf := make([]byte, 4)
+		_, err = pool.GetData(buf, 0)
+		s.NoError(err)
+
+		atomic.StoreInt32(&version, 2)
+		_, err = pool.GetData(buf, 16)
+		s.ErrorIs(err, errHTTPChanged, "with ETag: %v", etag)
+		server.Close()
+	}
+}
+
+func (s *HTTPPortTestSuite) TestBadRange() {
+	data := s.Files["p1.djvu"]
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-15/%d", len(data)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(data[:16])
+	}))
+	defer server.Close()
+
+	p := NewHTTPPort(server.Client())
+	p.BlockSize = 16
+	url, err := NewUrl(server.URL + "/p1.djvu")
+	s.Require().NoError(err)
+	pool := p.RequestData(context.Background(), nil, url)
+	s.Require().NotNil(pool)
+	buf := make([]byte, 4)
+	_, err = pool.GetData(buf, 0)
+	s.NoError(err)
+	_, err = pool.GetData(buf, 16) // Answered with the first block again
+	s.Error(err)
+}
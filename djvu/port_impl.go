@@ -1,73 +1,66 @@
 package djvu
 
-// Concrete implementation of a Port
+import "context"
+
+// Concrete implementation of a Port which ignores every request and notification.
+// It can be embedded in structs which only care about a few of the methods.
 type port struct {
-	// Insert elements here...
+	// Ports are told apart by their pointers,
+	// and pointers to distinct zero-sized values may be equal.
+	_ byte
 }
 
 // Copy implements Port
 func (*port) Copy() Port {
-	panic("unimplemented")
+	return &port{}
 }
 
 // IdToFile implements Port
 func (*port) IdToFile(source Port, id string) *File {
-	panic("unimplemented")
+	return nil
 }
 
 // IdToUrl implements Port
-func (*port) IdToUrl(source Port, id string) Url {
-	panic("unimplemented")
+func (*port) IdToUrl(source Port, id string) *Url {
+	return nil
 }
 
 // Inherits implements Port
 func (*port) Inherits(className string) bool {
-	panic("unimplemented")
+	return className == "Port"
 }
 
 // NotifyChunkDone implements Port
-func (*port) NotifyChunkDone(source Port, name string) {
-	panic("unimplemented")
-}
+func (*port) NotifyChunkDone(source Port, name string) {}
 
 // NotifyDecodeProgress implements Port
-func (*port) NotifyDecodeProgress(source Port, done float64) {
-	panic("unimplemented")
-}
+func (*port) NotifyDecodeProgress(source Port, done float64) {}
 
 // NotifyDocFlagsChanged implements Port
-func (*port) NotifyDocFlagsChanged(source *Document, setMask uint64, clearMask uint64) {
-	panic("unimplemented")
-}
+func (*port) NotifyDocFlagsChanged(source *Document, setMask uint64, clearMask uint64) {}
 
 // NotifyError implements Port
 func (*port) NotifyError(source Port, msg string) bool {
-	panic("unimplemented")
+	return false
 }
 
 // NotifyFileFlagsChanged implements Port
-func (*port) NotifyFileFlagsChanged(source *File, setMask uint64, clearMask uint64) {
-	panic("unimplemented")
-}
+func (*port) NotifyFileFlagsChanged(source *File, setMask uint64, clearMask uint64) {}
 
 // NotifyRedisplay implements Port
-func (*port) NotifyRedisplay(source *Image) {
-	panic("unimplemented")
-}
+func (*port) NotifyRedisplay(source *Image) {}
 
 // NotifyRelayout implements Port
-func (*port) NotifyRelayout(source *Image) {
-	panic("unimplemented")
-}
+func (*port) NotifyRelayout(source *Image) {}
 
 // NotifyStatus implements Port
 func (*port) NotifyStatus(source Port, msg string) bool {
-	panic("unimplemented")
+	return false
 }
 
 // RequestData implements Port
-func (*port) RequestData(source Port, url *Url) *DataPool {
-	panic("unimplemented")
+func (*port) RequestData(ctx context.Context, source Port, url *Url) *DataPool {
+	return nil
 }
 
 // Makes sure port implements Port in the compiler level
@@ -0,0 +1,89 @@
+package djvu
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryPort answers RequestData with data registered in memory,
+// so that documents can be opened without touching the filesystem.
+// Any URL will do, although the `memory:` protocol reads best:
+//
+//     mem := NewMemoryPort()
+//     url, _ := NewUrl("memory:book.djvu")
+//     mem.Add(url, data)
+//     doc, err := OpenDocument(ctx, url, mem)
+//
+// Indirect documents are served by registering every file
+// next to the index, such as `memory:p0001.djvu`.
+// URLs are compared without their hash argument.
+type MemoryPort struct {
+	port
+
+	mtx   sync.RWMutex
+	pools map[string]*DataPool
+}
+
+// NewMemoryPort creates a MemoryPort without any data.
+func NewMemoryPort() *MemoryPort {
+	return &MemoryPort{pools: make(map[string]*DataPool)}
+}
+
+// Add associates `url` with a copy of `data`,
+// replacing whatever was associated before.
+func (p *MemoryPort) Add(url *Url, data []byte) {
+	p.AddPool(url, NewDataPoolFromBytes(data))
+}
+
+// AddPool associates `url` with `pool`,
+// which may still be receiving data.
+func (p *MemoryPort) AddPool(url *Url, pool *DataPool) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.pools[memoryPortKey(url)] = pool
+}
+
+// Remove forgets the data associated with `url`.
+// DataPools already handed out remain usable.
+func (p *MemoryPort) Remove(url *Url) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	delete(p.pools, memoryPortKey(url))
+}
+
+func memoryPortKey(url *Url) string {
+	key := url.Copy()
+	key.ClearHashArguments()
+	return key.Raw()
+}
+
+// Copy implements Port.
+// The copy shares the registered DataPools.
+func (p *MemoryPort) Copy() Port {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	retval := NewMemoryPort()
+	for key, pool := range p.pools {
+		retval.pools[key] = pool
+	}
+	return retval
+}
+
+// Inherits implements Port
+func (p *MemoryPort) Inherits(className string) bool {
+	return className == "MemoryPort" || p.port.Inherits(className)
+}
+
+// RequestData returns the DataPool associated with `url`, or nil.
+func (p *MemoryPort) RequestData(ctx context.Context, source Port, url *Url) *DataPool {
+	if url == nil {
+		return nil
+	}
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+	return p.pools[memoryPortKey(url)]
+}
+
+// Enforces interface in the compiler level
+var _ Port = &MemoryPort{}
@@ -1,5 +1,14 @@
 package djvu
 
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
 // SimplePort provides basic functionality for the Port interface.
 // A SimplePort is automatically created
 // when you create a File or a Document without specifying a Port.
@@ -7,74 +16,91 @@ package djvu
 // (i.e. urls referring to local files)
 // and display error messages on STDERR.
 // All other notifications are ignored.
+//
+// Files are read on demand and kept open until Close is called,
+// and every request for a file returns the same DataPool.
+// Document.Close closes the SimplePort created by OpenDocument.
 type SimplePort struct {
-	// TODO: Fields
+	port
+
+	mtx   sync.Mutex
+	pools map[string]*DataPool // Pools already handed out, by file name
+	files map[string]*os.File  // Files kept open for their pools, by file name
 }
 
 // Copy implements Port
 func (*SimplePort) Copy() Port {
-	panic("unimplemented")
-}
-
-// IdToFile implements Port
-func (*SimplePort) IdToFile(source Port, id string) *File {
-	panic("unimplemented")
-}
-
-// IdToUrl implements Port
-func (*SimplePort) IdToUrl(source Port, id string) Url {
-	panic("unimplemented")
-}
-
-// NotifyChunkDone implements Port
-func (*SimplePort) NotifyChunkDone(source Port, name string) {
-	panic("unimplemented")
+	return &SimplePort{}
 }
 
-// NotifyDecodeProgress implements Port
-func (*SimplePort) NotifyDecodeProgress(source Port, done float64) {
-	panic("unimplemented")
-}
-
-// NotifyDocFlagsChanged implements Port
-func (*SimplePort) NotifyDocFlagsChanged(source *Document, setMask uint64, clearMask uint64) {
-	panic("unimplemented")
-}
-
-// NotifyFileFlagsChanged implements Port
-func (*SimplePort) NotifyFileFlagsChanged(source *File, setMask uint64, clearMask uint64) {
-	panic("unimplemented")
-}
-
-// NotifyRedisplay implements Port
-func (*SimplePort) NotifyRedisplay(source *Image) {
-	panic("unimplemented")
+/// Returns true if #class_name# is #"Port"# or #"SimplePort"#.
+func (p *SimplePort) Inherits(className string) bool {
+	return className == "SimplePort" || p.port.Inherits(className)
 }
 
-// NotifyRelayout implements Port
-func (*SimplePort) NotifyRelayout(source *Image) {
-	panic("unimplemented")
-}
+// RequestData returns the data of the local file `url` refers to,
+// or nil if `url` is not a local file or cannot be opened.
+func (p *SimplePort) RequestData(ctx context.Context, source Port, url *Url) *DataPool {
+	if !url.IsLocalFileUrl() {
+		return nil
+	}
+	filename, err := url.Filename()
+	if err != nil {
+		return nil
+	}
 
-/// Returns 1 if #class_name# is #"DjVuPort"# or #"DjVuSimplePort"#.
-func (p *SimplePort) Inherits(className string) bool {
-	panic("unimplemented")
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if pool, ok := p.pools[filename]; ok {
+		return pool
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil
+	}
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		f.Close()
+		return nil
+	}
+	if p.pools == nil {
+		p.pools, p.files = make(map[string]*DataPool), make(map[string]*os.File)
+	}
+	pool := NewDataPoolFromReaderAt(f, info.Size())
+	p.pools[filename], p.files[filename] = pool, f
+	return pool
 }
 
-/** If #url# is local, it created a \Ref{DataPool}, connects it to the
-  file with the given name and returns.  Otherwise returns #0#. */
-func (p *SimplePort) RequestData(source Port, url *Url) *DataPool {
-	panic("unimplemented")
+// Close closes the files kept open for the DataPools handed out so far,
+// and stops these DataPools.
+// Reading from them fails afterwards,
+// but the port can still be used: RequestData opens the files again.
+func (p *SimplePort) Close() error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	var err error
+	for name, f := range p.files {
+		if closeErr := f.Close(); closeErr != nil && err == nil {
+			err = errors.Wrapf(closeErr, "could not close %s", name)
+		}
+	}
+	for _, pool := range p.pools {
+		pool.Stop()
+	}
+	p.pools, p.files = nil, nil
+	return err
 }
 
 /// Displays error on #stderr#. Always returns 1.
 func (p *SimplePort) NotifyError(source Port, msg string) bool {
-	panic("unimplemented")
+	fmt.Fprintln(os.Stderr, msg)
+	return true
 }
 
 /// Displays status on #stderr#. Always returns 1.
 func (p *SimplePort) NotifyStatus(source Port, msg string) bool {
-	panic("unimplemented")
+	fmt.Fprintln(os.Stderr, msg)
+	return true
 }
 
 // Enforces interface in the compiler level
@@ -0,0 +1,217 @@
+package djvu
+
+import (
+	"bytes"
+	"image/jpeg"
+
+	"github.com/janreggie/go-djvulibre/djvu/iff"
+	"github.com/janreggie/go-djvulibre/djvu/image"
+	"github.com/janreggie/go-djvulibre/djvu/iw44"
+	"github.com/janreggie/go-djvulibre/djvu/jb2"
+	"github.com/pkg/errors"
+)
+
+// Render renders the page at 1/`subsample` of its resolution, the way viewers show it.
+// The foreground colors are painted through the mask over the background,
+// and the image is turned according to the orientation of the page.
+//
+// Layers of the page are read from its chunks:
+// the background from `BG44` or `BGjp`,
+// the mask from `Sjbz` with the shapes of `Djbz`,
+// which may be in an included file,
+// and the foreground colors from `FGbz`, `FG44` or `FGjp`.
+// Pages without background are white and masks without colors are black.
+func (p *Page) Render(subsample int) (*image.Pixmap, error) {
+	if subsample < 1 {
+		return nil, errors.Errorf("bad subsampling %d", subsample)
+	}
+	w, h := int(p.Info.Width), int(p.Info.Height)
+	if w == 0 || h == 0 {
+		return nil, errors.New("page is empty")
+	}
+
+	full := image.NewPixmap(h, w)
+	bg, err := p.layer("BG44", "BGjp")
+	if err != nil {
+		return nil, errors.Wrap(err, "could not decode background")
+	}
+	if bg != nil {
+		full = bg.Scale(h, w)
+	}
+
+	mask, err := p.Mask()
+	if err != nil {
+		return nil, err
+	}
+	if mask != nil {
+		if err := p.paintForeground(full, mask); err != nil {
+			return nil, err
+		}
+	}
+
+	retval := full
+	if subsample > 1 {
+		retval = full.Scale((h+subsample-1)/subsample, (w+subsample-1)/subsample)
+	}
+	return retval.Rotate(orientationTurns(p.Info.Orientation)), nil
+}
+
+// Thumbnail renders the page so that its larger side is `size` pixels long,
+// keeping its proportions.
+func (p *Page) Thumbnail(size int) (*image.Pixmap, error) {
+	if size < 1 {
+		return nil, errors.Errorf("bad thumbnail size %d", size)
+	}
+	w, h := int(p.Info.Width), int(p.Info.Height)
+	largest := h
+	if w > h {
+		largest = w
+	}
+	subsample := largest / size
+	if subsample < 1 {
+		subsample = 1
+	}
+	pm, err := p.Render(subsample)
+	if err != nil {
+		return nil, err
+	}
+
+	tw, th := size, pm.Rows()*size/pm.Cols()
+	if pm.Cols() < pm.Rows() {
+		tw, th = pm.Cols()*size/pm.Rows(), size
+	}
+	if tw < 1 {
+		tw = 1
+	}
+	if th < 1 {
+		th = 1
+	}
+	return pm.Scale(th, tw), nil
+}
+
+// Returns the number of quarter turns counterclockwise
+// which show a page of the given orientation upright
+func orientationTurns(o Orientation) int {
+	switch o {
+	case ORIENTATION_90:
+		return 1
+	case ORIENTATION_180:
+		return 2
+	case ORIENTATION_270:
+		return 3
+	}
+	return 0
+}
+
+// Decodes the layer held by the IW44 chunks `iw44ID` or the JPEG chunk `jpegID`,
+// returning nil if there is none
+func (p *Page) layer(iw44ID, jpegID string) (*image.Pixmap, error) {
+	if chunk := p.Form.Find(jpegID); chunk != nil {
+		img, err := jpeg.Decode(bytes.NewReader(chunk.Data))
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not decode %s chunk", jpegID)
+		}
+		return image.NewPixmapFromImage(img), nil
+	}
+	chunks := p.Form.FindAll(iw44ID)
+	if len(chunks) == 0 {
+		return nil, nil
+	}
+	img := iw44.NewImage()
+	for _, chunk := range chunks {
+		if err := img.DecodeChunk(bytes.NewReader(chunk.Data)); err != nil {
+			return nil, err
+		}
+	}
+	return img.Pixmap()
+}
+
+// Mask decodes the mask of the page from its `Sjbz` chunk,
+// returning nil if there is none.
+// The shapes the mask requires are read from the `Djbz` chunk of the page
+// or of the files it includes.
+func (p *Page) Mask() (*jb2.Image, error) {
+	chunk := p.Form.Find("Sjbz")
+	if chunk == nil {
+		return nil, nil
+	}
+	dict, err := p.sharedShapes()
+	if err != nil {
+		return nil, err
+	}
+	return jb2.Decode(bytes.NewReader(chunk.Data), dict)
+}
+
+// Returns the shapes shared with other pages, or nil if there are none
+func (p *Page) sharedShapes() (*jb2.Dict, error) {
+	chunk := p.Form.Find("Djbz")
+	if chunk == nil {
+		chunk = findChunk(p.Included, "Djbz")
+	}
+	if chunk == nil {
+		return nil, nil
+	}
+	return jb2.DecodeDict(bytes.NewReader(chunk.Data), nil)
+}
+
+// Paints the foreground through the mask onto full, an image of the size of the page
+func (p *Page) paintForeground(full *image.Pixmap, mask *jb2.Image) error {
+	var palette *Palette
+	if chunk := p.Form.Find("FGbz"); chunk != nil {
+		palette = NewPalette()
+		if err := palette.Decode(bytes.NewReader(chunk.Data)); err != nil {
+			return err
+		}
+	}
+	var fg *image.Pixmap
+	if palette == nil {
+		var err error
+		if fg, err = p.layer("FG44", "FGjp"); err != nil {
+			return errors.Wrap(err, "could not decode foreground")
+		}
+	}
+	w, h := full.Cols(), full.Rows()
+	if fg != nil {
+		fg = fg.Scale(h, w)
+	}
+
+	for ii, blit := range mask.Blits {
+		if blit.Shape < 0 || blit.Shape >= mask.ShapeCount() {
+			return errors.Errorf("blit %d of mask has bad shape %d", ii, blit.Shape)
+		}
+		color := image.BlackPixel
+		if palette != nil {
+			color = palette.Color(ii)
+		}
+		bits := mask.Shape(blit.Shape).Bits
+		for rr := 0; rr < int(bits.Rows()); rr++ {
+			y := blit.Bottom + rr
+			if y < 0 || y >= h {
+				continue
+			}
+			line := full.GetLine(y)
+			for cc, v := range bits.GetLine(rr) {
+				x := blit.Left + cc
+				if v == 0 || x < 0 || x >= w {
+					continue
+				}
+				if fg != nil {
+					line[x] = fg.GetLine(y)[x]
+				} else {
+					line[x] = color
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the first chunk with the given ID among the children of forms, or nil
+func findChunk(forms []*iff.Chunk, id string) *iff.Chunk {
+	for _, form := range forms {
+		if chunk := form.Find(id); chunk != nil {
+			return chunk
+		}
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package djvu
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/janreggie/go-djvulibre/djvu/bzz"
+	"github.com/janreggie/go-djvulibre/djvu/iff"
+	"github.com/janreggie/go-djvulibre/djvu/image"
+	"github.com/janreggie/go-djvulibre/djvu/iw44"
+	"github.com/janreggie/go-djvulibre/djvu/jb2"
+	"github.com/stretchr/testify/suite"
+)
+
+type RenderTestSuite struct {
+	suite.Suite
+}
+
+func TestRenderSuite(t *testing.T) {
+	suite.Run(t, new(RenderTestSuite))
+}
+
+var (
+	testBackground = image.Pixel{B: 200, G: 150, R: 100}
+	testForeground = image.Pixel{B: 0, G: 0, R: 255}
+)
+
+// Returns the data of a FGbz chunk with the given colors and indices of the blits
+func testPaletteData(s *suite.Suite, colors []image.Pixel, indices []int) []byte {
+	data := []byte{0, byte(len(colors) >> 8), byte(len(colors))}
+	for _, c := range colors {
+		data = append(data, c.B, c.G, c.R)
+	}
+	if indices == nil {
+		return data
+	}
+	data[0] |= 0x80
+	data = append(data, byte(len(indices)>>16), byte(len(indices)>>8), byte(len(indices)))
+	var raw []byte
+	for _, index := range indices {
+		raw = append(raw, byte(index>>8), byte(index))
+	}
+	compressed, err := bzz.Compress(raw)
+	s.Require().NoError(err)
+	return append(data, compressed...)
+}
+
+// Returns a 60x40 page with a uniform background and two 10x10 squares,
+// at (5, 5) in the first color of the palette and at (30, 20) in the second
+func (s *RenderTestSuite) page(orientation Orientation) *Page {
+	form := testPage(60, 40)
+	info := NewInfo()
+	info.Width, info.Height, info.Dpi, info.Gamma, info.Orientation = 60, 40, 300, 2.2, orientation
+	var buf bytes.Buffer
+	s.Require().NoError(info.Encode(&buf))
+	form.Children[0].Data = buf.Bytes()
+
+	bg := image.NewPixmap(20, 30)
+	bg.Fill(testBackground)
+	e, err := iw44.NewPixmapEncoder(bg, nil, iw44.CRCB_FULL)
+	s.Require().NoError(err)
+	chunks, err := e.EncodeChunks("BG44", iw44.EncoderParams{Slices: 100})
+	s.Require().NoError(err)
+	form.Children = append(form.Children, chunks...)
+
+	square, err := image.NewBitmap(10, 10, 0)
+	s.Require().NoError(err)
+	square.Fill(1)
+	mask := &jb2.Image{Width: 60, Height: 40}
+	shape := mask.AddShape(jb2.Shape{Bits: square, Parent: jb2.NO_PARENT})
+	mask.Blits = []jb2.Blit{{Left: 5, Bottom: 5, Shape: shape}, {Left: 30, Bottom: 20, Shape: shape}}
+	var sjbz bytes.Buffer
+	s.Require().NoError(mask.Encode(&sjbz))
+	form.Children = append(form.Children,
+		&iff.Chunk{ID: "Sjbz", Data: sjbz.Bytes()},
+		&iff.Chunk{ID: "FGbz", Data: testPaletteData(&s.Suite, []image.Pixel{testForeground, image.BlackPixel}, []int{0, 1})},
+	)
+
+	page, err := NewPage(0, form)
+	s.Require().NoError(err)
+	return page
+}
+
+// Checks that actual is at most 8 off expected in each component
+func testNear(s *suite.Suite, expected, actual image.Pixel) {
+	for _, d := range []int{
+		int(expected.B) - int(actual.B),
+		int(expected.G) - int(actual.G),
+		int(expected.R) - int(actual.R),
+	} {
+		s.True(d >= -8 && d <= 8, "expected %v, got %v", expected, actual)
+	}
+}
+
+func (s *RenderTestSuite) TestRender() {
+	page := s.page(ORIENTATION_0)
+	pm, err := page.Render(1)
+	s.Require().NoError(err)
+	s.Equal(40, pm.Rows())
+	s.Equal(60, pm.Cols())
+	s.Equal(testForeground, pm.GetLine(10)[10])
+	s.Equal(image.BlackPixel, pm.GetLine(25)[35])
+	testNear(&s.Suite, testBackground, pm.GetLine(30)[10])
+	testNear(&s.Suite, testBackground, pm.GetLine(10)[50])
+
+	pm, err = page.Render(2)
+	s.Require().NoError(err)
+	s.Equal(20, pm.Rows())
+	s.Equal(30, pm.Cols())
+	testNear(&s.Suite, testForeground, pm.GetLine(5)[5])
+
+	// Turned upright
+	pm, err = s.page(ORIENTATION_90).Render(1)
+	s.Require().NoError(err)
+	s.Equal(60, pm.Rows())
+	s.Equal(40, pm.Cols())
+
+	pm, err = page.Thumbnail(30)
+	s.Require().NoError(err)
+	s.Equal(20, pm.Rows())
+	s.Equal(30, pm.Cols())
+
+	_, err = page.Render(0)
+	s.Error(err)
+	_, err = page.Thumbnail(0)
+	s.Error(err)
+}
+
+func (s *RenderTestSuite) TestWithoutLayers() {
+	page, err := NewPage(0, testPage(20, 10))
+	s.Require().NoError(err)
+	mask, err := page.Mask()
+	s.NoError(err)
+	s.Nil(mask)
+	pm, err := page.Render(1)
+	s.Require().NoError(err)
+	s.Equal(image.WhitePixel, pm.GetLine(5)[5])
+
+	// Masks without colors are black
+	page = s.page(ORIENTATION_0)
+	page.Form.Children = page.Form.Children[:len(page.Form.Children)-1]
+	pm, err = page.Render(1)
+	s.Require().NoError(err)
+	s.Equal(image.BlackPixel, pm.GetLine(10)[10])
+}
+
+func (s *RenderTestSuite) TestPalette() {
+	colors := []image.Pixel{testForeground, image.BlackPixel, testBackground}
+	p := NewPalette()
+	s.Require().NoError(p.Decode(bytes.NewReader(testPaletteData(&s.Suite, colors, []int{2, 0, 1, 2}))))
+	s.Equal(colors, p.Colors)
+	s.Equal([]int{2, 0, 1, 2}, p.Indices)
+	s.Equal(testBackground, p.Color(0))
+	s.Equal(testForeground, p.Color(1))
+	s.Equal(testForeground, p.Color(10))
+
+	s.Require().NoError(p.Decode(bytes.NewReader(testPaletteData(&s.Suite, colors[:1], nil))))
+	s.Nil(p.Indices)
+	s.Equal(testForeground, p.Color(3))
+	s.Equal(image.BlackPixel, NewPalette().Color(0))
+
+	for _, bad := range [][]byte{
+		{0, 0},
+		{1, 0, 0},
+		{0, 0, 2, 1, 2, 3},
+		testPaletteData(&s.Suite, colors[:1], []int{1}),
+	} {
+		s.Error(p.Decode(bytes.NewReader(bad)), "%v", bad)
+	}
+}
+
+func (s *RenderTestSuite) TestPaletteFormat() {
+	// Only the indices are compressed, after their uncompressed count
+	compressed, err := bzz.Compress([]byte{0, 1, 0, 0, 0, 1})
+	s.Require().NoError(err)
+	data := append([]byte{
+		0x80,       // Version, with indices
+		0x00, 0x02, // Two colors
+		0x10, 0x20, 0x30, // Blue, green and red of the first color
+		0x40, 0x50, 0x60, // and of the second
+		0x00, 0x00, 0x03, // Three indices
+	}, compressed...)
+
+	p := NewPalette()
+	s.Require().NoError(p.Decode(bytes.NewReader(data)))
+	s.Equal([]image.Pixel{{B: 0x10, G: 0x20, R: 0x30}, {B: 0x40, G: 0x50, R: 0x60}}, p.Colors)
+	s.Equal([]int{1, 0, 1}, p.Indices)
+
+	var buf bytes.Buffer
+	s.Require().NoError(p.Encode(&buf))
+	s.Equal(data, buf.Bytes())
+}
@@ -0,0 +1,155 @@
+package djvu
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/janreggie/go-djvulibre/djvu/image"
+	"github.com/pkg/errors"
+)
+
+// SearchOptions tells how Search matches the query
+type SearchOptions struct {
+	// Whether upper and lower case letters are different
+	MatchCase bool
+
+	// Whether matches must start and end at word boundaries
+	WholeWord bool
+
+	// Whether the query is a regular expression, in the syntax of package regexp.
+	// Otherwise, the query is a phrase whose words may be split by any spaces or line breaks.
+	Regexp bool
+}
+
+// SearchResult is a match of a search
+type SearchResult struct {
+	// Page of the match, counted from 0
+	Page int
+
+	// The text matched, with words separated by single spaces
+	Text string
+
+	// Words the match overlaps, which may span several lines
+	Words []*Zone
+
+	// Union of the bounds of Words
+	Rect image.Rect
+}
+
+// Search finds the query in the hidden text of all pages, in order.
+// Pages without hidden text are skipped.
+func (doc *Document) Search(ctx context.Context, query string, opts SearchOptions) ([]SearchResult, error) {
+	re, err := opts.compile(query)
+	if err != nil {
+		return nil, err
+	}
+	var retval []SearchResult
+	for page := 0; page < doc.GetPagesNum(); page++ {
+		if err := ctx.Err(); err != nil {
+			return retval, err
+		}
+		text, err := doc.GetPageText(ctx, page)
+		if err != nil {
+			return retval, errors.Wrapf(err, "could not read the text of page %d", page)
+		}
+		for _, result := range text.search(re, opts.WholeWord) {
+			result.Page = page
+			retval = append(retval, result)
+		}
+	}
+	return retval, nil
+}
+
+// Search finds the query in the text of a single page.
+// The Page of the results is 0.
+func (t *Text) Search(query string, opts SearchOptions) ([]SearchResult, error) {
+	re, err := opts.compile(query)
+	if err != nil {
+		return nil, err
+	}
+	return t.search(re, opts.WholeWord), nil
+}
+
+// Returns the regular expression matching query
+func (opts SearchOptions) compile(query string) (*regexp.Regexp, error) {
+	expr := query
+	if !opts.Regexp {
+		words := strings.Fields(query)
+		for ii, word := range words {
+			words[ii] = regexp.QuoteMeta(word)
+		}
+		expr = strings.Join(words, `\s+`)
+	}
+	if expr == "" {
+		return nil, errors.New("empty search query")
+	}
+	if !opts.MatchCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "bad search query %q", query)
+	}
+	return re, nil
+}
+
+// A word of a page and where it is in the text being searched
+type searchWord struct {
+	zone       *Zone
+	start, end int
+}
+
+func (t *Text) search(re *regexp.Regexp, wholeWord bool) []SearchResult {
+	if t.Page == nil {
+		return nil
+	}
+
+	// Joins the words of the page with single spaces,
+	// taking the zones without children when there are no words
+	var sb strings.Builder
+	var words []searchWord
+	t.Page.Walk(func(zone *Zone) bool {
+		if zone.Level < ZONE_WORD && len(zone.Children) > 0 {
+			return true
+		}
+		text := trimSeparators(zone.PlainText())
+		if text == "" {
+			return false
+		}
+		if sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		words = append(words, searchWord{zone: zone, start: sb.Len(), end: sb.Len() + len(text)})
+		sb.WriteString(text)
+		return false
+	})
+	text := sb.String()
+
+	var retval []SearchResult
+	for _, match := range re.FindAllStringIndex(text, -1) {
+		start, end := match[0], match[1]
+		if start == end || (wholeWord && !(isWordBoundary(text, start) && isWordBoundary(text, end))) {
+			continue
+		}
+		result := SearchResult{Text: text[start:end]}
+		for _, word := range words {
+			if word.start < end && word.end > start {
+				result.Words = append(result.Words, word.zone)
+				result.Rect = result.Rect.Recthull(word.zone.Rect)
+			}
+		}
+		retval = append(retval, result)
+	}
+	return retval
+}
+
+// Returns whether the position ii of text is not between two letters or digits
+func isWordBoundary(text string, ii int) bool {
+	isWordRune := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' }
+	before, _ := utf8.DecodeLastRuneInString(text[:ii])
+	after, _ := utf8.DecodeRuneInString(text[ii:])
+	return !(ii > 0 && isWordRune(before)) || !(ii < len(text) && isWordRune(after))
+}
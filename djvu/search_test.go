@@ -0,0 +1,105 @@
+package djvu
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/janreggie/go-djvulibre/djvu/iff"
+	"github.com/janreggie/go-djvulibre/djvu/image"
+	"github.com/stretchr/testify/suite"
+)
+
+type SearchTestSuite struct {
+	suite.Suite
+}
+
+func TestSearchSuite(t *testing.T) {
+	suite.Run(t, new(SearchTestSuite))
+}
+
+func (s *SearchTestSuite) search(query string, opts SearchOptions) []SearchResult {
+	results, err := testText().Search(query, opts)
+	s.Require().NoError(err, query)
+	return results
+}
+
+func (s *SearchTestSuite) TestSearch() {
+	results := s.search("hello", SearchOptions{})
+	s.Require().Len(results, 1)
+	s.Equal("Hello", results[0].Text)
+	s.Equal(image.NewRect(100, 620, 300, 80), results[0].Rect)
+
+	// A phrase across two lines
+	results = s.search("WÖ   again", SearchOptions{})
+	s.Require().Len(results, 1)
+	s.Equal("wö again", results[0].Text)
+	s.Len(results[0].Words, 2)
+	s.Equal(image.NewRect(100, 500, 800, 200), results[0].Rect)
+
+	s.Len(s.search("hello", SearchOptions{MatchCase: true}), 0)
+	s.Len(s.search("Hello", SearchOptions{MatchCase: true}), 1)
+	s.Len(s.search("gain", SearchOptions{}), 1)
+	s.Len(s.search("gain", SearchOptions{WholeWord: true}), 0)
+	s.Len(s.search("End", SearchOptions{WholeWord: true}), 1)
+	s.Len(s.search("a.", SearchOptions{}), 0)
+	s.Len(s.search("l+o", SearchOptions{}), 0)
+
+	results = s.search(`[a-z]+ain|^h\w+`, SearchOptions{Regexp: true})
+	s.Require().Len(results, 2)
+	s.Equal("Hello", results[0].Text)
+	s.Equal("again", results[1].Text)
+	s.Len(s.search(`x*`, SearchOptions{Regexp: true}), 0)
+
+	for _, query := range []string{"", "  ", "("} {
+		_, err := testText().Search(query, SearchOptions{Regexp: query == "("})
+		s.Error(err, query)
+	}
+	results, err := NewText().Search("hello", SearchOptions{})
+	s.NoError(err)
+	s.Empty(results)
+}
+
+func (s *SearchTestSuite) TestDocumentSearch() {
+	withText := testPage(1000, 800)
+	s.Require().NoError(SetTextChunk(withText, testText(), true))
+
+	dir := NewMultiDir()
+	mem := NewMemoryPort()
+	add := func(name string, chunk *iff.Chunk) {
+		data, err := chunk.Bytes()
+		s.Require().NoError(err)
+		url, err := NewUrl("memory:" + name)
+		s.Require().NoError(err)
+		mem.Add(url, data)
+	}
+	for ii, page := range []*iff.Chunk{testPage(1000, 800), withText, withText} {
+		name := fmt.Sprintf("p%d.djvu", ii+1)
+		s.Require().NoError(dir.InsertFile(&MultiDirFile{ID: name, Type: FILE_PAGE}, -1))
+		add(name, page)
+	}
+	var dirm bytes.Buffer
+	s.Require().NoError(dir.Encode(&dirm, false))
+	add("index.djvu", &iff.Chunk{ID: "FORM:DJVM", Children: []*iff.Chunk{{ID: "DIRM", Data: dirm.Bytes()}}})
+
+	url, err := NewUrl("memory:index.djvu")
+	s.Require().NoError(err)
+	doc, err := OpenDocument(context.Background(), url, mem)
+	s.Require().NoError(err)
+	defer doc.Close()
+
+	results, err := doc.Search(context.Background(), "again end", SearchOptions{})
+	s.Require().NoError(err)
+	s.Require().Len(results, 2)
+	s.Equal(1, results[0].Page)
+	s.Equal(2, results[1].Page)
+	s.Equal(image.NewRect(100, 100, 500, 480), results[0].Rect)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = doc.Search(ctx, "again", SearchOptions{})
+	s.Error(err)
+	_, err = doc.Search(context.Background(), "", SearchOptions{})
+	s.Error(err)
+}
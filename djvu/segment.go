@@ -0,0 +1,289 @@
+package djvu
+
+import (
+	"github.com/janreggie/go-djvulibre/djvu/image"
+	"github.com/janreggie/go-djvulibre/djvu/jb2"
+	"github.com/pkg/errors"
+)
+
+// SegmentOptions tunes how Segment tells the text from the background.
+// Zero fields take their default values.
+type SegmentOptions struct {
+	// Side in pixels of the squares in which the text and the background
+	// are told apart by their lightness, 32 by default.
+	// Smaller squares follow changes of the background better,
+	// while larger squares handle bigger letters.
+	BlockSize int
+
+	// Smallest difference of lightness, from 1 to 255,
+	// between the text and the background of a square, 48 by default.
+	// Squares of less contrast are all background.
+	Contrast int
+
+	// Number of pixels of the smallest groups of touching pixels kept in the mask,
+	// 4 by default.
+	// Smaller groups are taken as specks of the background.
+	MinSize int
+
+	// Factors by which the foreground and the background are smaller than the page,
+	// from 1 to 12, 6 and 3 by default
+	ForegroundReduction, BackgroundReduction int
+}
+
+// Default values of SegmentOptions
+const (
+	defaultBlockSize           = 32
+	defaultContrast            = 48
+	defaultMinSize             = 4
+	defaultForegroundReduction = 6
+	defaultBackgroundReduction = 3
+)
+
+// Returns opts with the defaults filled in
+func (opts SegmentOptions) withDefaults() (SegmentOptions, error) {
+	for _, field := range []struct {
+		value *int
+		def   int
+		max   int
+		name  string
+	}{
+		{&opts.BlockSize, defaultBlockSize, 0xffff, "block size"},
+		{&opts.Contrast, defaultContrast, 255, "contrast"},
+		{&opts.MinSize, defaultMinSize, 1<<31 - 1, "smallest size"},
+		{&opts.ForegroundReduction, defaultForegroundReduction, maxReduction, "foreground reduction"},
+		{&opts.BackgroundReduction, defaultBackgroundReduction, maxReduction, "background reduction"},
+	} {
+		if *field.value == 0 {
+			*field.value = field.def
+		}
+		if *field.value < 0 || *field.value > field.max {
+			return opts, errors.Errorf("bad %s %d", field.name, *field.value)
+		}
+	}
+	return opts, nil
+}
+
+// Segment separates a color scan into the layers of a page,
+// such that ComposePage encodes it compactly.
+// The mask holds the text, which is darker than the background around it.
+// The foreground holds the colors of the text
+// and the background holds the rest of the scan,
+// with the text painted over by the colors around it.
+// The resolution and gamma of the layers are left to the caller.
+func Segment(pm *image.Pixmap, opts SegmentOptions) (*Layers, error) {
+	w, h := pm.Cols(), pm.Rows()
+	if w <= 0 || h <= 0 || w > 0xffff || h > 0xffff {
+		return nil, errors.Errorf("cannot segment image of %dx%d", w, h)
+	}
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	mask, err := image.NewBitmap(uint16(h), uint16(w), 0)
+	if err != nil {
+		return nil, err
+	}
+	gray := make([]int, w*h)
+	for rr := 0; rr < h; rr++ {
+		for cc, pix := range pm.GetLine(rr) {
+			gray[rr*w+cc] = lightness(pix)
+		}
+	}
+	n := opts.BlockSize
+	for bottom := 0; bottom < h; bottom += n {
+		for left := 0; left < w; left += n {
+			top, right := bottom+n, left+n
+			if top > h {
+				top = h
+			}
+			if right > w {
+				right = w
+			}
+			threshold, ok := blockThreshold(gray, w, left, bottom, right, top, opts.Contrast)
+			if !ok {
+				continue
+			}
+			for rr := bottom; rr < top; rr++ {
+				line := mask.GetLine(rr)
+				for cc := left; cc < right; cc++ {
+					if gray[rr*w+cc] <= threshold {
+						line[cc] = 1
+					}
+				}
+			}
+		}
+	}
+	img, err := jb2.NewImageFromBitmap(mask)
+	if err != nil {
+		return nil, err
+	}
+	removeSpeckles(img, opts.MinSize)
+	if mask, err = img.Bitmap(); err != nil {
+		return nil, err
+	}
+
+	layers := &Layers{Width: w, Height: h, Mask: mask}
+	layers.Foreground = reduceColors(pm, opts.ForegroundReduction, image.BlackPixel, func(rr, cc int) bool {
+		return mask.GetLine(rr)[cc] != 0
+	})
+	// Pixels next to the text blend with it
+	layers.Background = reduceColors(pm, opts.BackgroundReduction, image.WhitePixel, func(rr, cc int) bool {
+		for y := rr - 1; y <= rr+1; y++ {
+			if y < 0 || y >= h {
+				continue
+			}
+			line := mask.GetLine(y)
+			for x := cc - 1; x <= cc+1; x++ {
+				if x >= 0 && x < w && line[x] != 0 {
+					return false
+				}
+			}
+		}
+		return true
+	})
+	return layers, nil
+}
+
+// Returns the lightness of pix, from 0 to 255
+func lightness(pix image.Pixel) int {
+	return (77*int(pix.R) + 150*int(pix.G) + 29*int(pix.B) + 128) >> 8
+}
+
+// Returns the lightness at or below which the pixels of gray within the given bounds are text,
+// by splitting them into a dark and a light cluster,
+// and false if the clusters differ by less than contrast
+func blockThreshold(gray []int, w, left, bottom, right, top, contrast int) (int, bool) {
+	dark, light := 255, 0
+	for rr := bottom; rr < top; rr++ {
+		for _, v := range gray[rr*w+left : rr*w+right] {
+			if v < dark {
+				dark = v
+			}
+			if v > light {
+				light = v
+			}
+		}
+	}
+	for iter := 0; iter < 8 && light-dark >= contrast; iter++ {
+		threshold := (dark + light) / 2
+		var darkSum, darkCount, lightSum, lightCount int
+		for rr := bottom; rr < top; rr++ {
+			for _, v := range gray[rr*w+left : rr*w+right] {
+				if v <= threshold {
+					darkSum, darkCount = darkSum+v, darkCount+1
+				} else {
+					lightSum, lightCount = lightSum+v, lightCount+1
+				}
+			}
+		}
+		newDark, newLight := darkSum/darkCount, lightSum/lightCount
+		if newDark == dark && newLight == light {
+			break
+		}
+		dark, light = newDark, newLight
+	}
+	return (dark + light) / 2, light-dark >= contrast
+}
+
+// Removes the blits of img of fewer than minSize pixels
+func removeSpeckles(img *jb2.Image, minSize int) {
+	blits := img.Blits[:0]
+	for _, blit := range img.Blits {
+		if blackPixels(img.Shape(blit.Shape).Bits, minSize) >= minSize {
+			blits = append(blits, blit)
+		}
+	}
+	img.Blits = blits
+}
+
+// Returns the number of nonzero pixels of bm, counting no further than limit
+func blackPixels(bm *image.Bitmap, limit int) int {
+	n := 0
+	for rr := 0; rr < int(bm.Rows()) && n < limit; rr++ {
+		for _, v := range bm.GetLine(rr) {
+			if v != 0 {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// Returns pm reduced by k, each pixel being the average of the pixels it covers
+// for which use tells true.
+// Pixels covering none of those are painted from their neighbours,
+// or with fill if there are none.
+func reduceColors(pm *image.Pixmap, k int, fill image.Pixel, use func(rr, cc int) bool) *image.Pixmap {
+	w, h := pm.Cols(), pm.Rows()
+	rw, rh := (w+k-1)/k, (h+k-1)/k
+	sums := make([][4]int, rw*rh)
+	for rr := 0; rr < h; rr++ {
+		for cc, pix := range pm.GetLine(rr) {
+			if use(rr, cc) {
+				sum := &sums[rr/k*rw+cc/k]
+				sum[0], sum[1], sum[2], sum[3] = sum[0]+int(pix.B), sum[1]+int(pix.G), sum[2]+int(pix.R), sum[3]+1
+			}
+		}
+	}
+
+	reduced := image.NewPixmap(rh, rw)
+	known := make([]bool, rw*rh)
+	for rr := 0; rr < rh; rr++ {
+		line := reduced.GetLine(rr)
+		for cc := range line {
+			if sum := sums[rr*rw+cc]; sum[3] > 0 {
+				n := sum[3]
+				line[cc] = image.Pixel{B: uint8((sum[0] + n/2) / n), G: uint8((sum[1] + n/2) / n), R: uint8((sum[2] + n/2) / n)}
+				known[rr*rw+cc] = true
+			}
+		}
+	}
+	inpaint(reduced, known, fill)
+	return reduced
+}
+
+// Paints the pixels of pm which are not known with the average of their known neighbours,
+// spreading from the known pixels, or with fill if none are known
+func inpaint(pm *image.Pixmap, known []bool, fill image.Pixel) {
+	w, h := pm.Cols(), pm.Rows()
+	type update struct {
+		pos int
+		pix image.Pixel
+	}
+	for {
+		var updates []update
+		unknown := false
+		for rr := 0; rr < h; rr++ {
+			for cc := 0; cc < w; cc++ {
+				if known[rr*w+cc] {
+					continue
+				}
+				unknown = true
+				var b, g, r, n int
+				for y := rr - 1; y <= rr+1; y++ {
+					for x := cc - 1; x <= cc+1; x++ {
+						if y >= 0 && y < h && x >= 0 && x < w && known[y*w+x] {
+							pix := pm.GetLine(y)[x]
+							b, g, r, n = b+int(pix.B), g+int(pix.G), r+int(pix.R), n+1
+						}
+					}
+				}
+				if n > 0 {
+					updates = append(updates, update{rr*w + cc, image.Pixel{B: uint8((b + n/2) / n), G: uint8((g + n/2) / n), R: uint8((r + n/2) / n)}})
+				}
+			}
+		}
+		if !unknown {
+			return
+		}
+		if len(updates) == 0 {
+			pm.Fill(fill)
+			return
+		}
+		for _, u := range updates {
+			pm.GetLine(u.pos / w)[u.pos%w] = u.pix
+			known[u.pos] = true
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package djvu
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/janreggie/go-djvulibre/djvu/iff"
+	"github.com/janreggie/go-djvulibre/djvu/image"
+	"github.com/stretchr/testify/suite"
+)
+
+type SegmentTestSuite struct {
+	suite.Suite
+}
+
+func TestSegmentSuite(t *testing.T) {
+	suite.Run(t, new(SegmentTestSuite))
+}
+
+var (
+	testPaper = image.Pixel{B: 190, G: 235, R: 245}
+	testInk   = image.Pixel{B: 120, G: 30, R: 20}
+)
+
+// Returns whether (cc, rr) is on one of the bars of the text of the scan
+func testInked(rr, cc int) bool {
+	return rr >= 60 && rr < 70 && cc%8 < 3 && cc >= 10 && cc < 110 ||
+		rr >= 20 && rr < 23 && cc >= 10 && cc < 60
+}
+
+// Returns a 120x80 scan of text on shaded paper, with a speck at (100, 30)
+func (s *SegmentTestSuite) scan() *image.Pixmap {
+	pm := image.NewPixmap(80, 120)
+	for rr := 0; rr < 80; rr++ {
+		line := pm.GetLine(rr)
+		for cc := range line {
+			line[cc] = testPaper
+			line[cc].G -= uint8(cc / 8)
+			if testInked(rr, cc) {
+				line[cc] = testInk
+			}
+		}
+	}
+	pm.GetLine(30)[100] = testInk
+	return pm
+}
+
+func (s *SegmentTestSuite) TestSegment() {
+	layers, err := Segment(s.scan(), SegmentOptions{})
+	s.Require().NoError(err)
+	s.Equal(120, layers.Width)
+	s.Equal(80, layers.Height)
+	for rr := 0; rr < 80; rr++ {
+		line := layers.Mask.GetLine(rr)
+		for cc := range line {
+			s.Equal(testInked(rr, cc), line[cc] != 0, "pixel (%d, %d)", cc, rr)
+		}
+	}
+	s.Equal(20, layers.Foreground.Cols())
+	s.Equal(14, layers.Foreground.Rows())
+	s.Equal(testInk, layers.Foreground.GetLine(10)[3])
+	s.Equal(40, layers.Background.Cols())
+	s.Equal(27, layers.Background.Rows())
+	testNear(&s.Suite, testPaper, layers.Background.GetLine(21)[2])
+	testNear(&s.Suite, testPaper, layers.Background.GetLine(7)[10])
+
+	// Encodes the scan
+	layers.ForegroundPalette = true
+	form, err := ComposePage(layers)
+	s.Require().NoError(err)
+	var buf bytes.Buffer
+	s.Require().NoError(form.Encode(&buf, true))
+	decoded, err := iff.DecodeBytes(buf.Bytes())
+	s.Require().NoError(err)
+	page, err := NewPage(0, decoded)
+	s.Require().NoError(err)
+	pm, err := page.Render(1)
+	s.Require().NoError(err)
+	s.Equal(testInk, pm.GetLine(65)[10])
+	testNear(&s.Suite, testPaper, pm.GetLine(40)[5])
+}
+
+func (s *SegmentTestSuite) TestOptions() {
+	// Larger specks are kept
+	layers, err := Segment(s.scan(), SegmentOptions{MinSize: 1})
+	s.Require().NoError(err)
+	s.Equal(byte(1), layers.Mask.GetLine(30)[100])
+
+	// Text lighter than needed is background
+	layers, err = Segment(s.scan(), SegmentOptions{Contrast: 200})
+	s.Require().NoError(err)
+	s.Equal(byte(0), layers.Mask.GetLine(65)[10])
+
+	layers, err = Segment(s.scan(), SegmentOptions{BlockSize: 500, ForegroundReduction: 1, BackgroundReduction: 12})
+	s.Require().NoError(err)
+	s.Equal(byte(1), layers.Mask.GetLine(65)[10])
+	s.Equal(120, layers.Foreground.Cols())
+	s.Equal(10, layers.Background.Cols())
+
+	// Blank pages have no mask
+	blank := image.NewPixmap(10, 10)
+	layers, err = Segment(blank, SegmentOptions{})
+	s.Require().NoError(err)
+	s.Equal(byte(0), layers.Mask.GetLine(5)[5])
+	s.Equal(image.WhitePixel, layers.Background.GetLine(1)[1])
+
+	for _, opts := range []SegmentOptions{
+		{BlockSize: -1},
+		{Contrast: 256},
+		{MinSize: -1},
+		{ForegroundReduction: 13},
+		{BackgroundReduction: -2},
+	} {
+		_, err := Segment(blank, opts)
+		s.Error(err, "%+v", opts)
+	}
+	_, err = Segment(image.NewPixmap(0, 0), SegmentOptions{})
+	s.Error(err)
+}
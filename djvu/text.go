@@ -0,0 +1,451 @@
+package djvu
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/janreggie/go-djvulibre/djvu/bzz"
+	"github.com/janreggie/go-djvulibre/djvu/iff"
+	"github.com/janreggie/go-djvulibre/djvu/image"
+	"github.com/pkg/errors"
+)
+
+// Text represents the hidden text layer of a page,
+// as found in its `TXTa` and `TXTz` chunks.
+// The text is a tree of zones:
+// a page holds columns, which hold regions, then paragraphs, lines, words and characters.
+// Levels may be skipped, such as when lines hold no characters.
+//
+// `TXTz` chunks hold the same data compressed with BZZ.
+type Text struct {
+	// The page zone, or nil if there is no text
+	Page *Zone
+}
+
+// Zone is an area of a page holding text.
+// Only zones without children hold text of their own,
+// and the text of the others is that of their children.
+type Zone struct {
+	Level ZoneLevel
+
+	// Bounds of the zone, in page coordinates
+	Rect image.Rect
+
+	// UTF-8 text, for zones without children.
+	// It does not include the separators between zones.
+	Text string
+
+	Children []*Zone
+}
+
+// ZoneLevel is the kind of a Zone
+type ZoneLevel uint8
+
+const (
+	ZONE_PAGE ZoneLevel = iota + 1
+	ZONE_COLUMN
+	ZONE_REGION
+	ZONE_PARAGRAPH
+	ZONE_LINE
+	ZONE_WORD
+	ZONE_CHARACTER
+)
+
+var zoneLevelNames = []string{"", "page", "column", "region", "para", "line", "word", "char"}
+
+func (l ZoneLevel) String() string { return enumName(zoneLevelNames, int(l)) }
+
+// Characters appended to the text of a zone to separate it from the next one
+var zoneSeparators = map[ZoneLevel]byte{
+	ZONE_COLUMN:    '\v',
+	ZONE_REGION:    '\x1d',
+	ZONE_PARAGRAPH: '\x1f',
+	ZONE_LINE:      '\n',
+	ZONE_WORD:      ' ',
+}
+
+// Returns the level of the zones separated by c,
+// or 0 if c is not a separator
+func separatorLevel(c byte) ZoneLevel {
+	for level, sep := range zoneSeparators {
+		if sep == c {
+			return level
+		}
+	}
+	return 0
+}
+
+// Version of the zones in text chunks
+const textVersion = 1
+
+func NewText() *Text { return &Text{} }
+
+// IsEmpty returns whether there is no text at all.
+func (t *Text) IsEmpty() bool {
+	return t.Page == nil || (len(t.Page.Children) == 0 && t.Page.Text == "" && t.Page.Rect.IsEmpty())
+}
+
+// Decode decodes the contents of a `TXTa` chunk, replacing the text of t.
+func (t *Text) Decode(r io.Reader) error {
+	*t = Text{}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return errors.Wrap(err, "could not read text")
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	d := &textDecoder{data: data}
+	size := d.read24()
+	if d.err == nil && size > len(d.data) {
+		d.err = errors.Errorf("text of %d bytes in %d bytes", size, len(d.data))
+	}
+	if d.err != nil {
+		return d.err
+	}
+	d.text, d.data = string(d.data[:size]), d.data[size:]
+
+	if len(d.data) == 0 {
+		// Text without zones
+		if size > 0 {
+			t.Page = &Zone{Level: ZONE_PAGE, Text: trimSeparators(d.text)}
+		}
+		return nil
+	}
+	if version := d.read8(); version != textVersion {
+		return errors.Errorf("unsupported text version %d", version)
+	}
+	page, err := d.zone(nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	t.Page = page.zone
+	return nil
+}
+
+// Reads the zones of a text chunk
+type textDecoder struct {
+	data []byte
+	text string
+	err  error
+}
+
+func (d *textDecoder) read(n int) int {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.data) < n {
+		d.err = errors.Wrap(io.ErrUnexpectedEOF, "could not read text zones")
+		return 0
+	}
+	v := 0
+	for _, b := range d.data[:n] {
+		v = v<<8 | int(b)
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *textDecoder) read8() int  { return d.read(1) }
+func (d *textDecoder) read16() int { return d.read(2) - 0x8000 }
+func (d *textDecoder) read24() int { return d.read(3) }
+
+// Position of a zone in the text, which the next zones are relative to
+type zoneSpan struct {
+	zone          *Zone
+	start, length int
+}
+
+// Reads a zone and its children.
+// Its position is relative to the previous zone of the same parent if any,
+// or to the parent otherwise.
+func (d *textDecoder) zone(parent, prev *zoneSpan, depth int) (*zoneSpan, error) {
+	level := ZoneLevel(d.read8())
+	x, y := d.read16(), d.read16()
+	width, height := d.read16(), d.read16()
+	start, length := d.read16(), d.read24()
+	count := d.read24()
+	if d.err != nil {
+		return nil, d.err
+	}
+	if level < ZONE_PAGE || level > ZONE_CHARACTER {
+		return nil, errors.Errorf("bad text zone level %d", level)
+	}
+	if width < 0 || height < 0 || length < 0 {
+		return nil, errors.Errorf("bad %s zone size", level)
+	}
+	if depth > int(ZONE_CHARACTER) {
+		return nil, errors.New("text zones are nested too deeply")
+	}
+
+	switch {
+	case prev != nil:
+		r := prev.zone.Rect
+		if level == ZONE_PAGE || level == ZONE_PARAGRAPH || level == ZONE_LINE {
+			// From the top left corner of the previous zone, y going down
+			x += int(r.Xmin())
+			y = int(r.Ymin()) - (y + height)
+		} else {
+			// From the bottom right corner of the previous zone, y going up
+			x += int(r.Xmax())
+			y += int(r.Ymin())
+		}
+		start += prev.start + prev.length
+	case parent != nil:
+		// From the top left corner of the parent, y going down
+		r := parent.zone.Rect
+		x += int(r.Xmin())
+		y = int(r.Ymax()) - (y + height)
+		start += parent.start
+	}
+	zone := &Zone{Level: level, Rect: image.NewRect(int32(x), int32(y), int32(width), int32(height))}
+	span := &zoneSpan{zone: zone, start: start, length: length}
+
+	var prevChild *zoneSpan
+	for ii := 0; ii < count; ii++ {
+		child, err := d.zone(span, prevChild, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		zone.Children = append(zone.Children, child.zone)
+		prevChild = child
+	}
+
+	if count == 0 {
+		if start < 0 || start+length > len(d.text) {
+			return nil, errors.Errorf("%s zone text out of bounds", level)
+		}
+		zone.Text = trimSeparators(d.text[start : start+length])
+	}
+	return span, nil
+}
+
+// Removes the separators at the end of the text of a zone
+func trimSeparators(s string) string {
+	for len(s) > 0 && separatorLevel(s[len(s)-1]) != 0 {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+// Encode writes the text as the contents of a `TXTa` chunk.
+// The text of the zones is joined with the separators of their levels.
+func (t *Text) Encode(w io.Writer) error {
+	e := &textEncoder{}
+	if t.Page != nil {
+		e.layout(t.Page)
+	}
+	if len(e.text) >= 1<<24 {
+		return errors.Errorf("text of %d bytes is too long", len(e.text))
+	}
+
+	e.write(len(e.text), 3)
+	e.data = append(e.data, e.text...)
+	if !t.IsEmpty() {
+		e.write(textVersion, 1)
+		if err := e.zone(t.Page, nil, nil); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(e.data)
+	return errors.Wrap(err, "could not write text")
+}
+
+// Writes the zones of a text chunk
+type textEncoder struct {
+	data  []byte
+	text  []byte
+	spans map[*Zone]*zoneSpan
+}
+
+func (e *textEncoder) write(v int, n int) {
+	for ii := n - 1; ii >= 0; ii-- {
+		e.data = append(e.data, byte(v>>(8*ii)))
+	}
+}
+
+// Appends the text of zone and its children, recording where each one is
+func (e *textEncoder) layout(zone *Zone) {
+	if e.spans == nil {
+		e.spans = make(map[*Zone]*zoneSpan)
+	}
+	span := &zoneSpan{zone: zone, start: len(e.text)}
+	if len(zone.Children) == 0 {
+		e.text = append(e.text, zone.Text...)
+	}
+	for _, child := range zone.Children {
+		e.layout(child)
+	}
+	if sep, ok := zoneSeparators[zone.Level]; ok && len(e.text) > 0 {
+		// The space after the last word of a line or paragraph gives way to its separator
+		last := len(e.text) - 1
+		switch level := separatorLevel(e.text[last]); {
+		case level == ZONE_WORD && zone.Level < ZONE_WORD && last >= span.start:
+			e.text[last] = sep
+		case level == 0 || level > zone.Level:
+			e.text = append(e.text, sep)
+		}
+	}
+	span.length = len(e.text) - span.start
+	e.spans[zone] = span
+}
+
+func (e *textEncoder) zone(zone *Zone, parent, prev *zoneSpan) error {
+	if zone.Level < ZONE_PAGE || zone.Level > ZONE_CHARACTER {
+		return errors.Errorf("bad text zone level %d", zone.Level)
+	}
+	span := e.spans[zone]
+	r := zone.Rect
+	x, y := int(r.Xmin()), int(r.Ymin())
+	width, height := int(r.Width()), int(r.Height())
+	start := span.start
+
+	switch {
+	case prev != nil:
+		p := prev.zone.Rect
+		if zone.Level == ZONE_PAGE || zone.Level == ZONE_PARAGRAPH || zone.Level == ZONE_LINE {
+			x -= int(p.Xmin())
+			y = int(p.Ymin()) - (y + height)
+		} else {
+			x -= int(p.Xmax())
+			y -= int(p.Ymin())
+		}
+		start -= prev.start + prev.length
+	case parent != nil:
+		p := parent.zone.Rect
+		x -= int(p.Xmin())
+		y = int(p.Ymax()) - (y + height)
+		start -= parent.start
+	}
+	for _, v := range []int{x, y, width, height, start} {
+		if v < -0x8000 || v > 0x7fff {
+			return errors.Errorf("%s zone at %v is out of range", zone.Level, zone.Rect)
+		}
+	}
+
+	e.write(int(zone.Level), 1)
+	for _, v := range []int{x, y, width, height, start} {
+		e.write(v+0x8000, 2)
+	}
+	e.write(span.length, 3)
+	e.write(len(zone.Children), 3)
+
+	var prevChild *zoneSpan
+	for _, child := range zone.Children {
+		if err := e.zone(child, span, prevChild); err != nil {
+			return err
+		}
+		prevChild = e.spans[child]
+	}
+	return nil
+}
+
+// PlainText returns the text of the zone and its children,
+// each zone followed by the separator of its level:
+// a newline after lines, a space after words, and control characters after
+// columns (`\v`), regions (`\x1d`) and paragraphs (`\x1f`).
+func (z *Zone) PlainText() string {
+	e := &textEncoder{}
+	e.layout(z)
+	return string(e.text)
+}
+
+// Walk calls fn for the zone and its descendants, parents first.
+// Children of a zone are skipped if fn returns false for it.
+func (z *Zone) Walk(fn func(zone *Zone) bool) {
+	if !fn(z) {
+		return
+	}
+	for _, child := range z.Children {
+		child.Walk(fn)
+	}
+}
+
+// DecodeChunk decodes a `TXTa` or `TXTz` chunk, replacing the text of t.
+func (t *Text) DecodeChunk(chunk *iff.Chunk) error {
+	switch chunk.ID {
+	case "TXTa":
+		return t.Decode(bytes.NewReader(chunk.Data))
+	case "TXTz":
+		return t.Decode(bzz.NewReader(bytes.NewReader(chunk.Data)))
+	}
+	return errors.Errorf("%q is not a text chunk", chunk.ID)
+}
+
+// Chunk returns the text as a `TXTz` chunk if compress is set,
+// or as a `TXTa` chunk otherwise.
+func (t *Text) Chunk(compress bool) (*iff.Chunk, error) {
+	var buf bytes.Buffer
+	if err := t.Encode(&buf); err != nil {
+		return nil, err
+	}
+	if !compress {
+		return &iff.Chunk{ID: "TXTa", Data: buf.Bytes()}, nil
+	}
+	data, err := bzz.Compress(buf.Bytes())
+	if err != nil {
+		return nil, errors.Wrap(err, "could not compress text")
+	}
+	return &iff.Chunk{ID: "TXTz", Data: data}, nil
+}
+
+// Returns whether id is that of a text chunk
+func isTextChunk(id string) bool { return id == "TXTa" || id == "TXTz" }
+
+// DecodeTextChunk decodes the text chunk of a page,
+// given by its `FORM:DJVU` chunk.
+// The Text is empty if there is none.
+func DecodeTextChunk(form *iff.Chunk) (*Text, error) {
+	retval := NewText()
+	for _, chunk := range form.Children {
+		if isTextChunk(chunk.ID) {
+			return retval, retval.DecodeChunk(chunk)
+		}
+	}
+	return retval, nil
+}
+
+// SetTextChunk replaces the text chunks of a page,
+// given by its `FORM:DJVU` chunk, by a single chunk holding text.
+// The chunk is put in place of the first text chunk,
+// or at the end of the page if there were none.
+// All text chunks are removed if text is empty.
+func SetTextChunk(form *iff.Chunk, text *Text, compress bool) error {
+	var chunk *iff.Chunk
+	if !text.IsEmpty() {
+		var err error
+		if chunk, err = text.Chunk(compress); err != nil {
+			return err
+		}
+	}
+
+	children := make([]*iff.Chunk, 0, len(form.Children)+1)
+	for _, child := range form.Children {
+		if !isTextChunk(child.ID) {
+			children = append(children, child)
+		} else if chunk != nil {
+			children = append(children, chunk)
+			chunk = nil
+		}
+	}
+	if chunk != nil {
+		children = append(children, chunk)
+	}
+	form.Children = children
+	return nil
+}
+
+// GetPageText returns the hidden text of page `page`, counted from 0.
+func (doc *Document) GetPageText(ctx context.Context, page int) (*Text, error) {
+	pool, err := doc.GetPageData(ctx, page)
+	if err != nil {
+		return nil, err
+	}
+	form, err := iff.Decode(pool.NewReader(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read page")
+	}
+	return DecodeTextChunk(form)
+}
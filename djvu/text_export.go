@@ -0,0 +1,383 @@
+package djvu
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+
+	"github.com/janreggie/go-djvulibre/djvu/image"
+	"github.com/pkg/errors"
+)
+
+// TextFormat is a format to export hidden text to
+type TextFormat uint8
+
+const (
+	// Plain UTF-8 text, pages separated by form feeds
+	TEXT_PLAIN TextFormat = iota
+	// hOCR, an XHTML document whose elements carry the bounds of the zones
+	TEXT_HOCR
+	// ALTO XML version 4
+	TEXT_ALTO
+	// Tab separated values as output by Tesseract, which can only be imported
+	TEXT_TSV
+)
+
+var textFormatNames = []string{"plain", "hocr", "alto", "tsv"}
+
+func (f TextFormat) String() string { return enumName(textFormatNames, int(f)) }
+
+// ExportText writes the hidden text of the page in the given format.
+func (p *Page) ExportText(w io.Writer, format TextFormat) error {
+	done := false
+	return exportText(w, format, func() (*Page, error) {
+		if done {
+			return nil, nil
+		}
+		done = true
+		return p, nil
+	})
+}
+
+// ExportText writes the hidden text of all pages in the given format,
+// as a single document.
+func (doc *Document) ExportText(ctx context.Context, w io.Writer, format TextFormat) error {
+	next := 0
+	return exportText(w, format, func() (*Page, error) {
+		if next >= doc.GetPagesNum() {
+			return nil, nil
+		}
+		next++
+		return doc.GetPage(ctx, next-1)
+	})
+}
+
+// Writes the pages returned by next until it returns nil
+func exportText(w io.Writer, format TextFormat, next func() (*Page, error)) error {
+	var exporter textExporter
+	switch format {
+	case TEXT_PLAIN:
+		exporter = &plainExporter{w: w}
+	case TEXT_HOCR:
+		exporter = &hocrExporter{xmlWriter: newXmlWriter(w)}
+	case TEXT_ALTO:
+		exporter = &altoExporter{xmlWriter: newXmlWriter(w)}
+	case TEXT_TSV:
+		return errors.New("cannot export text as TSV")
+	default:
+		return errors.Errorf("unknown text format %d", format)
+	}
+
+	exporter.begin()
+	for {
+		page, err := next()
+		if err != nil {
+			return err
+		}
+		if page == nil {
+			break
+		}
+		exporter.page(page)
+	}
+	return errors.Wrapf(exporter.finish(), "could not write %s text", format)
+}
+
+// Writes the text of pages in some format.
+// Errors are returned by finish.
+type textExporter interface {
+	begin()
+	page(p *Page)
+	finish() error
+}
+
+type plainExporter struct {
+	w     io.Writer
+	pages int
+	err   error
+}
+
+func (e *plainExporter) begin() {}
+
+func (e *plainExporter) page(p *Page) {
+	if e.pages > 0 {
+		e.write("\f")
+	}
+	e.pages++
+	if text, _ := p.Text(image.Rect{}, ZONE_CHARACTER); text != "" {
+		e.write(text + "\n")
+	}
+}
+
+func (e *plainExporter) write(s string) {
+	if e.err == nil {
+		_, e.err = io.WriteString(e.w, s)
+	}
+}
+
+func (e *plainExporter) finish() error { return e.err }
+
+// Writes XML elements, keeping the first error
+type xmlWriter struct {
+	enc *xml.Encoder
+	err error
+}
+
+func newXmlWriter(w io.Writer) *xmlWriter {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return &xmlWriter{enc: xml.NewEncoder(w), err: err}
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", " ")
+	return &xmlWriter{enc: enc}
+}
+
+// Opens an element with attributes given as name and value pairs
+func (x *xmlWriter) start(name string, attrs ...string) {
+	elem := xml.StartElement{Name: xml.Name{Local: name}}
+	for ii := 0; ii+1 < len(attrs); ii += 2 {
+		elem.Attr = append(elem.Attr, xml.Attr{Name: xml.Name{Local: attrs[ii]}, Value: attrs[ii+1]})
+	}
+	x.token(elem)
+}
+
+func (x *xmlWriter) end(name string) { x.token(xml.EndElement{Name: xml.Name{Local: name}}) }
+
+func (x *xmlWriter) text(s string) { x.token(xml.CharData(s)) }
+
+// Writes an element holding only text
+func (x *xmlWriter) element(name string, text string, attrs ...string) {
+	x.start(name, attrs...)
+	x.text(text)
+	x.end(name)
+}
+
+func (x *xmlWriter) token(t xml.Token) {
+	if x.err == nil {
+		x.err = x.enc.EncodeToken(t)
+	}
+}
+
+func (x *xmlWriter) flush() error {
+	if x.err == nil {
+		x.err = x.enc.Flush()
+	}
+	return x.err
+}
+
+// Returns the zones inside zone.
+// The text of zones without children above the word level
+// is given as a single word.
+func zoneContents(zone *Zone) []*Zone {
+	if len(zone.Children) > 0 || zone.Level >= ZONE_WORD {
+		return zone.Children
+	}
+	if zone.Text == "" {
+		return nil
+	}
+	return []*Zone{{Level: ZONE_WORD, Rect: zone.Rect, Text: zone.Text}}
+}
+
+// Returns the text of a word, including that of its characters
+func wordText(zone *Zone) string { return trimSeparators(zone.PlainText()) }
+
+// Returns the bounds of r with y going down from the top of a page of height h,
+// as x, y, width and height
+func topDown(r image.Rect, h int) (x, y, width, height int) {
+	return int(r.Xmin()), h - int(r.Ymax()), int(r.Width()), int(r.Height())
+}
+
+type hocrExporter struct {
+	*xmlWriter
+	ids int
+}
+
+var hocrClasses = map[ZoneLevel][2]string{
+	ZONE_COLUMN:    {"div", "ocr_column"},
+	ZONE_REGION:    {"div", "ocr_carea"},
+	ZONE_PARAGRAPH: {"p", "ocr_par"},
+	ZONE_LINE:      {"span", "ocr_line"},
+	ZONE_WORD:      {"span", "ocrx_word"},
+	ZONE_CHARACTER: {"span", "ocrx_cinfo"},
+}
+
+func (e *hocrExporter) begin() {
+	e.token(xml.Directive(`DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Transitional//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd"`))
+	e.start("html", "xmlns", "http://www.w3.org/1999/xhtml", "xml:lang", "en", "lang", "en")
+	e.start("head")
+	e.element("title", "")
+	e.start("meta", "http-equiv", "Content-Type", "content", "text/html;charset=utf-8")
+	e.end("meta")
+	e.start("meta", "name", "ocr-system", "content", "go-djvulibre")
+	e.end("meta")
+	e.start("meta", "name", "ocr-capabilities", "content", "ocr_page ocr_column ocr_carea ocr_par ocr_line ocrx_word ocrx_cinfo")
+	e.end("meta")
+	e.end("head")
+	e.start("body")
+}
+
+func (e *hocrExporter) page(p *Page) {
+	height := int(p.Info.Height)
+	e.start("div", "class", "ocr_page", "id", fmt.Sprintf("page_%d", p.Number+1),
+		"title", fmt.Sprintf("bbox 0 0 %d %d; ppageno %d", p.Info.Width, p.Info.Height, p.Number))
+	if text := p.HiddenText; text != nil && text.Page != nil {
+		for _, zone := range zoneContents(text.Page) {
+			e.zone(zone, p.Number, height)
+		}
+	}
+	e.end("div")
+}
+
+func (e *hocrExporter) zone(zone *Zone, page int, height int) {
+	if zone.Level == ZONE_PAGE {
+		// Pages inside pages are not expected, but their contents are kept
+		for _, child := range zoneContents(zone) {
+			e.zone(child, page, height)
+		}
+		return
+	}
+
+	class := hocrClasses[zone.Level]
+	x, y, w, h := topDown(zone.Rect, height)
+	e.ids++
+	attrs := []string{
+		"class", class[1],
+		"id", fmt.Sprintf("%s_%d_%d", zone.Level, page+1, e.ids),
+		"title", fmt.Sprintf("bbox %d %d %d %d", x, y, x+w, y+h),
+	}
+	if zone.Level == ZONE_CHARACTER || (zone.Level == ZONE_WORD && len(zone.Children) == 0) {
+		e.element(class[0], wordText(zone), attrs...)
+		return
+	}
+	e.start(class[0], attrs...)
+	for _, child := range zoneContents(zone) {
+		e.zone(child, page, height)
+	}
+	e.end(class[0])
+}
+
+func (e *hocrExporter) finish() error {
+	e.end("body")
+	e.end("html")
+	return e.flush()
+}
+
+type altoExporter struct {
+	*xmlWriter
+	ids int
+}
+
+func (e *altoExporter) begin() {
+	e.start("alto", "xmlns", "http://www.loc.gov/standards/alto/ns-v4#")
+	e.start("Description")
+	e.element("MeasurementUnit", "pixel")
+	e.end("Description")
+	e.start("Layout")
+}
+
+func (e *altoExporter) page(p *Page) {
+	width, height := fmt.Sprint(p.Info.Width), fmt.Sprint(p.Info.Height)
+	e.start("Page", "ID", fmt.Sprintf("page_%d", p.Number+1), "PHYSICAL_IMG_NR", fmt.Sprint(p.Number+1),
+		"WIDTH", width, "HEIGHT", height)
+	e.start("PrintSpace", "HPOS", "0", "VPOS", "0", "WIDTH", width, "HEIGHT", height)
+	if text := p.HiddenText; text != nil && text.Page != nil {
+		e.blocks(zoneContents(text.Page), p.Number, int(p.Info.Height))
+	}
+	e.end("PrintSpace")
+	e.end("Page")
+}
+
+// Opens an element with the ID and bounds of a zone
+func (e *altoExporter) startZone(name string, r image.Rect, page int, height int, attrs ...string) {
+	x, y, w, h := topDown(r, height)
+	e.ids++
+	attrs = append([]string{
+		"ID", fmt.Sprintf("%s_%d_%d", name, page+1, e.ids),
+		"HPOS", fmt.Sprint(x), "VPOS", fmt.Sprint(y), "WIDTH", fmt.Sprint(w), "HEIGHT", fmt.Sprint(h),
+	}, attrs...)
+	e.start(name, attrs...)
+}
+
+// Returns the union of the bounds of zones
+func zonesRect(zones []*Zone) image.Rect {
+	var retval image.Rect
+	for _, zone := range zones {
+		retval = retval.Recthull(zone.Rect)
+	}
+	return retval
+}
+
+// Writes the contents of a PrintSpace or ComposedBlock.
+// Columns and regions become ComposedBlocks, paragraphs become TextBlocks,
+// and the lines and words outside paragraphs are put in TextBlocks of their own.
+func (e *altoExporter) blocks(zones []*Zone, page int, height int) {
+	for ii := 0; ii < len(zones); {
+		zone := zones[ii]
+		switch {
+		case zone.Level < ZONE_PARAGRAPH:
+			e.startZone("ComposedBlock", zone.Rect, page, height)
+			e.blocks(zoneContents(zone), page, height)
+			e.end("ComposedBlock")
+			ii++
+		case zone.Level == ZONE_PARAGRAPH:
+			e.startZone("TextBlock", zone.Rect, page, height)
+			e.lines(zoneContents(zone), page, height)
+			e.end("TextBlock")
+			ii++
+		default:
+			run := ii
+			for run < len(zones) && zones[run].Level > ZONE_PARAGRAPH {
+				run++
+			}
+			e.startZone("TextBlock", zonesRect(zones[ii:run]), page, height)
+			e.lines(zones[ii:run], page, height)
+			e.end("TextBlock")
+			ii = run
+		}
+	}
+}
+
+// Writes the contents of a TextBlock.
+// Words outside lines are put in TextLines of their own.
+func (e *altoExporter) lines(zones []*Zone, page int, height int) {
+	for ii := 0; ii < len(zones); {
+		zone := zones[ii]
+		switch {
+		case zone.Level < ZONE_LINE:
+			e.lines(zoneContents(zone), page, height)
+			ii++
+		case zone.Level == ZONE_LINE:
+			e.startZone("TextLine", zone.Rect, page, height)
+			e.strings(zoneContents(zone), page, height)
+			e.end("TextLine")
+			ii++
+		default:
+			run := ii
+			for run < len(zones) && zones[run].Level > ZONE_LINE {
+				run++
+			}
+			e.startZone("TextLine", zonesRect(zones[ii:run]), page, height)
+			e.strings(zones[ii:run], page, height)
+			e.end("TextLine")
+			ii = run
+		}
+	}
+}
+
+// Writes the words of a TextLine, separated by spaces
+func (e *altoExporter) strings(zones []*Zone, page int, height int) {
+	for ii, zone := range zones {
+		if ii > 0 {
+			e.start("SP")
+			e.end("SP")
+		}
+		e.startZone("String", zone.Rect, page, height, "CONTENT", wordText(zone))
+		e.end("String")
+	}
+}
+
+func (e *altoExporter) finish() error {
+	e.end("Layout")
+	e.end("alto")
+	return e.flush()
+}
@@ -0,0 +1,137 @@
+package djvu
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/janreggie/go-djvulibre/djvu/iff"
+	"github.com/stretchr/testify/suite"
+)
+
+type TextExportTestSuite struct {
+	suite.Suite
+}
+
+func TestTextExportSuite(t *testing.T) {
+	suite.Run(t, new(TextExportTestSuite))
+}
+
+func (s *TextExportTestSuite) export(page *Page, format TextFormat) string {
+	var buf bytes.Buffer
+	s.Require().NoError(page.ExportText(&buf, format))
+	return buf.String()
+}
+
+func (s *TextExportTestSuite) TestPlain() {
+	s.Equal("Hello wö\nagain\n\nEnd.\n", s.export(testTextPage(), TEXT_PLAIN))
+	s.Error(testTextPage().ExportText(&bytes.Buffer{}, TextFormat(9)))
+}
+
+func (s *TextExportTestSuite) TestHOCR() {
+	out := s.export(testTextPage(), TEXT_HOCR)
+	s.NoError(xml.Unmarshal([]byte(out), new(interface{})))
+	s.Contains(out, `<!DOCTYPE html`)
+	s.Contains(out, `<div class="ocr_page" id="page_1" title="bbox 0 0 1000 800; ppageno 0">`)
+	s.Contains(out, `<p class="ocr_par" id="para_1_1" title="bbox 100 100 900 300">`)
+	s.Contains(out, `<span class="ocr_line" id="line_1_2" title="bbox 100 100 900 180">`)
+	s.Contains(out, `<span class="ocrx_word" id="word_1_3" title="bbox 100 100 400 180">Hello</span>`)
+	s.Contains(out, `<span class="ocrx_word" id="word_1_4" title="bbox 450 100 900 170">`)
+	s.Contains(out, `<span class="ocrx_cinfo" id="char_1_5" title="bbox 450 100 650 170">w</span>`)
+	s.Contains(out, `<span class="ocrx_cinfo" id="char_1_6" title="bbox 650 100 900 170">ö</span>`)
+	s.Contains(out, `>End.</span>`)
+}
+
+func (s *TextExportTestSuite) TestALTO() {
+	type altoDocument struct {
+		Pages []struct {
+			ID         string `xml:"ID,attr"`
+			Width      int    `xml:"WIDTH,attr"`
+			PrintSpace struct {
+				Blocks []struct {
+					VPos  int `xml:"VPOS,attr"`
+					Lines []struct {
+						Strings []struct {
+							Content string `xml:"CONTENT,attr"`
+							HPos    int    `xml:"HPOS,attr"`
+						} `xml:"String"`
+						Spaces []struct{} `xml:"SP"`
+					} `xml:"TextLine"`
+				} `xml:"TextBlock"`
+			}
+		} `xml:"Layout>Page"`
+	}
+	var alto altoDocument
+	out := s.export(testTextPage(), TEXT_ALTO)
+	s.Require().NoError(xml.Unmarshal([]byte(out), &alto))
+	s.Contains(out, `<alto xmlns="http://www.loc.gov/standards/alto/ns-v4#">`)
+	s.Require().Len(alto.Pages, 1)
+	page := alto.Pages[0]
+	s.Equal("page_1", page.ID)
+	s.Equal(1000, page.Width)
+	s.Require().Len(page.PrintSpace.Blocks, 2)
+	s.Equal(100, page.PrintSpace.Blocks[0].VPos)
+	s.Equal(620, page.PrintSpace.Blocks[1].VPos)
+	lines := page.PrintSpace.Blocks[0].Lines
+	s.Require().Len(lines, 2)
+	s.Len(lines[0].Spaces, 1)
+	s.Equal("Hello", lines[0].Strings[0].Content)
+	s.Equal("wö", lines[0].Strings[1].Content)
+	s.Equal(450, lines[0].Strings[1].HPos)
+
+	// Words and lines outside paragraphs get a block and a line of their own
+	loose := testPage(100, 100)
+	s.Require().NoError(SetTextChunk(loose, &Text{Page: parentZone(ZONE_PAGE, 0, 0, 100, 100,
+		leafZone(ZONE_LINE, 0, 50, 100, 10, "A whole line"),
+		leafZone(ZONE_WORD, 0, 20, 40, 10, "one"),
+		leafZone(ZONE_WORD, 50, 20, 40, 10, "two"))}, false))
+	page2, err := NewPage(1, loose)
+	s.Require().NoError(err)
+	alto = altoDocument{}
+	out = s.export(page2, TEXT_ALTO)
+	s.Require().NoError(xml.Unmarshal([]byte(out), &alto))
+	blocks := alto.Pages[0].PrintSpace.Blocks
+	s.Require().Len(blocks, 1)
+	s.Require().Len(blocks[0].Lines, 2)
+	s.Equal("A whole line", blocks[0].Lines[0].Strings[0].Content)
+	s.Len(blocks[0].Lines[1].Strings, 2)
+}
+
+func (s *TextExportTestSuite) TestDocument() {
+	dir := NewMultiDir()
+	mem := NewMemoryPort()
+	add := func(name string, chunk *iff.Chunk) {
+		data, err := chunk.Bytes()
+		s.Require().NoError(err)
+		url, err := NewUrl("memory:" + name)
+		s.Require().NoError(err)
+		mem.Add(url, data)
+	}
+	for ii, page := range []*iff.Chunk{testTextPage().Form, testPage(100, 100), testTextPage().Form} {
+		name := fmt.Sprintf("p%d.djvu", ii+1)
+		s.Require().NoError(dir.InsertFile(&MultiDirFile{ID: name, Type: FILE_PAGE}, -1))
+		add(name, page)
+	}
+	var dirm bytes.Buffer
+	s.Require().NoError(dir.Encode(&dirm, false))
+	add("index.djvu", &iff.Chunk{ID: "FORM:DJVM", Children: []*iff.Chunk{{ID: "DIRM", Data: dirm.Bytes()}}})
+
+	url, err := NewUrl("memory:index.djvu")
+	s.Require().NoError(err)
+	doc, err := OpenDocument(context.Background(), url, mem)
+	s.Require().NoError(err)
+	defer doc.Close()
+
+	var buf bytes.Buffer
+	s.Require().NoError(doc.ExportText(context.Background(), &buf, TEXT_PLAIN))
+	s.Equal("Hello wö\nagain\n\nEnd.\n\f\fHello wö\nagain\n\nEnd.\n", buf.String())
+
+	buf.Reset()
+	s.Require().NoError(doc.ExportText(context.Background(), &buf, TEXT_HOCR))
+	s.Equal(3, strings.Count(buf.String(), `class="ocr_page"`))
+	s.Contains(buf.String(), `id="page_3"`)
+	s.Contains(buf.String(), `id="word_3_15"`)
+}
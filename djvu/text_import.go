@@ -0,0 +1,370 @@
+package djvu
+
+import (
+	"bufio"
+	"encoding/xml"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/janreggie/go-djvulibre/djvu/image"
+	"github.com/pkg/errors"
+)
+
+// ImportText reads the first page of OCR results in the given format,
+// and returns it as the hidden text of a page of width by height pixels.
+//
+// Coordinates are scaled from the size of the image given in the OCR results,
+// if any, and turned upside down since DjVu pages start at the bottom left.
+// Plain text has no coordinates and is given to the whole page.
+func ImportText(r io.Reader, format TextFormat, width, height int) (*Text, error) {
+	var page *ocrPage
+	var err error
+	switch format {
+	case TEXT_PLAIN:
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read plain text")
+		}
+		page = &ocrPage{zone: &Zone{Level: ZONE_PAGE, Text: strings.TrimSpace(string(data))}}
+	case TEXT_HOCR:
+		page, err = importHOCR(r)
+	case TEXT_ALTO:
+		page, err = importALTO(r)
+	case TEXT_TSV:
+		page, err = importTSV(r)
+	default:
+		err = errors.Errorf("unknown text format %d", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if page == nil {
+		return nil, errors.Errorf("no page in %s text", format)
+	}
+
+	if page.width <= 0 || page.height <= 0 {
+		page.width, page.height = float64(width), float64(height)
+	}
+	zone := page.place(page.zone, float64(width)/page.width, float64(height)/page.height, height)
+	if zone == nil {
+		return NewText(), nil
+	}
+	zone.Rect = image.NewRect(0, 0, int32(width), int32(height))
+	return &Text{Page: zone}, nil
+}
+
+// ImportText replaces the hidden text of the page by OCR results,
+// as read by ImportText, and stores it in a `TXTz` chunk of the page.
+// The form of the page is replaced by a copy holding the chunk,
+// so that pages sharing the form, such as those of a FileCache, are left unchanged.
+func (p *Page) ImportText(r io.Reader, format TextFormat) error {
+	text, err := ImportText(r, format, int(p.Info.Width), int(p.Info.Height))
+	if err != nil {
+		return err
+	}
+	form := *p.Form
+	if err := SetTextChunk(&form, text, true); err != nil {
+		return err
+	}
+	p.Form, p.HiddenText = &form, text
+	return nil
+}
+
+// A page of OCR results.
+// The bounds of its zones have y going down from the top,
+// and may be empty when not given.
+type ocrPage struct {
+	zone          *Zone
+	width, height float64
+}
+
+// Returns a copy of zone with its bounds scaled and turned upside down,
+// or nil if it holds no text.
+// Bounds which are not given are those of the children.
+func (page *ocrPage) place(zone *Zone, sx, sy float64, height int) *Zone {
+	retval := &Zone{Level: zone.Level, Text: strings.TrimSpace(zone.Text)}
+	for _, child := range zone.Children {
+		if child := page.place(child, sx, sy, height); child != nil {
+			retval.Children = append(retval.Children, child)
+			retval.Rect = retval.Rect.Recthull(child.Rect)
+		}
+	}
+	if len(retval.Children) > 0 {
+		retval.Text = ""
+	} else if retval.Text == "" {
+		return nil
+	}
+
+	if r := zone.Rect; !r.IsEmpty() {
+		xmin := int32(math.Round(float64(r.Xmin()) * sx))
+		xmax := int32(math.Round(float64(r.Xmax()) * sx))
+		ymin := int32(height) - int32(math.Round(float64(r.Ymax())*sy))
+		ymax := int32(height) - int32(math.Round(float64(r.Ymin())*sy))
+		retval.Rect = image.NewRect(xmin, ymin, xmax-xmin, ymax-ymin)
+	}
+	return retval
+}
+
+// Builds the zones of a page from elements nested in some markup
+type ocrBuilder struct {
+	page *ocrPage
+
+	// Zones of the elements which are open, with their depth
+	open   []*Zone
+	depths []int
+	depth  int
+}
+
+// Opens an element, which is a zone of the given level unless level is 0
+func (b *ocrBuilder) start(level ZoneLevel, rect image.Rect) *Zone {
+	b.depth++
+	if level == 0 || (b.page != nil && len(b.open) == 0) {
+		return nil // Not a zone, or on the pages after the first
+	}
+	zone := &Zone{Level: level, Rect: rect}
+	if level == ZONE_PAGE {
+		if b.page != nil {
+			return nil
+		}
+		b.page = &ocrPage{zone: zone}
+	} else if len(b.open) > 0 {
+		parent := b.open[len(b.open)-1]
+		parent.Children = append(parent.Children, zone)
+	} else {
+		return nil // Outside pages
+	}
+	b.open = append(b.open, zone)
+	b.depths = append(b.depths, b.depth)
+	return zone
+}
+
+func (b *ocrBuilder) end() {
+	if n := len(b.open); n > 0 && b.depths[n-1] == b.depth {
+		b.open, b.depths = b.open[:n-1], b.depths[:n-1]
+	}
+	b.depth--
+}
+
+// Adds text to the innermost word or character
+func (b *ocrBuilder) text(s string) {
+	if n := len(b.open); n > 0 && b.open[n-1].Level >= ZONE_WORD {
+		b.open[n-1].Text += s
+	}
+}
+
+// Returns a lenient decoder of XML and XHTML
+func newOcrDecoder(r io.Reader) *xml.Decoder {
+	dec := xml.NewDecoder(r)
+	dec.Strict = false
+	dec.AutoClose = xml.HTMLAutoClose
+	dec.Entity = xml.HTMLEntity
+	return dec
+}
+
+func xmlAttr(elem xml.StartElement, name string) string {
+	for _, attr := range elem.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// Levels of the hOCR classes
+var hocrLevels = map[string]ZoneLevel{
+	"ocr_page":      ZONE_PAGE,
+	"ocr_column":    ZONE_COLUMN,
+	"ocr_carea":     ZONE_REGION,
+	"ocr_par":       ZONE_PARAGRAPH,
+	"ocr_line":      ZONE_LINE,
+	"ocr_header":    ZONE_LINE,
+	"ocr_caption":   ZONE_LINE,
+	"ocr_textfloat": ZONE_LINE,
+	"ocrx_line":     ZONE_LINE,
+	"ocrx_word":     ZONE_WORD,
+	"ocrx_cinfo":    ZONE_CHARACTER,
+}
+
+// Reads the first page of an hOCR document
+func importHOCR(r io.Reader) (*ocrPage, error) {
+	dec := newOcrDecoder(r)
+	b := &ocrBuilder{}
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read hOCR")
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			var level ZoneLevel
+			for _, class := range strings.Fields(xmlAttr(t, "class")) {
+				if l, ok := hocrLevels[class]; ok {
+					level = l
+				}
+			}
+			rect, ok, err := hocrBbox(xmlAttr(t, "title"))
+			if err != nil {
+				return nil, err
+			}
+			zone := b.start(level, rect)
+			if zone != nil && level == ZONE_PAGE && ok {
+				b.page.width, b.page.height = float64(rect.Xmax()), float64(rect.Ymax())
+			}
+		case xml.EndElement:
+			b.end()
+		case xml.CharData:
+			b.text(string(t))
+		}
+	}
+	return b.page, nil
+}
+
+// Reads the `bbox x0 y0 x1 y1` property in the title of an hOCR element
+func hocrBbox(title string) (image.Rect, bool, error) {
+	for _, prop := range strings.Split(title, ";") {
+		fields := strings.Fields(prop)
+		if len(fields) == 0 || fields[0] != "bbox" {
+			continue
+		}
+		var coords [4]int
+		if len(fields) != 5 {
+			return image.Rect{}, false, errors.Errorf("bad hOCR bbox %q", prop)
+		}
+		for ii := range coords {
+			n, err := strconv.Atoi(fields[ii+1])
+			if err != nil {
+				return image.Rect{}, false, errors.Errorf("bad hOCR bbox %q", prop)
+			}
+			coords[ii] = n
+		}
+		return image.NewRect(int32(coords[0]), int32(coords[1]), int32(coords[2]-coords[0]), int32(coords[3]-coords[1])), true, nil
+	}
+	return image.Rect{}, false, nil
+}
+
+// Levels of the ALTO elements
+var altoLevels = map[string]ZoneLevel{
+	"Page":          ZONE_PAGE,
+	"ComposedBlock": ZONE_REGION,
+	"TextBlock":     ZONE_PARAGRAPH,
+	"TextLine":      ZONE_LINE,
+	"String":        ZONE_WORD,
+}
+
+// Reads the first page of an ALTO document
+func importALTO(r io.Reader) (*ocrPage, error) {
+	dec := newOcrDecoder(r)
+	b := &ocrBuilder{}
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read ALTO")
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			level := altoLevels[t.Name.Local]
+			var pos [4]float64
+			for ii, name := range []string{"HPOS", "VPOS", "WIDTH", "HEIGHT"} {
+				value := xmlAttr(t, name)
+				if value == "" || level == 0 {
+					continue
+				}
+				if pos[ii], err = strconv.ParseFloat(value, 64); err != nil {
+					return nil, errors.Errorf("bad ALTO %s %q", name, value)
+				}
+			}
+			rect := image.NewRect(int32(math.Round(pos[0])), int32(math.Round(pos[1])),
+				int32(math.Round(pos[0]+pos[2]))-int32(math.Round(pos[0])),
+				int32(math.Round(pos[1]+pos[3]))-int32(math.Round(pos[1])))
+			if level == ZONE_PAGE {
+				rect = image.Rect{}
+			}
+			zone := b.start(level, rect)
+			if zone == nil {
+				break
+			}
+			switch level {
+			case ZONE_PAGE:
+				b.page.width, b.page.height = pos[2], pos[3]
+			case ZONE_WORD:
+				zone.Text = xmlAttr(t, "CONTENT")
+			}
+		case xml.EndElement:
+			b.end()
+		}
+	}
+	return b.page, nil
+}
+
+// Levels of the rows of Tesseract TSV output
+var tsvLevels = []ZoneLevel{1: ZONE_PAGE, 2: ZONE_REGION, 3: ZONE_PARAGRAPH, 4: ZONE_LINE, 5: ZONE_WORD}
+
+// Reads the first page of Tesseract TSV output,
+// whose columns are level, page_num, block_num, par_num, line_num, word_num,
+// left, top, width, height, conf and text.
+func importTSV(r io.Reader) (*ocrPage, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	var page *ocrPage
+	// Last zone of each level
+	var last [6]*Zone
+	for lineno := 1; scanner.Scan(); lineno++ {
+		fields := strings.SplitN(strings.TrimRight(scanner.Text(), "\r"), "\t", 12)
+		if len(fields) < 11 || fields[0] == "level" {
+			continue // Header or blank line
+		}
+		var nums [10]int
+		for ii := range nums {
+			n, err := strconv.Atoi(fields[ii])
+			if err != nil {
+				return nil, errors.Errorf("bad number %q on TSV line %d", fields[ii], lineno)
+			}
+			nums[ii] = n
+		}
+		level := nums[0]
+		if level < 1 || level >= len(tsvLevels) {
+			return nil, errors.Errorf("bad level %d on TSV line %d", level, lineno)
+		}
+		zone := &Zone{Level: tsvLevels[level], Rect: image.NewRect(int32(nums[6]), int32(nums[7]), int32(nums[8]), int32(nums[9]))}
+		if len(fields) == 12 {
+			zone.Text = fields[11]
+		}
+
+		if level == 1 {
+			if page != nil {
+				break // Only the first page is read
+			}
+			page = &ocrPage{zone: zone, width: float64(nums[8]), height: float64(nums[9])}
+			zone.Rect = image.Rect{}
+		} else {
+			// Attaches the zone to the closest level above it
+			parent := 0
+			for ii := level - 1; ii >= 1; ii-- {
+				if last[ii] != nil {
+					parent = ii
+					break
+				}
+			}
+			if parent == 0 {
+				return nil, errors.Errorf("TSV line %d is outside a page", lineno)
+			}
+			last[parent].Children = append(last[parent].Children, zone)
+		}
+		last[level] = zone
+		for ii := level + 1; ii < len(last); ii++ {
+			last[ii] = nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not read TSV")
+	}
+	return page, nil
+}
@@ -0,0 +1,181 @@
+package djvu
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/janreggie/go-djvulibre/djvu/image"
+	"github.com/stretchr/testify/suite"
+)
+
+type TextImportTestSuite struct {
+	suite.Suite
+}
+
+func TestTextImportSuite(t *testing.T) {
+	suite.Run(t, new(TextImportTestSuite))
+}
+
+func (s *TextImportTestSuite) TestRoundTrip() {
+	// Characters are not exported in ALTO, so their word comes back whole
+	whole := testText()
+	chars := whole.Page.Children[0].Children[0].Children[1]
+	chars.Text, chars.Children = "wö", nil
+
+	for format, want := range map[TextFormat]*Text{TEXT_HOCR: testText(), TEXT_ALTO: whole} {
+		var buf bytes.Buffer
+		s.Require().NoError(testTextPage().ExportText(&buf, format))
+		text, err := ImportText(&buf, format, 1000, 800)
+		s.Require().NoError(err, format)
+		s.Equal(want, text, format)
+	}
+}
+
+const testHOCR = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>OCR</title></head>
+<body>
+<div class='ocr_page' id='page_1' title='image "p1.png"; bbox 0 0 2000 1600; ppageno 0'>
+ <div class='ocr_carea' title="bbox 200 200 1800 400">
+  <p class='ocr_par' title="bbox 200 200 1800 400">
+   <span class='ocr_line' title="bbox 200 200 1800 360; baseline 0 -8">
+    <span class='ocrx_word' title='bbox 200 200 800 360; x_wconf 96'>Hello</span>
+    <span class='ocrx_word' title='bbox 900 220 1800 360; x_wconf 91'><strong>w&ouml;rld</strong></span>
+    <span class='ocrx_word' title='bbox 1800 220 1800 360'> </span>
+   </span>
+  </p>
+ </div>
+</div>
+<div class='ocr_page' title='bbox 0 0 10 10'><span class='ocrx_word' title='bbox 0 0 1 1'>next</span></div>
+</body></html>`
+
+// Returns the text expected from testHOCR and testTSV on a page of 1000x800
+func importedText() *Text {
+	return &Text{Page: parentZone(ZONE_PAGE, 0, 0, 1000, 800,
+		parentZone(ZONE_REGION, 100, 600, 800, 100,
+			parentZone(ZONE_PARAGRAPH, 100, 600, 800, 100,
+				parentZone(ZONE_LINE, 100, 620, 800, 80,
+					leafZone(ZONE_WORD, 100, 620, 300, 80, "Hello"),
+					leafZone(ZONE_WORD, 450, 620, 450, 70, "wörld")))))}
+}
+
+func (s *TextImportTestSuite) TestHOCR() {
+	text, err := ImportText(strings.NewReader(testHOCR), TEXT_HOCR, 1000, 800)
+	s.Require().NoError(err)
+	s.Equal(importedText(), text)
+
+	for _, bad := range []string{
+		`<html><body></body></html>`,
+		`<div class='ocr_page' title='bbox 0 0 10'></div>`,
+		`<div class='ocr_page'><span class='ocrx_word' title='bbox 0 0 x 1'>a</span></div>`,
+	} {
+		_, err := ImportText(strings.NewReader(bad), TEXT_HOCR, 100, 100)
+		s.Error(err, bad)
+	}
+
+	// Without the size of the image, coordinates are those of the page
+	text, err = ImportText(strings.NewReader(
+		`<div class='ocr_page'><span class='ocrx_word' title='bbox 10 10 20 30'>a</span></div>`), TEXT_HOCR, 100, 100)
+	s.Require().NoError(err)
+	s.Equal(image.NewRect(10, 70, 10, 20), text.Page.Children[0].Rect)
+}
+
+const testTSV = "level\tpage_num\tblock_num\tpar_num\tline_num\tword_num\tleft\ttop\twidth\theight\tconf\ttext\n" +
+	"1\t1\t0\t0\t0\t0\t0\t0\t2000\t1600\t-1\t\n" +
+	"2\t1\t1\t0\t0\t0\t200\t200\t1600\t200\t-1\t\n" +
+	"3\t1\t1\t1\t0\t0\t200\t200\t1600\t200\t-1\t\n" +
+	"4\t1\t1\t1\t1\t0\t200\t200\t1600\t160\t-1\t\n" +
+	"5\t1\t1\t1\t1\t1\t200\t200\t600\t160\t96.5\tHello\n" +
+	"5\t1\t1\t1\t1\t2\t900\t220\t900\t140\t91\twörld\n" +
+	"5\t1\t1\t1\t1\t3\t1800\t220\t0\t140\t91\t \n" +
+	"1\t2\t0\t0\t0\t0\t0\t0\t10\t10\t-1\t\n" +
+	"5\t2\t1\t1\t1\t1\t0\t0\t1\t1\t90\tnext\n"
+
+func (s *TextImportTestSuite) TestTSV() {
+	text, err := ImportText(strings.NewReader(testTSV), TEXT_TSV, 1000, 800)
+	s.Require().NoError(err)
+	s.Equal(importedText(), text)
+
+	for _, bad := range []string{
+		"",
+		"1\t1\t0\t0\t0\t0\t0\t0\tx\t10\t-1\t\n",
+		"7\t1\t0\t0\t0\t0\t0\t0\t10\t10\t-1\t\n",
+		"5\t1\t1\t1\t1\t1\t0\t0\t1\t1\t90\tword\n",
+	} {
+		_, err := ImportText(strings.NewReader(bad), TEXT_TSV, 100, 100)
+		s.Error(err, bad)
+	}
+}
+
+func (s *TextImportTestSuite) TestALTO() {
+	// Measured in tenths of millimeters, which cancel out against the size of the page
+	alto := `<?xml version="1.0" encoding="UTF-8"?>
+<alto xmlns="http://www.loc.gov/standards/alto/ns-v3#">
+ <Description><MeasurementUnit>mm10</MeasurementUnit></Description>
+ <Layout><Page ID="p1" WIDTH="500" HEIGHT="400"><PrintSpace>
+  <TextBlock ID="b1" HPOS="50" VPOS="50" WIDTH="400" HEIGHT="40.2">
+   <TextLine HPOS="50" VPOS="50" WIDTH="400" HEIGHT="40">
+    <String CONTENT="Hello" HPOS="50" VPOS="50" WIDTH="150" HEIGHT="40"/><SP/>
+    <String CONTENT="wörld" HPOS="225" VPOS="55" WIDTH="225" HEIGHT="35"/>
+   </TextLine>
+  </TextBlock>
+ </PrintSpace></Page></Layout>
+</alto>`
+	text, err := ImportText(strings.NewReader(alto), TEXT_ALTO, 1000, 800)
+	s.Require().NoError(err)
+	want := importedText()
+	want.Page.Children = want.Page.Children[0].Children
+	want.Page.Children[0].Rect = image.NewRect(100, 620, 800, 80)
+	s.Equal(want, text)
+
+	_, err = ImportText(strings.NewReader(`<alto><Layout><Page WIDTH="x"/></Layout></alto>`), TEXT_ALTO, 100, 100)
+	s.Error(err)
+}
+
+func (s *TextImportTestSuite) TestPage() {
+	page, err := NewPage(0, testPage(1000, 800))
+	s.Require().NoError(err)
+	s.Require().NoError(page.ImportText(strings.NewReader(testHOCR), TEXT_HOCR))
+	s.Equal(importedText(), page.HiddenText)
+	s.Equal([]string{"INFO", "TXTz"}, chunkIDs(page.Form))
+
+	decoded, err := NewPage(0, page.Form)
+	s.Require().NoError(err)
+	s.Equal(importedText(), decoded.HiddenText)
+
+	s.Require().NoError(page.ImportText(strings.NewReader("  Just text.\n"), TEXT_PLAIN))
+	s.Equal(&Text{Page: leafZone(ZONE_PAGE, 0, 0, 1000, 800, "Just text.")}, page.HiddenText)
+
+	s.Error(page.ImportText(strings.NewReader(""), TextFormat(9)))
+	s.Error(page.ExportText(&bytes.Buffer{}, TEXT_TSV))
+}
+
+func (s *TextImportTestSuite) TestCachedPage() {
+	data, err := testTextPage().Form.Bytes()
+	s.Require().NoError(err)
+	url, err := NewUrl("memory:text.djvu")
+	s.Require().NoError(err)
+	mem := NewMemoryPort()
+	mem.Add(url, data)
+	doc, err := OpenDocument(context.Background(), url, mem)
+	s.Require().NoError(err)
+	defer doc.Close()
+	cache := NewFileCache(1 << 20)
+	doc.SetFileCache(cache)
+
+	page, err := doc.GetPage(context.Background(), 0)
+	s.Require().NoError(err)
+	size := cache.GetSize()
+	s.Require().NoError(page.ImportText(strings.NewReader(testHOCR), TEXT_HOCR))
+	s.Equal(importedText(), page.HiddenText)
+
+	again, err := doc.GetPage(context.Background(), 0)
+	s.Require().NoError(err)
+	s.Equal(testText(), again.HiddenText)
+	text, err := DecodeTextChunk(again.Form)
+	s.Require().NoError(err)
+	s.Equal(testText(), text)
+	s.Equal(size, cache.GetSize())
+	s.Equal(uint64(1), cache.Stats().Hits)
+}
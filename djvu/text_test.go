@@ -0,0 +1,153 @@
+package djvu
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/janreggie/go-djvulibre/djvu/iff"
+	"github.com/janreggie/go-djvulibre/djvu/image"
+	"github.com/stretchr/testify/suite"
+)
+
+type TextTestSuite struct {
+	suite.Suite
+}
+
+func TestTextSuite(t *testing.T) {
+	suite.Run(t, new(TextTestSuite))
+}
+
+// Returns a zone without children
+func leafZone(level ZoneLevel, x, y, w, h int32, text string) *Zone {
+	return &Zone{Level: level, Rect: image.NewRect(x, y, w, h), Text: text}
+}
+
+// Returns a zone holding children
+func parentZone(level ZoneLevel, x, y, w, h int32, children ...*Zone) *Zone {
+	return &Zone{Level: level, Rect: image.NewRect(x, y, w, h), Children: children}
+}
+
+// Returns the text of a page of 1000x800 with two paragraphs
+func testText() *Text {
+	return &Text{Page: parentZone(ZONE_PAGE, 0, 0, 1000, 800,
+		parentZone(ZONE_PARAGRAPH, 100, 500, 800, 200,
+			parentZone(ZONE_LINE, 100, 620, 800, 80,
+				leafZone(ZONE_WORD, 100, 620, 300, 80, "Hello"),
+				parentZone(ZONE_WORD, 450, 630, 450, 70,
+					leafZone(ZONE_CHARACTER, 450, 630, 200, 70, "w"),
+					leafZone(ZONE_CHARACTER, 650, 630, 250, 70, "ö"))),
+			parentZone(ZONE_LINE, 100, 500, 500, 80,
+				leafZone(ZONE_WORD, 100, 500, 500, 80, "again"))),
+		parentZone(ZONE_PARAGRAPH, 100, 100, 300, 80,
+			parentZone(ZONE_LINE, 100, 100, 300, 80,
+				leafZone(ZONE_WORD, 100, 100, 300, 80, "End.")))),
+	}
+}
+
+func (s *TextTestSuite) TestDecode() {
+	// A page of 100x50 with the word "Hi" at (10,20)
+	data := []byte{
+		0, 0, 3, 'H', 'i', ' ', 1,
+		1, 0x80, 0, 0x80, 0, 0x80, 100, 0x80, 50, 0x80, 0, 0, 0, 3, 0, 0, 1,
+		6, 0x80, 10, 0x80, 20, 0x80, 30, 0x80, 10, 0x80, 0, 0, 0, 3, 0, 0, 0,
+	}
+	text := NewText()
+	s.Require().NoError(text.Decode(bytes.NewReader(data)))
+	s.Equal(&Text{Page: parentZone(ZONE_PAGE, 0, 0, 100, 50, leafZone(ZONE_WORD, 10, 20, 30, 10, "Hi"))}, text)
+
+	var buf bytes.Buffer
+	s.NoError(text.Encode(&buf))
+	s.Equal(data, buf.Bytes())
+
+	s.NoError(text.Decode(bytes.NewReader([]byte{0, 0, 6, 'p', 'l', 'a', 'i', 'n', '\n'})))
+	s.Equal(&Text{Page: &Zone{Level: ZONE_PAGE, Text: "plain"}}, text)
+	s.NoError(text.Decode(bytes.NewReader(nil)))
+	s.True(text.IsEmpty())
+
+	for _, bad := range [][]byte{
+		{0, 0, 9, 'H', 'i'},
+		{0, 0, 2, 'H', 'i', 2},
+		data[:len(data)-1],
+		append([]byte{0, 0, 0, 1, 9}, data[8:]...),
+		append(append([]byte{}, data[:len(data)-4]...), 0, 0, 9, 0, 0, 0),
+	} {
+		s.Error(NewText().Decode(bytes.NewReader(bad)), "%v", bad)
+	}
+}
+
+func (s *TextTestSuite) TestRoundTrip() {
+	text := testText()
+	for _, compress := range []bool{false, true} {
+		chunk, err := text.Chunk(compress)
+		s.Require().NoError(err)
+		if compress {
+			s.Equal("TXTz", chunk.ID)
+		} else {
+			s.Equal("TXTa", chunk.ID)
+		}
+		decoded := NewText()
+		s.Require().NoError(decoded.DecodeChunk(chunk))
+		s.Equal(text, decoded)
+	}
+
+	s.Error(NewText().DecodeChunk(&iff.Chunk{ID: "ANTa"}))
+	s.Error((&Text{Page: &Zone{Level: 9, Text: "x"}}).Encode(&bytes.Buffer{}))
+	far := &Text{Page: parentZone(ZONE_PAGE, 0, 0, 100, 100, leafZone(ZONE_WORD, 50000, 0, 1, 1, "far"))}
+	s.Error(far.Encode(&bytes.Buffer{}))
+}
+
+func (s *TextTestSuite) TestPlainText() {
+	page := testText().Page
+	s.Equal("Hello wö\nagain\n\x1fEnd.\n\x1f", page.PlainText())
+	s.Equal("wö ", page.Children[0].Children[0].Children[1].PlainText())
+
+	var words []string
+	page.Walk(func(zone *Zone) bool {
+		if zone.Level == ZONE_WORD {
+			words = append(words, zone.PlainText())
+			return false
+		}
+		return true
+	})
+	s.Equal([]string{"Hello ", "wö ", "again ", "End. "}, words)
+}
+
+func (s *TextTestSuite) TestSetTextChunk() {
+	form := testPage(1000, 800)
+	form.Children = append(form.Children, &iff.Chunk{ID: "ANTa", Data: []byte(`(mode bw)`)})
+
+	text, err := DecodeTextChunk(form)
+	s.Require().NoError(err)
+	s.True(text.IsEmpty())
+
+	s.NoError(SetTextChunk(form, testText(), true))
+	s.Equal([]string{"INFO", "ANTa", "TXTz"}, chunkIDs(form))
+	text, err = DecodeTextChunk(form)
+	s.NoError(err)
+	s.Equal(testText(), text)
+
+	s.NoError(SetTextChunk(form, testText(), false))
+	s.Equal([]string{"INFO", "ANTa", "TXTa"}, chunkIDs(form))
+	s.NoError(SetTextChunk(form, NewText(), false))
+	s.Equal([]string{"INFO", "ANTa"}, chunkIDs(form))
+}
+
+func (s *TextTestSuite) TestGetPageText() {
+	page := testPage(1000, 800)
+	s.Require().NoError(SetTextChunk(page, testText(), true))
+	data, err := page.Bytes()
+	s.Require().NoError(err)
+
+	mem := NewMemoryPort()
+	url, err := NewUrl("memory:page.djvu")
+	s.Require().NoError(err)
+	mem.Add(url, data)
+	doc, err := OpenDocument(context.Background(), url, mem)
+	s.Require().NoError(err)
+	defer doc.Close()
+
+	text, err := doc.GetPageText(context.Background(), 0)
+	s.Require().NoError(err)
+	s.Equal(testText(), text)
+}
@@ -0,0 +1,117 @@
+package djvu
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/janreggie/go-djvulibre/djvu/iff"
+	"github.com/janreggie/go-djvulibre/djvu/image"
+	"github.com/janreggie/go-djvulibre/djvu/iw44"
+	"github.com/pkg/errors"
+)
+
+// ThumbnailSize is the usual size of the larger side of thumbnails
+const ThumbnailSize = 128
+
+// Number of slices of the IW44 data of thumbnails
+const thumbnailSlices = 97
+
+// DecodeThumbnail decodes a thumbnail from a `TH44` chunk.
+// Each chunk holds a complete IW44 color image.
+func DecodeThumbnail(chunk *iff.Chunk) (*image.Pixmap, error) {
+	if chunk.ID != "TH44" {
+		return nil, errors.Errorf("%q is not a thumbnail", chunk.ID)
+	}
+	img := iw44.NewImage()
+	if err := img.DecodeChunk(bytes.NewReader(chunk.Data)); err != nil {
+		return nil, errors.Wrap(err, "could not decode thumbnail")
+	}
+	return img.Pixmap()
+}
+
+// DecodeThumbnails decodes the thumbnails of a thumbnails file,
+// given by its `FORM:THUM` chunk.
+// They are those of the pages following the file in the document, in order.
+func DecodeThumbnails(form *iff.Chunk) ([]*image.Pixmap, error) {
+	if form.ID != "FORM:THUM" {
+		return nil, errors.Errorf("%q is not a thumbnails file", form.ID)
+	}
+	var retval []*image.Pixmap
+	for _, chunk := range form.FindAll("TH44") {
+		pm, err := DecodeThumbnail(chunk)
+		if err != nil {
+			return nil, err
+		}
+		retval = append(retval, pm)
+	}
+	return retval, nil
+}
+
+// EncodeThumbnail encodes a thumbnail into a `TH44` chunk.
+func EncodeThumbnail(pm *image.Pixmap) (*iff.Chunk, error) {
+	e, err := iw44.NewPixmapEncoder(pm, nil, iw44.CRCB_NORMAL)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not encode thumbnail")
+	}
+	chunks, err := e.EncodeChunks("TH44", iw44.EncoderParams{Slices: thumbnailSlices})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not encode thumbnail")
+	}
+	return chunks[0], nil
+}
+
+// Calls fn with each thumbnails file of dir
+// and the number of the page following it, counted from 0
+func eachThumbnailsFile(dir *MultiDir, fn func(file *MultiDirFile, first int) error) error {
+	page := 0
+	for _, file := range dir.GetFiles() {
+		switch {
+		case file.Type == FILE_THUMBNAILS:
+			if err := fn(file, page); err != nil {
+				return err
+			}
+		case file.IsPage():
+			page++
+		}
+	}
+	return nil
+}
+
+// GetThumbnail returns the thumbnail of page `page`, counted from 0,
+// or nil if the document has none for it.
+// Thumbnails are only found in multipage documents,
+// in the thumbnails files preceding the pages.
+func (doc *Document) GetThumbnail(ctx context.Context, page int) (*image.Pixmap, error) {
+	if page < 0 || page >= doc.GetPagesNum() {
+		return nil, errors.Errorf("page %d out of range [0, %d)", page, doc.GetPagesNum())
+	}
+	if doc.dir == nil {
+		return nil, nil
+	}
+
+	var thumbs *MultiDirFile
+	index := 0
+	eachThumbnailsFile(doc.dir, func(file *MultiDirFile, first int) error {
+		if first <= page {
+			thumbs, index = file, page-first
+		}
+		return nil
+	})
+	if thumbs == nil {
+		return nil, nil
+	}
+
+	pool, err := doc.includedData(ctx, thumbs.ID)
+	if err != nil {
+		return nil, err
+	}
+	form, err := iff.Decode(pool.NewReader(ctx))
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read thumbnails file %q", thumbs.ID)
+	}
+	chunks := form.FindAll("TH44")
+	if index >= len(chunks) {
+		return nil, nil
+	}
+	return DecodeThumbnail(chunks[index])
+}
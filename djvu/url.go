@@ -1,14 +1,16 @@
 package djvu
 
 import (
-	"errors"
-	"fmt"
-	"net/url"
+	neturl "net/url"
+	"os"
 	"path"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
 	"unicode"
+
+	"github.com/pkg/errors"
 )
 
 // TODO: You might want to make Url an *interface*
@@ -58,13 +60,30 @@ func NewUrl(urlStr string) (*Url, error) {
 
 // Copy copies a Url
 func (url *Url) Copy() *Url {
-	panic("unimplemented")
+	url.mtx.RLock()
+	defer url.mtx.RUnlock()
+
+	retval := &Url{
+		url:         url.url,
+		cgiNameArr:  make([]string, len(url.cgiNameArr)),
+		cgiValueArr: make([]string, len(url.cgiValueArr)),
+		validUrl:    url.validUrl,
+	}
+	copy(retval.cgiNameArr, url.cgiNameArr)
+	copy(retval.cgiValueArr, url.cgiValueArr)
+	return retval
 }
 
 // IsValid checks if the URL is valid.
 // If invalid, reinitialize
 func (url *Url) IsValid() bool {
-	panic("unimplemented")
+	url.mtx.Lock()
+	defer url.mtx.Unlock()
+
+	if !url.validUrl {
+		url.init()
+	}
+	return url.validUrl
 }
 
 // Extracts the Protocol part from the URL and returns it
@@ -77,12 +96,20 @@ func (url *Url) Protocol() string {
 // Returns string after the first `#`
 // with decoded escape sequences.
 func (url *Url) HashArgument() string {
-	panic("unimplemented")
+	url.mtx.RLock()
+	defer url.mtx.RUnlock()
+
+	_, hash, _ := splitHash(url.url)
+	return decodeReserved(strings.TrimPrefix(hash, "#"))
 }
 
 // Inserts `arg` after a separating hash into the URL.
 func (url *Url) SetHashArgument(arg string) {
-	panic("unimplemented")
+	url.mtx.Lock()
+	defer url.mtx.Unlock()
+
+	head, _, tail := splitHash(url.url)
+	url.url = head + "#" + encodeReserved(arg) + tail
 }
 
 // Returns the total number of CGI arguments in the URL.
@@ -99,13 +126,19 @@ func (url *Url) DjvuCgiArguments() int {
 	url.mtx.RLock()
 	defer url.mtx.RUnlock()
 
+	return len(url.cgiNameArr) - url.djvuCgiStart()
+}
+
+// Returns the index of the first DjVu-related CGI argument,
+// or the number of CGI arguments if there are none.
+// Only use when it's guaranteed that the URL is read-locked.
+func (url *Url) djvuCgiStart() int {
 	for ii, arg := range url.cgiNameArr {
 		if strings.ToUpper(arg) == djvuopts {
-			return len(url.cgiNameArr) - ii + 1
+			return ii + 1
 		}
 	}
-
-	return 0
+	return len(url.cgiNameArr)
 }
 
 // Returns that part of CGI argument `num`,
@@ -115,7 +148,7 @@ func (url *Url) CgiName(num int) string {
 	url.mtx.RLock()
 	defer url.mtx.RUnlock()
 
-	if len(url.cgiNameArr) >= num {
+	if num < 0 || num >= len(url.cgiNameArr) {
 		return ""
 	}
 	return url.cgiNameArr[num]
@@ -127,8 +160,11 @@ func (url *Url) DjvuCgiName(num int) string {
 	url.mtx.RLock()
 	defer url.mtx.RUnlock()
 
-	// TODO: Interpret
-	panic("unimplemented")
+	names := url.cgiNameArr[url.djvuCgiStart():]
+	if num < 0 || num >= len(names) {
+		return ""
+	}
+	return names[num]
 }
 
 // Returns that part of CGI argument number `num`,
@@ -137,7 +173,7 @@ func (url *Url) CgiValue(num int) string {
 	url.mtx.RLock()
 	defer url.mtx.RUnlock()
 
-	if len(url.cgiValueArr) >= num {
+	if num < 0 || num >= len(url.cgiValueArr) {
 		return ""
 	}
 	return url.cgiValueArr[num]
@@ -146,7 +182,14 @@ func (url *Url) CgiValue(num int) string {
 // Returns that part of DjVu-related CGI argument number `num`,
 // which is after the equal sign
 func (url *Url) DjvuCgiValue(num int) string {
-	panic("unimplemented")
+	url.mtx.RLock()
+	defer url.mtx.RUnlock()
+
+	values := url.cgiValueArr[url.djvuCgiStart():]
+	if num < 0 || num >= len(values) {
+		return ""
+	}
+	return values[num]
 }
 
 // Returns array of all known CGI names
@@ -163,7 +206,13 @@ func (url *Url) CgiNames() []string {
 // Returns array of all known DjVu-related CGI arguments
 // (arguments following `DJVUOPTS` option)
 func (url *Url) DjvuCgiNames() []string {
-	panic("unimplemented")
+	url.mtx.RLock()
+	defer url.mtx.RUnlock()
+
+	names := url.cgiNameArr[url.djvuCgiStart():]
+	retval := make([]string, len(names))
+	copy(retval, names)
+	return retval
 }
 
 // Returns array of all known CGI names
@@ -180,7 +229,13 @@ func (url *Url) CgiValues() []string {
 // Returns array of values of DjVu-related CGI arguments
 // (arguments following `DJVUOPTS` option)
 func (url *Url) DjvuCgiValues() []string {
-	panic("unimplemented")
+	url.mtx.RLock()
+	defer url.mtx.RUnlock()
+
+	values := url.cgiValueArr[url.djvuCgiStart():]
+	retval := make([]string, len(values))
+	copy(retval, values)
+	return retval
 }
 
 // Erases everything after the first `#` or `?`
@@ -193,12 +248,37 @@ func (url *Url) ClearAllArguments() {
 
 // Erases everything after the first `#`
 func (url *Url) ClearHashArguments() {
-	panic("unimplemented")
+	url.mtx.Lock()
+	defer url.mtx.Unlock()
+
+	head, _, tail := splitHash(url.url)
+	url.url = head + tail
 }
 
 // Erases DjVu CGI arguments (following `DJVUOPTS`)
 func (url *Url) ClearDjvuCgiArguments() {
-	panic("unimplemented")
+	url.mtx.Lock()
+	defer url.mtx.Unlock()
+
+	if !url.hasDjvuopts() {
+		return
+	}
+	// Drop `DJVUOPTS` itself as well
+	start := url.djvuCgiStart()
+	url.cgiNameArr = url.cgiNameArr[:start-1]
+	url.cgiValueArr = url.cgiValueArr[:start-1]
+	url.storeCgiArgs()
+}
+
+// Returns whether there is a `DJVUOPTS` CGI argument.
+// Only use when it's guaranteed that the URL is read-locked.
+func (url *Url) hasDjvuopts() bool {
+	for _, arg := range url.cgiNameArr {
+		if strings.ToUpper(arg) == djvuopts {
+			return true
+		}
+	}
+	return false
 }
 
 // Erases all CGI arguments (following the first `?`)
@@ -209,29 +289,175 @@ func (url *Url) ClearCgiArguments() {
 	// Clear everything past the '?' sign in the url
 	split := strings.SplitN(url.url, "?", 2)
 	url.url = split[0]
+	url.cgiNameArr = url.cgiNameArr[:0]
+	url.cgiValueArr = url.cgiValueArr[:0]
 }
 
 // Appends the specified CGI argument.
 // Will insert `DJVUOPTS` if necessary.
-// TODO: Pointer necessary?
-func (url *Url) AddDjvuCgiArgument(name string, value *string) {
-	panic("unimplemented")
+// An empty value leaves out the equal sign.
+func (url *Url) AddDjvuCgiArgument(name string, value string) {
+	url.mtx.Lock()
+	defer url.mtx.Unlock()
+
+	if !url.hasDjvuopts() {
+		url.cgiNameArr = append(url.cgiNameArr, djvuopts)
+		url.cgiValueArr = append(url.cgiValueArr, "")
+	}
+	url.cgiNameArr = append(url.cgiNameArr, name)
+	url.cgiValueArr = append(url.cgiValueArr, value)
+	url.storeCgiArgs()
 }
 
 // Returns the URL corresponding to the dictionary
 // containing the document with this URL.
 // The function basically takes the URL
 // and clears everything after the last slash.
+//
+// A URL without any slash in its path, such as `memory:doc.djvu`,
+// has the bare protocol (`memory:`) for base.
 func (url *Url) Base() *Url {
-	panic("unimplemented")
+	url.mtx.RLock()
+	xurl := url.url
+	url.mtx.RUnlock()
+
+	start := len(protocol(xurl)) + 1
+	if start > len(xurl) {
+		return &Url{url: xurl, validUrl: false}
+	}
+	end := start
+	for ii := start; ii < len(xurl) && !isArgumentInit(rune(xurl[ii])); ii++ {
+		if xurl[ii] == slash {
+			end = ii + 1
+		}
+	}
+	retval, err := NewUrl(xurl[:end])
+	if err != nil {
+		return &Url{url: xurl[:end], validUrl: false}
+	}
+	return retval
+}
+
+// Resolve returns the URL which the reference ref points to
+// when found in the document with this URL.
+// This is how the components of an indirect document
+// are named relative to its index file.
+//
+// References from http and https URLs are resolved as described in RFC 3986.
+// References from local file URLs are joined with the directory as file names.
+// For other protocols, such as `memory:`, relative references are appended to Base.
+// Relative references keep the DjVu CGI arguments of this URL
+// unless they come with DjVu CGI arguments of their own.
+func (url *Url) Resolve(ref string) (*Url, error) {
+	if len(protocol(ref)) >= 2 {
+		return NewUrl(ref)
+	}
+
+	var retval *Url
+	var err error
+	switch proto := url.Protocol(); {
+	case proto == "http" || proto == "https":
+		retval, err = url.resolveReference(ref)
+	case url.IsLocalFileUrl():
+		retval, err = url.resolvePath(ref, func(refPath string) (string, error) {
+			dir, err := url.Base().Filename()
+			if err != nil {
+				return "", err
+			}
+			name, err := expandName(decodeReserved(refPath), dir)
+			if err != nil {
+				return "", err
+			}
+			resolved, err := UrlFromFilename(name)
+			if err != nil {
+				return "", err
+			}
+			return resolved.Raw(), nil
+		})
+	default:
+		retval, err = url.resolvePath(ref, func(refPath string) (string, error) {
+			if strings.HasPrefix(refPath, "/") {
+				return url.Protocol() + ":" + refPath, nil
+			}
+			for strings.HasPrefix(refPath, "./") {
+				refPath = refPath[2:]
+			}
+			return url.Base().Raw() + refPath, nil
+		})
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not resolve %q from %q", ref, url.Raw())
+	}
+
+	if retval.DjvuCgiArguments() == 0 {
+		names, values := url.DjvuCgiNames(), url.DjvuCgiValues()
+		for ii := range names {
+			retval.AddDjvuCgiArgument(names[ii], values[ii])
+		}
+	}
+	return retval, nil
+}
+
+// Resolves ref as described in RFC 3986.
+func (url *Url) resolveReference(ref string) (*Url, error) {
+	head, _, tail := splitHash(url.Raw())
+	base, err := neturl.Parse(head + tail)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := neturl.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	resolved := base.ResolveReference(parsed)
+	hash := resolved.EscapedFragment()
+	resolved.Fragment, resolved.RawFragment = "", ""
+	return newUrlWithHash(resolved.String(), hash)
+}
+
+// Resolves ref by joining its path with that of the URL using join.
+// The query of the URL is kept for references without a path nor a query.
+func (url *Url) resolvePath(ref string, join func(refPath string) (string, error)) (*Url, error) {
+	refPath, query, hash := ref, "", ""
+	if ii := strings.IndexByte(refPath, '#'); ii >= 0 {
+		refPath, hash = refPath[:ii], refPath[ii+1:]
+	}
+	if ii := strings.IndexByte(refPath, '?'); ii >= 0 {
+		refPath, query = refPath[:ii], refPath[ii:]
+	}
+
+	head, _, tail := splitHash(url.Raw())
+	if refPath == "" {
+		if query == "" {
+			query = tail
+		}
+		return newUrlWithHash(head+query, hash)
+	}
+	joined, err := join(refPath)
+	if err != nil {
+		return nil, err
+	}
+	return newUrlWithHash(joined+query, hash)
+}
+
+// Creates a URL from urlStr with hash placed before its CGI arguments.
+// The hash is expected to be escaped already.
+func newUrlWithHash(urlStr, hash string) (*Url, error) {
+	if hash != "" {
+		head, _, tail := splitHash(urlStr)
+		urlStr = head + "#" + hash + tail
+	}
+	return NewUrl(urlStr)
 }
 
 // Returns the absolute URL without the host part.
 func (url *Url) Pathname() string {
 	url.mtx.RLock()
 	defer url.mtx.RUnlock()
-	if url.IsLocalFileUrl() {
-		return encodeReserved(url.utf8Filename())
+	if url.isLocalFileUrl() {
+		if filename, err := url.utf8Filename(); err == nil {
+			return encodePath(filepath.ToSlash(filename))
+		}
 	}
 	urlStr := url.url
 	protoLen := len(protocol(urlStr))
@@ -251,10 +477,16 @@ func (url *Url) Name() string {
 
 	xurl := url.url
 	protocolLength := len(protocol(xurl))
-	_ = protocolLength
-	// TODO: How do we do this?
 
-	panic("unimplemented")
+	// Everything after the last slash and before the arguments
+	xslash := protocolLength
+	ii := protocolLength + 1
+	for ; ii < len(xurl) && !isArgumentInit(rune(xurl[ii])); ii++ {
+		if xurl[ii] == slash {
+			xslash = ii
+		}
+	}
+	return xurl[xslash+1 : ii]
 }
 
 // Returns the name part of this URL with escape sequences expanded.
@@ -284,13 +516,31 @@ func (url *Url) isEmpty() bool { return len(url.url) == 0 }
 func (url *Url) IsLocalFileUrl() bool {
 	url.mtx.RLock()
 	defer url.mtx.RUnlock()
+	return url.isLocalFileUrl()
+}
 
-	return protocol(url.url) == "file" && url.url[5] == slash
+// Unsafe isLocalFileUrl. Only use when it's guaranteed that the URL is read-locked.
+func (url *Url) isLocalFileUrl() bool {
+	return protocol(url.url) == "file" && len(url.url) > 5 && url.url[5] == slash
 }
 
-// Checks whether two URLs are the same
+// Checks whether two URLs are the same.
+// A single trailing slash is not significant,
+// so `http://host/dir` and `http://host/dir/` are equal.
 func (url *Url) Equal(rhs *Url) bool {
-	panic("unimplemented")
+	if url == rhs {
+		return true
+	}
+	g1, g2 := url.Raw(), rhs.Raw()
+	switch len(g1) - len(g2) {
+	case 0:
+		return g1 == g2
+	case -1:
+		return g2[len(g1)] == slash && strings.HasPrefix(g2, g1)
+	case 1:
+		return g1[len(g2)] == slash && strings.HasPrefix(g1, g2)
+	}
+	return false
 }
 
 // Returns internal URL representation.
@@ -301,61 +551,54 @@ func (url *Url) Raw() string {
 	return url.url
 }
 
-// Applies heuristic rules to convert a URl into a valid file name.
-// Returns a simple basename in case of failure.
-//
-// TODO: Export logic to some helper function, then export this method, effectively calling that helper.
-func (url *Url) utf8Filename() string {
+// Applies heuristic rules to convert a URL into a valid file name.
+// A URL which is not a file URL yields its decoded basename.
+// Only use when it's guaranteed that the URL is read-locked.
+func (url *Url) utf8Filename() (string, error) {
 	if url.url == "" {
-		return ""
+		return "", nil
 	}
 
-	retval := ""
-	uu := decodeReserved(url.url)
+	// Arguments are never part of the file name
+	uu := url.url
+	if ii := strings.IndexFunc(uu, isArgumentInit); ii >= 0 {
+		uu = uu[:ii]
+	}
 
 	// Expect file URL to start with `file:` (filespec)
 	if !strings.HasPrefix(uu, filespec) {
-		return path.Base(uu)
+		return path.Base(decodeReserved(uu)), nil
 	}
 	uu = uu[len(filespec):]
 
-	if runtime.GOOS == osMac {
-		// Remove leading slashes
-		uu = strings.TrimLeft(uu, "/")
-		uu = strings.TrimPrefix(uu, localhost)
-		uu = strings.TrimLeft(uu, "/")
-	} else {
-		if strings.HasPrefix(uu, localhostspec1) {
-			uu = strings.TrimPrefix(uu, localhostspec1) // RFC 1738 local host form
-		} else if strings.HasPrefix(uu, localhostspec2) {
-			uu = strings.TrimPrefix(uu, localhostspec2) // RFC 1738 local host form
-		} else if len(uu) > 4 && //  "file://<letter>:/<path>"
-			uu[:2] == "//" && // "file://<letter>|/<path>"
-			unicode.IsLetter(rune(uu[2])) &&
-			(uu[3] == colon || uu[3] == vertical) && uu[4] == slash {
-			uu = uu[2:]
-		} else if len(uu) > 2 && // "file:/<path>"
-			uu[0] == slash && uu[1] != slash {
-			uu = uu[1:]
-		}
+	switch {
+	case strings.HasPrefix(uu, localhostspec1): // RFC 1738 local host form
+		uu = uu[len(localhostspec1)-1:]
+	case strings.HasPrefix(uu, localhostspec2): // RFC 1738 local host form
+		uu = uu[len(localhostspec2)-1:]
+	case len(uu) > 4 && // "file://<letter>:/<path>"
+		uu[:2] == "//" && // "file://<letter>|/<path>"
+		isDriveLetter(uu[2:]):
+		uu = uu[1:]
+	case strings.HasPrefix(uu, "//"):
+		return "", errors.Errorf("%q does not refer to the local host", url.url)
 	}
 
-	// Check if we are finished
-	if runtime.GOOS == osMac {
-		// TODO: Implement...
-		panic("unimplemented")
-	} else {
-		retval = expandName(uu, root)
-	}
+	decoded := decodeReserved(uu)
 
-	if runtime.GOOS == osWindows || runtime.GOOS == osMac {
-		if unicode.IsLetter(rune(uu[0])) && uu[1] == vertical && uu[2] == slash {
-			drive := fmt.Sprintf("%v%v%v", uu[0], colon, backslash)
-			retval = expandName(uu[3:], drive)
-		}
+	// "/<letter>|/<path>" is the drive <letter>
+	if len(decoded) > 3 && decoded[0] == slash && isDriveLetter(decoded[1:]) {
+		drive := decoded[1:2] + string(colon) + string(backslash)
+		return expandName(decoded[4:], drive)
 	}
+	return expandName(decoded, "")
+}
 
-	return retval
+// Returns whether s starts with `<letter>:/` or `<letter>|/`.
+func isDriveLetter(s string) bool {
+	return len(s) >= 3 &&
+		unicode.IsLetter(rune(s[0])) &&
+		(s[1] == colon || s[1] == vertical) && s[2] == slash
 }
 
 // Returns a string representation of the URL.
@@ -369,67 +612,148 @@ func (url *Url) GetStringWithUseragent(useragent string) string {
 
 // Return whether this URL is an existing file, directory, or device.
 func (url *Url) IsLocalPath() bool {
-	panic("unimplemented")
+	_, err := url.stat()
+	return err == nil
 }
 
 // Return whether this URL is an existing file
 func (url *Url) IsFile() bool {
-	panic("unimplemented")
+	info, err := url.stat()
+	return err == nil && info.Mode().IsRegular()
 }
 
 // Return whether this URL is an existing directory
 func (url *Url) IsDir() bool {
-	panic("unimplemented")
+	info, err := url.stat()
+	return err == nil && info.IsDir()
+}
+
+func (url *Url) stat() (os.FileInfo, error) {
+	if !url.IsLocalFileUrl() {
+		return nil, errors.Errorf("%q is not a local file URL", url.Raw())
+	}
+	filename, err := url.Filename()
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(filename)
 }
 
-// Follows symbolic links
+// Follows symbolic links.
+// The URL itself is returned if it does not refer to an existing local file.
 func (url *Url) FollowSymlinks() *Url {
-	panic("unimplemented")
+	if !url.IsLocalFileUrl() {
+		return url
+	}
+	filename, err := url.Filename()
+	if err != nil {
+		return url
+	}
+	target, err := filepath.EvalSymlinks(filename)
+	if err != nil {
+		return url
+	}
+	retval, err := UrlFromFilename(target)
+	if err != nil {
+		return url
+	}
+	return retval
 }
 
-// Creates the specified directory
-//
-// TODO: Returns what?
-func (url *Url) Mkdir() int {
-	panic("unimplemented")
+// Creates the specified directory, along with any missing parents.
+// Nothing is done if the directory already exists.
+func (url *Url) Mkdir() error {
+	filename, err := url.localFilename()
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(filename, 0777)
 }
 
 // Deletes file or directory.
 // Directories are not deleted unless the directory is empty.
-// Returns a negative number if an error occurs.
-//
-// TODO: Return an error instead.
 //
 // TODO: Create a "service" which does operating system manipulation
 // instead of turning them as methods for URL
-func (url *Url) DeleteFile() int {
-	panic("unimplemented")
+func (url *Url) DeleteFile() error {
+	filename, err := url.localFilename()
+	if err != nil {
+		return err
+	}
+	return os.Remove(filename)
 }
 
 // Recursively erases contents of directory.
 // The directory itself will not be removed.
-func (url *Url) ClearDir(timeout int) int {
-	panic("unimplemented")
+func (url *Url) ClearDir() error {
+	if !url.IsDir() {
+		return errors.Errorf("%q is not a directory", url.Raw())
+	}
+	filename, err := url.localFilename()
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(filename)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(filename, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Rename a file or directory
-func (url *Url) RenameTo(newUrl *Url) int {
-	panic("unimplemented")
+func (url *Url) RenameTo(newUrl *Url) error {
+	oldName, err := url.localFilename()
+	if err != nil {
+		return err
+	}
+	newName, err := newUrl.localFilename()
+	if err != nil {
+		return err
+	}
+	return os.Rename(oldName, newName)
 }
 
 // List the contents of a directory
-func (url *Url) ListDir() []*Url {
-	panic("unimplemented")
+func (url *Url) ListDir() ([]*Url, error) {
+	filename, err := url.localFilename()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(filename)
+	if err != nil {
+		return nil, err
+	}
+	retval := make([]*Url, 0, len(entries))
+	for _, entry := range entries {
+		child, err := UrlFromFilename(filepath.Join(filename, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		retval = append(retval, child)
+	}
+	return retval, nil
+}
+
+// Like Filename but fails for URLs which are not local file URLs.
+func (url *Url) localFilename() (string, error) {
+	if !url.IsLocalFileUrl() {
+		return "", errors.Errorf("%q is not a local file URL", url.Raw())
+	}
+	return url.Filename()
 }
 
 // Returns a filename for a URL.
 // Argument must be a legal file URL.
 // This function applies heuristic rules to convert the URL into a valid file name.
 // It is guaranteed that this function can properly parse all URLs
-// generated by `filename_to_url`
-// The heuristics also work better when the file actually exists.
-// An error is returned when this function cannot parse the URL
-// or when the URL is not a file URL.
+// generated by UrlFromFilename.
+// A URL which is not a file URL yields its decoded basename.
+// An error is returned when the file URL refers to another host.
 //
 // URL formats are as described in RFC 1738
 // plus the following alternative formats for files on the local host:
@@ -439,26 +763,30 @@ func (url *Url) ListDir() []*Url {
 //     file:/<path>
 //
 // which are accepted because various browsers recognize them.
-//
-// TODO: Can we use os for this?
 func (url *Url) Filename() (string, error) {
 	url.mtx.RLock()
 	defer url.mtx.RUnlock()
-	if len(url.url) == 0 {
-		return "", nil
-	}
+	return url.utf8Filename()
+}
 
-	urlCopy := decodeReserved(url.url)
-	if !strings.HasPrefix(urlCopy, filespec) {
-		return path.Base(urlCopy), nil
+// UrlFromFilename creates a file URL from the file name.
+// Relative names are interpreted relative to the current working directory.
+// The URL has the `file://localhost/` form described in RFC 1738.
+func UrlFromFilename(filename string) (*Url, error) {
+	abs, err := expandName(filename, "")
+	if err != nil {
+		return nil, err
 	}
-	urlCopy = strings.TrimPrefix(urlCopy, filespec)
+	return NewUrl(filenameToUrl(abs))
+}
 
-	if runtime.GOOS == osMac {
-		// TODO: Needful
+// Returns the `file://localhost/` URL of the absolute file name `abs`
+func filenameToUrl(abs string) string {
+	name := filepath.ToSlash(abs)
+	if !strings.HasPrefix(name, "/") {
+		name = "/" + name // Windows drive letters
 	}
-	// Do more things here...
-	panic("unimplemented")
+	return localhost + encodePath(name[1:])
 }
 
 // Hashing function
@@ -486,42 +814,60 @@ func (url *Url) init() error {
 
 	protocol := protocol(url.url)
 	if len(protocol) < 2 {
+		url.validUrl = false
 		return errors.New("GURL.no_protocol " + url.url)
 	}
 
-	// For the `localhost` protocol
-	if strings.HasPrefix(url.url, localhost) {
-		// Take the arguments first
-		argsStr := strings.TrimLeftFunc(url.url, func(r rune) bool { return !isArgumentInit(r) })
-		url.url = url.url[0 : len(url.url)-len(argsStr)] // Before the start of the arguments
-
-		// Do double conversion
-		tmp := url.utf8Filename()
-		if len(tmp) == 0 {
-			url.validUrl = false
-			return errors.New("GURL.fail_to_file")
-		}
-		// TODO: My brain hurts...
+	url.convertSlashes()
+	if err := url.canonicalizeFile(); err != nil {
+		url.validUrl = false
+		return err
+	}
+	url.beautifyPath()
+	url.parseCgiArgs()
+	return nil
+}
 
-		// Append the arguments back
-		url.url += argsStr
+// Converts local file URLs to a filename and back,
+// so that equivalent spellings such as `file:/dir/file.djvu`,
+// `file:///dir/file.djvu` and `file://localhost/dir/file.djvu`
+// all end up as the last one.
+// URLs of files on other hosts are left alone.
+func (url *Url) canonicalizeFile() error {
+	if !url.isLocalFileUrl() {
+		return nil
+	}
+	rest := url.url[len(filespec):]
+	if strings.HasPrefix(rest, "//") && !strings.HasPrefix(rest, localhostspec1) && !strings.HasPrefix(rest, localhostspec2) {
+		return nil
 	}
 
-	panic("unimplemented") // TODO
+	var args string
+	if ii := strings.IndexFunc(url.url, isArgumentInit); ii >= 0 {
+		args = url.url[ii:]
+	}
+	filename, err := url.utf8Filename()
+	if err != nil {
+		return errors.Wrapf(err, "invalid file URL %s", url.url)
+	}
+	url.url = filenameToUrl(filename) + args
+	return nil
 }
 
+// Converts backslashes into forward slashes
+// since Windows users tend to type them in.
 func (url *Url) convertSlashes() {
-	if runtime.GOOS == "windows" {
+	if runtime.GOOS == osWindows {
 		xurl := url.url
 		protocol := protocol(xurl)
 		remaining := xurl[len(protocol):]
-		remaining = strings.ReplaceAll(remaining, "/", "\\")
+		remaining = strings.ReplaceAll(remaining, "\\", "/")
 		url.url = protocol + remaining
 	}
 }
 
 func (url *Url) beautifyPath() {
-	panic("unimplemented")
+	url.url = beautifyPath(url.url)
 }
 
 func (url *Url) parseCgiArgs() {
@@ -531,19 +877,24 @@ func (url *Url) parseCgiArgs() {
 	if len(split) == 1 {
 		return // No arguments to be found
 	}
-	args := split[1]
 
-	// TODO: Interpret
-	_ = args
-	panic("unimplemented")
+	args := strings.FieldsFunc(split[1], isArgumentSeparator)
+	for _, arg := range args {
+		name, value := arg, ""
+		if eq := strings.IndexByte(arg, '='); eq >= 0 {
+			name, value = arg[:eq], arg[eq+1:]
+		}
+		url.cgiNameArr = append(url.cgiNameArr, decodeReserved(name))
+		url.cgiValueArr = append(url.cgiValueArr, decodeReserved(value))
+	}
 }
 
 func (url *Url) storeCgiArgs() {
 	var sb strings.Builder
-	sb.WriteString(url.url)
+	sb.WriteString(strings.SplitN(url.url, "?", 2)[0])
 
 	for ii := range url.cgiNameArr {
-		name, value := encodeReserved(url.cgiNameArr[ii]), encodeReserved(url.cgiValueArr[ii])
+		name, value := encodeCgi(url.cgiNameArr[ii]), encodeCgi(url.cgiValueArr[ii])
 		if ii == 0 {
 			sb.WriteRune('?')
 		} else {
@@ -558,25 +909,107 @@ func (url *Url) storeCgiArgs() {
 	url.url = sb.String()
 }
 
-// Escape special characters
 func encodeReserved(gs string) string {
 	// TODO: For now this should work, but you'd be better off basing code from encode_reserved
-	return url.PathEscape(gs)
+	return neturl.PathEscape(gs)
+}
+
+// Like encodeReserved, but also escapes the CGI argument separators.
+// Commas are left alone since DjVu CGI values are comma separated lists.
+func encodeCgi(gs string) string {
+	return cgiReplacer.Replace(encodeReserved(gs))
 }
 
-// Decodes reserved characters from the URL
+var cgiReplacer = strings.NewReplacer("&", "%26", ";", "%3B", "=", "%3D", "%2C", ",")
+
+// Splits urlStr around its hash argument.
+// The hash argument, including its `#`, ends at the CGI arguments.
+func splitHash(urlStr string) (head, hash, tail string) {
+	start := strings.IndexByte(urlStr, '#')
+	if start < 0 {
+		start = strings.IndexByte(urlStr, '?')
+		if start < 0 {
+			return urlStr, "", ""
+		}
+		return urlStr[:start], "", urlStr[start:]
+	}
+	end := strings.IndexByte(urlStr[start:], '?')
+	if end < 0 {
+		return urlStr[:start], urlStr[start:], ""
+	}
+	return urlStr[:start], urlStr[start : start+end], urlStr[start+end:]
+}
+
+// Decodes reserved characters from the URL.
+// Malformed escape sequences such as `%zz` or a trailing `%` are kept as they are,
+// and `+` stays a plus sign since it only stands for a space in form data.
 func decodeReserved(urlStr string) string {
-	// TODO: For now this should work, but you'd be better off basing code from decode_reserved
-	result, err := url.PathUnescape(urlStr)
-	if err != nil {
-		// TODO: Return err instead of panicking
-		panic(err)
+	if strings.IndexByte(urlStr, percent) < 0 {
+		return urlStr
+	}
+	var sb strings.Builder
+	sb.Grow(len(urlStr))
+	for ii := 0; ii < len(urlStr); ii++ {
+		if urlStr[ii] == percent && ii+2 < len(urlStr) {
+			hi, lo := hexVal(rune(urlStr[ii+1])), hexVal(rune(urlStr[ii+2]))
+			if hi >= 0 && lo >= 0 {
+				sb.WriteByte(byte(hi<<4 | lo))
+				ii += 2
+				continue
+			}
+		}
+		sb.WriteByte(urlStr[ii])
 	}
-	return result
+	return sb.String()
 }
 
+// Eats parts like `./`, `../` or `///` from the path part of the URL.
+// Arguments are left untouched.
 func beautifyPath(urlStr string) string {
-	panic("unimplemented")
+	start := pathnameStart(urlStr, len(protocol(urlStr)))
+	head, rest := urlStr[:start], urlStr[start:]
+
+	// Find end of the url (don't touch arguments)
+	args := ""
+	if ii := strings.IndexFunc(rest, isArgumentInit); ii >= 0 {
+		rest, args = rest[:ii], rest[ii:]
+	}
+
+	// Eat multiple slashes
+	for strings.Contains(rest, "//") {
+		rest = strings.ReplaceAll(rest, "//", "/")
+	}
+	// Convert /./ stuff into plain /
+	for strings.Contains(rest, "/./") {
+		rest = strings.ReplaceAll(rest, "/./", "/")
+	}
+	// Process /../
+	for {
+		ii := strings.Index(rest, "/../")
+		if ii < 0 {
+			break
+		}
+		jj := strings.LastIndexByte(rest[:ii], slash)
+		if jj < 0 {
+			// Nothing to go up to, just drop the `..`
+			rest = rest[:ii] + rest[ii+3:]
+			continue
+		}
+		rest = rest[:jj] + rest[ii+3:]
+	}
+	// Remove trailing /.
+	if strings.HasSuffix(rest, "/.") {
+		rest = rest[:len(rest)-1]
+	}
+	// Eat trailing /..
+	if strings.HasSuffix(rest, "/..") {
+		rest = rest[:len(rest)-3]
+		if jj := strings.LastIndexByte(rest, slash); jj >= 0 {
+			rest = rest[:jj+1]
+		}
+	}
+
+	return head + rest + args
 }
 
 func pathnameStart(urlStr string, protolength int) int {
@@ -584,7 +1017,7 @@ func pathnameStart(urlStr string, protolength int) int {
 	retval := 0
 	if protolength+1 < urlLength {
 		if urlStr[protolength+1] == slash {
-			if urlStr[protolength+2] == slash {
+			if protolength+2 < urlLength && urlStr[protolength+2] == slash {
 				retval = search(urlStr, slash, protolength+3)
 			} else {
 				retval = search(urlStr, slash, protolength+2)
@@ -612,20 +1045,36 @@ func search(s string, c byte, startAt int) int {
 
 // Returns the full path name of filename interpreted relative to fromDir.
 // Use current working dir when fromDir is empty.
-func expandName(filename string, fromDir string) string {
-
-	// Do things here
+// A leading `~` is expanded into the home directory of the user.
+func expandName(filename string, fromDir string) (string, error) {
+	if filename == string(tilde) || strings.HasPrefix(filename, string(tilde)+"/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", errors.Wrap(err, "could not expand "+filename)
+		}
+		filename = home + filename[1:]
+	}
+	filename = filepath.FromSlash(filename)
+	if filepath.IsAbs(filename) {
+		return filepath.Clean(filename), nil
+	}
+	if fromDir == "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return "", errors.Wrap(err, "could not expand "+filename)
+		}
+		fromDir = cwd
+	}
+	return filepath.Join(fromDir, filename), nil
+}
 
-	// The IF function
-	switch runtime.GOOS {
-	case osMac, osLinux:
-		// TODO: UNIX impl
-	case osWindows:
-		// TODO: Windows impl
-	default:
-		panic(unsupportedSystem)
+// Escapes each segment of a slash separated path.
+func encodePath(p string) string {
+	segments := strings.Split(p, "/")
+	for ii := range segments {
+		segments[ii] = encodeReserved(segments[ii])
 	}
-	panic("unimplemented")
+	return strings.Join(segments, "/")
 }
 
 // protocol extracts the protocol part of the url string.
@@ -633,7 +1082,7 @@ func expandName(filename string, fromDir string) string {
 // If a protocol cannot be found, return an empty string.
 func protocol(urlStr string) string {
 	remaining := strings.TrimLeft(urlStr, alphanum+"+-.")
-	if len(remaining) >= 3 && remaining[:3] == "://" {
+	if len(remaining) >= 1 && remaining[0] == colon {
 		protocolLength := len(urlStr) - len(remaining)
 		return urlStr[:protocolLength]
 	}
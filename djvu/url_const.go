@@ -1,7 +1,5 @@
 package djvu
 
-import "runtime"
-
 const (
 	djvuopts        = "DJVUOPTS"
 	localhost       = "file://localhost/"
@@ -24,15 +22,3 @@ const (
 const (
 	alphanum = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 )
-
-var root = func() string {
-	switch runtime.GOOS {
-	case osMac:
-		return ""
-	case osLinux:
-		return "/"
-	case osWindows:
-		return "\\"
-	}
-	panic("define something here for your operating system")
-}()
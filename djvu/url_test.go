@@ -1,6 +1,9 @@
 package djvu
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/suite"
@@ -29,3 +32,296 @@ func (s *UrlTestSuite) TestPathname() {
 func (s *UrlTestSuite) TestUrlName() {
 	s.Equal(`file%201.djvu`, s.SimpleUrl.Name())
 }
+
+func (s *UrlTestSuite) TestHashArgument() {
+	url, err := NewUrl(`memory:book.djvu?DJVUOPTS&page=2`)
+	s.NoError(err)
+	s.Equal(``, url.HashArgument())
+
+	url.SetHashArgument(`p 1.djvu`)
+	s.Equal(`memory:book.djvu#p%201.djvu?DJVUOPTS&page=2`, url.Raw())
+	s.Equal(`p 1.djvu`, url.HashArgument())
+
+	url.ClearHashArguments()
+	s.Equal(`memory:book.djvu?DJVUOPTS&page=2`, url.Raw())
+}
+
+func (s *UrlTestSuite) TestBase() {
+	s.Equal(`http://www.lizardtech.com/`, s.SimpleUrl.Base().Raw())
+
+	url, err := NewUrl(`memory:book.djvu`)
+	s.NoError(err)
+	s.Equal(`memory:`, url.Base().Raw())
+}
+
+func (s *UrlTestSuite) TestFilename() {
+	for _, raw := range []string{
+		`file:/dir/file%201.djvu`,
+		`file:///dir/file%201.djvu`,
+		`file://localhost/dir/file%201.djvu`,
+		`file://localhost/dir/file%201.djvu#page?DJVUOPTS`,
+	} {
+		url, err := NewUrl(raw)
+		s.Require().NoError(err)
+		filename, err := url.Filename()
+		s.NoError(err, raw)
+		s.Equal(filepath.FromSlash(`/dir/file 1.djvu`), filename, raw)
+	}
+
+	url, err := NewUrl(`file://example.com/dir/file.djvu`)
+	s.Require().NoError(err)
+	_, err = url.Filename()
+	s.Error(err)
+
+	filename, err := s.SimpleUrl.Filename()
+	s.NoError(err)
+	s.Equal(`file 1.djvu`, filename)
+}
+
+func (s *UrlTestSuite) TestCanonicalFile() {
+	canonical, err := NewUrl(`file://localhost/tmp/x%201.djvu#p2?DJVUOPTS`)
+	s.Require().NoError(err)
+	s.Equal(`file://localhost/tmp/x%201.djvu#p2?DJVUOPTS`, canonical.Raw())
+	for _, raw := range []string{
+		`file:/tmp/x%201.djvu#p2?DJVUOPTS`,
+		`file:///tmp/x%201.djvu#p2?DJVUOPTS`,
+		`file:/tmp/./dir/../x%201.djvu#p2?DJVUOPTS`,
+	} {
+		url, err := NewUrl(raw)
+		s.Require().NoError(err)
+		s.True(canonical.Equal(url), raw)
+		s.Equal(canonical.Hash(), url.Hash(), raw)
+		s.Equal(`p2`, url.HashArgument(), raw)
+	}
+
+	// Files on other hosts are left alone
+	url, err := NewUrl(`file://example.com/tmp/x.djvu`)
+	s.Require().NoError(err)
+	s.Equal(`file://example.com/tmp/x.djvu`, url.Raw())
+}
+
+func (s *UrlTestSuite) TestUrlFromFilename() {
+	dir := s.T().TempDir()
+	name := filepath.Join(dir, "my book#1.djvu")
+	url, err := UrlFromFilename(name)
+	s.Require().NoError(err)
+	s.True(strings.HasPrefix(url.Raw(), `file://localhost/`))
+	s.True(strings.HasSuffix(url.Raw(), `/my%20book%231.djvu`))
+	s.True(url.IsLocalFileUrl())
+	s.Equal(``, url.HashArgument())
+
+	filename, err := url.Filename()
+	s.NoError(err)
+	s.Equal(name, filename)
+	s.Equal(`my%20book%231.djvu`, url.Name())
+}
+
+func (s *UrlTestSuite) TestFileOperations() {
+	dir, err := UrlFromFilename(s.T().TempDir())
+	s.Require().NoError(err)
+	s.True(dir.IsDir())
+	s.True(dir.IsLocalPath())
+	s.False(dir.IsFile())
+	s.Error(s.SimpleUrl.Mkdir())
+
+	sub := s.child(dir, "a/b")
+	s.NoError(sub.Mkdir())
+	s.True(sub.IsDir())
+	s.NoError(sub.Mkdir())
+
+	file := s.child(sub, "file.djvu")
+	s.False(file.IsLocalPath())
+	filename, err := file.Filename()
+	s.Require().NoError(err)
+	s.Require().NoError(os.WriteFile(filename, []byte("AT&T"), 0666))
+	s.True(file.IsFile())
+
+	renamed := s.child(sub, "renamed.djvu")
+	s.NoError(file.RenameTo(renamed))
+	s.False(file.IsLocalPath())
+	s.True(renamed.IsFile())
+
+	list, err := sub.ListDir()
+	s.NoError(err)
+	s.Require().Len(list, 1)
+	s.True(list[0].Equal(renamed))
+
+	parent := s.child(dir, "a")
+	s.Error(parent.DeleteFile()) // Not empty
+	s.NoError(parent.ClearDir())
+	s.True(parent.IsDir())
+	list, err = parent.ListDir()
+	s.NoError(err)
+	s.Empty(list)
+	s.NoError(parent.DeleteFile())
+	s.False(parent.IsLocalPath())
+	s.Error(parent.ClearDir())
+}
+
+func (s *UrlTestSuite) TestFollowSymlinks() {
+	dir := s.T().TempDir()
+	target := filepath.Join(dir, "target.djvu")
+	s.Require().NoError(os.WriteFile(target, nil, 0666))
+	link := filepath.Join(dir, "link.djvu")
+	if err := os.Symlink(target, link); err != nil {
+		s.T().Skip("symbolic links unavailable: ", err)
+	}
+
+	url, err := UrlFromFilename(link)
+	s.Require().NoError(err)
+	filename, err := url.FollowSymlinks().Filename()
+	s.NoError(err)
+	s.Equal(filepath.Base(target), filepath.Base(filename))
+	s.Same(s.SimpleUrl, s.SimpleUrl.FollowSymlinks())
+}
+
+// Returns the URL of name, a slash separated path relative to dir.
+func (s *UrlTestSuite) child(dir *Url, name string) *Url {
+	url, err := NewUrl(dir.Raw() + "/" + name)
+	s.Require().NoError(err)
+	return url
+}
+
+func (s *UrlTestSuite) TestDecodeReserved() {
+	for encoded, decoded := range map[string]string{
+		`file%201.djvu`:   `file 1.djvu`,
+		`caf%C3%A9`:       `café`,
+		`caf%c3%a9`:       `café`,
+		`a+b`:             `a+b`,
+		`100%`:            `100%`,
+		`100%2`:           `100%2`,
+		`%zz%41`:          `%zzA`,
+		`%%41`:            `%A`,
+		`%2F%3F%23`:       `/?#`,
+		`no escapes here`: `no escapes here`,
+	} {
+		s.Equal(decoded, decodeReserved(encoded), encoded)
+	}
+
+	url, err := NewUrl(`memory:book.djvu#100%?DJVUOPTS&zoom=%zz`)
+	s.Require().NoError(err)
+	s.Equal(`100%`, url.HashArgument())
+	s.Equal(`%zz`, url.DjvuCgiValue(0))
+}
+
+func (s *UrlTestSuite) TestCgiArguments() {
+	url, err := NewUrl(`http://host/book.djvu?id=7&DJVUOPTS&zoom=page&mode=bw`)
+	s.Require().NoError(err)
+	s.Equal(4, url.CgiArguments())
+	s.Equal(`id`, url.CgiName(0))
+	s.Equal(`7`, url.CgiValue(0))
+	s.Equal(``, url.CgiName(4))
+	s.Equal(``, url.CgiValue(-1))
+
+	s.Equal(2, url.DjvuCgiArguments())
+	s.Equal([]string{`zoom`, `mode`}, url.DjvuCgiNames())
+	s.Equal([]string{`page`, `bw`}, url.DjvuCgiValues())
+	s.Equal(`mode`, url.DjvuCgiName(1))
+	s.Equal(``, url.DjvuCgiName(2))
+
+	url.ClearDjvuCgiArguments()
+	s.Equal(`http://host/book.djvu?id=7`, url.Raw())
+	s.Equal(0, url.DjvuCgiArguments())
+
+	url.AddDjvuCgiArgument(`page`, `2`)
+	url.AddDjvuCgiArgument(`frame`, ``)
+	s.Equal(`http://host/book.djvu?id=7&DJVUOPTS&page=2&frame`, url.Raw())
+	s.Equal(2, url.DjvuCgiArguments())
+
+	url.ClearCgiArguments()
+	s.Equal(`http://host/book.djvu`, url.Raw())
+	s.Equal(0, url.CgiArguments())
+}
+
+func (s *UrlTestSuite) TestResolveHTTP() {
+	base, err := NewUrl(`http://a/b/c/d;p?q`)
+	s.Require().NoError(err)
+	// Examples from RFC 3986, section 5.4
+	for ref, want := range map[string]string{
+		`ftp:h`:   `ftp:h`,
+		`g`:       `http://a/b/c/g`,
+		`./g`:     `http://a/b/c/g`,
+		`g/`:      `http://a/b/c/g/`,
+		`/g`:      `http://a/g`,
+		`//g`:     `http://g`,
+		`?y`:      `http://a/b/c/d;p?y`,
+		`g?y`:     `http://a/b/c/g?y`,
+		`#s`:      `http://a/b/c/d;p#s?q`,
+		`g#s`:     `http://a/b/c/g#s`,
+		`g?y#s`:   `http://a/b/c/g#s?y`,
+		``:        `http://a/b/c/d;p?q`,
+		`.`:       `http://a/b/c/`,
+		`..`:      `http://a/b/`,
+		`../g`:    `http://a/b/g`,
+		`../../g`: `http://a/g`,
+	} {
+		resolved, err := base.Resolve(ref)
+		if s.NoError(err, ref) {
+			s.Equal(want, resolved.Raw(), ref)
+		}
+	}
+}
+
+func (s *UrlTestSuite) TestResolveKeepsDjvuCgiArguments() {
+	base, err := NewUrl(`https://host/books/index.djvu?DJVUOPTS&zoom=100`)
+	s.Require().NoError(err)
+
+	resolved, err := base.Resolve(`p%201.djvu`)
+	s.NoError(err)
+	s.Equal(`https://host/books/p%201.djvu?DJVUOPTS&zoom=100`, resolved.Raw())
+
+	resolved, err = base.Resolve(`p1.djvu?token=x`)
+	s.NoError(err)
+	s.Equal(`https://host/books/p1.djvu?token=x&DJVUOPTS&zoom=100`, resolved.Raw())
+
+	resolved, err = base.Resolve(`p1.djvu?DJVUOPTS&zoom=50`)
+	s.NoError(err)
+	s.Equal(`https://host/books/p1.djvu?DJVUOPTS&zoom=50`, resolved.Raw())
+
+	resolved, err = base.Resolve(`memory:p1.djvu`)
+	s.NoError(err)
+	s.Equal(`memory:p1.djvu`, resolved.Raw())
+}
+
+func (s *UrlTestSuite) TestResolveFile() {
+	dir := s.T().TempDir()
+	base, err := UrlFromFilename(filepath.Join(dir, "books", "index.djvu"))
+	s.Require().NoError(err)
+
+	for ref, want := range map[string]string{
+		`p%201.djvu`:       filepath.Join(dir, "books", "p 1.djvu"),
+		`./pages/p1.djvu`:  filepath.Join(dir, "books", "pages", "p1.djvu"),
+		`../shared.djvu`:   filepath.Join(dir, "shared.djvu"),
+		`p1.djvu#anno`:     filepath.Join(dir, "books", "p1.djvu"),
+		`p%2523.djvu?x=%z`: filepath.Join(dir, "books", "p%23.djvu"),
+	} {
+		resolved, err := base.Resolve(ref)
+		if !s.NoError(err, ref) {
+			continue
+		}
+		filename, err := resolved.Filename()
+		s.NoError(err, ref)
+		s.Equal(want, filename, ref)
+	}
+
+	resolved, err := base.Resolve(`p1.djvu#anno`)
+	s.NoError(err)
+	s.Equal(`anno`, resolved.HashArgument())
+}
+
+func (s *UrlTestSuite) TestResolveOther() {
+	base, err := NewUrl(`memory:books/index.djvu?DJVUOPTS&page=2`)
+	s.Require().NoError(err)
+	for ref, want := range map[string]string{
+		`p1.djvu`:       `memory:books/p1.djvu?DJVUOPTS&page=2`,
+		`./a:b.djvu`:    `memory:books/a:b.djvu?DJVUOPTS&page=2`,
+		`a/../p1.djvu`:  `memory:books/p1.djvu?DJVUOPTS&page=2`,
+		`/other.djvu`:   `memory:/other.djvu?DJVUOPTS&page=2`,
+		`p1.djvu#x%20y`: `memory:books/p1.djvu#x%20y?DJVUOPTS&page=2`,
+	} {
+		resolved, err := base.Resolve(ref)
+		if s.NoError(err, ref) {
+			s.Equal(want, resolved.Raw(), ref)
+		}
+	}
+}
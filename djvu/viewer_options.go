@@ -0,0 +1,563 @@
+package djvu
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ViewerOptions are the DjVu CGI arguments of a URL,
+// that is, the arguments following `DJVUOPTS`, such as in
+//
+//     https://example.com/book.djvu?DJVUOPTS&page=12&zoom=width&mode=bw
+//
+// DjVu viewers use these to decide how to display the document.
+// The zero value of each field stands for an argument which is not given.
+type ViewerOptions struct {
+	// Page to display, counted from 1
+	Page int
+
+	// Id, name or title of the page to display, used in place of Page
+	PageID string
+
+	Zoom     Zoom
+	Mode     DisplayMode
+	Rotate   Rotation
+	HorAlign Alignment
+	VerAlign Alignment
+
+	// Rectangles to highlight on the page
+	Highlight []Highlight
+
+	// Position of the page to scroll to, if any
+	ShowPosition *Position
+
+	// Text to search for
+	Find string
+
+	Toolbar    Toolbar
+	Frame      Toggle
+	Menubar    Toggle
+	Statusbar  Toggle
+	Scrollbars Toggle
+	Sidebar    Toggle
+	Continuous Toggle
+	SideBySide Toggle
+	Keyboard   Toggle
+	Links      Toggle
+	Print      Toggle
+
+	// Arguments which are not known, in the order they were given
+	Other []CgiArgument
+}
+
+// CgiArgument is a CGI argument of a URL
+type CgiArgument struct {
+	Name  string
+	Value string
+}
+
+// Zoom is either a percentage in the range [MinZoom, MaxZoom] or one of the ZOOM constants.
+type Zoom int
+
+const (
+	ZOOM_DEFAULT   Zoom = 0
+	ZOOM_FIT_WIDTH Zoom = -1
+	ZOOM_FIT_PAGE  Zoom = -2
+	ZOOM_ONE2ONE   Zoom = -3
+	ZOOM_STRETCH   Zoom = -4
+)
+
+const (
+	MinZoom = 5
+	MaxZoom = 999
+)
+
+var zoomNames = map[Zoom]string{
+	ZOOM_FIT_WIDTH: "width",
+	ZOOM_FIT_PAGE:  "page",
+	ZOOM_ONE2ONE:   "one2one",
+	ZOOM_STRETCH:   "stretch",
+}
+
+func (z Zoom) String() string {
+	if name, ok := zoomNames[z]; ok {
+		return name
+	}
+	return strconv.Itoa(int(z))
+}
+
+// DisplayMode tells which layers of the page are displayed
+type DisplayMode uint8
+
+const (
+	DISPLAY_DEFAULT DisplayMode = iota
+	DISPLAY_COLOR
+	DISPLAY_BW
+	DISPLAY_FORE
+	DISPLAY_BACK
+)
+
+var displayModeNames = []string{"", "color", "bw", "fore", "back"}
+
+func (m DisplayMode) String() string { return enumName(displayModeNames, int(m)) }
+
+// Rotation is the counter-clockwise rotation of the displayed page
+type Rotation uint8
+
+const (
+	ROTATE_DEFAULT Rotation = iota
+	ROTATE_0
+	ROTATE_90
+	ROTATE_180
+	ROTATE_270
+)
+
+var rotationNames = []string{"", "0", "90", "180", "270"}
+
+func (r Rotation) String() string { return enumName(rotationNames, int(r)) }
+
+// Alignment of the page within the viewer.
+// Horizontal alignments are ALIGN_LEFT, ALIGN_CENTER and ALIGN_RIGHT,
+// and vertical alignments are ALIGN_TOP, ALIGN_CENTER and ALIGN_BOTTOM.
+type Alignment uint8
+
+const (
+	ALIGN_DEFAULT Alignment = iota
+	ALIGN_LEFT
+	ALIGN_CENTER
+	ALIGN_RIGHT
+	ALIGN_TOP
+	ALIGN_BOTTOM
+)
+
+var alignmentNames = []string{"", "left", "center", "right", "top", "bottom"}
+
+var (
+	horAlignments = []Alignment{ALIGN_LEFT, ALIGN_CENTER, ALIGN_RIGHT}
+	verAlignments = []Alignment{ALIGN_TOP, ALIGN_CENTER, ALIGN_BOTTOM}
+)
+
+func (a Alignment) String() string { return enumName(alignmentNames, int(a)) }
+
+// Toolbar tells whether and where the toolbar is displayed
+type Toolbar uint8
+
+const (
+	TOOLBAR_DEFAULT Toolbar = iota
+	TOOLBAR_YES
+	TOOLBAR_NO
+	TOOLBAR_AUTO
+	TOOLBAR_ALWAYS
+	TOOLBAR_TOP
+	TOOLBAR_BOTTOM
+)
+
+var toolbarNames = []string{"", "yes", "no", "auto", "always", "top", "bottom"}
+
+func (t Toolbar) String() string { return enumName(toolbarNames, int(t)) }
+
+// Toggle is an option which is either on or off
+type Toggle uint8
+
+const (
+	TOGGLE_DEFAULT Toggle = iota
+	TOGGLE_YES
+	TOGGLE_NO
+)
+
+var toggleNames = []string{"", "yes", "no"}
+
+func (t Toggle) String() string { return enumName(toggleNames, int(t)) }
+
+// Highlight is a rectangle in page coordinates,
+// with the origin at the bottom left corner of the page
+type Highlight struct {
+	X, Y, W, H int
+
+	// Color of the highlight, or nil for the default color of the viewer
+	Color *color.RGBA
+}
+
+// Position is a point on the page,
+// given as fractions of the width and height of the page
+type Position struct {
+	X, Y float64
+}
+
+// ViewerOptionError is returned for DjVu CGI arguments with invalid values
+type ViewerOptionError struct {
+	Name  string
+	Value string
+	Err   error
+}
+
+func (e *ViewerOptionError) Error() string {
+	return fmt.Sprintf("invalid DjVu CGI argument %s=%q: %v", e.Name, e.Value, e.Err)
+}
+
+func (e *ViewerOptionError) Unwrap() error { return e.Err }
+
+// ParseViewerOptions parses the DjVu CGI arguments of url.
+// Names and keyword values are not case sensitive.
+// Every valid argument is kept in the returned options
+// even when an error is returned for the first invalid one.
+func ParseViewerOptions(url *Url) (*ViewerOptions, error) {
+	opts := &ViewerOptions{}
+	names, values := url.DjvuCgiNames(), url.DjvuCgiValues()
+	var firstErr error
+	for ii := range names {
+		saved := *opts
+		if err := opts.set(names[ii], values[ii]); err != nil {
+			*opts = saved
+			if firstErr == nil {
+				firstErr = &ViewerOptionError{Name: names[ii], Value: values[ii], Err: err}
+			}
+		}
+	}
+	return opts, firstErr
+}
+
+// Sets the option `name` to `value`
+func (opts *ViewerOptions) set(name, value string) error {
+	lower := strings.ToLower(value)
+	var err error
+	switch strings.ToLower(name) {
+	case "page":
+		if page, perr := strconv.Atoi(value); perr == nil {
+			if page < 1 {
+				return errors.New("pages are counted from 1")
+			}
+			opts.Page, opts.PageID = page, ""
+		} else if value == "" {
+			return errors.New("missing page")
+		} else {
+			opts.Page, opts.PageID = 0, value
+		}
+	case "zoom":
+		opts.Zoom, err = parseZoom(lower)
+	case "mode":
+		opts.Mode, err = parseDisplayMode(lower)
+	case "rotate":
+		var r int
+		r, err = parseEnum(rotationNames, lower)
+		opts.Rotate = Rotation(r)
+	case "hor_align", "halign":
+		opts.HorAlign, err = parseAlignment(lower, horAlignments...)
+	case "ver_align", "valign":
+		opts.VerAlign, err = parseAlignment(lower, verAlignments...)
+	case "highlight":
+		var h Highlight
+		h, err = parseHighlight(lower)
+		if err == nil {
+			opts.Highlight = append(opts.Highlight, h)
+		}
+	case "showposition":
+		var pos Position
+		pos, err = parsePosition(lower)
+		if err == nil {
+			opts.ShowPosition = &pos
+		}
+	case "find":
+		opts.Find = value
+	case "toolbar":
+		var t int
+		t, err = parseEnum(toolbarNames, parseBoolAlias(lower))
+		opts.Toolbar = Toolbar(t)
+	case "frame":
+		opts.Frame, err = parseToggle(lower)
+	case "menubar":
+		opts.Menubar, err = parseToggle(lower)
+	case "statusbar":
+		opts.Statusbar, err = parseToggle(lower)
+	case "scrollbars":
+		opts.Scrollbars, err = parseToggle(lower)
+	case "sidebar", "thumbnails":
+		opts.Sidebar, err = parseToggle(lower)
+	case "continuous":
+		opts.Continuous, err = parseToggle(lower)
+	case "sidebyside":
+		opts.SideBySide, err = parseToggle(lower)
+	case "keyboard":
+		opts.Keyboard, err = parseToggle(lower)
+	case "links":
+		opts.Links, err = parseToggle(lower)
+	case "print":
+		opts.Print, err = parseToggle(lower)
+	default:
+		opts.Other = append(opts.Other, CgiArgument{Name: name, Value: value})
+	}
+	return err
+}
+
+// Validate checks that the options are within range
+// and that there is a name for every enumerated value.
+func (opts *ViewerOptions) Validate() error {
+	_, err := opts.Arguments()
+	return err
+}
+
+// SetUrl returns a copy of url whose DjVu CGI arguments are the options.
+// The other CGI arguments and the hash argument of url are kept.
+func (opts *ViewerOptions) SetUrl(url *Url) (*Url, error) {
+	args, err := opts.Arguments()
+	if err != nil {
+		return nil, err
+	}
+	retval := url.Copy()
+	retval.ClearDjvuCgiArguments()
+	for _, arg := range args {
+		retval.AddDjvuCgiArgument(arg.Name, arg.Value)
+	}
+	return retval, nil
+}
+
+// Arguments returns the options as DjVu CGI arguments.
+// An error is returned for options which are out of range.
+func (opts *ViewerOptions) Arguments() ([]CgiArgument, error) {
+	var args []CgiArgument
+	add := func(name, value string) { args = append(args, CgiArgument{Name: name, Value: value}) }
+	invalid := func(name string, value interface{}, msg string) error {
+		return &ViewerOptionError{Name: name, Value: fmt.Sprint(value), Err: errors.New(msg)}
+	}
+
+	switch {
+	case opts.Page < 0:
+		return nil, invalid("page", opts.Page, "pages are counted from 1")
+	case opts.Page > 0 && opts.PageID != "":
+		return nil, invalid("page", opts.PageID, "both a page number and a page id are given")
+	case opts.Page > 0:
+		add("page", strconv.Itoa(opts.Page))
+	case opts.PageID != "":
+		add("page", opts.PageID)
+	}
+
+	if opts.Zoom != ZOOM_DEFAULT {
+		if _, ok := zoomNames[opts.Zoom]; !ok && (opts.Zoom < MinZoom || opts.Zoom > MaxZoom) {
+			return nil, invalid("zoom", int(opts.Zoom), fmt.Sprintf("zoom is outside [%d, %d]", MinZoom, MaxZoom))
+		}
+		add("zoom", opts.Zoom.String())
+	}
+
+	enums := []struct {
+		name  string
+		value int
+		names []string
+	}{
+		{"mode", int(opts.Mode), displayModeNames},
+		{"rotate", int(opts.Rotate), rotationNames},
+	}
+	for _, e := range enums {
+		if e.value == 0 {
+			continue
+		}
+		if e.value >= len(e.names) {
+			return nil, invalid(e.name, e.value, "unknown value")
+		}
+		add(e.name, e.names[e.value])
+	}
+	if opts.HorAlign != ALIGN_DEFAULT {
+		if _, err := parseAlignment(opts.HorAlign.String(), horAlignments...); err != nil {
+			return nil, invalid("hor_align", opts.HorAlign, err.Error())
+		}
+		add("hor_align", opts.HorAlign.String())
+	}
+	if opts.VerAlign != ALIGN_DEFAULT {
+		if _, err := parseAlignment(opts.VerAlign.String(), verAlignments...); err != nil {
+			return nil, invalid("ver_align", opts.VerAlign, err.Error())
+		}
+		add("ver_align", opts.VerAlign.String())
+	}
+
+	for _, h := range opts.Highlight {
+		if h.W <= 0 || h.H <= 0 {
+			return nil, invalid("highlight", h, "highlight is empty")
+		}
+		value := fmt.Sprintf("%d,%d,%d,%d", h.X, h.Y, h.W, h.H)
+		if h.Color != nil {
+			value += fmt.Sprintf(",#%02x%02x%02x", h.Color.R, h.Color.G, h.Color.B)
+		}
+		add("highlight", value)
+	}
+
+	if pos := opts.ShowPosition; pos != nil {
+		if pos.X < 0 || pos.X > 1 || pos.Y < 0 || pos.Y > 1 {
+			return nil, invalid("showposition", *pos, "position is outside the page")
+		}
+		add("showposition", strconv.FormatFloat(pos.X, 'g', -1, 64)+","+strconv.FormatFloat(pos.Y, 'g', -1, 64))
+	}
+
+	if opts.Find != "" {
+		add("find", opts.Find)
+	}
+
+	if opts.Toolbar != TOOLBAR_DEFAULT {
+		if int(opts.Toolbar) >= len(toolbarNames) {
+			return nil, invalid("toolbar", int(opts.Toolbar), "unknown value")
+		}
+		add("toolbar", opts.Toolbar.String())
+	}
+
+	toggles := []struct {
+		name  string
+		value Toggle
+	}{
+		{"frame", opts.Frame},
+		{"menubar", opts.Menubar},
+		{"statusbar", opts.Statusbar},
+		{"scrollbars", opts.Scrollbars},
+		{"sidebar", opts.Sidebar},
+		{"continuous", opts.Continuous},
+		{"sidebyside", opts.SideBySide},
+		{"keyboard", opts.Keyboard},
+		{"links", opts.Links},
+		{"print", opts.Print},
+	}
+	for _, t := range toggles {
+		if t.value == TOGGLE_DEFAULT {
+			continue
+		}
+		if int(t.value) >= len(toggleNames) {
+			return nil, invalid(t.name, int(t.value), "unknown value")
+		}
+		add(t.name, t.value.String())
+	}
+
+	for _, arg := range opts.Other {
+		if strings.ToUpper(arg.Name) == djvuopts || arg.Name == "" {
+			return nil, invalid(arg.Name, arg.Value, "not an option name")
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// Returns names[ii], or the number itself if it has no name
+func enumName(names []string, ii int) string {
+	if ii >= 0 && ii < len(names) {
+		return names[ii]
+	}
+	return strconv.Itoa(ii)
+}
+
+// Returns the index of value in names, or -1 if it is not there
+func enumIndex(names []string, value string) int {
+	for ii, name := range names {
+		if name == value {
+			return ii
+		}
+	}
+	return -1
+}
+
+// Returns the index of value in names, skipping the unnamed default
+func parseEnum(names []string, value string) (int, error) {
+	if ii := enumIndex(names[1:], value); ii >= 0 {
+		return ii + 1, nil
+	}
+	return 0, errors.Errorf("expected one of %s", strings.Join(names[1:], ", "))
+}
+
+func parseZoom(value string) (Zoom, error) {
+	for zoom, name := range zoomNames {
+		if name == value {
+			return zoom, nil
+		}
+	}
+	percent, err := strconv.Atoi(value)
+	if err != nil {
+		return ZOOM_DEFAULT, errors.New("expected one2one, width, page, stretch or a percentage")
+	}
+	if percent < MinZoom || percent > MaxZoom {
+		return ZOOM_DEFAULT, errors.Errorf("zoom is outside [%d, %d]", MinZoom, MaxZoom)
+	}
+	return Zoom(percent), nil
+}
+
+func parseDisplayMode(value string) (DisplayMode, error) {
+	switch value {
+	case "black":
+		return DISPLAY_BW, nil
+	case "foreground", "fg":
+		return DISPLAY_FORE, nil
+	case "background", "bg":
+		return DISPLAY_BACK, nil
+	}
+	mode, err := parseEnum(displayModeNames, value)
+	return DisplayMode(mode), err
+}
+
+func parseAlignment(value string, allowed ...Alignment) (Alignment, error) {
+	names := make([]string, len(allowed))
+	for ii, a := range allowed {
+		if a.String() == value {
+			return a, nil
+		}
+		names[ii] = a.String()
+	}
+	return ALIGN_DEFAULT, errors.Errorf("expected one of %s", strings.Join(names, ", "))
+}
+
+// Maps the usual spellings of booleans to `yes` and `no`
+func parseBoolAlias(value string) string {
+	switch value {
+	case "yes", "true", "on", "1":
+		return "yes"
+	case "no", "false", "off", "0":
+		return "no"
+	}
+	return value
+}
+
+func parseToggle(value string) (Toggle, error) {
+	toggle, err := parseEnum(toggleNames, parseBoolAlias(value))
+	return Toggle(toggle), err
+}
+
+// Parses `x,y,w,h` with an optional `,#rrggbb` color
+func parseHighlight(value string) (Highlight, error) {
+	fields := strings.Split(value, ",")
+	if len(fields) != 4 && len(fields) != 5 {
+		return Highlight{}, errors.New("expected x,y,w,h[,#rrggbb]")
+	}
+	var nums [4]int
+	for ii := range nums {
+		num, err := strconv.Atoi(strings.TrimSpace(fields[ii]))
+		if err != nil {
+			return Highlight{}, errors.New("expected x,y,w,h[,#rrggbb]")
+		}
+		nums[ii] = num
+	}
+	h := Highlight{X: nums[0], Y: nums[1], W: nums[2], H: nums[3]}
+	if h.W <= 0 || h.H <= 0 {
+		return Highlight{}, errors.New("highlight is empty")
+	}
+	if len(fields) == 5 {
+		c, err := parseColor(strings.TrimSpace(fields[4]))
+		if err != nil {
+			return Highlight{}, err
+		}
+		h.Color = c
+	}
+	return h, nil
+}
+
+// Parses `x,y` fractions of the page
+func parsePosition(value string) (Position, error) {
+	fields := strings.Split(value, ",")
+	if len(fields) != 2 {
+		return Position{}, errors.New("expected x,y")
+	}
+	x, errx := strconv.ParseFloat(strings.TrimSpace(fields[0]), 64)
+	y, erry := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+	if errx != nil || erry != nil {
+		return Position{}, errors.New("expected x,y")
+	}
+	if x < 0 || x > 1 || y < 0 || y > 1 {
+		return Position{}, errors.New("position is outside the page")
+	}
+	return Position{X: x, Y: y}, nil
+}
@@ -0,0 +1,137 @@
+package djvu
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ViewerOptionsTestSuite struct {
+	suite.Suite
+}
+
+func TestViewerOptionsSuite(t *testing.T) {
+	suite.Run(t, new(ViewerOptionsTestSuite))
+}
+
+func (s *ViewerOptionsTestSuite) parse(raw string) (*ViewerOptions, error) {
+	url, err := NewUrl(raw)
+	s.Require().NoError(err)
+	return ParseViewerOptions(url)
+}
+
+func (s *ViewerOptionsTestSuite) TestParse() {
+	opts, err := s.parse(`http://host/book.djvu?id=7&DJVUOPTS&page=12&ZOOM=Width&mode=black` +
+		`&rotate=90&hor_align=left&valign=bottom&highlight=10,20,30,40,%23FF8000&highlight=1,2,3,4` +
+		`&showposition=0.5,0.25&find=a%26b&toolbar=off&frame=yes&sidebar=0&print=false&logo=no`)
+	s.Require().NoError(err)
+	s.Equal(&ViewerOptions{
+		Page:     12,
+		Zoom:     ZOOM_FIT_WIDTH,
+		Mode:     DISPLAY_BW,
+		Rotate:   ROTATE_90,
+		HorAlign: ALIGN_LEFT,
+		VerAlign: ALIGN_BOTTOM,
+		Highlight: []Highlight{
+			{X: 10, Y: 20, W: 30, H: 40, Color: &color.RGBA{R: 0xff, G: 0x80, A: 0xff}},
+			{X: 1, Y: 2, W: 3, H: 4},
+		},
+		ShowPosition: &Position{X: 0.5, Y: 0.25},
+		Find:         "a&b",
+		Toolbar:      TOOLBAR_NO,
+		Frame:        TOGGLE_YES,
+		Sidebar:      TOGGLE_NO,
+		Print:        TOGGLE_NO,
+		Other:        []CgiArgument{{Name: "logo", Value: "no"}},
+	}, opts)
+
+	opts, err = s.parse(`memory:book.djvu?page=3`)
+	s.NoError(err)
+	s.Equal(&ViewerOptions{}, opts)
+
+	opts, err = s.parse(`memory:book.djvu?DJVUOPTS&page=chapter%201.djvu&zoom=150`)
+	s.NoError(err)
+	s.Equal(0, opts.Page)
+	s.Equal("chapter 1.djvu", opts.PageID)
+	s.Equal(Zoom(150), opts.Zoom)
+}
+
+func (s *ViewerOptionsTestSuite) TestParseErrors() {
+	for _, args := range []string{
+		`page=0`,
+		`zoom=1000`,
+		`zoom=huge`,
+		`mode=sepia`,
+		`rotate=45`,
+		`hor_align=top`,
+		`ver_align=left`,
+		`highlight=1,2,3`,
+		`highlight=1,2,0,4`,
+		`highlight=1,2,3,4,red`,
+		`showposition=2,0`,
+		`toolbar=sideways`,
+		`frame=maybe`,
+	} {
+		opts, err := s.parse(`memory:book.djvu?DJVUOPTS&mode=bw&` + args)
+		var optErr *ViewerOptionError
+		if s.ErrorAs(err, &optErr, args) {
+			s.Contains(args, optErr.Name+"=")
+		}
+		// Valid arguments are kept anyway
+		s.Equal(DISPLAY_BW, opts.Mode, args)
+	}
+}
+
+func (s *ViewerOptionsTestSuite) TestSetUrl() {
+	url, err := NewUrl(`http://host/book.djvu#p2?id=7&DJVUOPTS&zoom=100`)
+	s.Require().NoError(err)
+
+	opts := &ViewerOptions{
+		PageID:       "p 3",
+		Zoom:         ZOOM_ONE2ONE,
+		Rotate:       ROTATE_0,
+		Highlight:    []Highlight{{X: 1, Y: 2, W: 3, H: 4, Color: &color.RGBA{R: 0x12, G: 0x34, B: 0x56}}},
+		ShowPosition: &Position{X: 1, Y: 0.5},
+		Find:         "x=y&z",
+		Toolbar:      TOOLBAR_TOP,
+		Continuous:   TOGGLE_YES,
+		Other:        []CgiArgument{{Name: "logo", Value: "no"}},
+	}
+	linked, err := opts.SetUrl(url)
+	s.Require().NoError(err)
+	s.Equal(`http://host/book.djvu#p2?id=7&DJVUOPTS&page=p%203&zoom=one2one&rotate=0`+
+		`&highlight=1,2,3,4,%23123456&showposition=1,0.5&find=x%3Dy%26z&toolbar=top&continuous=yes&logo=no`,
+		linked.Raw())
+	s.Equal(`http://host/book.djvu#p2?id=7&DJVUOPTS&zoom=100`, url.Raw())
+
+	parsed, err := ParseViewerOptions(linked)
+	s.NoError(err)
+	opts.Highlight[0].Color.A = 0xff
+	s.Equal(opts, parsed)
+
+	cleared, err := (&ViewerOptions{}).SetUrl(url)
+	s.NoError(err)
+	s.Equal(`http://host/book.djvu#p2?id=7`, cleared.Raw())
+}
+
+func (s *ViewerOptionsTestSuite) TestValidate() {
+	s.NoError((&ViewerOptions{}).Validate())
+	for _, opts := range []*ViewerOptions{
+		{Page: -1},
+		{Page: 1, PageID: "p1"},
+		{Zoom: 4},
+		{Zoom: -5},
+		{Mode: DISPLAY_BACK + 1},
+		{HorAlign: ALIGN_TOP},
+		{VerAlign: ALIGN_RIGHT},
+		{Highlight: []Highlight{{W: 0, H: 1}}},
+		{ShowPosition: &Position{X: -0.1}},
+		{Toolbar: TOOLBAR_BOTTOM + 1},
+		{Print: TOGGLE_NO + 1},
+		{Other: []CgiArgument{{Name: "djvuopts"}}},
+	} {
+		var optErr *ViewerOptionError
+		s.ErrorAs(opts.Validate(), &optErr, "%+v", opts)
+	}
+}
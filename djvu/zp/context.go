@@ -0,0 +1,23 @@
+package zp
+
+import (
+	"io"
+	"math/bits"
+)
+
+// Context is an adaptive bit context.
+// Its zero value is the initial state for any bit.
+// Each Context must be used by one Encoder or Decoder at a time.
+type Context uint8
+
+// ErrEndOfData is returned when the decoder read too far past the end of its input.
+var ErrEndOfData = io.ErrUnexpectedEOF
+
+// Number of bytes past the end of input the decoder tolerates.
+// The encoder flushes enough bits for the decoder to never need more.
+const maxDelay = 25
+
+// Returns the number of leading 1s of the 16-bit number x.
+func ffz(x uint32) uint {
+	return uint(bits.LeadingZeros16(^uint16(x)))
+}
@@ -0,0 +1,156 @@
+package zp
+
+import (
+	"bufio"
+	"io"
+)
+
+// Decoder decodes bits coded by an Encoder.
+//
+// Errors are sticky: once the input fails,
+// decoding goes on with meaningless bits and Err returns the failure.
+// Callers check Err once a meaningful unit (such as a block) is decoded.
+type Decoder struct {
+	r   io.ByteReader
+	err error
+
+	a      uint32
+	code   uint32
+	fence  uint32
+	buffer uint32
+	scount int
+	delay  int
+
+	table [256]state
+}
+
+// NewDecoder creates a Decoder reading the coded bits from r.
+func NewDecoder(r io.Reader) *Decoder {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+
+	d := &Decoder{r: br, delay: maxDelay, table: defaultTable}
+	d.code = uint32(d.readByte()) << 8
+	d.code |= uint32(d.readByte())
+	d.preload()
+	d.setFence()
+	return d
+}
+
+// Err returns the first error encountered while reading the input.
+func (d *Decoder) Err() error {
+	return d.err
+}
+
+// Decode decodes a bit using the adaptive context ctx, and updates ctx.
+func (d *Decoder) Decode(ctx *Context) int {
+	z := d.a + uint32(d.table[*ctx].p)
+	if z <= d.fence {
+		d.a = z
+		return int(*ctx & 1)
+	}
+	return d.decodeSub(ctx, z)
+}
+
+// DecodePassthrough decodes a bit coded without context,
+// with a probability of 1/2.
+func (d *Decoder) DecodePassthrough() int {
+	return d.decodeSubSimple(0, 0x8000+(d.a>>1))
+}
+
+// DecodeIW decodes a bit coded without context
+// with the slightly different probability used by the IW44 codec.
+func (d *Decoder) DecodeIW() int {
+	return d.decodeSubSimple(0, 0x8000+((d.a+d.a+d.a)>>3))
+}
+
+func (d *Decoder) readByte() byte {
+	if d.err == nil {
+		b, err := d.r.ReadByte()
+		if err == nil {
+			return b
+		}
+		if err != io.EOF {
+			d.err = err
+		}
+	}
+
+	// Past the end of the input, the coder reads 1s
+	d.delay--
+	if d.delay < 1 && d.err == nil {
+		d.err = ErrEndOfData
+	}
+	return 0xff
+}
+
+func (d *Decoder) preload() {
+	for d.scount <= 24 {
+		d.buffer = (d.buffer << 8) | uint32(d.readByte())
+		d.scount += 8
+	}
+}
+
+func (d *Decoder) setFence() {
+	d.fence = d.code
+	if d.code >= 0x8000 {
+		d.fence = 0x7fff
+	}
+}
+
+func (d *Decoder) decodeSub(ctx *Context, z uint32) int {
+	bit := int(*ctx & 1)
+
+	// Avoid interval reversion
+	if dd := 0x6000 + ((z + d.a) >> 2); z > dd {
+		z = dd
+	}
+
+	if z > d.code {
+		// LPS branch
+		*ctx = Context(d.table[*ctx].dn)
+		d.lps(z)
+		return bit ^ 1
+	}
+
+	// MPS branch
+	if d.a >= uint32(d.table[*ctx].m) {
+		*ctx = Context(d.table[*ctx].up)
+	}
+	d.mps(z)
+	return bit
+}
+
+func (d *Decoder) decodeSubSimple(mps int, z uint32) int {
+	if z > d.code {
+		d.lps(z)
+		return mps ^ 1
+	}
+	d.mps(z)
+	return mps
+}
+
+func (d *Decoder) lps(z uint32) {
+	z = 0x10000 - z
+	d.a += z
+	d.code += z
+	shift := ffz(d.a)
+	d.scount -= int(shift)
+	d.a = (d.a << shift) & 0xffff
+	d.code = ((d.code << shift) & 0xffff) | ((d.buffer >> uint(d.scount)) & ((1 << shift) - 1))
+	if d.scount < 16 {
+		d.preload()
+	}
+	d.setFence()
+}
+
+func (d *Decoder) mps(z uint32) {
+	d.scount--
+	d.a = (z << 1) & 0xffff
+	d.code = ((d.code << 1) & 0xffff) | ((d.buffer >> uint(d.scount)) & 1)
+	if d.scount < 16 {
+		d.preload()
+	}
+	d.setFence()
+}
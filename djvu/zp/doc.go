@@ -0,0 +1,14 @@
+// Package zp implements the ZP-Coder,
+// the adaptive binary arithmetic coder used by most DjVu codecs
+// (BZZ, JB2 and IW44).
+//
+// The coder encodes a sequence of bits.
+// Each bit is coded using an adaptive Context
+// which keeps an estimate of the probability of the bit being 1.
+// The estimate is updated after each bit,
+// so a well chosen set of contexts yields a good compression ratio.
+// Bits can also be coded without a context
+// using the passthrough methods.
+//
+// Only the DjVu compatible variant of the coder is implemented.
+package zp
@@ -0,0 +1,184 @@
+package zp
+
+import (
+	"bufio"
+	"io"
+)
+
+// Encoder codes bits into a stream decodable by a Decoder.
+// Close must be called to flush the last bits.
+//
+// Errors are sticky: once writing fails,
+// encoding goes on without output and Close returns the failure.
+type Encoder struct {
+	w   *bufio.Writer
+	err error
+
+	a      uint32
+	subend uint32
+	buffer uint32
+	nrun   int
+	delay  int
+	b      byte // Byte being assembled
+	scount int  // Number of bits in b
+	nbytes int  // Number of bytes coded
+
+	table [256]state
+}
+
+// NewEncoder creates an Encoder writing the coded bits to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{
+		w:      bufio.NewWriter(w),
+		buffer: 0xffffff,
+		delay:  maxDelay,
+		table:  defaultTable,
+	}
+}
+
+// Encode codes bit (0 or 1) using the adaptive context ctx, and updates ctx.
+func (e *Encoder) Encode(bit int, ctx *Context) {
+	z := e.a + uint32(e.table[*ctx].p)
+	if bit != int(*ctx&1) {
+		e.encodeLPS(ctx, z)
+	} else if z >= 0x8000 {
+		e.encodeMPS(ctx, z)
+	} else {
+		e.a = z
+	}
+}
+
+// EncodePassthrough codes bit without context,
+// with a probability of 1/2.
+func (e *Encoder) EncodePassthrough(bit int) {
+	e.encodeSimple(bit, 0x8000+(e.a>>1))
+}
+
+// EncodeIW codes bit without context
+// with the slightly different probability used by the IW44 codec.
+func (e *Encoder) EncodeIW(bit int) {
+	e.encodeSimple(bit, 0x8000+((e.a+e.a+e.a)>>3))
+}
+
+// Len returns the number of bytes coded so far,
+// some of which may not be written yet.
+func (e *Encoder) Len() int {
+	return e.nbytes
+}
+
+// Close flushes the coded bits.
+// The Encoder must not be used afterwards.
+// The underlying writer is not closed.
+func (e *Encoder) Close() error {
+	if e.subend > 0x8000 {
+		e.subend = 0x10000
+	} else if e.subend > 0 {
+		e.subend = 0x8000
+	}
+	for e.buffer != 0xffffff || e.subend != 0 {
+		e.emit(1 - int(e.subend>>15))
+		e.subend = (e.subend << 1) & 0xffff
+	}
+	e.outbit(1)
+	for ; e.nrun > 0; e.nrun-- {
+		e.outbit(0)
+	}
+	for e.scount > 0 {
+		e.outbit(1)
+	}
+	e.delay = 0xff
+
+	if err := e.w.Flush(); err != nil && e.err == nil {
+		e.err = err
+	}
+	return e.err
+}
+
+func (e *Encoder) encodeMPS(ctx *Context, z uint32) {
+	// Avoid interval reversion
+	if d := 0x6000 + ((z + e.a) >> 2); z > d {
+		z = d
+	}
+	if e.a >= uint32(e.table[*ctx].m) {
+		*ctx = Context(e.table[*ctx].up)
+	}
+	e.a = z
+	e.shiftMPS()
+}
+
+func (e *Encoder) encodeLPS(ctx *Context, z uint32) {
+	// Avoid interval reversion
+	if d := 0x6000 + ((z + e.a) >> 2); z > d {
+		z = d
+	}
+	*ctx = Context(e.table[*ctx].dn)
+	e.shiftLPS(z)
+}
+
+func (e *Encoder) encodeSimple(bit int, z uint32) {
+	if bit != 0 {
+		e.shiftLPS(z)
+	} else {
+		e.a = z
+		e.shiftMPS()
+	}
+}
+
+func (e *Encoder) shiftMPS() {
+	if e.a >= 0x8000 {
+		e.emit(1 - int(e.subend>>15))
+		e.subend = (e.subend << 1) & 0xffff
+		e.a = (e.a << 1) & 0xffff
+	}
+}
+
+func (e *Encoder) shiftLPS(z uint32) {
+	z = 0x10000 - z
+	e.subend += z
+	e.a += z
+	for e.a >= 0x8000 {
+		e.emit(1 - int(e.subend>>15))
+		e.subend = (e.subend << 1) & 0xffff
+		e.a = (e.a << 1) & 0xffff
+	}
+}
+
+func (e *Encoder) emit(b int) {
+	e.buffer = (e.buffer << 1) + uint32(b)
+	top := e.buffer >> 24
+	e.buffer &= 0xffffff
+	switch top {
+	case 1:
+		e.outbit(1)
+		for ; e.nrun > 0; e.nrun-- {
+			e.outbit(0)
+		}
+	case 0xff:
+		e.outbit(0)
+		for ; e.nrun > 0; e.nrun-- {
+			e.outbit(1)
+		}
+	case 0:
+		e.nrun++
+	}
+}
+
+func (e *Encoder) outbit(bit int) {
+	if e.delay > 0 {
+		if e.delay < 0xff { // Delay the first bits
+			e.delay--
+		}
+		return
+	}
+
+	e.b = (e.b << 1) | byte(bit)
+	e.scount++
+	if e.scount == 8 {
+		if e.err == nil {
+			e.err = e.w.WriteByte(e.b)
+		}
+		e.nbytes++
+		e.scount = 0
+		e.b = 0
+	}
+}
@@ -0,0 +1,269 @@
+package zp
+
+// state is an entry of the adaptation table.
+type state struct {
+	p  uint16 // Probability of the LPS
+	m  uint16 // Threshold for the MPS adaptation
+	up uint8  // Next state after a MPS
+	dn uint8  // Next state after a LPS
+}
+
+// defaultTable is the adaptation table of the DjVu compatible ZP-Coder.
+//
+// States come in pairs: the low bit of a state is its MPS.
+// The probability estimated by states 83 and beyond
+// is given in the comments as (count of 0s, count of 1s).
+var defaultTable = [256]state{
+	{0x8000, 0x0000, 84, 145},  // 000: p=0.500000 (    0,    0)
+	{0x8000, 0x0000, 3, 4},     // 001: p=0.500000 (    0,    0)
+	{0x8000, 0x0000, 4, 3},     // 002: p=0.500000 (    0,    0)
+	{0x6bbd, 0x10a5, 5, 1},     // 003: p=0.465226 (    0,    0)
+	{0x6bbd, 0x10a5, 6, 2},     // 004: p=0.465226 (    0,    0)
+	{0x5d45, 0x1f28, 7, 3},     // 005: p=0.430708 (    0,    0)
+	{0x5d45, 0x1f28, 8, 4},     // 006: p=0.430708 (    0,    0)
+	{0x51b9, 0x2bd3, 9, 5},     // 007: p=0.396718 (    0,    0)
+	{0x51b9, 0x2bd3, 10, 6},    // 008: p=0.396718 (    0,    0)
+	{0x4813, 0x36e3, 11, 7},    // 009: p=0.363535 (    0,    0)
+	{0x4813, 0x36e3, 12, 8},    // 010: p=0.363535 (    0,    0)
+	{0x3fd5, 0x408c, 13, 9},    // 011: p=0.331418 (    0,    0)
+	{0x3fd5, 0x408c, 14, 10},   // 012: p=0.331418 (    0,    0)
+	{0x38b1, 0x48fd, 15, 11},   // 013: p=0.300562 (    0,    0)
+	{0x38b1, 0x48fd, 16, 12},   // 014: p=0.300562 (    0,    0)
+	{0x3275, 0x505d, 17, 13},   // 015: p=0.271166 (    0,    0)
+	{0x3275, 0x505d, 18, 14},   // 016: p=0.271166 (    0,    0)
+	{0x2cfd, 0x56d0, 19, 15},   // 017: p=0.243389 (    0,    0)
+	{0x2cfd, 0x56d0, 20, 16},   // 018: p=0.243389 (    0,    0)
+	{0x2825, 0x5c71, 21, 17},   // 019: p=0.217351 (    0,    0)
+	{0x2825, 0x5c71, 22, 18},   // 020: p=0.217351 (    0,    0)
+	{0x23ab, 0x615b, 23, 19},   // 021: p=0.193091 (    0,    0)
+	{0x23ab, 0x615b, 24, 20},   // 022: p=0.193091 (    0,    0)
+	{0x1f87, 0x65a5, 25, 21},   // 023: p=0.170683 (    0,    0)
+	{0x1f87, 0x65a5, 26, 22},   // 024: p=0.170683 (    0,    0)
+	{0x1bbb, 0x6962, 27, 23},   // 025: p=0.150134 (    0,    0)
+	{0x1bbb, 0x6962, 28, 24},   // 026: p=0.150134 (    0,    0)
+	{0x1845, 0x6ca2, 29, 25},   // 027: p=0.131397 (    0,    0)
+	{0x1845, 0x6ca2, 30, 26},   // 028: p=0.131397 (    0,    0)
+	{0x1523, 0x6f74, 31, 27},   // 029: p=0.114384 (    0,    0)
+	{0x1523, 0x6f74, 32, 28},   // 030: p=0.114384 (    0,    0)
+	{0x1253, 0x71e6, 33, 29},   // 031: p=0.099017 (    0,    0)
+	{0x1253, 0x71e6, 34, 30},   // 032: p=0.099017 (    0,    0)
+	{0x0fcf, 0x7404, 35, 31},   // 033: p=0.085222 (    0,    0)
+	{0x0fcf, 0x7404, 36, 32},   // 034: p=0.085222 (    0,    0)
+	{0x0d95, 0x75d6, 37, 33},   // 035: p=0.072898 (    0,    0)
+	{0x0d95, 0x75d6, 38, 34},   // 036: p=0.072898 (    0,    0)
+	{0x0b9d, 0x7768, 39, 35},   // 037: p=0.061981 (    0,    0)
+	{0x0b9d, 0x7768, 40, 36},   // 038: p=0.061981 (    0,    0)
+	{0x09e3, 0x78c2, 41, 37},   // 039: p=0.052360 (    0,    0)
+	{0x09e3, 0x78c2, 42, 38},   // 040: p=0.052360 (    0,    0)
+	{0x0861, 0x79ea, 43, 39},   // 041: p=0.043928 (    0,    0)
+	{0x0861, 0x79ea, 44, 40},   // 042: p=0.043928 (    0,    0)
+	{0x0711, 0x7ae7, 45, 41},   // 043: p=0.036572 (    0,    0)
+	{0x0711, 0x7ae7, 46, 42},   // 044: p=0.036572 (    0,    0)
+	{0x05f1, 0x7bbe, 47, 43},   // 045: p=0.030192 (    0,    0)
+	{0x05f1, 0x7bbe, 48, 44},   // 046: p=0.030192 (    0,    0)
+	{0x04f9, 0x7c75, 49, 45},   // 047: p=0.024684 (    0,    0)
+	{0x04f9, 0x7c75, 50, 46},   // 048: p=0.024684 (    0,    0)
+	{0x0425, 0x7d0f, 51, 47},   // 049: p=0.019997 (    0,    0)
+	{0x0425, 0x7d0f, 52, 48},   // 050: p=0.019997 (    0,    0)
+	{0x0371, 0x7d91, 53, 49},   // 051: p=0.016038 (    0,    0)
+	{0x0371, 0x7d91, 54, 50},   // 052: p=0.016038 (    0,    0)
+	{0x02d9, 0x7dfe, 55, 51},   // 053: p=0.012724 (    0,    0)
+	{0x02d9, 0x7dfe, 56, 52},   // 054: p=0.012724 (    0,    0)
+	{0x0259, 0x7e5a, 57, 53},   // 055: p=0.009982 (    0,    0)
+	{0x0259, 0x7e5a, 58, 54},   // 056: p=0.009982 (    0,    0)
+	{0x01ed, 0x7ea6, 59, 55},   // 057: p=0.007743 (    0,    0)
+	{0x01ed, 0x7ea6, 60, 56},   // 058: p=0.007743 (    0,    0)
+	{0x0193, 0x7ee6, 61, 57},   // 059: p=0.005929 (    0,    0)
+	{0x0193, 0x7ee6, 62, 58},   // 060: p=0.005929 (    0,    0)
+	{0x0149, 0x7f1a, 63, 59},   // 061: p=0.004483 (    0,    0)
+	{0x0149, 0x7f1a, 64, 60},   // 062: p=0.004483 (    0,    0)
+	{0x010b, 0x7f45, 65, 61},   // 063: p=0.003341 (    0,    0)
+	{0x010b, 0x7f45, 66, 62},   // 064: p=0.003341 (    0,    0)
+	{0x00d5, 0x7f6b, 67, 63},   // 065: p=0.002452 (    0,    0)
+	{0x00d5, 0x7f6b, 68, 64},   // 066: p=0.002452 (    0,    0)
+	{0x00a5, 0x7f8d, 69, 65},   // 067: p=0.001757 (    0,    0)
+	{0x00a5, 0x7f8d, 70, 66},   // 068: p=0.001757 (    0,    0)
+	{0x007b, 0x7faa, 71, 67},   // 069: p=0.001223 (    0,    0)
+	{0x007b, 0x7faa, 72, 68},   // 070: p=0.001223 (    0,    0)
+	{0x0057, 0x7fc3, 73, 69},   // 071: p=0.000817 (    0,    0)
+	{0x0057, 0x7fc3, 74, 70},   // 072: p=0.000817 (    0,    0)
+	{0x003b, 0x7fd7, 75, 71},   // 073: p=0.000528 (    0,    0)
+	{0x003b, 0x7fd7, 76, 72},   // 074: p=0.000528 (    0,    0)
+	{0x0023, 0x7fe7, 77, 73},   // 075: p=0.000308 (    0,    0)
+	{0x0023, 0x7fe7, 78, 74},   // 076: p=0.000308 (    0,    0)
+	{0x0013, 0x7ff2, 79, 75},   // 077: p=0.000166 (    0,    0)
+	{0x0013, 0x7ff2, 80, 76},   // 078: p=0.000166 (    0,    0)
+	{0x0007, 0x7ffa, 81, 77},   // 079: p=0.000062 (    0,    0)
+	{0x0007, 0x7ffa, 82, 78},   // 080: p=0.000062 (    0,    0)
+	{0x0001, 0x7fff, 81, 79},   // 081: p=0.000009 (    0,    0)
+	{0x0001, 0x7fff, 82, 80},   // 082: p=0.000009 (    0,    0)
+	{0x5695, 0x0000, 9, 85},    // 083: p=0.411764 (    2,    3)
+	{0x24ee, 0x0000, 86, 226},  // 084: p=0.199988 (    1,    0)
+	{0x8000, 0x0000, 5, 6},     // 085: p=0.500000 (    3,    3)
+	{0x0d30, 0x0000, 88, 176},  // 086: p=0.071422 (    4,    0)
+	{0x481a, 0x0000, 89, 143},  // 087: p=0.363634 (    1,    2)
+	{0x0481, 0x0000, 90, 138},  // 088: p=0.024690 (   13,    0)
+	{0x3579, 0x0000, 91, 141},  // 089: p=0.285711 (    1,    3)
+	{0x017a, 0x0000, 92, 112},  // 090: p=0.007853 (   41,    0)
+	{0x24ef, 0x0000, 93, 135},  // 091: p=0.200003 (    1,    5)
+	{0x007b, 0x0000, 94, 104},  // 092: p=0.002586 (  127,    0)
+	{0x1978, 0x0000, 95, 133},  // 093: p=0.137933 (    1,    8)
+	{0x0028, 0x0000, 96, 100},  // 094: p=0.000845 (  392,    0)
+	{0x10ca, 0x0000, 97, 129},  // 095: p=0.090897 (    1,   13)
+	{0x000d, 0x0000, 82, 98},   // 096: p=0.000276 ( 1208,    0)
+	{0x0b5d, 0x0000, 99, 127},  // 097: p=0.061987 (    1,   20)
+	{0x0034, 0x0000, 76, 72},   // 098: p=0.001085 (  293,    1)
+	{0x078a, 0x0000, 101, 125}, // 099: p=0.040320 (    1,   31)
+	{0x00a0, 0x0000, 70, 102},  // 100: p=0.003360 (   94,    1)
+	{0x050f, 0x0000, 103, 123}, // 101: p=0.027331 (    1,   47)
+	{0x0117, 0x0000, 66, 60},   // 102: p=0.005837 (   54,    1)
+	{0x0358, 0x0000, 105, 121}, // 103: p=0.018057 (    1,   72)
+	{0x01ea, 0x0000, 106, 110}, // 104: p=0.010298 (   30,    1)
+	{0x0234, 0x0000, 107, 119}, // 105: p=0.011916 (    1,  110)
+	{0x0144, 0x0000, 66, 108},  // 106: p=0.006749 (   46,    1)
+	{0x0173, 0x0000, 109, 117}, // 107: p=0.007852 (    1,  168)
+	{0x0234, 0x0000, 60, 54},   // 108: p=0.011916 (   26,    1)
+	{0x00f5, 0x0000, 111, 115}, // 109: p=0.005166 (    1,  256)
+	{0x0353, 0x0000, 56, 48},   // 110: p=0.017893 (   17,    1)
+	{0x00a1, 0x0000, 69, 113},  // 111: p=0.003392 (    1,  389)
+	{0x05c5, 0x0000, 114, 134}, // 112: p=0.031123 (   12,    1)
+	{0x011a, 0x0000, 65, 59},   // 113: p=0.005893 (    2,  389)
+	{0x03cf, 0x0000, 116, 132}, // 114: p=0.020524 (   18,    2)
+	{0x01aa, 0x0000, 61, 55},   // 115: p=0.008950 (    2,  256)
+	{0x0285, 0x0000, 118, 130}, // 116: p=0.013590 (   27,    2)
+	{0x0286, 0x0000, 57, 51},   // 117: p=0.013626 (    2,  168)
+	{0x01ab, 0x0000, 120, 128}, // 118: p=0.008980 (   41,    2)
+	{0x03d3, 0x0000, 53, 47},   // 119: p=0.020684 (    2,  110)
+	{0x011a, 0x0000, 122, 126}, // 120: p=0.005893 (   62,    2)
+	{0x05c5, 0x0000, 49, 41},   // 121: p=0.031123 (    2,   72)
+	{0x00ba, 0x0000, 124, 62},  // 122: p=0.003923 (   94,    2)
+	{0x08ad, 0x0000, 43, 37},   // 123: p=0.046906 (    2,   47)
+	{0x007a, 0x0000, 72, 66},   // 124: p=0.002575 (  143,    2)
+	{0x0ccc, 0x0000, 39, 31},   // 125: p=0.069215 (    2,   31)
+	{0x01eb, 0x0000, 60, 54},   // 126: p=0.010337 (   62,    3)
+	{0x1302, 0x0000, 33, 25},   // 127: p=0.102679 (    2,   20)
+	{0x02e6, 0x0000, 56, 50},   // 128: p=0.015641 (   41,    3)
+	{0x1b81, 0x0000, 29, 131},  // 129: p=0.148656 (    2,   13)
+	{0x045e, 0x0000, 52, 46},   // 130: p=0.023590 (   27,    3)
+	{0x24ef, 0x0000, 23, 17},   // 131: p=0.200003 (    3,   13)
+	{0x0690, 0x0000, 48, 40},   // 132: p=0.035469 (   18,    3)
+	{0x2865, 0x0000, 23, 15},   // 133: p=0.218728 (    2,    8)
+	{0x09de, 0x0000, 42, 136},  // 134: p=0.053457 (   12,    3)
+	{0x3987, 0x0000, 137, 7},   // 135: p=0.304341 (    2,    5)
+	{0x0dc8, 0x0000, 38, 32},   // 136: p=0.074529 (   12,    4)
+	{0x2c99, 0x0000, 21, 139},  // 137: p=0.241021 (    3,    5)
+	{0x10ca, 0x0000, 140, 172}, // 138: p=0.090897 (   13,    1)
+	{0x3b5f, 0x0000, 15, 9},    // 139: p=0.312499 (    4,    5)
+	{0x0b5d, 0x0000, 142, 170}, // 140: p=0.061987 (   20,    1)
+	{0x5695, 0x0000, 9, 85},    // 141: p=0.411764 (    2,    3)
+	{0x078a, 0x0000, 144, 168}, // 142: p=0.040320 (   31,    1)
+	{0x8000, 0x0000, 141, 248}, // 143: p=0.500000 (    2,    2)
+	{0x050f, 0x0000, 146, 166}, // 144: p=0.027331 (   47,    1)
+	{0x24ee, 0x0000, 147, 247}, // 145: p=0.199988 (    0,    1)
+	{0x0358, 0x0000, 148, 164}, // 146: p=0.018057 (   72,    1)
+	{0x0d30, 0x0000, 149, 197}, // 147: p=0.071422 (    0,    4)
+	{0x0234, 0x0000, 150, 162}, // 148: p=0.011916 (  110,    1)
+	{0x0481, 0x0000, 151, 95},  // 149: p=0.024690 (    0,   13)
+	{0x0173, 0x0000, 152, 160}, // 150: p=0.007852 (  168,    1)
+	{0x017a, 0x0000, 153, 173}, // 151: p=0.007853 (    0,   41)
+	{0x00f5, 0x0000, 154, 158}, // 152: p=0.005166 (  256,    1)
+	{0x007b, 0x0000, 155, 165}, // 153: p=0.002586 (    0,  127)
+	{0x00a1, 0x0000, 70, 156},  // 154: p=0.003392 (  389,    1)
+	{0x0028, 0x0000, 157, 161}, // 155: p=0.000845 (    0,  392)
+	{0x011a, 0x0000, 66, 60},   // 156: p=0.005893 (  389,    2)
+	{0x000d, 0x0000, 81, 159},  // 157: p=0.000276 (    0, 1208)
+	{0x01aa, 0x0000, 62, 56},   // 158: p=0.008950 (  256,    2)
+	{0x0034, 0x0000, 75, 71},   // 159: p=0.001085 (    1,  293)
+	{0x0286, 0x0000, 58, 52},   // 160: p=0.013626 (  168,    2)
+	{0x00a0, 0x0000, 69, 163},  // 161: p=0.003360 (    1,   94)
+	{0x03d3, 0x0000, 54, 48},   // 162: p=0.020684 (  110,    2)
+	{0x0117, 0x0000, 65, 59},   // 163: p=0.005837 (    1,   54)
+	{0x05c5, 0x0000, 50, 42},   // 164: p=0.031123 (   72,    2)
+	{0x01ea, 0x0000, 167, 171}, // 165: p=0.010298 (    1,   30)
+	{0x08ad, 0x0000, 44, 38},   // 166: p=0.046906 (   47,    2)
+	{0x0144, 0x0000, 65, 169},  // 167: p=0.006749 (    1,   46)
+	{0x0ccc, 0x0000, 40, 32},   // 168: p=0.069215 (   31,    2)
+	{0x0234, 0x0000, 59, 53},   // 169: p=0.011916 (    1,   26)
+	{0x1302, 0x0000, 34, 26},   // 170: p=0.102679 (   20,    2)
+	{0x0353, 0x0000, 55, 47},   // 171: p=0.017893 (    1,   17)
+	{0x1b81, 0x0000, 30, 174},  // 172: p=0.148656 (   13,    2)
+	{0x05c5, 0x0000, 175, 193}, // 173: p=0.031123 (    1,   12)
+	{0x24ef, 0x0000, 24, 18},   // 174: p=0.200003 (   13,    3)
+	{0x03cf, 0x0000, 177, 191}, // 175: p=0.020524 (    2,   18)
+	{0x2b74, 0x0000, 178, 222}, // 176: p=0.235601 (    4,    1)
+	{0x0285, 0x0000, 179, 189}, // 177: p=0.013590 (    2,   27)
+	{0x201d, 0x0000, 180, 218}, // 178: p=0.173661 (    6,    1)
+	{0x01ab, 0x0000, 181, 187}, // 179: p=0.008980 (    2,   41)
+	{0x1715, 0x0000, 182, 216}, // 180: p=0.124884 (    9,    1)
+	{0x011a, 0x0000, 183, 185}, // 181: p=0.005893 (    2,   62)
+	{0x0fb7, 0x0000, 184, 214}, // 182: p=0.085478 (   14,    1)
+	{0x00ba, 0x0000, 69, 61},   // 183: p=0.003923 (    2,   94)
+	{0x0a67, 0x0000, 186, 212}, // 184: p=0.056725 (   21,    1)
+	{0x01eb, 0x0000, 59, 53},   // 185: p=0.010337 (    3,   62)
+	{0x06e7, 0x0000, 188, 210}, // 186: p=0.037599 (   32,    1)
+	{0x02e6, 0x0000, 55, 49},   // 187: p=0.015641 (    3,   41)
+	{0x0496, 0x0000, 190, 208}, // 188: p=0.024938 (   48,    1)
+	{0x045e, 0x0000, 51, 45},   // 189: p=0.023590 (    3,   27)
+	{0x030d, 0x0000, 192, 206}, // 190: p=0.016584 (   73,    1)
+	{0x0690, 0x0000, 47, 39},   // 191: p=0.035469 (    3,   18)
+	{0x0206, 0x0000, 194, 204}, // 192: p=0.011022 (  111,    1)
+	{0x09de, 0x0000, 41, 195},  // 193: p=0.053457 (    3,   12)
+	{0x0155, 0x0000, 196, 202}, // 194: p=0.007236 (  168,    1)
+	{0x0dc8, 0x0000, 37, 31},   // 195: p=0.074529 (    4,   12)
+	{0x00e1, 0x0000, 198, 200}, // 196: p=0.004789 (  256,    1)
+	{0x2b74, 0x0000, 199, 243}, // 197: p=0.235601 (    1,    4)
+	{0x0094, 0x0000, 72, 64},   // 198: p=0.003155 (  387,    1)
+	{0x201d, 0x0000, 201, 239}, // 199: p=0.173661 (    1,    6)
+	{0x0188, 0x0000, 62, 56},   // 200: p=0.008339 (  256,    2)
+	{0x1715, 0x0000, 203, 237}, // 201: p=0.124884 (    1,    9)
+	{0x0252, 0x0000, 58, 52},   // 202: p=0.012616 (  168,    2)
+	{0x0fb7, 0x0000, 205, 235}, // 203: p=0.085478 (    1,   14)
+	{0x0383, 0x0000, 54, 48},   // 204: p=0.019099 (  111,    2)
+	{0x0a67, 0x0000, 207, 233}, // 205: p=0.056725 (    1,   21)
+	{0x0547, 0x0000, 50, 44},   // 206: p=0.028660 (   73,    2)
+	{0x06e7, 0x0000, 209, 231}, // 207: p=0.037599 (    1,   32)
+	{0x07e2, 0x0000, 46, 38},   // 208: p=0.042957 (   48,    2)
+	{0x0496, 0x0000, 211, 229}, // 209: p=0.024938 (    1,   48)
+	{0x0bc0, 0x0000, 40, 34},   // 210: p=0.063906 (   32,    2)
+	{0x030d, 0x0000, 213, 227}, // 211: p=0.016584 (    1,   73)
+	{0x1178, 0x0000, 36, 28},   // 212: p=0.094170 (   21,    2)
+	{0x0206, 0x0000, 215, 225}, // 213: p=0.011022 (    1,  111)
+	{0x19da, 0x0000, 30, 22},   // 214: p=0.139540 (   14,    2)
+	{0x0155, 0x0000, 217, 223}, // 215: p=0.007236 (    1,  168)
+	{0x24ef, 0x0000, 26, 16},   // 216: p=0.200003 (    9,    2)
+	{0x00e1, 0x0000, 219, 221}, // 217: p=0.004789 (    1,  256)
+	{0x320e, 0x0000, 20, 220},  // 218: p=0.269798 (    6,    2)
+	{0x0094, 0x0000, 71, 63},   // 219: p=0.003155 (    1,  387)
+	{0x432a, 0x0000, 14, 8},    // 220: p=0.349609 (    6,    3)
+	{0x0188, 0x0000, 61, 55},   // 221: p=0.008339 (    2,  256)
+	{0x447d, 0x0000, 14, 224},  // 222: p=0.355491 (    4,    2)
+	{0x0252, 0x0000, 57, 51},   // 223: p=0.012616 (    2,  168)
+	{0x5ece, 0x0000, 8, 2},     // 224: p=0.446282 (    4,    3)
+	{0x0383, 0x0000, 53, 47},   // 225: p=0.019099 (    2,  111)
+	{0x8000, 0x0000, 228, 87},  // 226: p=0.500000 (    1,    1)
+	{0x0547, 0x0000, 49, 43},   // 227: p=0.028660 (    2,   73)
+	{0x481a, 0x0000, 230, 246}, // 228: p=0.363634 (    2,    1)
+	{0x07e2, 0x0000, 45, 37},   // 229: p=0.042957 (    2,   48)
+	{0x3579, 0x0000, 232, 244}, // 230: p=0.285711 (    3,    1)
+	{0x0bc0, 0x0000, 39, 33},   // 231: p=0.063906 (    2,   32)
+	{0x24ef, 0x0000, 234, 238}, // 232: p=0.200003 (    5,    1)
+	{0x1178, 0x0000, 35, 27},   // 233: p=0.094170 (    2,   21)
+	{0x1978, 0x0000, 138, 236}, // 234: p=0.137933 (    8,    1)
+	{0x19da, 0x0000, 29, 21},   // 235: p=0.139540 (    2,   14)
+	{0x2865, 0x0000, 24, 16},   // 236: p=0.218728 (    8,    2)
+	{0x24ef, 0x0000, 25, 15},   // 237: p=0.200003 (    2,    9)
+	{0x3987, 0x0000, 240, 8},   // 238: p=0.304341 (    5,    2)
+	{0x320e, 0x0000, 19, 241},  // 239: p=0.269798 (    2,    6)
+	{0x2c99, 0x0000, 22, 242},  // 240: p=0.241021 (    5,    3)
+	{0x432a, 0x0000, 13, 7},    // 241: p=0.349609 (    3,    6)
+	{0x3b5f, 0x0000, 16, 10},   // 242: p=0.312499 (    5,    4)
+	{0x447d, 0x0000, 13, 245},  // 243: p=0.355491 (    2,    4)
+	{0x5695, 0x0000, 10, 2},    // 244: p=0.411764 (    3,    2)
+	{0x5ece, 0x0000, 7, 1},     // 245: p=0.446282 (    3,    4)
+	{0x8000, 0x0000, 244, 83},  // 246: p=0.500000 (    2,    2)
+	{0x8000, 0x0000, 249, 250}, // 247: p=0.500000 (    1,    1)
+	{0x5695, 0x0000, 10, 2},    // 248: p=0.411764 (    3,    2)
+	{0x481a, 0x0000, 89, 143},  // 249: p=0.363634 (    1,    2)
+	{0x481a, 0x0000, 230, 246}, // 250: p=0.363634 (    2,    1)
+	// 251 to 255 are unused
+}
@@ -0,0 +1,104 @@
+package zp
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ZPTestSuite struct {
+	suite.Suite
+	Rand *rand.Rand
+}
+
+func TestZPSuite(t *testing.T) {
+	suite.Run(t, new(ZPTestSuite))
+}
+
+func (s *ZPTestSuite) SetupTest() {
+	s.Rand = rand.New(rand.NewSource(1))
+}
+
+// Generates bits which are 1 with probability p
+func (s *ZPTestSuite) bits(n int, p float64) []int {
+	retval := make([]int, n)
+	for ii := range retval {
+		if s.Rand.Float64() < p {
+			retval[ii] = 1
+		}
+	}
+	return retval
+}
+
+func (s *ZPTestSuite) TestTableConsistency() {
+	for ii, st := range defaultTable[:251] {
+		s.NotZero(st.p, "state %d", ii)
+		s.Less(int(st.up), 251, "state %d", ii)
+		s.Less(int(st.dn), 251, "state %d", ii)
+	}
+}
+
+func (s *ZPTestSuite) TestRoundTrip() {
+	for _, p := range []float64{0, 0.01, 0.3, 0.5, 0.9, 1} {
+		bits := s.bits(5000, p)
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		var ctx [2]Context
+		for ii, bit := range bits {
+			switch ii % 3 {
+			case 0:
+				enc.Encode(bit, &ctx[0])
+			case 1:
+				enc.EncodePassthrough(bit)
+			default:
+				enc.EncodeIW(bit)
+			}
+			enc.Encode(bit^1, &ctx[1])
+		}
+		s.NoError(enc.Close())
+
+		dec := NewDecoder(bytes.NewReader(buf.Bytes()))
+		ctx = [2]Context{}
+		for ii, bit := range bits {
+			var got int
+			switch ii % 3 {
+			case 0:
+				got = dec.Decode(&ctx[0])
+			case 1:
+				got = dec.DecodePassthrough()
+			default:
+				got = dec.DecodeIW()
+			}
+			s.Equal(bit, got, "p=%v, bit %d", p, ii)
+			s.Equal(bit^1, dec.Decode(&ctx[1]), "p=%v, bit %d", p, ii)
+			if bit != got {
+				return
+			}
+		}
+		s.NoError(dec.Err())
+	}
+}
+
+func (s *ZPTestSuite) TestCompresses() {
+	bits := s.bits(80000, 0.05)
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	var ctx Context
+	for _, bit := range bits {
+		enc.Encode(bit, &ctx)
+	}
+	s.NoError(enc.Close())
+	s.Less(buf.Len(), 10000/3) // Entropy is about 0.29 bit per bit
+}
+
+func (s *ZPTestSuite) TestEndOfData() {
+	dec := NewDecoder(bytes.NewReader(nil))
+	var ctx Context
+	for ii := 0; ii < 1000; ii++ {
+		dec.Decode(&ctx)
+		dec.DecodePassthrough()
+	}
+	s.ErrorIs(dec.Err(), ErrEndOfData)
+}